@@ -3,6 +3,7 @@ module github.com/esm-dev/esm.sh
 go 1.18
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/Masterminds/semver/v3 v3.2.1
 	github.com/evanw/esbuild v0.17.18
 	github.com/ije/esbuild-internal v0.17.18
@@ -10,13 +11,14 @@ require (
 	github.com/ije/rex v1.9.1
 	github.com/mssola/useragent v1.0.0
 	go.etcd.io/bbolt v1.3.7
+	golang.org/x/net v0.9.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/andybalholm/brotli v1.0.5 // indirect
 	github.com/rs/cors v1.9.0 // indirect
 	golang.org/x/crypto v0.8.0 // indirect
-	golang.org/x/net v0.9.0 // indirect
 	golang.org/x/sys v0.7.0 // indirect
 	golang.org/x/text v0.9.0 // indirect
 )
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/esm-dev/esm.sh/server/config"
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+// debugMux serves the pprof and expvar endpoints under `/debug/`
+var debugMux = http.NewServeMux()
+
+func init() {
+	debugMux.HandleFunc("/debug/pprof/", pprof.Index)
+	debugMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	debugMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	debugMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	debugMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	debugMux.Handle("/debug/vars", expvar.Handler())
+
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+	expvar.Publish("buildQueue", expvar.Func(func() interface{} {
+		if buildQueue == nil {
+			return nil
+		}
+		buildQueue.lock.RLock()
+		defer buildQueue.lock.RUnlock()
+		return map[string]interface{}{
+			"tasks":       buildQueue.list.Len(),
+			"processes":   len(buildQueue.processes),
+			"avgDuration": buildQueue.avgDuration.String(),
+		}
+	}))
+}
+
+// adminHandler serves the admin endpoints under `/_admin/`, the requests must be
+// authorized with the `adminToken` of the config: `Authorization: Bearer <adminToken>`.
+func adminHandler() rex.Handle {
+	return func(ctx *rex.Context) interface{} {
+		pathname := ctx.Path.String()
+		// `DELETE /v{N}/pkg@version` is the shortcut of the `POST /_admin/purge` endpoint
+		isPurgeShortcut := ctx.R.Method == http.MethodDelete && (strings.HasPrefix(pathname, fmt.Sprintf("/v%d/", VERSION)) || strings.HasPrefix(pathname, "/stable/"))
+		if !strings.HasPrefix(pathname, "/_admin/") && !isPurgeShortcut {
+			return nil
+		}
+		if !checkBearerToken(ctx.R, cfg.AdminToken) {
+			return rex.Status(401, "Unauthorized")
+		}
+		ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
+
+		if isPurgeShortcut {
+			_, spec := utils.SplitByFirstByte(pathname[1:], '/')
+			pkg, err := parseExactPackage(spec)
+			if err != nil {
+				return rex.Err(400, err.Error())
+			}
+			purged, err := purgePackage(pkg, ctx.Form.Has("dependents"))
+			if err != nil {
+				return rex.Err(500, err.Error())
+			}
+			log.Infof("Purged %d builds of %s", len(purged), spec)
+			return PurgeOutput{Purged: purged}
+		}
+
+		// runtime diagnostics
+		if strings.HasPrefix(pathname, "/_admin/debug/") {
+			return http.StripPrefix("/_admin", debugMux)
+		}
+
+		switch pathname {
+		case "/_admin/webhooks":
+			switch ctx.R.Method {
+			case http.MethodGet:
+				return getWebhooks()
+			case http.MethodPost:
+				var hook config.Webhook
+				defer ctx.R.Body.Close()
+				err := json.NewDecoder(ctx.R.Body).Decode(&hook)
+				if err != nil {
+					return rex.Err(400, "invalid webhook: "+err.Error())
+				}
+				if !isRemoteSpecifier(hook.URL) {
+					return rex.Err(400, "invalid webhook url")
+				}
+				err = addWebhook(hook)
+				if err != nil {
+					return rex.Err(500, err.Error())
+				}
+				return getWebhooks()
+			case http.MethodDelete:
+				err := removeWebhook(ctx.Form.Value("url"))
+				if err != nil {
+					return rex.Err(500, err.Error())
+				}
+				return getWebhooks()
+			default:
+				return rex.Err(405, "method not allowed")
+			}
+
+		case "/_admin/purge":
+			if ctx.R.Method != http.MethodPost {
+				return rex.Err(405, "method not allowed")
+			}
+			var input PurgeInput
+			defer ctx.R.Body.Close()
+			err := json.NewDecoder(ctx.R.Body).Decode(&input)
+			if err != nil {
+				return rex.Err(400, "invalid input: "+err.Error())
+			}
+			pkg, err := parseExactPackage(input.Package)
+			if err != nil {
+				return rex.Err(400, err.Error())
+			}
+			purged, err := purgePackage(pkg, input.Dependents)
+			if err != nil {
+				return rex.Err(500, err.Error())
+			}
+			log.Infof("Purged %d builds of %s", len(purged), input.Package)
+			return PurgeOutput{Purged: purged}
+
+		case "/_admin/warmup":
+			if ctx.R.Method != http.MethodPost {
+				return rex.Err(405, "method not allowed")
+			}
+			if readOnly {
+				return rex.Err(403, "the server is in read-only mode")
+			}
+			var manifest WarmupManifest
+			defer ctx.R.Body.Close()
+			err := json.NewDecoder(ctx.R.Body).Decode(&manifest)
+			if err != nil {
+				return rex.Err(400, "invalid manifest: "+err.Error())
+			}
+			out := queueWarmupBuilds(&manifest)
+			log.Infof("warmup: %d builds queued", out.Queued)
+			return out
+
+		case "/_admin/failures":
+			if ctx.R.Method != http.MethodGet {
+				return rex.Err(405, "method not allowed")
+			}
+			return getRecentFailures()
+
+		case "/_admin/dependents":
+			if ctx.R.Method != http.MethodGet {
+				return rex.Err(405, "method not allowed")
+			}
+			pkg, err := parseExactPackage(ctx.Form.Value("package"))
+			if err != nil {
+				return rex.Err(400, err.Error())
+			}
+			dependents, err := getDependents(pkg)
+			if err != nil {
+				return rex.Err(500, err.Error())
+			}
+			return DependentsOutput{Package: ctx.Form.Value("package"), Dependents: dependents}
+
+		case "/_admin/analytics":
+			if !cfg.Analytics.Enabled {
+				return rex.Err(404, "analytics is disabled")
+			}
+			from := ctx.Form.Value("from")
+			to := ctx.Form.Value("to")
+			for _, date := range []string{from, to} {
+				if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+					return rex.Err(400, fmt.Sprintf("invalid date '%s', the format is YYYY-MM-DD", date))
+				}
+			}
+			// flush the pending counts to include them in the export
+			err := flushAnalytics(time.Now())
+			if err != nil {
+				return rex.Err(500, err.Error())
+			}
+			records, err := getAnalytics(from, to)
+			if err != nil {
+				return rex.Err(500, err.Error())
+			}
+			if ctx.Form.Value("format") == "csv" {
+				buf := bytes.NewBuffer(nil)
+				w := csv.NewWriter(buf)
+				w.Write([]string{"date", "package", "version", "target", "count"})
+				for _, r := range records {
+					w.Write([]string{r.Date, r.Package, r.Version, r.Target, strconv.Itoa(r.Count)})
+				}
+				w.Flush()
+				ctx.SetHeader("Content-Type", "text/csv; charset=utf-8")
+				return buf
+			}
+			return records
+
+		case "/_admin/log":
+			switch ctx.R.Method {
+			case http.MethodGet:
+				return getLogFilter()
+			case http.MethodPost:
+				var input LogFilterOptions
+				defer ctx.R.Body.Close()
+				err := json.NewDecoder(ctx.R.Body).Decode(&input)
+				if err != nil {
+					return rex.Err(400, "invalid input: "+err.Error())
+				}
+				for _, name := range input.Subsystems {
+					if !includes(logSubsystems, name) {
+						return rex.Err(400, fmt.Sprintf("invalid subsystem '%s', available subsystems: %s", name, strings.Join(logSubsystems, ",")))
+					}
+				}
+				if input.Level != "" {
+					switch input.Level {
+					case "debug", "info", "warn", "error":
+						setLogLevel(input.Level)
+					default:
+						return rex.Err(400, "invalid log level")
+					}
+				}
+				setLogFilter(input.Packages, input.Subsystems)
+				return getLogFilter()
+			default:
+				return rex.Err(405, "method not allowed")
+			}
+
+		default:
+			return rex.Err(404, "not found")
+		}
+	}
+}
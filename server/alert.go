@@ -0,0 +1,138 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ije/gox/utils"
+)
+
+// the min interval of the same alert
+const alertCooldown = 10 * time.Minute
+
+var (
+	alertLock     sync.Mutex
+	buildFailures = map[string][]time.Time{}
+	alertedAt     = map[string]time.Time{}
+)
+
+// recordBuildFailure records a build failure of the package, and sends an alert
+// if the package fails `failureThreshold` times within the `failureWindow`.
+func recordBuildFailure(pkgName string, err error) {
+	if cfg.Alert.Webhook == "" {
+		return
+	}
+
+	window := time.Duration(cfg.Alert.FailureWindow) * time.Second
+	now := time.Now()
+
+	alertLock.Lock()
+	failures := []time.Time{}
+	for _, t := range buildFailures[pkgName] {
+		if now.Sub(t) < window {
+			failures = append(failures, t)
+		}
+	}
+	failures = append(failures, now)
+	exceeded := len(failures) >= int(cfg.Alert.FailureThreshold)
+	if exceeded {
+		delete(buildFailures, pkgName)
+	} else {
+		buildFailures[pkgName] = failures
+	}
+	alertLock.Unlock()
+
+	if exceeded {
+		alert("build-failure:"+pkgName, fmt.Sprintf("Package `%s` failed to build %d times in %v, last error: %v", pkgName, len(failures), window, err))
+	}
+}
+
+// startAlertMonitor checks the build queue and disk space thresholds periodically, and prunes the
+// expired failure records.
+func startAlertMonitor() {
+	if cfg.Alert.Webhook == "" {
+		return
+	}
+	for {
+		pruneAlertRecords(time.Now())
+		if cfg.Alert.QueueThreshold > 0 {
+			if n := buildQueue.Len(); n >= int(cfg.Alert.QueueThreshold) {
+				alert("queue", fmt.Sprintf("The build queue has %d tasks, exceeds the threshold %d", n, cfg.Alert.QueueThreshold))
+			}
+		}
+		if cfg.Alert.DiskThreshold > 0 {
+			free, err := getDiskFreeSpace(cfg.WorkDir)
+			if err == nil && free < uint64(cfg.Alert.DiskThreshold)*1024*1024 {
+				alert("disk", fmt.Sprintf("Only %dMB disk space is free in %s, below the threshold %dMB", free/1024/1024, cfg.WorkDir, cfg.Alert.DiskThreshold))
+			}
+		}
+		time.Sleep(time.Minute)
+	}
+}
+
+// pruneAlertRecords removes the failures that are out of the window and the expired cooldowns, so
+// the packages that failed once don't stay in the memory.
+func pruneAlertRecords(now time.Time) {
+	window := time.Duration(cfg.Alert.FailureWindow) * time.Second
+
+	alertLock.Lock()
+	defer alertLock.Unlock()
+
+	for pkgName, failures := range buildFailures {
+		if len(failures) == 0 || now.Sub(failures[len(failures)-1]) >= window {
+			delete(buildFailures, pkgName)
+		}
+	}
+	for key, t := range alertedAt {
+		if now.Sub(t) >= alertCooldown {
+			delete(alertedAt, key)
+		}
+	}
+}
+
+// alert sends a message to the alert webhook, the same alert is sent at most once per `alertCooldown`.
+func alert(key string, message string) {
+	alertLock.Lock()
+	if t, ok := alertedAt[key]; ok && time.Since(t) < alertCooldown {
+		alertLock.Unlock()
+		return
+	}
+	alertedAt[key] = time.Now()
+	alertLock.Unlock()
+
+	log.Warn("alert:", message)
+	go func() {
+		err := sendAlert(cfg.Alert.Webhook, cfg.Alert.Format, message)
+		if err != nil {
+			log.Errorf("send alert: %v", err)
+		}
+	}()
+}
+
+func sendAlert(webhook string, format string, message string) error {
+	if format == "" {
+		format = "slack"
+		if strings.Contains(webhook, "discord.com/") || strings.Contains(webhook, "discordapp.com/") {
+			format = "discord"
+		}
+	}
+	text := fmt.Sprintf("[esm.sh] %s", message)
+	var payload map[string]interface{}
+	if format == "discord" {
+		payload = map[string]interface{}{"content": text}
+	} else {
+		payload = map[string]interface{}{"text": text}
+	}
+	res, err := httpClient.Post(webhook, "application/json", bytes.NewReader(utils.MustEncodeJSON(payload)))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("unexpected http status %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/esm-dev/esm.sh/server/config"
+)
+
+func TestPruneAlertRecords(t *testing.T) {
+	withConfig(t, &config.Config{Alert: config.Alert{FailureWindow: 600}})
+	defer func() {
+		buildFailures = map[string][]time.Time{}
+		alertedAt = map[string]time.Time{}
+	}()
+
+	now := time.Now()
+	buildFailures = map[string][]time.Time{
+		"foo": {now.Add(-time.Hour)},
+		"bar": {now.Add(-time.Hour), now.Add(-time.Minute)},
+	}
+	alertedAt = map[string]time.Time{
+		"build-failure:foo": now.Add(-time.Hour),
+		"queue":             now.Add(-time.Minute),
+	}
+	pruneAlertRecords(now)
+	if _, ok := buildFailures["foo"]; ok || len(buildFailures) != 1 {
+		t.Fatalf("the expired failures should be pruned: %v", buildFailures)
+	}
+	if _, ok := alertedAt["build-failure:foo"]; ok || len(alertedAt) != 1 {
+		t.Fatalf("the expired cooldowns should be pruned: %v", alertedAt)
+	}
+}
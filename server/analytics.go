@@ -0,0 +1,207 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ije/gox/utils"
+)
+
+// the db key prefix of the daily rollups of the analytics, e.g. `_analytics:2024-01-31`
+const analyticsDBKeyPrefix = "_analytics:"
+
+// the interval to flush the request counts to the daily rollup
+const analyticsFlushInterval = time.Minute
+
+// requestHits counts the module requests by `pkg@version target` since the last flush, no client
+// info(e.g. the ip address) is recorded.
+var requestHits = &hitCounter{hits: map[string]int{}}
+
+// AnalyticsRecord is a row of the analytics export.
+type AnalyticsRecord struct {
+	Date    string `json:"date"`
+	Package string `json:"package"`
+	Version string `json:"version"`
+	Target  string `json:"target"`
+	Count   int    `json:"count"`
+}
+
+// recordRequest counts the module request if the analytics is enabled.
+func recordRequest(pkg Pkg, target string) {
+	if !cfg.Analytics.Enabled {
+		return
+	}
+	name := pkg.Name
+	if pkg.FromGithub {
+		name = "gh/" + name
+	}
+	requestHits.add(fmt.Sprintf("%s@%s %s", name, pkg.Version, target))
+}
+
+// startAnalytics flushes the request counts to the daily rollup periodically.
+func startAnalytics() {
+	for {
+		time.Sleep(analyticsFlushInterval)
+		err := flushAnalytics(time.Now())
+		if err != nil {
+			log.Errorf("analytics: %v", err)
+		}
+	}
+}
+
+// the lock of the analytics records in the db, the records are flushed periodically and by the
+// `/_admin/analytics` endpoint.
+var analyticsLock sync.Mutex
+
+// the date that the analytics records are compacted, the compaction runs once a day.
+var analyticsCompactedDate string
+
+// the sequence of the analytics chunks to avoid the key conflicts of the flushes at the same time
+var analyticsChunkSeq int
+
+// flushAnalytics appends the request counts as a chunk of the day, the chunks of the previous days
+// are merged into the daily rollups once a day, and the rollups that are older than the `retention`
+// days are removed.
+func flushAnalytics(now time.Time) error {
+	analyticsLock.Lock()
+	defer analyticsLock.Unlock()
+
+	date := now.UTC().Format("2006-01-02")
+	hits := requestHits.drain()
+	if len(hits) > 0 {
+		// e.g. `_analytics:2024-01-31:1706702400000000000.1`, the small chunk is written instead of
+		// rewriting the whole rollup of the day
+		analyticsChunkSeq++
+		key := fmt.Sprintf("%s%s:%d.%d", analyticsDBKeyPrefix, date, now.UnixNano(), analyticsChunkSeq)
+		err := db.Put(key, utils.MustEncodeJSON(hits))
+		if err != nil {
+			return err
+		}
+	}
+	if analyticsCompactedDate == date {
+		return nil
+	}
+	err := compactAnalytics(date, now.UTC().AddDate(0, 0, -int(cfg.Analytics.Retention)).Format("2006-01-02"))
+	if err != nil {
+		return err
+	}
+	analyticsCompactedDate = date
+	return nil
+}
+
+// compactAnalytics merges the chunks of the days before the `today` into the daily rollups, and
+// removes the records of the days before the `expires`.
+func compactAnalytics(today string, expires string) error {
+	keys, err := db.Keys(analyticsDBKeyPrefix)
+	if err != nil {
+		return err
+	}
+	chunks := map[string][]string{}
+	for _, key := range keys {
+		date, _, isChunk := strings.Cut(strings.TrimPrefix(key, analyticsDBKeyPrefix), ":")
+		if date < expires {
+			db.Delete(key)
+		} else if isChunk && date < today {
+			chunks[date] = append(chunks[date], key)
+		}
+	}
+	for date, keys := range chunks {
+		rollupKey := analyticsDBKeyPrefix + date
+		rollup, err := getAnalyticsRecord(rollupKey)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			chunk, err := getAnalyticsRecord(key)
+			if err != nil {
+				return err
+			}
+			for k, n := range chunk {
+				rollup[k] += n
+			}
+		}
+		err = db.Put(rollupKey, utils.MustEncodeJSON(rollup))
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			db.Delete(key)
+		}
+	}
+	return nil
+}
+
+// getAnalyticsRecord returns the request counts of the rollup or the chunk.
+func getAnalyticsRecord(key string) (map[string]int, error) {
+	record := map[string]int{}
+	data, err := db.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		err = json.Unmarshal(data, &record)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return record, nil
+}
+
+// getAnalytics returns the rows of the daily rollups between the `from` and `to` dates(inclusive),
+// the empty date is unbounded. The rows are sorted by the date and the count in descending order.
+func getAnalytics(from string, to string) (records []AnalyticsRecord, err error) {
+	analyticsLock.Lock()
+	defer analyticsLock.Unlock()
+
+	keys, err := db.Keys(analyticsDBKeyPrefix)
+	if err != nil {
+		return
+	}
+	// the rollup and the chunks of a day are merged
+	days := map[string]map[string]int{}
+	for _, key := range keys {
+		date, _, _ := strings.Cut(strings.TrimPrefix(key, analyticsDBKeyPrefix), ":")
+		if (from != "" && date < from) || (to != "" && date > to) {
+			continue
+		}
+		record, e := getAnalyticsRecord(key)
+		if e != nil {
+			continue
+		}
+		if days[date] == nil {
+			days[date] = map[string]int{}
+		}
+		for k, n := range record {
+			days[date][k] += n
+		}
+	}
+	records = []AnalyticsRecord{}
+	for date, rollup := range days {
+		for k, n := range rollup {
+			spec, target, _ := strings.Cut(k, " ")
+			name, version := splitPkgSpec(spec)
+			records = append(records, AnalyticsRecord{
+				Date:    date,
+				Package: name,
+				Version: version,
+				Target:  target,
+				Count:   n,
+			})
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if a.Date != b.Date {
+			return a.Date > b.Date
+		}
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Package+"@"+a.Version+" "+a.Target < b.Package+"@"+b.Version+" "+b.Target
+	})
+	return
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/esm-dev/esm.sh/server/config"
+	"github.com/esm-dev/esm.sh/server/storage"
+)
+
+func TestAnalytics(t *testing.T) {
+	dir, err := os.MkdirTemp("", "esm-analytics-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	withConfig(t, &config.Config{Analytics: config.Analytics{Enabled: true, Retention: 30}})
+	db, err = storage.OpenDB("bolt:" + path.Join(dir, "esm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		db.Close()
+		db = nil
+		analyticsCompactedDate = ""
+	}()
+
+	day := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	db.Put(analyticsDBKeyPrefix+"2023-12-01", []byte(`{"react@18.2.0 es2022":1}`))
+	db.Put(analyticsDBKeyPrefix+"2024-01-30", []byte(`{"react@18.2.0 es2022":5}`))
+
+	recordRequest(Pkg{Name: "react", Version: "18.2.0"}, "es2022")
+	recordRequest(Pkg{Name: "react", Version: "18.2.0"}, "es2022")
+	recordRequest(Pkg{Name: "@types/react", Version: "18.2.0"}, "deno")
+	recordRequest(Pkg{Name: "owner/repo", Version: "v1.0.0", FromGithub: true}, "es2022")
+	err = flushAnalytics(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordRequest(Pkg{Name: "react", Version: "18.2.0"}, "es2022")
+	err = flushAnalytics(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value, _ := db.Get(analyticsDBKeyPrefix + "2023-12-01"); value != nil {
+		t.Fatal("the expired rollup should be removed")
+	}
+
+	records, err := getAnalytics("2024-01-31", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AnalyticsRecord{
+		{Date: "2024-01-31", Package: "react", Version: "18.2.0", Target: "es2022", Count: 3},
+		{Date: "2024-01-31", Package: "@types/react", Version: "18.2.0", Target: "deno", Count: 1},
+		{Date: "2024-01-31", Package: "gh/owner/repo", Version: "v1.0.0", Target: "es2022", Count: 1},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("invalid records: %v", records)
+	}
+	for i, r := range records {
+		if r != want[i] {
+			t.Fatalf("invalid record #%d: %v, want %v", i, r, want[i])
+		}
+	}
+
+	records, _ = getAnalytics("", "2024-01-30")
+	if len(records) != 1 || records[0].Count != 5 {
+		t.Fatalf("invalid records: %v", records)
+	}
+
+	// the chunks of the previous days are merged into the daily rollup
+	recordRequest(Pkg{Name: "react", Version: "18.2.0"}, "es2022")
+	err = flushAnalytics(day.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, _ := db.Keys(analyticsDBKeyPrefix + "2024-01-31")
+	if len(keys) != 1 || keys[0] != analyticsDBKeyPrefix+"2024-01-31" {
+		t.Fatalf("the chunks should be compacted: %v", keys)
+	}
+	records, _ = getAnalytics("2024-01-31", "2024-01-31")
+	if len(records) != 3 || records[0].Count != 3 {
+		t.Fatalf("invalid records: %v", records)
+	}
+	records, _ = getAnalytics("2024-02-01", "")
+	if len(records) != 1 || records[0].Count != 1 {
+		t.Fatalf("invalid records: %v", records)
+	}
+
+	cfg.Analytics.Enabled = false
+	recordRequest(Pkg{Name: "react", Version: "18.2.0"}, "es2022")
+	if hits := requestHits.drain(); len(hits) != 0 {
+		t.Fatalf("should not count the requests if the analytics is disabled: %v", hits)
+	}
+}
@@ -0,0 +1,318 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"math"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/esm-dev/esm.sh/server/storage"
+	"github.com/ije/rex"
+)
+
+// the number of the largest contributors that are listed in the `?max-size` error
+const maxSizeContributors = 10
+
+// Metafile is the esbuild metafile of the build that is stored for the `?analyze` query, the paths of
+// the build directory are stripped, e.g. "node_modules/react/index.js".
+type Metafile struct {
+	Inputs  map[string]MetafileInput  `json:"inputs"`
+	Outputs map[string]MetafileOutput `json:"outputs"`
+}
+
+type MetafileInput struct {
+	Bytes   int              `json:"bytes"`
+	Imports []MetafileImport `json:"imports,omitempty"`
+}
+
+type MetafileImport struct {
+	Path     string `json:"path"`
+	Kind     string `json:"kind"`
+	External bool   `json:"external,omitempty"`
+}
+
+type MetafileOutput struct {
+	Bytes      int                            `json:"bytes"`
+	Inputs     map[string]MetafileOutputInput `json:"inputs"`
+	Exports    []string                       `json:"exports,omitempty"`
+	EntryPoint string                         `json:"entryPoint,omitempty"`
+}
+
+type MetafileOutputInput struct {
+	BytesInOutput int `json:"bytesInOutput"`
+}
+
+// MetafileGroup is the size of a package in the build output.
+type MetafileGroup struct {
+	Name  string `json:"name"`
+	Bytes int    `json:"bytes"`
+	Files int    `json:"files"`
+}
+
+// compactMetafile strips the paths of the build directory from the esbuild metafile.
+func compactMetafile(metafile string) (data []byte, err error) {
+	var meta Metafile
+	err = json.Unmarshal([]byte(metafile), &meta)
+	if err != nil {
+		return
+	}
+	ret := Metafile{
+		Inputs:  make(map[string]MetafileInput, len(meta.Inputs)),
+		Outputs: make(map[string]MetafileOutput, len(meta.Outputs)),
+	}
+	for name, input := range meta.Inputs {
+		for i, imp := range input.Imports {
+			input.Imports[i].Path = toMetafilePath(imp.Path)
+		}
+		ret.Inputs[toMetafilePath(name)] = input
+	}
+	for name, output := range meta.Outputs {
+		inputs := make(map[string]MetafileOutputInput, len(output.Inputs))
+		for name, input := range output.Inputs {
+			inputs[toMetafilePath(name)] = input
+		}
+		output.Inputs = inputs
+		if output.EntryPoint != "" {
+			output.EntryPoint = toMetafilePath(output.EntryPoint)
+		}
+		ret.Outputs[path.Base(name)] = output
+	}
+	return json.Marshal(ret)
+}
+
+func toMetafilePath(p string) string {
+	if strings.HasPrefix(p, "__ESM_SH_EXTERNAL:") {
+		return strings.TrimPrefix(p, "__ESM_SH_EXTERNAL:")
+	}
+	// e.g. "../tmp/esm-build-xxx/node_modules/.pnpm/react@18.3.1/node_modules/react/index.js"
+	if i := strings.LastIndex(p, "node_modules/"); i >= 0 {
+		return p[i:]
+	}
+	// the files of the build directory, e.g. "../tmp/esm-build-xxx/entry.js"
+	if strings.HasPrefix(p, "../") || strings.HasPrefix(p, "/") {
+		return path.Base(p)
+	}
+	return p
+}
+
+// groupMetafile returns the packages of the js output sorted by the size, the files that are not in
+// the `node_modules` are grouped as "(app)".
+func groupMetafile(meta *Metafile) (total int, groups []MetafileGroup) {
+	sizes := map[string]*MetafileGroup{}
+	for name, output := range meta.Outputs {
+		if !strings.HasSuffix(name, ".js") && !strings.HasSuffix(name, ".mjs") {
+			continue
+		}
+		for input, v := range output.Inputs {
+			pkgName := "(app)"
+			if strings.HasPrefix(input, "node_modules/") {
+				pkgName, _ = splitPkgPath(strings.TrimPrefix(input, "node_modules/"))
+			}
+			g, ok := sizes[pkgName]
+			if !ok {
+				g = &MetafileGroup{Name: pkgName}
+				sizes[pkgName] = g
+			}
+			g.Bytes += v.BytesInOutput
+			g.Files++
+			total += v.BytesInOutput
+		}
+	}
+	groups = make([]MetafileGroup, 0, len(sizes))
+	for _, g := range sizes {
+		groups = append(groups, *g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Bytes == groups[j].Bytes {
+			return groups[i].Name < groups[j].Name
+		}
+		return groups[i].Bytes > groups[j].Bytes
+	})
+	return
+}
+
+// checkSizeBudget checks the size of the build with the `?max-size` query, a `413` error that lists
+// the largest contributors of the build is returned if the budget is exceeded, otherwise nil.
+func checkSizeBudget(ctx *rex.Context, savePath string) interface{} {
+	v := ctx.Form.Value("max-size")
+	if v == "" {
+		return nil
+	}
+	maxSize, err := parseByteSize(v)
+	if err != nil {
+		return rex.Status(400, "Invalid `max-size` query: "+err.Error())
+	}
+	ret, err := getSizeBudgetError(savePath, maxSize)
+	if err != nil {
+		return rex.Status(500, err.Error())
+	}
+	if ret == nil {
+		return nil
+	}
+	ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
+	return rex.Status(http.StatusRequestEntityTooLarge, ret)
+}
+
+// getSizeBudgetError returns the error of the build that exceeds the size budget, or nil if the build
+// is in the budget or not found.
+func getSizeBudgetError(savePath string, maxSize int64) (map[string]interface{}, error) {
+	fi, err := fs.Stat(savePath)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if fi.Size() <= maxSize {
+		return nil, nil
+	}
+	contributors := []MetafileGroup{}
+	if r, _, err := openStorageFile(savePath + ".metafile.json"); err == nil {
+		var meta Metafile
+		if json.NewDecoder(r).Decode(&meta) == nil {
+			_, groups := groupMetafile(&meta)
+			if len(groups) > maxSizeContributors {
+				groups = groups[:maxSizeContributors]
+			}
+			contributors = groups
+		}
+		r.Close()
+	}
+	return map[string]interface{}{
+		"error": map[string]interface{}{
+			"status":  http.StatusRequestEntityTooLarge,
+			"message": fmt.Sprintf("the build size %s exceeds the budget %s", formatBytes(int(fi.Size())), formatBytes(int(maxSize))),
+		},
+		"size":         fi.Size(),
+		"maxSize":      maxSize,
+		"contributors": contributors,
+	}, nil
+}
+
+type treemapRect struct {
+	group      MetafileGroup
+	x, y, w, h float64
+}
+
+// layoutTreemap lays out the groups in the `w*h` rect with the squarified algorithm, the groups
+// must be sorted by the size in descending order.
+func layoutTreemap(groups []MetafileGroup, x, y, w, h float64) []treemapRect {
+	total := 0
+	for _, g := range groups {
+		total += g.Bytes
+	}
+	rects := make([]treemapRect, 0, len(groups))
+	if total == 0 {
+		return rects
+	}
+	scale := w * h / float64(total)
+	worst := func(row []MetafileGroup, side float64) float64 {
+		sum, max, min := 0.0, 0.0, 0.0
+		for i, g := range row {
+			a := float64(g.Bytes) * scale
+			sum += a
+			if i == 0 || a > max {
+				max = a
+			}
+			if i == 0 || a < min {
+				min = a
+			}
+		}
+		if sum == 0 || min == 0 {
+			return 1e18
+		}
+		s2 := side * side
+		return math.Max(s2*max/(sum*sum), sum*sum/(s2*min))
+	}
+	for len(groups) > 0 {
+		side := math.Min(w, h)
+		n := 1
+		for n < len(groups) && worst(groups[:n+1], side) <= worst(groups[:n], side) {
+			n++
+		}
+		row := groups[:n]
+		groups = groups[n:]
+		sum := 0.0
+		for _, g := range row {
+			sum += float64(g.Bytes) * scale
+		}
+		thickness := 0.0
+		if side > 0 {
+			thickness = sum / side
+		}
+		offset := 0.0
+		for _, g := range row {
+			length := 0.0
+			if thickness > 0 {
+				length = float64(g.Bytes) * scale / thickness
+			}
+			if w >= h {
+				rects = append(rects, treemapRect{g, x, y + offset, thickness, length})
+			} else {
+				rects = append(rects, treemapRect{g, x + offset, y, length, thickness})
+			}
+			offset += length
+		}
+		if w >= h {
+			x += thickness
+			w -= thickness
+		} else {
+			y += thickness
+			h -= thickness
+		}
+	}
+	return rects
+}
+
+// renderTreemapHTML renders the packages of the metafile as a treemap page.
+func renderTreemapHTML(title string, meta *Metafile) []byte {
+	total, groups := groupMetafile(meta)
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>%s - esm.sh</title>
+<style>
+body{margin:0;font:13px/1.4 system-ui,sans-serif;color:#222}
+header{padding:12px 16px;border-bottom:1px solid #eee}
+h1{margin:0;font-size:16px}
+main{position:relative;height:calc(100vh - 72px);margin:8px}
+main div{position:absolute;box-sizing:border-box;overflow:hidden;padding:4px;border:1px solid #fff;color:#fff;text-overflow:ellipsis}
+main div span{display:block;opacity:.8;font-size:11px}
+</style>
+</head>
+<body>
+<header><h1>%s</h1>%s in %d packages</header>
+<main>
+`, html.EscapeString(title), html.EscapeString(title), formatBytes(total), len(groups))
+	for i, r := range layoutTreemap(groups, 0, 0, 100, 100) {
+		g := r.group
+		fmt.Fprintf(
+			buf,
+			`<div style="left:%.3f%%;top:%.3f%%;width:%.3f%%;height:%.3f%%;background:hsl(%d,55%%,45%%)" title="%s: %s (%d files)">%s<span>%s</span></div>`+"\n",
+			r.x, r.y, r.w, r.h,
+			(i*47)%360,
+			html.EscapeString(g.Name), formatBytes(g.Bytes), g.Files,
+			html.EscapeString(g.Name), formatBytes(g.Bytes),
+		)
+	}
+	buf.WriteString("</main>\n</body>\n</html>\n")
+	return buf.Bytes()
+}
+
+func formatBytes(n int) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	if n < 1024*1024 {
+		return fmt.Sprintf("%.1f KB", float64(n)/1024)
+	}
+	return fmt.Sprintf("%.2f MB", float64(n)/1024/1024)
+}
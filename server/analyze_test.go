@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/esm-dev/esm.sh/server/storage"
+)
+
+func TestAnalyzeMetafile(t *testing.T) {
+	metafile := `{
+		"inputs": {
+			"../tmp/esm-build-abc/node_modules/.pnpm/react@18.3.1/node_modules/react/index.js": {"bytes": 100, "imports": [{"path": "__ESM_SH_EXTERNAL:scheduler", "kind": "require-call", "external": true}]},
+			"../tmp/esm-build-abc/node_modules/@scope/pkg/lib/a.js": {"bytes": 300},
+			"../tmp/esm-build-abc/node_modules/@scope/pkg/lib/b.js": {"bytes": 100},
+			"<stdin>": {"bytes": 20}
+		},
+		"outputs": {
+			"/esbuild/stdin.js": {
+				"bytes": 480,
+				"inputs": {
+					"../tmp/esm-build-abc/node_modules/.pnpm/react@18.3.1/node_modules/react/index.js": {"bytesInOutput": 80},
+					"../tmp/esm-build-abc/node_modules/@scope/pkg/lib/a.js": {"bytesInOutput": 250},
+					"../tmp/esm-build-abc/node_modules/@scope/pkg/lib/b.js": {"bytesInOutput": 50},
+					"<stdin>": {"bytesInOutput": 20}
+				},
+				"exports": ["default"],
+				"entryPoint": "<stdin>"
+			}
+		}
+	}`
+	data, err := compactMetafile(metafile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "esm-build-abc") || strings.Contains(string(data), "__ESM_SH_EXTERNAL") {
+		t.Fatalf("the paths of the build dir should be stripped: %s", data)
+	}
+	var meta Metafile
+	if err = json.Unmarshal(data, &meta); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := meta.Inputs["node_modules/react/index.js"]; !ok {
+		t.Fatalf("invalid inputs: %v", meta.Inputs)
+	}
+	if meta.Inputs["node_modules/react/index.js"].Imports[0].Path != "scheduler" {
+		t.Fatalf("invalid imports: %v", meta.Inputs["node_modules/react/index.js"].Imports)
+	}
+
+	total, groups := groupMetafile(&meta)
+	if total != 400 || len(groups) != 3 {
+		t.Fatalf("invalid groups: %d, %v", total, groups)
+	}
+	if groups[0].Name != "@scope/pkg" || groups[0].Bytes != 300 || groups[0].Files != 2 {
+		t.Fatalf("invalid group: %v", groups[0])
+	}
+
+	area := 0.0
+	for _, r := range layoutTreemap(groups, 0, 0, 100, 100) {
+		if r.x < 0 || r.y < 0 || r.x+r.w > 100.001 || r.y+r.h > 100.001 {
+			t.Fatalf("the rect of %s is out of bounds: %v", r.group.Name, r)
+		}
+		if a := r.w * r.h; math.Abs(a-float64(r.group.Bytes)*10000/float64(total)) > 0.01 {
+			t.Fatalf("the area of %s should be proportional to the size: %f", r.group.Name, a)
+		}
+		area += r.w * r.h
+	}
+	if math.Abs(area-10000) > 0.01 {
+		t.Fatalf("the treemap should fill the rect: %f", area)
+	}
+
+	html := string(renderTreemapHTML("<pkg>@1.0.0", &meta))
+	if !strings.Contains(html, "&lt;pkg&gt;@1.0.0") || !strings.Contains(html, "@scope/pkg<span>300 B</span>") {
+		t.Fatalf("invalid treemap html: %s", html)
+	}
+}
+
+func TestSizeBudget(t *testing.T) {
+	dir := t.TempDir()
+	var err error
+	cache, err = storage.OpenCache("memory:test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err = storage.OpenFS("local:" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cache = nil
+		fs = nil
+	}()
+
+	savePath := "builds/v135/foo@1.0.0/es2022/foo.bundle.mjs"
+	fs.WriteFile(savePath, strings.NewReader(strings.Repeat("x", 2048)))
+	fs.WriteFile(savePath+".metafile.json", strings.NewReader(`{"inputs":{},"outputs":{"foo.bundle.mjs":{"bytes":2048,"inputs":{"node_modules/foo/index.js":{"bytesInOutput":500},"node_modules/bar/index.js":{"bytesInOutput":1500}}}}}`))
+
+	if ret, err := getSizeBudgetError(savePath, 2048); ret != nil || err != nil {
+		t.Fatalf("the build is in the budget: %v, %v", ret, err)
+	}
+	if ret, err := getSizeBudgetError(savePath+".missing", 1024); ret != nil || err != nil {
+		t.Fatalf("the missing build should be skipped: %v, %v", ret, err)
+	}
+	ret, err := getSizeBudgetError(savePath, 1024)
+	if ret == nil || err != nil {
+		t.Fatalf("the build exceeds the budget: %v", err)
+	}
+	data, _ := json.Marshal(ret)
+	if !strings.Contains(string(data), `"contributors":[{"name":"bar","bytes":1500,"files":1},{"name":"foo","bytes":500,"files":1}]`) {
+		t.Fatalf("invalid error: %s", data)
+	}
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	neturl "net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/esm-dev/esm.sh/server/storage"
+	"github.com/ije/gox/utils"
+)
+
+// BuildProvenance records the upstream urls fetched by a build in the audit mode.
+type BuildProvenance struct {
+	ID       string          `json:"id"`
+	Pkg      string          `json:"pkg"`
+	Registry string          `json:"registry,omitempty"`
+	Fetches  []UpstreamFetch `json:"fetches"`
+	Time     int64           `json:"time"`
+}
+
+// UpstreamFetch is an url fetched from the npm registry or github, the integrity is
+// the SRI hash(e.g. "sha512-...") of the tarball.
+type UpstreamFetch struct {
+	URL       string `json:"url"`
+	Integrity string `json:"integrity,omitempty"`
+}
+
+// auditBuild collects the upstream urls fetched by the build task and checks them with
+// the `audit.allowHosts` config.
+func (task *BuildTask) auditBuild() (provenance BuildProvenance, err error) {
+	provenance = BuildProvenance{
+		ID:       task.ID(),
+		Pkg:      task.Pkg.String(),
+		Registry: task.registry,
+		Time:     time.Now().Unix(),
+	}
+	if task.Pkg.FromEsmsh {
+		return
+	}
+	provenance.Fetches = append(provenance.Fetches, task.upstreamFetch())
+	fetches, err := readPnpmLockFetches(path.Join(task.wd, "pnpm-lock.yaml"), func(name string) string {
+		return getRegistryURL(task.registry, name)
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return
+	}
+	provenance.Fetches = append(provenance.Fetches, fetches...)
+	err = checkUpstreamHosts(provenance.Fetches)
+	return
+}
+
+// upstreamFetch returns the url of the package fetched from the npm registry or github.
+func (task *BuildTask) upstreamFetch() UpstreamFetch {
+	if task.Pkg.FromGithub {
+		return UpstreamFetch{URL: fmt.Sprintf("https://github.com/%s", task.Pkg.Name)}
+	}
+	return UpstreamFetch{URL: getRegistryURL(task.registry, task.Pkg.Name)}
+}
+
+// checkUpstreamHost checks the upstream of the package with the `audit.allowHosts` config
+// before fetching it, the urls of the dependencies are checked by `auditBuild` after installing.
+func (task *BuildTask) checkUpstreamHost() error {
+	if !cfg.Audit.Enabled || task.Pkg.FromEsmsh {
+		return nil
+	}
+	return checkUpstreamHosts([]UpstreamFetch{task.upstreamFetch()})
+}
+
+// checkUpstreamHosts returns an error if any of the fetched urls is not allowed
+// by the `audit.allowHosts` config.
+func checkUpstreamHosts(fetches []UpstreamFetch) error {
+	if len(cfg.Audit.AllowHosts) == 0 {
+		return nil
+	}
+	for _, f := range fetches {
+		u, err := neturl.Parse(f.URL)
+		if err != nil || !includes(cfg.Audit.AllowHosts, u.Hostname()) {
+			return fmt.Errorf("audit: upstream '%s' is not allowed", f.URL)
+		}
+	}
+	return nil
+}
+
+// readPnpmLockFetches reads the tarball urls and hashes of the `packages` section of a
+// `pnpm-lock.yaml` file, the `registryURL` function returns the registry url of a package
+// to resolve the tarball url if the lock file only has the integrity.
+func readPnpmLockFetches(filename string, registryURL func(name string) string) (fetches []UpstreamFetch, err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	var section string
+	var name, version string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimed := strings.TrimSpace(line)
+		if trimed == "" || strings.HasPrefix(trimed, "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if indent == 0 {
+			section = strings.TrimSuffix(trimed, ":")
+			continue
+		}
+		if section != "packages" {
+			continue
+		}
+		if indent == 2 && strings.HasSuffix(trimed, ":") {
+			name, version = parsePnpmLockKey(strings.TrimSuffix(trimed, ":"))
+			continue
+		}
+		if indent == 4 && strings.HasPrefix(trimed, "resolution:") && name != "" {
+			resolution := parsePnpmLockFlowMap(strings.TrimSpace(strings.TrimPrefix(trimed, "resolution:")))
+			url := resolution["tarball"]
+			if url == "" && version != "" {
+				url = fmt.Sprintf("%s/-/%s-%s.tgz", registryURL(name), path.Base(name), version)
+			}
+			if url != "" {
+				fetches = append(fetches, UpstreamFetch{URL: url, Integrity: resolution["integrity"]})
+			}
+			name, version = "", ""
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+// parsePnpmLockKey parses the package key of the lock file, the formats are:
+//   - v5: `/name/1.0.0_peer@1.0.0`
+//   - v6: `/name@1.0.0(peer@1.0.0)`
+//   - v9: `name@1.0.0`
+func parsePnpmLockKey(key string) (name string, version string) {
+	key = strings.TrimPrefix(strings.Trim(key, `'"`), "/")
+	if i := strings.IndexByte(key, '('); i > 0 {
+		key = key[:i]
+	}
+	scope := ""
+	if strings.HasPrefix(key, "@") {
+		scope, key = utils.SplitByFirstByte(key, '/')
+		scope += "/"
+	}
+	if strings.ContainsRune(key, '/') {
+		name, version = utils.SplitByFirstByte(key, '/')
+		version, _ = utils.SplitByFirstByte(version, '_')
+	} else {
+		name, version = utils.SplitByFirstByte(key, '@')
+	}
+	return scope + name, version
+}
+
+// parsePnpmLockFlowMap parses a yaml flow map like `{integrity: sha512-xxx, tarball: https://...}`.
+func parsePnpmLockFlowMap(s string) map[string]string {
+	m := map[string]string{}
+	for _, p := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(s, "{"), "}"), ",") {
+		k, v := utils.SplitByFirstByte(strings.TrimSpace(p), ':')
+		if k != "" {
+			m[k] = strings.Trim(strings.TrimSpace(v), `'"`)
+		}
+	}
+	return m
+}
+
+func saveBuildProvenance(provenance BuildProvenance) error {
+	return db.Put("_provenance:"+provenance.ID, utils.MustEncodeJSON(provenance))
+}
+
+func getBuildProvenance(id string) (provenance BuildProvenance, err error) {
+	data, err := db.Get("_provenance:" + id)
+	if err != nil {
+		return
+	}
+	if data == nil {
+		err = storage.ErrNotFound
+		return
+	}
+	err = json.Unmarshal(data, &provenance)
+	return
+}
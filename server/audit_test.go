@@ -0,0 +1,89 @@
+package server
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/esm-dev/esm.sh/server/config"
+)
+
+func TestReadPnpmLockFetches(t *testing.T) {
+	lockfile := `lockfileVersion: '6.0'
+
+dependencies:
+  react:
+    specifier: 18.2.0
+    version: 18.2.0
+
+packages:
+
+  /@babel/runtime@7.23.2:
+    resolution: {integrity: sha512-aaa==}
+    engines: {node: '>=6.9.0'}
+    dev: false
+
+  /react-dom@18.2.0(react@18.2.0):
+    resolution: {integrity: sha512-bbb==}
+    peerDependencies:
+      react: ^18.2.0
+
+  github.com/esm-dev/foo/abcdef:
+    resolution: {tarball: https://codeload.github.com/esm-dev/foo/tar.gz/abcdef}
+    name: foo
+`
+	filename := path.Join(t.TempDir(), "pnpm-lock.yaml")
+	err := os.WriteFile(filename, []byte(lockfile), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetches, err := readPnpmLockFetches(filename, func(name string) string {
+		return "https://registry.npmjs.org/" + name
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []UpstreamFetch{
+		{URL: "https://registry.npmjs.org/@babel/runtime/-/runtime-7.23.2.tgz", Integrity: "sha512-aaa=="},
+		{URL: "https://registry.npmjs.org/react-dom/-/react-dom-18.2.0.tgz", Integrity: "sha512-bbb=="},
+		{URL: "https://codeload.github.com/esm-dev/foo/tar.gz/abcdef"},
+	}
+	if len(fetches) != len(expected) {
+		t.Fatalf("expected %d fetches, got %v", len(expected), fetches)
+	}
+	for i, f := range fetches {
+		if f != expected[i] {
+			t.Fatalf("expected %v, got %v", expected[i], f)
+		}
+	}
+}
+
+func TestParsePnpmLockKey(t *testing.T) {
+	for key, expected := range map[string][2]string{
+		"/react/18.2.0":                   {"react", "18.2.0"},
+		"/react-dom/18.2.0_react@18.2.0":  {"react-dom", "18.2.0"},
+		"/@babel/core/7.23.2":             {"@babel/core", "7.23.2"},
+		"/react-dom@18.2.0(react@18.2.0)": {"react-dom", "18.2.0"},
+		"'@babel/core@7.23.2'":            {"@babel/core", "7.23.2"},
+		"react@18.2.0":                    {"react", "18.2.0"},
+	} {
+		name, version := parsePnpmLockKey(key)
+		if name != expected[0] || version != expected[1] {
+			t.Fatalf("parsePnpmLockKey(%s): expected %v, got %s@%s", key, expected, name, version)
+		}
+	}
+}
+
+func TestCheckUpstreamHost(t *testing.T) {
+	withConfig(t, &config.Config{
+		NpmRegistry: "https://registry.npmjs.org/",
+		Audit:       config.Audit{Enabled: true, AllowHosts: []string{"registry.npmjs.org"}},
+	})
+
+	if err := (&BuildTask{Pkg: Pkg{Name: "react", Version: "18.2.0"}}).checkUpstreamHost(); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&BuildTask{Pkg: Pkg{Name: "esm-dev/esm.sh", Version: "main", FromGithub: true}}).checkUpstreamHost(); err == nil {
+		t.Fatal("github.com should not be allowed")
+	}
+}
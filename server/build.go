@@ -2,17 +2,22 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha512"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/evanw/esbuild/pkg/api"
@@ -26,6 +31,34 @@ type ESMBuild struct {
 	Dts              string   `json:"t"`
 	TypesOnly        bool     `json:"o"`
 	PackageCSS       bool     `json:"s"`
+	// the build options that produced the artifact
+	Target string            `json:"tg,omitempty"`
+	Dev    bool              `json:"dv,omitempty"`
+	Deps   []string          `json:"dp,omitempty"`
+	Alias  map[string]string `json:"al,omitempty"`
+	// the entry fallback of the build for debugging, e.g. "module(esm/index.js) -> main(index.js)"
+	Fallback string `json:"fb,omitempty"`
+	// the higher target that the build falls back to since the syntax of the package can't be
+	// lowered to the build target, e.g. "es2018"
+	FallbackTarget string `json:"ft,omitempty"`
+	// the subresource integrity(sha384) of the build, e.g. "sha384-..."
+	Integrity string `json:"i,omitempty"`
+	// the ES module of the package is served without esbuild, see `passthrough`
+	Passthrough bool `json:"pt,omitempty"`
+}
+
+// setBuildOptions records the build options in the esm build.
+func (esm *ESMBuild) setBuildOptions(target string, dev bool, args BuildArgs) {
+	esm.Target = target
+	esm.Dev = dev
+	esm.Deps = nil
+	for _, dep := range args.deps {
+		esm.Deps = append(esm.Deps, dep.Name+"@"+dep.Version)
+	}
+	esm.Alias = nil
+	if len(args.alias) > 0 {
+		esm.Alias = args.alias
+	}
 }
 
 type BuildTask struct {
@@ -43,15 +76,80 @@ type BuildTask struct {
 	id          string
 	wd          string
 	realWd      string
-	stage       string
-	appendLines int // to fix the source map
+	stage       atomic.Value    // the current stage of the build, read by the queue while building
+	appendLines int             // to fix the source map
+	exports     []string        // the exports of the build, only recorded if the `generateTypes` config is enabled
+	requestID   string          // the id of the request that triggered the build
+	priority    int             // the priority of the request that is marked as high priority by a priority token
+	imports     PkgSlice        // the dependencies that the build imports by url, indexed to purge the dependents
+	ctx         context.Context // canceled if the requests waiting for the build are gone
+	recipe      *Recipe         // the snapshot of the recipe, see `getRecipe`
+	recipeOnce  bool
+}
+
+// setStage sets the current stage of the build.
+func (task *BuildTask) setStage(stage string) {
+	task.stage.Store(stage)
+}
+
+// getStage returns the current stage of the build.
+func (task *BuildTask) getStage() string {
+	stage, _ := task.stage.Load().(string)
+	return stage
+}
+
+// context returns the context of the build, the work stops at the next stage once it's canceled.
+func (task *BuildTask) context() context.Context {
+	if task.ctx == nil {
+		return context.Background()
+	}
+	return task.ctx
+}
+
+// esbuild runs the esbuild build that is canceled when the context of the task is done, e.g.
+// the build is timed out.
+func (task *BuildTask) esbuild(options api.BuildOptions) (result api.BuildResult, err error) {
+	ctx, ctxErr := api.Context(options)
+	if ctxErr != nil {
+		if len(ctxErr.Errors) > 0 {
+			return result, errors.New(ctxErr.Errors[0].Text)
+		}
+		return result, errors.New("esbuild: invalid options")
+	}
+	defer ctx.Dispose()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-task.context().Done():
+			ctx.Cancel()
+		case <-stop:
+		}
+	}()
+
+	result = ctx.Rebuild()
+	err = task.context().Err()
+	return
+}
+
+// writeFile writes the file of the build to the storage, the write stops when the build is canceled.
+func (task *BuildTask) writeFile(name string, r io.Reader) (int64, error) {
+	return fs.WriteFile(name, &contextReader{ctx: task.context(), r: r})
 }
 
 func (task *BuildTask) Build() (esm *ESMBuild, err error) {
+	debugf("build", task.Pkg.Name, "build %s", task.ID())
+
+	err = task.checkUpstreamHost()
+	if err != nil {
+		return
+	}
+
 	// check request package
 	if !task.Pkg.FromEsmsh && !task.Pkg.FromGithub {
 		var p NpmPackage
-		p, _, err = getPackageInfo("", task.Pkg.Name, task.Pkg.Version)
+		p, _, err = getRegistryPackageInfoContext(task.context(), task.registry, "", task.Pkg.Name, task.Pkg.Version)
 		if err != nil {
 			return
 		}
@@ -61,49 +159,19 @@ func (task *BuildTask) Build() (esm *ESMBuild, err error) {
 	pkgVersionName := task.Pkg.VersionName()
 	if task.wd == "" {
 		task.wd = path.Join(cfg.WorkDir, fmt.Sprintf("npm/%s", pkgVersionName))
+		if task.registry != "" {
+			// use a separate work directory for the alternate registry
+			task.wd = path.Join(cfg.WorkDir, fmt.Sprintf("registries/%s/npm/%s", task.registry, pkgVersionName))
+		}
 		err = ensureDir(task.wd)
 		if err != nil {
 			return
 		}
 
-		if cfg.NpmToken != "" || (cfg.NpmUser != "" && cfg.NpmPassword != "") {
-			rcFilePath := path.Join(task.wd, ".npmrc")
-			if !fileExists(rcFilePath) {
-				var output bytes.Buffer
-
-				if cfg.NpmRegistryScope != "" && cfg.NpmRegistry != "" {
-					output.WriteString(fmt.Sprintf("%s:registry=%s\n", cfg.NpmRegistryScope, cfg.NpmRegistry))
-				} else if cfg.NpmRegistryScope == "" && cfg.NpmRegistry != "" {
-					output.WriteString(fmt.Sprintf("registry=%s\n", cfg.NpmRegistry))
-				}
-
-				if cfg.NpmRegistry != "" && cfg.NpmToken != "" {
-					var tokenReg string
-					tokenReg, err = removeHttpPrefix(cfg.NpmRegistry)
-					if err != nil {
-						log.Errorf("Invalid npm registry in config: %v", err)
-						return
-					}
-					output.WriteString(fmt.Sprintf("%s:_authToken=${ESM_NPM_TOKEN}\n", tokenReg))
-				}
-
-				if cfg.NpmRegistry != "" && cfg.NpmUser != "" && cfg.NpmPassword != "" {
-					var tokenReg string
-					tokenReg, err = removeHttpPrefix(cfg.NpmRegistry)
-					if err != nil {
-						log.Errorf("Invalid npm registry in config: %v", err)
-						return
-					}
-					output.WriteString(fmt.Sprintf("%s:username=${ESM_NPM_USER}\n", tokenReg))
-					output.WriteString(fmt.Sprintf("%s:_password=${ESM_NPM_PASSWORD}\n", tokenReg))
-				}
-
-				err = os.WriteFile(rcFilePath, output.Bytes(), 0644)
-				if err != nil {
-					log.Errorf("Failed to create .npmrc file: %v", err)
-					return
-				}
-			}
+		err = writeNpmrc(task.wd, task.registry)
+		if err != nil {
+			log.Errorf("Failed to create .npmrc file: %v", err)
+			return
 		}
 	}
 
@@ -115,19 +183,57 @@ func (task *BuildTask) Build() (esm *ESMBuild, err error) {
 		toPurge(pkgVersionName, dir)
 	}(task.wd, pkgVersionName)
 
-	task.stage = "install"
+	wdLock := getWorkDirLock(task.wd)
+	wdLock.RLock()
+	defer func() { wdLock.RUnlock() }()
+
+	task.setStage("install")
+	if err = task.context().Err(); err != nil {
+		return
+	}
 
-	err = installPackage(task.wd, task.Pkg)
+	err = installPackage(task.context(), task.wd, task.Pkg, task.registry)
 	if err != nil {
 		return
 	}
 
+	if cfg.Audit.Enabled {
+		provenance, auditErr := task.auditBuild()
+		if e := saveBuildProvenance(provenance); e != nil {
+			log.Errorf("db: %v", e)
+		}
+		if auditErr != nil {
+			err = auditErr
+			return
+		}
+	}
+
 	if task.Target == "raw" {
 		return
 	}
 
-	task.stage = "build"
-	return task.build()
+	task.setStage("build")
+	if err = task.context().Err(); err != nil {
+		return
+	}
+	esm, err = task.build()
+	if err != nil && task.context().Err() == nil && isPartialInstallError(err, task.wd) {
+		log.Warnf("build %s: %v, reinstall %s", task.ID(), err, pkgVersionName)
+		task.setStage("install")
+		// wait for the other builds of the work directory to finish before removing the files
+		wdLock.RUnlock()
+		wdLock.Lock()
+		err = reinstallPackage(task.context(), task.wd, task.Pkg, task.registry)
+		wdLock.Unlock()
+		wdLock.RLock()
+		if err != nil {
+			return
+		}
+		task.realWd = ""
+		task.setStage("build")
+		esm, err = task.build()
+	}
+	return
 }
 
 func (task *BuildTask) build() (esm *ESMBuild, err error) {
@@ -142,7 +248,7 @@ func (task *BuildTask) build() (esm *ESMBuild, err error) {
 			}
 			buffer := bytes.NewBufferString("export default ")
 			buffer.Write(json)
-			_, err = fs.WriteFile(task.getSavepath(), buffer)
+			_, err = task.writeFile(task.getSavepath(), buffer)
 			if err != nil {
 				return nil, err
 			}
@@ -169,12 +275,26 @@ func (task *BuildTask) build() (esm *ESMBuild, err error) {
 
 	if esm.TypesOnly {
 		dts := npm.Name + "@" + npm.Version + path.Join("/", npm.Types)
-		esm.Dts = fmt.Sprintf("/v%d%s/%s", task.BuildVersion, task.ghPrefix(), dts)
+		esm.Dts = fmt.Sprintf("%s%s/%s", getTypesPathPrefix(task.BuildVersion), task.ghPrefix(), dts)
 		task.buildDTS(dts)
 		task.storeToDB(esm)
 		return
 	}
 
+	if task.lazy && task.Pkg.Submodule == "" {
+		err = task.buildLazyIndex(esm, npm)
+		if err != nil {
+			return
+		}
+		task.checkDTS(esm, npm)
+		err = task.buildLazyIndexDTS(esm)
+		if err != nil {
+			return
+		}
+		task.storeToDB(esm)
+		return
+	}
+
 	if reexport != "" {
 		p, _, e := task.getPackageInfo(reexport, "latest")
 		if e != nil {
@@ -205,7 +325,7 @@ func (task *BuildTask) build() (esm *ESMBuild, err error) {
 			fmt.Fprintf(buf, `export { default } from "%s";`, importPath)
 		}
 
-		_, err = fs.WriteFile(task.getSavepath(), buf)
+		_, err = task.writeFile(task.getSavepath(), buf)
 		if err != nil {
 			return
 		}
@@ -220,6 +340,20 @@ func (task *BuildTask) build() (esm *ESMBuild, err error) {
 		}
 	}()
 
+	// serve the ES module without esbuild if it's possible
+	if cfg.EsmPassthrough {
+		var ok bool
+		ok, err = task.passthrough(esm, npm)
+		if err != nil {
+			return
+		}
+		if ok {
+			task.checkDTS(esm, npm)
+			task.storeToDB(esm)
+			return
+		}
+	}
+
 	var entryPoint string
 	var input *api.StdinOptions
 
@@ -241,7 +375,36 @@ func (task *BuildTask) build() (esm *ESMBuild, err error) {
 			}
 		}
 		fmt.Fprintf(buf, "const { default: __default, ...__rest } = __module;")
-		fmt.Fprintf(buf, "export default (__default !== undefined ? __default : __rest);")
+		global := task.global
+		if recipe, ok := task.getRecipe(); ok && global == "" {
+			global = recipe.Global
+		}
+		if global != "" {
+			// the UMD package may attach the exports onto the global instead of the `module.exports`,
+			// capture the global after the evaluation only if the module exports nothing, and re-export
+			// its properties
+			fmt.Fprintf(buf, "const __exports = __default !== undefined ? __default : __rest;")
+			fmt.Fprintf(buf, `const __global = __module["%s"] ?? (__exports === null || (typeof __exports === "object" && Object.keys(__exports).length === 0) ? globalThis["%s"] ?? __exports : __exports);`, global, global)
+			fmt.Fprintf(buf, "export default __global;")
+			var exports []string
+			if filename, ok := resolveCJSFile(path.Join(task.wd, "node_modules", npm.Name, npm.Main)); ok {
+				if code, e := os.ReadFile(filename); e == nil {
+					for _, k := range lexUMDGlobalExports(string(code), global) {
+						if k != global && !includes(esm.NamedExports, k) {
+							exports = append(exports, k)
+						}
+					}
+				}
+			}
+			if len(exports) > 0 {
+				fmt.Fprintf(buf, `export const { %s } = __global;`, strings.Join(exports, ","))
+			}
+			if !includes(esm.NamedExports, global) {
+				fmt.Fprintf(buf, "export { __global as %s };", global)
+			}
+		} else {
+			fmt.Fprintf(buf, "export default (__default !== undefined ? __default : __rest);")
+		}
 		// Default reexport all members from original module to prevent missing named exports members
 		fmt.Fprintf(buf, `export * from "%s";`, importPath)
 		input = &api.StdinOptions{
@@ -253,7 +416,10 @@ func (task *BuildTask) build() (esm *ESMBuild, err error) {
 		if task.treeShaking.Len() > 0 {
 			buf := bytes.NewBuffer(nil)
 			importPath := task.Pkg.ImportPath()
-			fmt.Fprintf(buf, `export { %s } from "%s";`, strings.Join(task.treeShaking.Values(), ","), importPath)
+			// the exports are sorted since the build ID doesn't depend on the order of the `?exports` query
+			exports := task.treeShaking.Values()
+			sort.Strings(exports)
+			fmt.Fprintf(buf, `export { %s } from "%s";`, strings.Join(exports, ","), importPath)
 			input = &api.StdinOptions{
 				Contents:   buf.String(),
 				ResolveDir: task.wd,
@@ -285,9 +451,16 @@ func (task *BuildTask) build() (esm *ESMBuild, err error) {
 		"global.require.resolve":      "__rResolve$",
 		"global.process.env.NODE_ENV": fmt.Sprintf(`"%s"`, nodeEnv),
 	}
-	externalDeps := &orderedStringSet{}
+	// the resolver may be called concurrently, the external deps are sorted when writing the output
+	externalDeps := newStringSet()
 	implicitExternal := newStringSet()
 	browserExclude := map[string]*stringSet{}
+	// the target of esbuild, it's raised if the syntax of the package can't be lowered to the build target
+	esbuildTarget := task.Target
+	recipe, _ := task.getRecipe()
+	if recipe == nil {
+		recipe = &Recipe{}
+	}
 
 rebuild:
 	options := api.BuildOptions{
@@ -295,14 +468,14 @@ rebuild:
 		Write:             false,
 		Bundle:            true,
 		Conditions:        task.conditions.Values(),
-		Target:            targets[task.Target],
+		Target:            targets[esbuildTarget],
 		Format:            api.FormatESModule,
 		Platform:          api.PlatformBrowser,
-		MinifyWhitespace:  !task.Dev,
-		MinifyIdentifiers: !task.Dev,
-		MinifySyntax:      !task.Dev,
-		KeepNames:         task.keepNames,         // prevent class/function names erasing
-		IgnoreAnnotations: task.ignoreAnnotations, // some libs maybe use wrong side-effect annotations
+		MinifyWhitespace:  !task.Dev && !recipe.NoMinify,
+		MinifyIdentifiers: !task.Dev && !recipe.NoMinify,
+		MinifySyntax:      !task.Dev && !recipe.NoMinify,
+		KeepNames:         task.keepNames || task.Dev, // prevent class/function names erasing
+		IgnoreAnnotations: task.ignoreAnnotations,     // some libs maybe use wrong side-effect annotations
 		PreserveSymlinks:  true,
 		Plugins: []api.Plugin{{
 			Name: "esm",
@@ -310,6 +483,7 @@ rebuild:
 				build.OnResolve(
 					api.OnResolveOptions{Filter: ".*"},
 					func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+						debugf("resolver", task.Pkg.Name, "resolve '%s' (importer: %s)", args.Path, args.Importer)
 						if strings.HasPrefix(args.Path, "file:") {
 							return api.OnResolveResult{
 								Path:     fmt.Sprintf("/error.js?type=unsupported-file-dependency&name=%s&importer=%s", strings.TrimPrefix(args.Path, "file:"), task.Pkg.Name),
@@ -324,6 +498,9 @@ rebuild:
 						if strings.HasSuffix(args.Path, ".json") {
 							jsonFile := filepath.Join(args.ResolveDir, args.Path)
 							if fileExists(jsonFile) {
+								if _, err := realpathIn(task.wd, jsonFile); err != nil {
+									return api.OnResolveResult{}, err
+								}
 								return api.OnResolveResult{Path: jsonFile}, nil
 							}
 						}
@@ -335,6 +512,9 @@ rebuild:
 						if strings.HasSuffix(args.Path, ".wasm") {
 							fullFilepath := filepath.Join(args.ResolveDir, args.Path)
 							if fileExists(fullFilepath) {
+								if _, err := realpathIn(task.wd, fullFilepath); err != nil {
+									return api.OnResolveResult{}, err
+								}
 								return api.OnResolveResult{Path: fullFilepath, Namespace: "wasm"}, nil
 							}
 						}
@@ -346,6 +526,9 @@ rebuild:
 									version := "latest"
 									if pkgName == task.Pkg.Name {
 										version = task.Pkg.Version
+									} else if dep, ok := task.deps.Get(pkgName); ok {
+										// use version defined in `?deps` query
+										version = dep.Version
 									} else if v, ok := npm.Dependencies[pkgName]; ok {
 										version = v
 									} else if v, ok := npm.PeerDependencies[pkgName]; ok {
@@ -376,11 +559,33 @@ rebuild:
 							return api.OnResolveResult{Path: args.Path, External: true}, nil
 						}
 
+						// resolve the `#` specifier by the `imports` field of package.json
+						if strings.HasPrefix(args.Path, "#") {
+							pkgDir, imports := findPackageImports(args.ResolveDir, filepath.FromSlash(task.wd))
+							if target, ok := resolvePackageImports(imports, args.Path, task.getImportsConditions()); ok {
+								if strings.HasPrefix(target, "./") {
+									return api.OnResolveResult{Path: filepath.Join(pkgDir, filepath.FromSlash(target))}, nil
+								}
+								// maps to a dependency
+								args.Path = target
+							}
+						}
+
 						// clean specifier
 						specifier := strings.TrimSuffix(args.Path, "/")
 						specifier = strings.TrimPrefix(specifier, "node:")
 						specifier = strings.TrimPrefix(specifier, "npm:")
 
+						// the local dependencies are not installed, see `fixLocalDependencyVersion`
+						if pkgName, _ := splitPkgPath(specifier); isLocalDependencyVersion(npm.Dependencies[pkgName]) {
+							return api.OnResolveResult{Path: fmt.Sprintf(
+								"%s/error.js?type=unsupported-file-dependency&name=%s&importer=%s",
+								cfg.BasePath,
+								pkgName,
+								task.Pkg.Name,
+							), External: true}, nil
+						}
+
 						// bundle polyfills if `?bundle` is set for browser target
 						if task.Bundle && !task.isServerTarget() {
 							data, err := embedFS.ReadFile(("server/embed/polyfills/node_" + specifier))
@@ -398,7 +603,7 @@ rebuild:
 							spec := specifier
 							if strings.HasPrefix(specifier, "./") || strings.HasPrefix(specifier, "../") || specifier == ".." {
 								fullFilepath := filepath.Join(args.ResolveDir, specifier)
-								spec = "." + strings.TrimPrefix(fullFilepath, path.Join(task.wd, "node_modules", npm.Name))
+								spec = "." + strings.TrimPrefix(filepath.ToSlash(fullFilepath), path.Join(task.wd, "node_modules", npm.Name))
 							}
 							if name, ok := npm.Browser[spec]; ok {
 								if name == "" {
@@ -413,11 +618,11 @@ rebuild:
 							}
 						}
 
-						// use `?alias` query
-						if len(task.alias) > 0 {
-							if name, ok := task.alias[specifier]; ok {
-								specifier = name
-							}
+						// use `?alias` query, or the alias of the recipe
+						if name, ok := task.alias[specifier]; ok {
+							specifier = name
+						} else if name, ok := recipe.Alias[specifier]; ok {
+							specifier = name
 						}
 
 						// bundles all dependencies in `bundle` mode, apart from peer dependencies and `?external` query
@@ -431,6 +636,26 @@ rebuild:
 							}
 						}
 
+						// bundles the packages of the scopes with `?bundle-scope` query, apart from peer dependencies
+						if task.bundleScopes != nil && task.bundleScopes.Len() > 0 && strings.HasPrefix(specifier, "@") && !implicitExternal.Has(specifier) && !task.external.Has(specifier) {
+							pkgName, _ := splitPkgPath(specifier)
+							scope, _ := utils.SplitByFirstByte(pkgName, '/')
+							if _, isPeer := npm.PeerDependencies[pkgName]; !isPeer && pkgName != task.Pkg.Name && task.bundleScopes.Has(scope) {
+								return api.OnResolveResult{}, nil
+							}
+						}
+
+						// bundles the tiny dependencies with `?bundle-deps-under` query
+						if task.bundleDepsUnder > 0 && !task.Bundle && !isLocalSpecifier(specifier) && !isRemoteSpecifier(specifier) && !implicitExternal.Has(specifier) && !task.external.Has(specifier) {
+							pkgName, subpath := splitPkgPath(specifier)
+							if subpath == "" && pkgName != task.Pkg.Name && !builtInNodeModules[pkgName] {
+								_, isPeer := npm.PeerDependencies[pkgName]
+								if size, ok := task.getDepEntrySize(args.ResolveDir, pkgName); !isPeer && ok && size < task.bundleDepsUnder {
+									return api.OnResolveResult{}, nil
+								}
+							}
+						}
+
 						if v, ok := npm.Dependencies[args.Path]; ok && (strings.HasPrefix(v, "git+ssh://") || strings.HasPrefix(v, "git+https://") || strings.HasPrefix(v, "git://")) {
 							gitUrl, err := url.Parse(v)
 							if err == nil && gitUrl.Hostname() == "github.com" {
@@ -449,29 +674,6 @@ rebuild:
 							}
 						}
 
-						// resolve path by `imports` of package.json
-						if v, ok := npm.Imports[args.Path]; ok {
-							if s, ok := v.(string); ok {
-								return api.OnResolveResult{
-									Path: path.Join(task.wd, "node_modules", npm.Name, s),
-								}, nil
-							} else if m, ok := v.(map[string]interface{}); ok {
-								targets := []string{"browser", "default", "node"}
-								if task.isServerTarget() {
-									targets = []string{"node", "default", "browser"}
-								}
-								for _, t := range targets {
-									if v, ok := m[t]; ok {
-										if s, ok := v.(string); ok {
-											return api.OnResolveResult{
-												Path: path.Join(task.wd, "node_modules", npm.Name, s),
-											}, nil
-										}
-									}
-								}
-							}
-						}
-
 						// externalize the main module
 						// e.g. "react/jsx-runtime" imports "react"
 						if task.Pkg.Submodule != "" && task.Pkg.Name == specifier {
@@ -488,7 +690,7 @@ rebuild:
 						// see https://nodejs.org/api/packages.html
 						if (strings.HasPrefix(specifier, "./") || strings.HasPrefix(specifier, "../") || specifier == "..") && !strings.HasSuffix(specifier, ".js") && !strings.HasSuffix(specifier, ".mjs") && !strings.HasSuffix(specifier, ".json") {
 							fullFilepath := filepath.Join(args.ResolveDir, specifier)
-							spec := "." + strings.TrimPrefix(fullFilepath, path.Join(task.wd, "node_modules", npm.Name))
+							spec := "." + strings.TrimPrefix(filepath.ToSlash(fullFilepath), path.Join(task.wd, "node_modules", npm.Name))
 							// bundle {pkgName}/{pkgName}.js
 							if spec == fmt.Sprintf("./%s.js", task.Pkg.Name) {
 								return api.OnResolveResult{}, nil
@@ -538,15 +740,16 @@ rebuild:
 								return api.OnResolveResult{}, nil
 							}
 
-							// bundle if this pkg has 'exports' definitions
-							if npm.DefinedExports != nil && !reflect.ValueOf(npm.DefinedExports).IsNil() {
+							// bundle if this pkg has 'exports' definitions, except the submodules loaded by the lazy
+							// index that share the local modules to avoid duplicated module states
+							if !task.lazy && npm.DefinedExports != nil && !reflect.ValueOf(npm.DefinedExports).IsNil() {
 								return api.OnResolveResult{}, nil
 							}
 
 							// otherwise do not bundle its local dependencies
 							fullFilepath := filepath.Join(args.ResolveDir, specifier)
 							// convert: full filepath -> package name + submodule path
-							specifier = strings.TrimPrefix(fullFilepath, filepath.Join(task.wd, "node_modules")+"/")
+							specifier = strings.TrimPrefix(filepath.ToSlash(fullFilepath), path.Join(task.wd, "node_modules")+"/")
 							externalDeps.Add(specifier)
 							return api.OnResolveResult{Path: "__ESM_SH_EXTERNAL:" + specifier, External: true}, nil
 						}
@@ -608,6 +811,16 @@ rebuild:
 						return api.OnLoadResult{Contents: &contents, Loader: api.LoaderJS}, nil
 					},
 				)
+
+				// the packages are linked to the `.pnpm` store by pnpm, don't load the files
+				// that are linked out of the build dir
+				build.OnLoad(
+					api.OnLoadOptions{Filter: ".*", Namespace: "file"},
+					func(args api.OnLoadArgs) (ret api.OnLoadResult, err error) {
+						_, err = realpathIn(task.wd, args.Path)
+						return
+					},
+				)
 			},
 		}},
 		// for css bundle
@@ -623,17 +836,45 @@ rebuild:
 		SourceRoot: "/",
 		Sourcemap:  api.SourceMapExternal,
 	}
+	if task.Dev {
+		// keep the code readable for debugging, the source map is inlined in the output as well.
+		// the tree shaking is kept for the `?exports` query that filters the exports
+		if task.treeShaking.Len() == 0 {
+			options.TreeShaking = api.TreeShakingFalse
+		}
+		options.LegalComments = api.LegalCommentsInline
+	}
+	if task.mv3 {
+		// inline the assets since the extension can't load remote resources
+		for _, ext := range []string{".jpg", ".jpeg", ".gif", ".avif", ".ico"} {
+			options.Loader[ext] = api.LoaderDataURL
+		}
+	}
 	if task.Target == "node" {
 		options.Platform = api.PlatformNode
 	} else {
 		options.Define = define
 	}
+	if len(recipe.Define) > 0 {
+		if options.Define == nil {
+			options.Define = map[string]string{}
+		}
+		for k, v := range recipe.Define {
+			options.Define[k] = v
+		}
+	}
 	if input != nil {
 		options.Stdin = input
 	} else if entryPoint != "" {
 		options.EntryPoints = []string{entryPoint}
 	}
-	result := api.Build(options)
+	// the metafile is stored for the `?analyze` query if the `buildAnalysis` config is enabled
+	options.Metafile = cfg.BuildAnalysis || cfg.GenerateTypes
+	// the canceled build returns the context error here, nothing of it is stored
+	result, err := task.esbuild(options)
+	if err != nil {
+		return
+	}
 	if len(result.Errors) > 0 {
 		// mark the missing module as external to exclude it from the bundle
 		msg := result.Errors[0].Text
@@ -668,9 +909,19 @@ rebuild:
 				}
 			}
 		}
+		if isLoweringError(msg) {
+			if next, ok := getNextLoweringTarget(esbuildTarget); ok {
+				log.Warnf("esbuild(%s): %s, fallback to %s", task.ID(), msg, next)
+				esbuildTarget = next
+				goto rebuild
+			}
+		}
 		err = errors.New("esbuild: " + msg)
 		return
 	}
+	if esbuildTarget != task.Target {
+		esm.FallbackTarget = esbuildTarget
+	}
 
 	for _, w := range result.Warnings {
 		if strings.HasPrefix(w.Text, "Could not resolve \"") {
@@ -678,18 +929,24 @@ rebuild:
 		}
 	}
 
+	if cfg.GenerateTypes && result.Metafile != "" {
+		task.exports = parseMetafileExports(result.Metafile)
+	}
+
 	eol := "\n"
 
 	// TODO: using `__ESM_SH_EXTERNAL` sucks! must be refactored!!!
-	for _, file := range result.OutputFiles {
+	task.imports = PkgSlice{}
+	for i, file := range result.OutputFiles {
 		if strings.HasSuffix(file.Path, ".js") {
 			jsContent := file.Contents
-			header := bytes.NewBufferString(fmt.Sprintf(
-				"/* esm.sh - esbuild bundle(%s) %s %s */\n",
-				task.Pkg.String(),
-				strings.ToLower(task.Target),
-				nodeEnv,
-			))
+			// drop the reference of the output file, so the original content can be collected once the
+			// external imports are rewritten
+			result.OutputFiles[i].Contents = nil
+			// the cjs imports are prepended to the content when writing to avoid one more copy of the content
+			var cjsImports []byte
+			// the build metadata is sent in the `X-Esm-*` headers to keep the file byte-stable
+			header := bytes.NewBuffer(nil)
 
 			esModuleAnn := bytes.Contains(jsContent, []byte("__esModule"))
 
@@ -700,139 +957,12 @@ rebuild:
 			}
 
 			// replace external imports/requires
-			for depIndex, name := range externalDeps.Values() {
-				var importPath string
-				// remote imports
-				if isRemoteSpecifier(name) || task.external.Has(name) {
-					importPath = name
-				}
-				// sub module
-				if importPath == "" && strings.HasPrefix(name, task.Pkg.Name+"/") {
-					subPath := strings.TrimPrefix(name, task.Pkg.Name+"/")
-					subPkg := Pkg{
-						Name:      task.Pkg.Name,
-						Version:   task.Pkg.Version,
-						Subpath:   subPath,
-						Submodule: toModuleName(subPath),
-					}
-					importPath = task.getImportPath(subPkg, encodeBuildArgsPrefix(task.BuildArgs, subPkg, false))
-				}
-				// node builtin module
-				if importPath == "" && builtInNodeModules[name] {
-					if task.Target == "node" {
-						importPath = fmt.Sprintf("node:%s", name)
-					} else if task.Target == "denonext" && !denoNextUnspportedNodeModules[name] {
-						importPath = fmt.Sprintf("node:%s", name)
-					} else if task.Target == "deno" {
-						importPath = fmt.Sprintf("https://deno.land/std@%s/node/%s.ts", task.denoStdVersion, name)
-					} else {
-						polyfill, ok := polyfilledBuiltInNodeModules[name]
-						if ok {
-							p, _, e := validatePkgPath(polyfill)
-							if e != nil {
-								err = e
-								return
-							}
-							importPath = task.getImportPath(p, "")
-							extname := filepath.Ext(importPath)
-							importPath = strings.TrimSuffix(importPath, extname) + ".bundle" + extname
-						} else {
-							_, err := embedFS.ReadFile(fmt.Sprintf("server/embed/polyfills/node_%s.js", name))
-							if err == nil {
-								importPath = fmt.Sprintf("%s/v%d/node_%s.js", cfg.BasePath, task.BuildVersion, name)
-							} else {
-								importPath = fmt.Sprintf(
-									"%s/error.js?type=unsupported-nodejs-builtin-module&name=%s&importer=%s",
-									cfg.BasePath,
-									name,
-									task.Pkg.Name,
-								)
-							}
-						}
-					}
-				}
-				// external all pattern
-				if importPath == "" && task.external.Has("*") {
-					importPath = name
-				}
-				// use `node_fetch.js` polyfill instead of `node-fetch`
-				if importPath == "" && name == "node-fetch" && task.Target != "node" {
-					importPath = fmt.Sprintf("%s/v%d/node_fetch.js", cfg.BasePath, task.BuildVersion)
-				}
-				// use version defined in `?deps` query
-				if importPath == "" {
-					for _, dep := range task.deps {
-						if name == dep.Name || strings.HasPrefix(name, dep.Name+"/") {
-							var subPath string
-							if name != dep.Name {
-								subPath = strings.TrimPrefix(name, dep.Name+"/")
-							}
-							subPkg := Pkg{
-								Name:      dep.Name,
-								Version:   dep.Version,
-								Subpath:   subPath,
-								Submodule: toModuleName(subPath),
-							}
-							importPath = task.getImportPath(subPkg, encodeBuildArgsPrefix(task.BuildArgs, subPkg, false))
-							break
-						}
-					}
-				}
-				// force the dependency version of `react` equals to react-dom
-				if importPath == "" && task.Pkg.Name == "react-dom" && name == "react" {
-					importPath = task.getImportPath(Pkg{
-						Name:    name,
-						Version: task.Pkg.Version,
-					}, "")
-				}
-				// common npm dependency
-				if importPath == "" {
-					version := "latest"
-					pkgName, subpath := splitPkgPath(name)
-					if pkgName == task.Pkg.Name {
-						version = task.Pkg.Version
-					} else if v, ok := npm.Dependencies[pkgName]; ok {
-						version = v
-					} else if v, ok := npm.PeerDependencies[pkgName]; ok {
-						version = v
-					}
-					p, _, e := task.getPackageInfo(pkgName, version)
-					if e != nil {
-						err = e
-						return
-					}
-
-					pkg := Pkg{
-						Name:      p.Name,
-						Version:   p.Version,
-						Subpath:   subpath,
-						Submodule: toModuleName(subpath),
-					}
-					t := &BuildTask{
-						BuildArgs: BuildArgs{
-							alias:          map[string]string{},
-							deps:           task.deps,
-							external:       task.external,
-							treeShaking:    newStringSet(), // remove `?exports` args
-							conditions:     newStringSet(), // remove `?conditions` args
-							denoStdVersion: task.denoStdVersion,
-						},
-						CdnOrigin:    task.CdnOrigin,
-						BuildVersion: task.BuildVersion,
-						Pkg:          pkg,
-						Target:       task.Target,
-						Dev:          task.Dev,
-					}
-
-					_, ok := queryESMBuild(t.ID())
-					if !ok {
-						buildQueue.Add(t, "")
-					}
-
-					importPath = task.getImportPath(pkg, encodeBuildArgsPrefix(t.BuildArgs, pkg, false))
-				}
-				if importPath == "" {
-					err = fmt.Errorf("could not resolve \"%s\" (Imported by \"%s\")", name, task.Pkg.Name)
+			deps := externalDeps.Values()
+			sort.Strings(deps)
+			for depIndex, name := range deps {
+				importPath, e := task.resolveExternalImportPath(name, npm)
+				if e != nil {
+					err = e
 					return
 				}
 
@@ -840,6 +970,7 @@ rebuild:
 				cjsContext := false
 				cjsImportNames := newStringSet()
 				buffer := bytes.NewBuffer(nil)
+				buffer.Grow(len(jsContent))
 				slice := bytes.Split(jsContent, []byte(fmt.Sprintf("\"__ESM_SH_EXTERNAL:%s\"", name)))
 
 				// walk output content to find all external dependencies
@@ -990,10 +1121,9 @@ rebuild:
 						}
 					}
 					task.appendLines += strings.Count(buf.String(), eol)
-					jsContent = concatBytes(buf.Bytes(), buffer.Bytes())
-				} else {
-					jsContent = buffer.Bytes()
+					cjsImports = append(buf.Bytes(), cjsImports...)
 				}
+				jsContent = buffer.Bytes()
 			}
 
 			// add nodejs compatibility
@@ -1034,21 +1164,50 @@ rebuild:
 			// to fix the source map
 			task.appendLines += strings.Count(header.String(), eol)
 
-			finalContent := bytes.NewBuffer(nil)
-			finalContent.Write(header.Bytes())
-			finalContent.Write(rewriteJS(task, jsContent))
+			footer := bytes.NewBuffer(nil)
 
 			// check if package is deprecated
 			if task.Deprecated != "" {
-				fmt.Fprintf(finalContent, `console.warn("[npm] %%cdeprecated%%c %s@%s: %s", "color:red", "");%s`, task.Pkg.Name, task.Pkg.Version, task.Deprecated, "\n")
+				fmt.Fprintf(footer, `console.warn("[npm] %%cdeprecated%%c %s@%s: %s", "color:red", "");%s`, task.Pkg.Name, task.Pkg.Version, task.Deprecated, "\n")
+			}
+
+			jsContent = rewriteJS(task, jsContent)
+
+			// check the constraints of browser extensions(manifest v3)
+			if task.mv3 {
+				var problems []string
+				for _, code := range [][]byte{header.Bytes(), cjsImports, jsContent} {
+					problems = append(problems, checkMV3Constraints(code)...)
+				}
+				for _, problem := range problems {
+					log.Warnf("mv3: %s %s", task.Pkg, problem)
+					fmt.Fprintf(footer, `console.warn("[esm.sh] %%cmv3%%c %s %s", "color:red", "");%s`, task.Pkg, strings.ReplaceAll(problem, `"`, `\"`), "\n")
+				}
 			}
 
 			// add sourcemap Url
-			finalContent.WriteString("//# sourceMappingURL=")
-			finalContent.WriteString(filepath.Base(task.ID()))
-			finalContent.WriteString(".map")
+			var sourceMap map[string]interface{}
+			if task.Dev {
+				// inline the source map in dev mode
+				sourceMap = getSourceMapOutput(result.OutputFiles, task.appendLines)
+			}
+			footer.WriteString("//# sourceMappingURL=")
+			if sourceMap != nil {
+				footer.WriteString("data:application/json;charset=utf-8;base64,")
+				footer.WriteString(base64.StdEncoding.EncodeToString(utils.MustEncodeJSON(sourceMap)))
+			} else {
+				footer.WriteString(filepath.Base(task.ID()))
+				footer.WriteString(".map")
+			}
 
-			_, err = fs.WriteFile(task.getSavepath(), finalContent)
+			// write the parts without concatenating them, note the content is still held in memory since
+			// esbuild returns the output files in memory
+			err = task.writeJS(esm, io.MultiReader(
+				header,
+				bytes.NewReader(cjsImports),
+				bytes.NewReader(jsContent),
+				footer,
+			))
 			if err != nil {
 				return
 			}
@@ -1058,42 +1217,231 @@ rebuild:
 	for _, file := range result.OutputFiles {
 		if strings.HasSuffix(file.Path, ".css") {
 			savePath := task.getSavepath()
-			_, err = fs.WriteFile(strings.TrimSuffix(savePath, path.Ext(savePath))+".css", bytes.NewReader(file.Contents))
+			_, err = task.writeFile(strings.TrimSuffix(savePath, path.Ext(savePath))+".css", bytes.NewReader(file.Contents))
 			if err != nil {
 				return
 			}
 			esm.PackageCSS = true
 		} else if strings.HasSuffix(file.Path, ".js.map") {
-			var sourceMap map[string]interface{}
-			if json.Unmarshal(file.Contents, &sourceMap) == nil {
-				if mapping, ok := sourceMap["mappings"].(string); ok {
-					fixedMapping := make([]byte, task.appendLines+len(mapping))
-					for i := 0; i < task.appendLines; i++ {
-						fixedMapping[i] = ';'
-					}
-					copy(fixedMapping[task.appendLines:], mapping)
-					sourceMap["mappings"] = string(fixedMapping)
-				}
-				buf := bytes.NewBuffer(nil)
-				if json.NewEncoder(buf).Encode(sourceMap) == nil {
-					_, err = fs.WriteFile(task.getSavepath()+".map", buf)
-					if err != nil {
-						return
-					}
+			if sourceMap := fixSourceMap(file.Contents, task.appendLines); sourceMap != nil {
+				// encode the source map to the storage without an intermediate buffer
+				r, w := io.Pipe()
+				go func() {
+					w.CloseWithError(json.NewEncoder(w).Encode(sourceMap))
+				}()
+				_, err = task.writeFile(task.getSavepath()+".map", r)
+				r.Close()
+				if err != nil {
+					return
 				}
 			}
 		}
 	}
 
+	if cfg.BuildAnalysis && result.Metafile != "" {
+		var metafile []byte
+		metafile, err = compactMetafile(result.Metafile)
+		if err != nil {
+			return
+		}
+		_, err = task.writeFile(task.getSavepath()+".metafile.json", bytes.NewReader(metafile))
+		if err != nil {
+			return
+		}
+	}
+
 	task.checkDTS(esm, npm)
 	task.storeToDB(esm)
 	return
 }
 
+// writeJS writes the js output of the build to the storage, the subresource integrity of the
+// content is computed as it's written.
+func (task *BuildTask) writeJS(esm *ESMBuild, r io.Reader) (err error) {
+	h := sha512.New384()
+	r = io.TeeReader(r, h)
+	// send the content to the waiting requests as it's written
+	stream := takeBuildStream(task.getSavepath())
+	if stream != nil {
+		r = io.TeeReader(r, stream)
+	}
+	_, err = task.writeFile(task.getSavepath(), r)
+	if stream != nil {
+		stream.Close(err)
+	}
+	if err != nil {
+		return
+	}
+	esm.Integrity = "sha384-" + base64.StdEncoding.EncodeToString(h.Sum(nil))
+	debugf("storage", task.Pkg.Name, "write %s", task.getSavepath())
+	return
+}
+
+// resolveExternalImportPath returns the import url of the external module of the build, the
+// dependencies that are not built yet are added to the build queue.
+func (task *BuildTask) resolveExternalImportPath(name string, npm NpmPackage) (importPath string, err error) {
+	// remote imports
+	if isRemoteSpecifier(name) || task.external.Has(name) {
+		importPath = name
+	}
+	// sub module
+	if importPath == "" && strings.HasPrefix(name, task.Pkg.Name+"/") {
+		subPath := strings.TrimPrefix(name, task.Pkg.Name+"/")
+		subPkg := Pkg{
+			Name:      task.Pkg.Name,
+			Version:   task.Pkg.Version,
+			Subpath:   subPath,
+			Submodule: toModuleName(subPath),
+		}
+		importPath = task.getImportPath(subPkg, encodeBuildArgsPrefix(task.BuildArgs, subPkg, false))
+	}
+	// node builtin module
+	if importPath == "" && builtInNodeModules[name] {
+		if task.Target == "node" {
+			importPath = fmt.Sprintf("node:%s", name)
+		} else if task.Target == "denonext" && !denoNextUnspportedNodeModules[name] {
+			importPath = fmt.Sprintf("node:%s", name)
+		} else if task.Target == "deno" {
+			importPath = fmt.Sprintf("https://deno.land/std@%s/node/%s.ts", task.denoStdVersion, name)
+		} else {
+			polyfill, ok := polyfilledBuiltInNodeModules[name]
+			if ok {
+				p, _, e := validatePkgPath(polyfill)
+				if e != nil {
+					err = e
+					return
+				}
+				importPath = task.getImportPath(p, "")
+				extname := filepath.Ext(importPath)
+				importPath = strings.TrimSuffix(importPath, extname) + ".bundle" + extname
+			} else {
+				_, err := embedFS.ReadFile(fmt.Sprintf("server/embed/polyfills/node_%s.js", name))
+				if err == nil {
+					importPath = fmt.Sprintf("%s/v%d/node_%s.js", cfg.BasePath, task.BuildVersion, name)
+				} else {
+					importPath = fmt.Sprintf(
+						"%s/error.js?type=unsupported-nodejs-builtin-module&name=%s&importer=%s",
+						cfg.BasePath,
+						name,
+						task.Pkg.Name,
+					)
+				}
+			}
+		}
+	}
+	// external all pattern
+	if importPath == "" && task.external.Has("*") {
+		importPath = name
+	}
+	// use `node_fetch.js` polyfill instead of `node-fetch`
+	if importPath == "" && name == "node-fetch" && task.Target != "node" {
+		importPath = fmt.Sprintf("%s/v%d/node_fetch.js", cfg.BasePath, task.BuildVersion)
+	}
+	// use version defined in `?deps` query
+	if importPath == "" {
+		for _, dep := range task.deps {
+			if name == dep.Name || strings.HasPrefix(name, dep.Name+"/") {
+				var subPath string
+				if name != dep.Name {
+					subPath = strings.TrimPrefix(name, dep.Name+"/")
+				}
+				subPkg := Pkg{
+					Name:      dep.Name,
+					Version:   dep.Version,
+					Subpath:   subPath,
+					Submodule: toModuleName(subPath),
+				}
+				// the `?lazy` option only applies to the package itself
+				args := task.BuildArgs
+				args.lazy = false
+				importPath = task.getImportPath(subPkg, encodeBuildArgsPrefix(args, subPkg, false))
+				task.addImport(dep)
+				break
+			}
+		}
+	}
+	// force the dependency version of `react` equals to react-dom
+	if importPath == "" && task.Pkg.Name == "react-dom" && name == "react" {
+		pkg := Pkg{
+			Name:    name,
+			Version: task.Pkg.Version,
+		}
+		importPath = task.getImportPath(pkg, "")
+		task.addImport(pkg)
+	}
+	// common npm dependency
+	if importPath == "" {
+		version := "latest"
+		pkgName, subpath := splitPkgPath(name)
+		if pkgName == task.Pkg.Name {
+			version = task.Pkg.Version
+		} else if v, ok := npm.Dependencies[pkgName]; ok {
+			version = v
+		} else if v, ok := npm.PeerDependencies[pkgName]; ok {
+			version = v
+		}
+		p, _, e := task.getPackageInfo(pkgName, version)
+		if e != nil {
+			err = e
+			return
+		}
+
+		pkg := Pkg{
+			Name:      p.Name,
+			Version:   p.Version,
+			Subpath:   subpath,
+			Submodule: toModuleName(subpath),
+		}
+		t := &BuildTask{
+			BuildArgs: BuildArgs{
+				alias:          map[string]string{},
+				deps:           task.deps,
+				external:       task.external,
+				treeShaking:    newStringSet(), // remove `?exports` args
+				conditions:     newStringSet(), // remove `?conditions` args
+				denoStdVersion: task.denoStdVersion,
+				registry:       task.registry, // the dependency is resolved from the registry of the package
+			},
+			CdnOrigin:    task.CdnOrigin,
+			BuildVersion: task.BuildVersion,
+			Pkg:          pkg,
+			Target:       task.Target,
+			Dev:          task.Dev,
+			requestID:    task.requestID,
+		}
+
+		_, ok := queryESMBuild(t.ID())
+		if !ok {
+			buildQueue.Add(t, "")
+		}
+
+		importPath = task.getImportPath(pkg, encodeBuildArgsPrefix(t.BuildArgs, pkg, false))
+		task.addImport(pkg)
+	}
+	if importPath == "" {
+		err = fmt.Errorf("could not resolve \"%s\" (Imported by \"%s\")", name, task.Pkg.Name)
+	}
+	return
+}
+
 func (task *BuildTask) storeToDB(esm *ESMBuild) {
-	err := db.Put(task.ID(), utils.MustEncodeJSON(esm))
+	id := task.ID()
+	esm.setBuildOptions(task.Target, task.Dev, task.BuildArgs)
+	existing, _ := db.Get(id)
+	err := db.Put(id, utils.MustEncodeJSON(esm))
 	if err != nil {
 		log.Errorf("db: %v", err)
+	} else if existing == nil {
+		trackCachedBuild(id, 1)
+	}
+	cache.Delete("esm-build:" + id)
+	indexDependents(id, task.imports)
+}
+
+// addImport records the dependency that the build imports by url.
+func (task *BuildTask) addImport(pkg Pkg) {
+	if !task.imports.Has(pkg.Name) {
+		task.imports = append(task.imports, Pkg{Name: pkg.Name, Version: pkg.Version, FromGithub: pkg.FromGithub})
 	}
 }
 
@@ -1128,22 +1476,30 @@ func (task *BuildTask) checkDTS(esm *ESMBuild, npm NpmPackage) {
 			}
 		}
 	}
-	if dts != "" {
-		bv := task.BuildVersion
-		if stableBuild[task.Pkg.Name] {
-			bv = STABLE_VERSION
+	bv := task.BuildVersion
+	if stableBuild[task.Pkg.Name] {
+		bv = STABLE_VERSION
+	}
+	if dts == "" && cfg.GenerateTypes && task.exports != nil {
+		var err error
+		dts, err = task.generateDTS(bv)
+		if err != nil {
+			log.Errorf("generateDTS(%s): %v", task.Pkg, err)
+			dts = ""
 		}
-		esm.Dts = fmt.Sprintf("/v%d%s/%s", bv, task.ghPrefix(), dts)
+	}
+	if dts != "" {
+		esm.Dts = fmt.Sprintf("%s%s/%s", getTypesPathPrefix(bv), task.ghPrefix(), dts)
 	}
 }
 
 func (task *BuildTask) buildDTS(dts string) {
 	start := time.Now()
-	task.stage = "transform-dts"
+	task.setStage("transform-dts")
 	n, err := task.TransformDTS(dts)
 	if err != nil && os.IsExist(err) {
 		log.Errorf("TransformDTS(%s): %v", dts, err)
 		return
 	}
-	log.Debugf("transform dts '%s'(%d related dts files) in %v", dts, n, time.Since(start))
+	debugf("dts", task.Pkg.Name, "transform dts '%s'(%d related dts files) in %v", dts, n, time.Since(start))
 }
@@ -1,8 +1,10 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/ije/gox/utils"
@@ -15,18 +17,51 @@ type BuildArgs struct {
 	external          *stringSet
 	treeShaking       *stringSet
 	denoStdVersion    string
+	global            string
+	registry          string
+	bundleDepsUnder   int64
+	bundleScopes      *stringSet
 	ignoreAnnotations bool
 	ignoreRequire     bool
 	keepNames         bool
+	mv3               bool
+	lazy              bool
+}
+
+// errInvalidBuildArgs is the error of the build args prefix that has an invalid value.
+var errInvalidBuildArgs = errors.New("invalid build args")
+
+// isValidGlobalName checks whether the name can be used as the variable name of the `global` option.
+func isValidGlobalName(name string) bool {
+	return regexpJSIdent.MatchString(name) && !jsReservedWords.Has(name)
+}
+
+// isValidBundleScope checks whether the scope of the `bundle-scope` option is a npm scope, e.g. "@org".
+func isValidBundleScope(scope string) bool {
+	return strings.HasPrefix(scope, "@") && !strings.Contains(scope, "/") && validatePackageName(scope+"/x")
+}
+
+// newBuildArgs returns the build args without any option, e.g. for the builds that are
+// queued by the server itself(warmup, refresh).
+func newBuildArgs() BuildArgs {
+	return BuildArgs{
+		alias:        map[string]string{},
+		deps:         PkgSlice{},
+		conditions:   newStringSet(),
+		external:     newStringSet(),
+		treeShaking:  newStringSet(),
+		bundleScopes: newStringSet(),
+	}
 }
 
 func decodeBuildArgsPrefix(raw string) (args BuildArgs, err error) {
 	s, err := atobUrl(strings.TrimPrefix(strings.TrimSuffix(raw, "/"), "X-"))
 	if err == nil {
 		args = BuildArgs{
-			external:    newStringSet(),
-			treeShaking: newStringSet(),
-			conditions:  newStringSet(),
+			external:     newStringSet(),
+			treeShaking:  newStringSet(),
+			conditions:   newStringSet(),
+			bundleScopes: newStringSet(),
 		}
 		for _, p := range strings.Split(s, "\n") {
 			if strings.HasPrefix(p, "a/") {
@@ -66,6 +101,26 @@ func decodeBuildArgsPrefix(raw string) (args BuildArgs, err error) {
 				}
 			} else if strings.HasPrefix(p, "dsv/") {
 				args.denoStdVersion = strings.TrimPrefix(p, "dsv/")
+			} else if strings.HasPrefix(p, "bs/") {
+				for _, scope := range strings.Split(strings.TrimPrefix(p, "bs/"), ",") {
+					if !isValidBundleScope(scope) {
+						return args, fmt.Errorf("%w: bundle scope '%s'", errInvalidBuildArgs, scope)
+					}
+					args.bundleScopes.Add(scope)
+				}
+			} else if strings.HasPrefix(p, "bdu/") {
+				args.bundleDepsUnder, err = strconv.ParseInt(strings.TrimPrefix(p, "bdu/"), 10, 64)
+				if err != nil || args.bundleDepsUnder < 0 {
+					return args, fmt.Errorf("%w: bundle deps size '%s'", errInvalidBuildArgs, strings.TrimPrefix(p, "bdu/"))
+				}
+			} else if strings.HasPrefix(p, "r/") {
+				args.registry = strings.TrimPrefix(p, "r/")
+			} else if strings.HasPrefix(p, "g/") {
+				// the global name is put into the generated code as is
+				args.global = strings.TrimPrefix(p, "g/")
+				if !isValidGlobalName(args.global) {
+					return args, fmt.Errorf("%w: global '%s'", errInvalidBuildArgs, args.global)
+				}
 			} else {
 				switch p {
 				case "ir":
@@ -74,6 +129,10 @@ func decodeBuildArgsPrefix(raw string) (args BuildArgs, err error) {
 					args.keepNames = true
 				case "ia":
 					args.ignoreAnnotations = true
+				case "mv3":
+					args.mv3 = true
+				case "lazy":
+					args.lazy = true
 				}
 			}
 		}
@@ -81,7 +140,39 @@ func decodeBuildArgsPrefix(raw string) (args BuildArgs, err error) {
 	return
 }
 
+// normalizeBuildArgs removes the build args that don't change the build output, so the equivalent
+// queries(e.g. `?dev&keep-names` and `?dev`) share the same build id.
+func normalizeBuildArgs(args *BuildArgs, isDev bool, isBundle bool, isESM bool) {
+	for name, to := range args.alias {
+		if name == to {
+			delete(args.alias, name)
+		}
+	}
+	if args.external != nil && args.external.Has("*") && args.external.Len() > 1 {
+		args.external.Reset()
+		args.external.Add("*")
+	}
+	// the dev build keeps the names always
+	if isDev {
+		args.keepNames = false
+	}
+	// all the dependencies are bundled in the `bundle` mode
+	if isBundle {
+		args.bundleScopes = newStringSet()
+		args.bundleDepsUnder = 0
+	}
+	// the `global` option only applies to the UMD packages, the ES modules export their own bindings
+	if isESM {
+		args.global = ""
+	}
+}
+
 func encodeBuildArgsPrefix(args BuildArgs, pkg Pkg, forTypes bool) string {
+	recipe, _ := getRecipe(pkg)
+	return encodeBuildArgsPrefixWithRecipe(args, pkg, recipe, forTypes)
+}
+
+func encodeBuildArgsPrefixWithRecipe(args BuildArgs, pkg Pkg, recipe *Recipe, forTypes bool) string {
 	lines := []string{}
 	if !(stableBuild[pkg.Name] && pkg.Submodule == "") {
 		if len(args.alias) > 0 {
@@ -147,10 +238,21 @@ func encodeBuildArgsPrefix(args BuildArgs, pkg Pkg, forTypes bool) string {
 			lines = append(lines, fmt.Sprintf("c/%s", strings.Join(ss, ",")))
 		}
 	}
+	// the registry is always folded into the build id to avoid cache poisoning
+	if args.registry != "" {
+		lines = append(lines, fmt.Sprintf("r/%s", args.registry))
+	}
+	// the recipe of the package is folded into the build id, the build is rebuilt if the recipe changes
+	if recipe != nil && !forTypes {
+		lines = append(lines, fmt.Sprintf("rcp/%s", recipe.hash))
+	}
 	if !forTypes {
 		if args.denoStdVersion != "" && args.denoStdVersion != denoStdVersion {
 			lines = append(lines, fmt.Sprintf("dsv/%s", args.denoStdVersion))
 		}
+		if args.global != "" {
+			lines = append(lines, fmt.Sprintf("g/%s", args.global))
+		}
 		if args.ignoreRequire {
 			lines = append(lines, "ir")
 		}
@@ -160,6 +262,20 @@ func encodeBuildArgsPrefix(args BuildArgs, pkg Pkg, forTypes bool) string {
 		if args.ignoreAnnotations {
 			lines = append(lines, "ia")
 		}
+		if args.mv3 {
+			lines = append(lines, "mv3")
+		}
+		if args.lazy {
+			lines = append(lines, "lazy")
+		}
+		if args.bundleScopes != nil && args.bundleScopes.Len() > 0 {
+			ss := sort.StringSlice(args.bundleScopes.Values())
+			ss.Sort()
+			lines = append(lines, fmt.Sprintf("bs/%s", strings.Join(ss, ",")))
+		}
+		if args.bundleDepsUnder > 0 {
+			lines = append(lines, fmt.Sprintf("bdu/%d", args.bundleDepsUnder))
+		}
 	}
 	if len(lines) > 0 {
 		return fmt.Sprintf("X-%s/", btoaUrl(strings.Join(lines, "\n")))
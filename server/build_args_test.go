@@ -1,6 +1,7 @@
 package server
 
 import (
+	"errors"
 	"testing"
 )
 
@@ -13,6 +14,7 @@ func TestEncodeBuildArgs(t *testing.T) {
 	treeShaking.Add("baz")
 	treeShaking.Add("bar")
 	conditions.Add("react-server")
+	bundleScopes := newStringSet("@org", "@acme")
 	prefix := encodeBuildArgsPrefix(
 		BuildArgs{
 			alias: map[string]string{"a": "b"},
@@ -26,6 +28,10 @@ func TestEncodeBuildArgs(t *testing.T) {
 			treeShaking:       treeShaking,
 			conditions:        conditions,
 			denoStdVersion:    "0.128.0",
+			registry:          "staging",
+			global:            "Foo",
+			bundleDepsUnder:   5120,
+			bundleScopes:      bundleScopes,
 			ignoreRequire:     true,
 			keepNames:         true,
 			ignoreAnnotations: true,
@@ -52,9 +58,21 @@ func TestEncodeBuildArgs(t *testing.T) {
 	if args.conditions.Len() != 1 {
 		t.Fatal("invalid conditions")
 	}
+	if args.global != "Foo" {
+		t.Fatal("invalid global")
+	}
 	if args.denoStdVersion != "0.128.0" {
 		t.Fatal("invalid denoStdVersion")
 	}
+	if args.bundleScopes.Len() != 2 || !args.bundleScopes.Has("@acme") {
+		t.Fatal("invalid bundleScopes")
+	}
+	if args.bundleDepsUnder != 5120 {
+		t.Fatal("invalid bundleDepsUnder")
+	}
+	if args.registry != "staging" {
+		t.Fatal("invalid registry")
+	}
 	if !args.ignoreRequire {
 		t.Fatal("ignoreRequire should be true")
 	}
@@ -65,4 +83,75 @@ func TestEncodeBuildArgs(t *testing.T) {
 		t.Fatal("ignoreAnnotations should be true")
 	}
 	t.Log(prefix, args)
+
+	// the values that are put into the build are validated
+	for _, raw := range []string{"g/x\"]);evil();//", "g/class", "bs/@acme/x", "bs/acme", "bdu/-1", "bdu/5kb"} {
+		if _, err := decodeBuildArgsPrefix("X-" + btoaUrl(raw)); !errors.Is(err, errInvalidBuildArgs) {
+			t.Fatalf("decodeBuildArgsPrefix(%q): should be invalid, got %v", raw, err)
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	for s, expected := range map[string]int64{"512": 512, "512b": 512, "5kb": 5120, "5KB": 5120, "1mb": 1048576} {
+		n, err := parseByteSize(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != expected {
+			t.Fatalf("parseByteSize(%s): expected %d, got %d", s, expected, n)
+		}
+	}
+	for _, s := range []string{"", "kb", "-1kb", "5gb"} {
+		if _, err := parseByteSize(s); err == nil {
+			t.Fatalf("parseByteSize(%s): expected error", s)
+		}
+	}
+}
+
+func TestNormalizeBuildArgs(t *testing.T) {
+	newArgs := func(deps PkgSlice, alias map[string]string, external ...string) BuildArgs {
+		return BuildArgs{
+			alias:        alias,
+			deps:         deps,
+			external:     newStringSet(external...),
+			treeShaking:  newStringSet(),
+			conditions:   newStringSet(),
+			bundleScopes: newStringSet("@org"),
+		}
+	}
+	pkg := Pkg{Name: "foo"}
+
+	a := newArgs(PkgSlice{{Name: "c", Version: "1.0.0"}, {Name: "d", Version: "1.0.0"}}, map[string]string{"a": "b", "x": "y"}, "bar", "baz")
+	b := newArgs(PkgSlice{{Name: "d", Version: "1.0.0"}, {Name: "c", Version: "1.0.0"}}, map[string]string{"x": "y", "a": "b", "z": "z"}, "baz", "bar")
+	normalizeBuildArgs(&a, false, false, false)
+	normalizeBuildArgs(&b, false, false, false)
+	if encodeBuildArgsPrefix(a, pkg, false) != encodeBuildArgsPrefix(b, pkg, false) {
+		t.Fatal("the permutations of the build args should have the same prefix")
+	}
+
+	a = newArgs(nil, nil, "*")
+	b = newArgs(nil, nil, "bar", "*")
+	b.keepNames = true
+	b.bundleDepsUnder = 1024
+	normalizeBuildArgs(&a, true, true, false)
+	normalizeBuildArgs(&b, true, true, false)
+	if prefix := encodeBuildArgsPrefix(b, pkg, false); prefix != encodeBuildArgsPrefix(a, pkg, false) {
+		t.Fatalf("the no-op build args should be removed: %s", prefix)
+	}
+
+	b = newArgs(nil, nil)
+	b.keepNames = true
+	normalizeBuildArgs(&b, false, false, false)
+	if !b.keepNames || b.bundleScopes.Len() != 1 {
+		t.Fatal("the build args should be kept")
+	}
+
+	a = newArgs(nil, nil)
+	b = newArgs(nil, nil)
+	b.global = "Foo"
+	normalizeBuildArgs(&b, false, false, true)
+	if encodeBuildArgsPrefix(b, pkg, false) != encodeBuildArgsPrefix(a, pkg, false) {
+		t.Fatal("the global should be removed for the ES modules")
+	}
 }
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DryRunOutput is the response of the `?dry-run` query, it reports how the dependencies and the node
+// builtin modules would be resolved by the build without building the package.
+type DryRunOutput struct {
+	Package  string            `json:"package"`
+	Target   string            `json:"target"`
+	Bundle   bool              `json:"bundle,omitempty"`
+	Imports  []DryRunImport    `json:"imports"`
+	Builtins map[string]string `json:"builtins"`
+}
+
+// DryRunImport is the resolution of a dependency of the package.
+type DryRunImport struct {
+	Specifier string `json:"specifier"`
+	// "dependency" or "peerDependency"
+	Kind string `json:"kind"`
+	// the version range of the package.json, or the version of the `?deps` query
+	Version string `json:"version,omitempty"`
+	// "bundle", "external", "conditional" or "unsupported"
+	Action string `json:"action"`
+	Reason string `json:"reason"`
+}
+
+// dryRun reports how the dependencies of the package would be resolved with the build options, only
+// the package.json of the package is fetched. The dependencies that are not imported by the package
+// are reported as well since the modules are not parsed.
+func (task *BuildTask) dryRun() (out DryRunOutput, err error) {
+	npm, _, err := getRegistryPackageInfo(task.registry, "", task.Pkg.Name, task.Pkg.Version)
+	if err != nil {
+		return
+	}
+	recipe, _ := task.getRecipe()
+	if recipe == nil {
+		recipe = &Recipe{}
+	}
+
+	out = DryRunOutput{
+		Package:  task.Pkg.String(),
+		Target:   task.Target,
+		Bundle:   task.Bundle,
+		Imports:  []DryRunImport{},
+		Builtins: map[string]string{},
+	}
+	for _, kind := range []string{"dependency", "peerDependency"} {
+		deps := npm.Dependencies
+		if kind == "peerDependency" {
+			deps = npm.PeerDependencies
+		}
+		names := make([]string, 0, len(deps))
+		for name := range deps {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			// the peer dependency may be listed in the `dependencies` as well
+			if _, ok := npm.PeerDependencies[name]; ok && kind == "dependency" {
+				continue
+			}
+			imp := task.dryRunImport(npm, recipe, name, deps[name])
+			imp.Kind = kind
+			out.Imports = append(out.Imports, imp)
+		}
+	}
+	for name := range builtInNodeModules {
+		out.Builtins[name] = task.dryRunBuiltin(name)
+	}
+	return
+}
+
+// dryRunImport resolves the dependency in the order of the resolver of the build.
+func (task *BuildTask) dryRunImport(npm NpmPackage, recipe *Recipe, specifier string, version string) DryRunImport {
+	imp := DryRunImport{Specifier: specifier, Version: version}
+	if isLocalDependencyVersion(version) {
+		imp.Action = "unsupported"
+		imp.Reason = "the local dependency is not supported"
+		return imp
+	}
+	var aliased string
+	if name, ok := task.alias[specifier]; ok {
+		aliased = fmt.Sprintf("aliased to %s by the `?alias` query, ", name)
+		specifier = name
+	} else if name, ok := recipe.Alias[specifier]; ok {
+		aliased = fmt.Sprintf("aliased to %s by the recipe, ", name)
+		specifier = name
+	}
+	pkgName, _ := splitPkgPath(specifier)
+	_, isPeer := npm.PeerDependencies[pkgName]
+	if dep, ok := task.deps.Get(pkgName); ok {
+		imp.Version = dep.Version
+	}
+
+	switch {
+	case task.external.Has(specifier) || task.external.Has("*"):
+		imp.Action = "external"
+		imp.Reason = "marked as external by the `?external` query"
+	case task.Bundle && isPeer:
+		imp.Action = "external"
+		imp.Reason = "the peer dependency is not bundled"
+	case task.Bundle:
+		imp.Action = "bundle"
+		imp.Reason = "bundled in the `bundle` mode"
+	case task.bundleScopes != nil && task.bundleScopes.Len() > 0 && strings.HasPrefix(pkgName, "@") && !isPeer && task.bundleScopes.Has(strings.Split(pkgName, "/")[0]):
+		imp.Action = "bundle"
+		imp.Reason = "bundled by the `?bundle-scope` query"
+	case task.bundleDepsUnder > 0 && !isPeer && pkgName != task.Pkg.Name:
+		imp.Action = "conditional"
+		imp.Reason = fmt.Sprintf("bundled if the entry is smaller than %d bytes by the `?bundle-deps-under` query, that is checked at build time", task.bundleDepsUnder)
+	default:
+		imp.Action = "external"
+		imp.Reason = "imported from the url of the dependency"
+	}
+	imp.Reason = aliased + imp.Reason
+	return imp
+}
+
+// dryRunBuiltin returns how the node builtin module would be resolved: "bundle", "polyfill",
+// "native" or "unsupported".
+func (task *BuildTask) dryRunBuiltin(name string) string {
+	if task.Bundle && !task.isServerTarget() {
+		if _, err := embedFS.ReadFile("server/embed/polyfills/node_" + name); err == nil {
+			return "bundle"
+		}
+	}
+	switch task.Target {
+	case "node":
+		return "native"
+	case "denonext":
+		if !denoNextUnspportedNodeModules[name] {
+			return "native"
+		}
+	case "deno":
+		return "polyfill"
+	}
+	if _, ok := polyfilledBuiltInNodeModules[name]; ok {
+		return "polyfill"
+	}
+	if _, err := embedFS.ReadFile(fmt.Sprintf("server/embed/polyfills/node_%s.js", name)); err == nil {
+		return "polyfill"
+	}
+	return "unsupported"
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"embed"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	embedFS = &devFS{".."}
+	defer func() {
+		embedFS = &embed.FS{}
+	}()
+
+	npm := NpmPackage{
+		Name:             "foo",
+		Version:          "1.0.0",
+		Dependencies:     map[string]string{"bar": "^1.0.0", "@org/baz": "^2.0.0", "react": "^18.0.0", "qux": "file:../qux"},
+		PeerDependencies: map[string]string{"react": "^18.0.0"},
+	}
+	newTask := func(bundle bool, target string) *BuildTask {
+		task := newTestBuildTask(Pkg{Name: "foo", Version: "1.0.0"}, target)
+		task.Bundle = bundle
+		return task
+	}
+	recipe := &Recipe{}
+
+	task := newTask(false, "es2022")
+	for name, action := range map[string]string{"bar": "external", "react": "external", "qux": "unsupported"} {
+		if imp := task.dryRunImport(npm, recipe, name, npm.Dependencies[name]); imp.Action != action {
+			t.Fatalf("%s should be %s: %v", name, action, imp)
+		}
+	}
+
+	task = newTask(true, "es2022")
+	task.external.Add("bar")
+	task.deps = PkgSlice{{Name: "@org/baz", Version: "2.1.0"}}
+	for name, action := range map[string]string{"bar": "external", "@org/baz": "bundle", "react": "external"} {
+		if imp := task.dryRunImport(npm, recipe, name, npm.Dependencies[name]); imp.Action != action {
+			t.Fatalf("%s should be %s in bundle mode: %v", name, action, imp)
+		}
+	}
+	if imp := task.dryRunImport(npm, recipe, "@org/baz", "^2.0.0"); imp.Version != "2.1.0" {
+		t.Fatalf("the version of the `?deps` query should be reported: %v", imp)
+	}
+
+	task = newTask(false, "es2022")
+	task.bundleScopes.Add("@org")
+	task.bundleDepsUnder = 1024
+	if imp := task.dryRunImport(npm, recipe, "@org/baz", "^2.0.0"); imp.Action != "bundle" {
+		t.Fatalf("@org/baz should be bundled by the scope: %v", imp)
+	}
+	if imp := task.dryRunImport(npm, recipe, "bar", "^1.0.0"); imp.Action != "conditional" {
+		t.Fatalf("bar should be conditional: %v", imp)
+	}
+	if imp := task.dryRunImport(npm, recipe, "react", "^18.0.0"); imp.Action != "external" {
+		t.Fatalf("the peer dependency should be external: %v", imp)
+	}
+
+	for target, action := range map[string]string{"es2022": "polyfill", "node": "native", "denonext": "native", "deno": "polyfill"} {
+		if a := newTask(false, target).dryRunBuiltin("buffer"); a != action {
+			t.Fatalf("buffer should be %s for %s: %s", action, target, a)
+		}
+	}
+	if a := newTask(false, "denonext").dryRunBuiltin("inspector"); a != "polyfill" {
+		t.Fatalf("inspector should be polyfilled for denonext: %s", a)
+	}
+	if a := newTask(false, "es2022").dryRunBuiltin("child_process"); a != "unsupported" {
+		t.Fatalf("child_process should be unsupported: %s", a)
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ije/gox/utils"
+)
+
+// the db key prefix of the failed build records
+const buildFailureDBKeyPrefix = "_failure:"
+
+// BuildFailure is the record of a failed build, the requests of the build get the error
+// immediately until the record expires or the package is purged.
+type BuildFailure struct {
+	Message   string `json:"message"`
+	Status    int    `json:"status"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+func (f *BuildFailure) Error() string {
+	return f.Message
+}
+
+// getBuildFailureStatus returns the http status code of the build error.
+func getBuildFailureStatus(err error) int {
+	if errors.Is(err, errBuildTimeout) {
+		return 504
+	}
+	msg := err.Error()
+	if strings.HasPrefix(msg, "could not resolve ") || (strings.HasPrefix(msg, "npm: ") && strings.HasSuffix(msg, " not found")) {
+		return 404
+	}
+	return 500
+}
+
+// getBuildFailureTTL returns the TTL of the failure record of the build error, the timed-out
+// build is not retried until the `buildTimeout` passes, the other errors are recorded by the
+// `cacheTTL.failure` config.
+func getBuildFailureTTL(err error) time.Duration {
+	ttl := time.Duration(cfg.CacheTTL.Failure) * time.Second
+	if errors.Is(err, errBuildTimeout) && getBuildTimeout() > ttl {
+		ttl = getBuildTimeout()
+	}
+	return ttl
+}
+
+// saveBuildFailure records the failed build, it returns the record as the error of the build
+// output, or the original error if the failure is not recorded.
+func saveBuildFailure(task *BuildTask, err error) error {
+	ttl := getBuildFailureTTL(err)
+	if db == nil || ttl <= 0 {
+		return err
+	}
+	failure := &BuildFailure{
+		Message:   err.Error(),
+		Status:    getBuildFailureStatus(err),
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+	}
+	if e := db.Put(buildFailureDBKeyPrefix+task.ID(), utils.MustEncodeJSON(failure)); e != nil {
+		log.Errorf("db: %v", e)
+		return err
+	}
+	return failure
+}
+
+// getBuildFailure returns the unexpired failure record of the build, the expired record is removed.
+func getBuildFailure(id string) *BuildFailure {
+	if db == nil {
+		return nil
+	}
+	data, err := db.Get(buildFailureDBKeyPrefix + id)
+	if err != nil || data == nil {
+		return nil
+	}
+	var failure BuildFailure
+	if json.Unmarshal(data, &failure) != nil || time.Now().Unix() >= failure.ExpiresAt {
+		db.Delete(buildFailureDBKeyPrefix + id)
+		return nil
+	}
+	return &failure
+}
+
+// sweepBuildFailures removes the expired failure records periodically, the records of the builds
+// that are not requested again are never read by `getBuildFailure`.
+func sweepBuildFailures() {
+	for {
+		time.Sleep(time.Hour)
+		n, err := removeExpiredBuildFailures()
+		if err != nil {
+			log.Errorf("db: %v", err)
+		} else if n > 0 {
+			debugf("build", "", "removed %d expired build failures", n)
+		}
+	}
+}
+
+// removeExpiredBuildFailures removes the expired failure records, it returns the number of the
+// removed records.
+func removeExpiredBuildFailures() (n int, err error) {
+	keys, err := db.Keys(buildFailureDBKeyPrefix)
+	if err != nil {
+		return
+	}
+	for _, key := range keys {
+		if getBuildFailure(strings.TrimPrefix(key, buildFailureDBKeyPrefix)) == nil {
+			n++
+		}
+	}
+	return
+}
+
+// purgeBuildFailures removes the failure records of the package version.
+func purgeBuildFailures(pkg Pkg) (err error) {
+	ghPrefix := ""
+	if pkg.FromGithub {
+		ghPrefix = "/gh"
+	}
+	for _, bv := range []string{fmt.Sprintf("v%d", VERSION), "stable"} {
+		var keys []string
+		keys, err = db.Keys(fmt.Sprintf("%s%s%s/%s@%s/", buildFailureDBKeyPrefix, bv, ghPrefix, pkg.Name, pkg.Version))
+		if err != nil {
+			return
+		}
+		for _, key := range keys {
+			err = db.Delete(key)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
@@ -1,6 +1,11 @@
 package server
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,9 +14,13 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/esm-dev/esm.sh/server/storage"
+	"github.com/evanw/esbuild/pkg/api"
 	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
 )
 
 func (task *BuildTask) ID() string {
@@ -46,7 +55,7 @@ func (task *BuildTask) ID() string {
 		task.ghPrefix(),
 		pkg.Name,
 		pkg.Version,
-		encodeBuildArgsPrefix(task.BuildArgs, task.Pkg, task.Target == "types"),
+		task.encodeBuildArgsPrefix(),
 		task.Target,
 		name,
 		extname,
@@ -57,6 +66,22 @@ func (task *BuildTask) ID() string {
 	return task.id
 }
 
+// getRecipe returns the recipe of the package, it's looked up once so the build id and the build use
+// the same recipe even if the recipes are reloaded during the build.
+func (task *BuildTask) getRecipe() (*Recipe, bool) {
+	if !task.recipeOnce {
+		task.recipe, _ = getRecipe(task.Pkg)
+		task.recipeOnce = true
+	}
+	return task.recipe, task.recipe != nil
+}
+
+// encodeBuildArgsPrefix encodes the build args prefix of the task with the snapshot of the recipe.
+func (task *BuildTask) encodeBuildArgsPrefix() string {
+	recipe, _ := task.getRecipe()
+	return encodeBuildArgsPrefixWithRecipe(task.BuildArgs, task.Pkg, recipe, task.Target == "types")
+}
+
 func (task *BuildTask) ghPrefix() string {
 	if task.Pkg.FromGithub {
 		return "/gh"
@@ -116,7 +141,10 @@ func (task *BuildTask) getSavepath() string {
 
 func (task *BuildTask) getRealWD() string {
 	if task.realWd == "" {
-		if l, e := filepath.EvalSymlinks(path.Join(task.wd, "node_modules", task.Pkg.Name)); e == nil {
+		// the package is linked to the `.pnpm` store of the build dir, the links escape
+		// the build dir are ignored
+		if l, e := realpathIn(task.wd, path.Join(task.wd, "node_modules", task.Pkg.Name)); e == nil {
+			l = filepath.ToSlash(l)
 			if strings.HasPrefix(task.Pkg.Name, "@") {
 				task.realWd = path.Join(l, "../../..")
 			} else {
@@ -130,7 +158,36 @@ func (task *BuildTask) getRealWD() string {
 }
 
 func (task *BuildTask) getPackageInfo(name string, version string) (info NpmPackage, fromPackageJSON bool, err error) {
-	return getPackageInfo(task.getRealWD(), name, version)
+	return getRegistryPackageInfoContext(task.context(), task.registry, task.getRealWD(), name, version)
+}
+
+// getDepEntrySize returns the size of the entry file of the dependency that is installed
+// in the `node_modules` of the resolve dir or its parents.
+func (task *BuildTask) getDepEntrySize(resolveDir string, pkgName string) (size int64, ok bool) {
+	for dir := filepath.ToSlash(resolveDir); strings.HasPrefix(dir, task.wd); dir = path.Dir(dir) {
+		pkgDir := path.Join(dir, "node_modules", pkgName)
+		var p NpmPackage
+		if utils.ParseJSONFile(path.Join(pkgDir, "package.json"), &p) == nil {
+			entry := p.Module
+			if entry == "" {
+				entry = p.Main
+			}
+			if entry == "" {
+				entry = "index.js"
+			}
+			for _, filename := range []string{entry, entry + ".js", path.Join(entry, "index.js")} {
+				fi, err := os.Stat(path.Join(pkgDir, filename))
+				if err == nil && !fi.IsDir() {
+					return fi.Size(), true
+				}
+			}
+			return 0, false
+		}
+		if dir == task.wd {
+			break
+		}
+	}
+	return 0, false
 }
 
 func (task *BuildTask) isServerTarget() bool {
@@ -167,11 +224,13 @@ func (task *BuildTask) analyze() (esm *ESMBuild, npm NpmPackage, reexport string
 	}()
 
 	if pkg.Submodule != "" {
-		if endsWith(pkg.Submodule, ".d.ts", ".d.mts") {
+		if isDtsFile(pkg.Submodule) {
 			if strings.HasSuffix(pkg.Submodule, "~.d.ts") {
 				submodule := strings.TrimSuffix(pkg.Submodule, "~.d.ts")
 				subDir := path.Join(wd, "node_modules", npm.Name, submodule)
-				if fileExists(path.Join(subDir, "index.d.ts")) {
+				if f, ok := resolveTypesVersionsFile(path.Join(wd, "node_modules", npm.Name), npm.TypesVersions, submodule); ok {
+					npm.Types = f
+				} else if fileExists(path.Join(subDir, "index.d.ts")) {
 					npm.Types = path.Join(submodule, "index.d.ts")
 				} else if fileExists(path.Join(subDir + ".d.ts")) {
 					npm.Types = submodule + ".d.ts"
@@ -213,12 +272,19 @@ func (task *BuildTask) analyze() (esm *ESMBuild, npm NpmPackage, reexport string
 				} else if fileExists(path.Join(subDir + ".d.ts")) {
 					npm.Types = pkg.Submodule + ".d.ts"
 				}
+				if f, ok := resolveTypesVersionsFile(path.Join(wd, "node_modules", npm.Name), npm.TypesVersions, pkg.Submodule); ok {
+					npm.Types = f
+				}
 			} else {
-				if npm.Type == "module" || npm.Module != "" {
-					// follow main module type
+				if npm.Type == "module" || npm.Module != "" || endsWith(pkg.Submodule, ".ts", ".mts", ".tsx", ".jsx") {
+					// follow main module type, the typescript/jsx sources are ES modules
 					npm.Module = pkg.Submodule
 				} else {
 					npm.Main = pkg.Submodule
+					// the `.cjs` extension is stripped from the submodule name, e.g. "lib/foo.cjs" -> "lib/foo"
+					if !fileExists(subDir) && !fileExists(subDir+".js") && fileExists(subDir+".cjs") {
+						npm.Main = pkg.Submodule + ".cjs"
+					}
 				}
 				npm.Types = ""
 				if fileExists(path.Join(subDir, "index.d.ts")) {
@@ -226,26 +292,40 @@ func (task *BuildTask) analyze() (esm *ESMBuild, npm NpmPackage, reexport string
 				} else if fileExists(path.Join(subDir + ".d.ts")) {
 					npm.Types = pkg.Submodule + ".d.ts"
 				}
+				// the `typesVersions` mappings(e.g. `{">=4.2": {"*": ["ts4.2/*"]}}`) take precedence over the
+				// files of the submodule, the `types` condition of `exports` overrides it
+				if f, ok := resolveTypesVersionsFile(path.Join(wd, "node_modules", npm.Name), npm.TypesVersions, pkg.Submodule); ok {
+					npm.Types = f
+				}
 				// reslove submodule wiht `exports` conditions if exists
 				if npm.DefinedExports != nil {
 					if m, ok := npm.DefinedExports.(map[string]interface{}); ok {
-						for name, defines := range m {
-							if name == "./"+pkg.Submodule || name == "./"+pkg.Submodule+".js" || name == "./"+pkg.Submodule+".mjs" {
-								/**
-								  exports: {
-								    "./lib/core": {
-								      "require": "./lib/core.js",
-								      "import": "./esm/core.js"
-								    },
-									"./lib/core.js": {
-								      "require": "./lib/core.js",
-								      "import": "./esm/core.js"
-								    }
-								  }
-								*/
+						/**
+						  exports: {
+						    "./lib/core": {
+						      "require": "./lib/core.js",
+						      "import": "./esm/core.js"
+						    },
+						    "./lib/core.js": {
+						      "require": "./lib/core.js",
+						      "import": "./esm/core.js"
+						    }
+						  }
+						*/
+						// the exact keys are checked in order before the patterns, since the map is unordered
+						matched := false
+						for _, name := range []string{"./" + pkg.Submodule, "./" + pkg.Submodule + ".js", "./" + pkg.Submodule + ".mjs"} {
+							if defines, ok := m[name]; ok {
 								task.applyConditions(&npm, defines, npm.Type)
+								matched = true
 								break
-							} else if strings.HasSuffix(name, "*") && strings.HasPrefix("./"+pkg.Submodule, strings.TrimSuffix(name, "*")) {
+							}
+						}
+						for name, defines := range m {
+							if matched {
+								break
+							}
+							if strings.HasSuffix(name, "*") && strings.HasPrefix("./"+pkg.Submodule, strings.TrimSuffix(name, "*")) {
 								/**
 								  exports: {
 								    "./lib/languages/*": {
@@ -319,26 +399,34 @@ func (task *BuildTask) analyze() (esm *ESMBuild, npm NpmPackage, reexport string
 			esm.HasExportDefault = includes(namedExports, "default")
 			return
 		}
-		if erro != nil && erro.Error() != "not a module" {
-			err = fmt.Errorf("resolveESModule: %s", erro)
-			return
-		}
+		// the `module` entry may be excluded from the published tarball by `.npmignore`
+		// or the `files` field of package.json, fall back to the `main` entry
+		if os.IsNotExist(erro) && npm.Main != "" {
+			log.Warnf("module entry '%s' of '%s' not found, fall back to main entry '%s'", npm.Module, pkg, npm.Main)
+			esm.Fallback = fmt.Sprintf("module(%s) -> main(%s)", npm.Module, npm.Main)
+			npm.Module = ""
+		} else {
+			if erro.Error() != "not a module" {
+				err = fmt.Errorf("resolveESModule: %s", erro)
+				return
+			}
 
-		var ret cjsExportsResult
-		ret, err = parseCJSModuleExports(wd, path.Join(wd, "node_modules", pkg.Name, modulePath), nodeEnv)
-		if err == nil && ret.Error != "" {
-			err = fmt.Errorf("parseCJSModuleExports: %s", ret.Error)
-		}
-		if err != nil {
+			var ret cjsExportsResult
+			ret, err = parseCJSModuleExports(wd, path.Join(wd, "node_modules", pkg.Name, modulePath), nodeEnv)
+			if err == nil && ret.Error != "" {
+				err = fmt.Errorf("parseCJSModuleExports: %s", ret.Error)
+			}
+			if err != nil {
+				return
+			}
+			reexport = ret.Reexport
+			npm.Main = npm.Module
+			npm.Module = ""
+			esm.HasExportDefault = ret.ExportDefault
+			esm.NamedExports = ret.Exports
+			log.Warnf("fake ES module '%s' of '%s'", npm.Main, npm.Name)
 			return
 		}
-		reexport = ret.Reexport
-		npm.Main = npm.Module
-		npm.Module = ""
-		esm.HasExportDefault = ret.ExportDefault
-		esm.NamedExports = ret.Exports
-		log.Warnf("fake ES module '%s' of '%s'", npm.Main, npm.Name)
-		return
 	}
 
 	if npm.Main != "" {
@@ -350,7 +438,7 @@ func (task *BuildTask) analyze() (esm *ESMBuild, npm NpmPackage, reexport string
 				pkgs[i] = n + "@" + v
 				i++
 			}
-			err = pnpmInstall(wd, pkgs...)
+			err = pnpmInstall(task.context(), wd, task.registry, pkgs...)
 			if err != nil {
 				return
 			}
@@ -383,33 +471,12 @@ func (task *BuildTask) fixNpmPackage(p NpmPackage) NpmPackage {
 	}
 
 	if len(p.TypesVersions) > 0 {
-		var usedCondition string
-		for c, e := range p.TypesVersions {
-			if c == "*" && strings.HasPrefix(c, ">") || strings.HasPrefix(c, ">=") {
-				if usedCondition == "" || c == "*" || c > usedCondition {
-					if m, ok := e.(map[string]interface{}); ok {
-						d, ok := m["*"]
-						if !ok {
-							d, ok = m["."]
-						}
-						if ok {
-							if a, ok := d.([]interface{}); ok && len(a) > 0 {
-								if t, ok := a[0].(string); ok {
-									usedCondition = c
-									if strings.HasSuffix(t, "*") {
-										f := p.Types
-										if f == "" {
-											f = "index.d.ts"
-										}
-										t = path.Join(t[:len(t)-1], f)
-									}
-									p.Types = t
-								}
-							}
-						}
-					}
-				}
-			}
+		types := p.Types
+		if types == "" {
+			types = "index.d.ts"
+		}
+		if t, ok := resolveTypesVersions(p.TypesVersions, path.Clean(types)); ok {
+			p.Types = t
 		}
 	}
 
@@ -505,6 +572,19 @@ func (task *BuildTask) fixNpmPackage(p NpmPackage) NpmPackage {
 		}
 	}
 
+	// use the forced entry of the recipe, the dependencies are fixed by their own builds
+	if recipe, ok := task.getRecipe(); ok && recipe.Entry != "" && p.Name == task.Pkg.Name && task.Pkg.Submodule == "" {
+		isEsm, _, err := validateJS(path.Join(nmDir, p.Name, recipe.Entry))
+		if err != nil {
+			log.Warnf("recipe(%s): %v", task.Pkg, err)
+		} else if isEsm {
+			p.Module = recipe.Entry
+		} else {
+			p.Module = ""
+			p.Main = recipe.Entry
+		}
+	}
+
 	// log.Debug("[main]", task.Pkg, p.Main, p.Module)
 
 	if p.Types == "" && p.Main != "" {
@@ -618,27 +698,209 @@ func (task *BuildTask) applyConditions(p *NpmPackage, exports interface{}, pType
 }
 
 func queryESMBuild(id string) (*ESMBuild, bool) {
+	// check the cache layer first to reduce db lookups for hot modules
+	cacheKey := "esm-build:" + id
+	if data, err := cache.Get(cacheKey); err == nil {
+		var esm ESMBuild
+		// the build file may be removed since the record is cached
+		if json.Unmarshal(data, &esm) == nil && (esm.TypesOnly || existsBuildFile(id)) {
+			return &esm, true
+		}
+		cache.Delete(cacheKey)
+	}
+
 	value, err := db.Get(id)
 	if err == nil && value != nil {
 		var esm ESMBuild
 		err = json.Unmarshal(value, &esm)
-		if err == nil {
-			if strings.HasPrefix(id, "stable/") {
-				id = fmt.Sprintf("v%d/", STABLE_VERSION) + strings.TrimPrefix(id, "stable/")
-			}
-			if !esm.TypesOnly {
-				_, err = fs.Stat(path.Join("builds", id))
-			}
-			if err == nil || os.IsExist(err) {
-				return &esm, true
-			}
+		if err == nil && (esm.TypesOnly || existsBuildFile(id)) {
+			cache.Set(cacheKey, value, time.Hour)
+			return &esm, true
 		}
 		// delete the invalid db entry
-		db.Delete(id)
+		if db.Delete(id) == nil {
+			trackCachedBuild(id, -1)
+		}
+		cache.Delete(cacheKey)
 	}
 	return nil, false
 }
 
+// existsBuildFile checks whether the file of the build exists in the storage.
+func existsBuildFile(id string) bool {
+	if strings.HasPrefix(id, "stable/") {
+		id = fmt.Sprintf("v%d/", STABLE_VERSION) + strings.TrimPrefix(id, "stable/")
+	}
+	_, err := fs.Stat(path.Join("builds", id))
+	return err == nil
+}
+
+// the number of the esm builds of the current build version, the db keys are counted once
+// at startup, then the counter is updated when a build is stored or deleted.
+var cachedBuilds int64
+
+// countCachedBuilds counts the esm builds of the current build version in the db.
+func countCachedBuilds() {
+	n := 0
+	for _, prefix := range []string{fmt.Sprintf("v%d/", VERSION), "stable/"} {
+		keys, err := db.Keys(prefix)
+		if err != nil {
+			log.Errorf("db: %v", err)
+			return
+		}
+		n += len(keys)
+	}
+	atomic.AddInt64(&cachedBuilds, int64(n))
+}
+
+// trackCachedBuild updates the counter of the esm builds when a build record is added(+1) or removed(-1).
+func trackCachedBuild(id string, delta int64) {
+	if strings.HasPrefix(id, fmt.Sprintf("v%d/", VERSION)) || strings.HasPrefix(id, "stable/") {
+		atomic.AddInt64(&cachedBuilds, delta)
+	}
+}
+
+// smallFileSizeLimit is the max size of the storage files that can be cached in memory.
+const smallFileSizeLimit = 16 * 1024
+
+// the in-memory cache of the small storage files, it's bounded to 64MB
+var smallFileCache = newLRUCache(64 * 1024 * 1024)
+
+// openStorageFile opens a file in the storage, small files are cached in memory to reduce disk
+// reads under high concurrency.
+func openStorageFile(name string) (content io.ReadSeekCloser, modtime time.Time, err error) {
+	fi, err := fs.Stat(name)
+	if err != nil {
+		smallFileCache.Delete(name)
+		return
+	}
+	// the cached file is used only if the file is not changed, e.g. purged or synced
+	if data, ok := smallFileCache.Get(name); ok && len(data) >= 8 && int64(binary.BigEndian.Uint64(data[:8])) == fi.ModTime().UnixNano() {
+		modtime = fi.ModTime()
+		content = &bytesReadSeekCloser{bytes.NewReader(data[8:])}
+		return
+	}
+	modtime = fi.ModTime()
+	content, err = fs.OpenFile(name)
+	if err != nil || fi.Size() > smallFileSizeLimit {
+		return
+	}
+
+	data := make([]byte, 8+fi.Size())
+	binary.BigEndian.PutUint64(data[:8], uint64(modtime.UnixNano()))
+	_, err = io.ReadFull(content, data[8:])
+	content.Close()
+	if err != nil {
+		return nil, modtime, err
+	}
+	smallFileCache.Set(name, data)
+	content = &bytesReadSeekCloser{bytes.NewReader(data[8:])}
+	return
+}
+
+// serveStorageFile streams the file content to the client with `http.ServeContent`, that
+// handles the conditional and range requests without reading the whole file into memory.
+func serveStorageFile(ctx *rex.Context, name string, modtime time.Time, content io.ReadSeekCloser) interface{} {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = content.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		content.Close()
+		return rex.Status(500, err.Error())
+	}
+	// weak etag since the content may be compressed
+	ctx.SetHeader("ETag", fmt.Sprintf(`W/"%x-%x"`, modtime.UnixNano(), size))
+	if hash, ok := getArtifactHash(name, modtime, content); ok {
+		ctx.SetHeader("X-Content-Sha256", hash)
+	}
+	if sig := getArtifactSignature(name); sig != "" {
+		ctx.SetHeader("X-Esm-Signature", sig)
+		ctx.SetHeader("X-Esm-Signature-Path", name)
+	}
+	return rex.Content(name, modtime, content) // auto closed
+}
+
+// getBuildIntegrity returns the subresource integrity(sha384) of the build, the integrity is computed
+// at build time and stored in the db, the builds before that are hashed from the storage.
+func getBuildIntegrity(id string) (string, error) {
+	if esm, ok := queryESMBuild(id); ok && esm.Integrity != "" {
+		return esm.Integrity, nil
+	}
+	if strings.HasPrefix(id, "stable/") {
+		id = fmt.Sprintf("v%d/", STABLE_VERSION) + strings.TrimPrefix(id, "stable/")
+	}
+	r, _, err := openStorageFile(path.Join("builds", id))
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	h := sha512.New384()
+	_, err = io.Copy(h, r)
+	if err != nil {
+		return "", err
+	}
+	return "sha384-" + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// getSourceMapOutput returns the source map of the js output with the fixed mappings, or nil if not found.
+func getSourceMapOutput(outputFiles []api.OutputFile, appendLines int) map[string]interface{} {
+	for _, file := range outputFiles {
+		if strings.HasSuffix(file.Path, ".js.map") {
+			return fixSourceMap(file.Contents, appendLines)
+		}
+	}
+	return nil
+}
+
+// fixSourceMap shifts the mappings of the source map by the lines that are prepended to the js output.
+func fixSourceMap(data []byte, appendLines int) map[string]interface{} {
+	var sourceMap map[string]interface{}
+	if json.Unmarshal(data, &sourceMap) != nil {
+		return nil
+	}
+	if mapping, ok := sourceMap["mappings"].(string); ok && appendLines > 0 {
+		fixedMapping := make([]byte, appendLines+len(mapping))
+		for i := 0; i < appendLines; i++ {
+			fixedMapping[i] = ';'
+		}
+		copy(fixedMapping[appendLines:], mapping)
+		sourceMap["mappings"] = string(fixedMapping)
+	}
+	return sourceMap
+}
+
+// the in-memory cache of the artifact hashes, it's bounded to 16MB
+var artifactHashCache = newLRUCache(16 * 1024 * 1024)
+
+// getArtifactHash returns the SHA-256 hash of the stored file in the format of the deno lockfile,
+// the hash is cached by the modtime of the file.
+func getArtifactHash(name string, modtime time.Time, content io.ReadSeeker) (string, bool) {
+	cacheKey := fmt.Sprintf("%s:%d", name, modtime.UnixNano())
+	if data, ok := artifactHashCache.Get(cacheKey); ok {
+		return string(data), true
+	}
+	h := sha256.New()
+	_, err := io.Copy(h, content)
+	if err == nil {
+		_, err = content.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return "", false
+	}
+	hash := fmt.Sprintf("%x", h.Sum(nil))
+	artifactHashCache.Set(cacheKey, []byte(hash))
+	return hash, true
+}
+
+type bytesReadSeekCloser struct {
+	*bytes.Reader
+}
+
+func (r *bytesReadSeekCloser) Close() error {
+	return nil
+}
+
 var esmExts = []string{".mjs", ".js", ".jsx", ".mts", ".ts", ".tsx"}
 
 func resolveESModule(wd string, packageName string, moduleSpecifier string) (resolvedName string, namedExports []string, err error) {
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/esm-dev/esm.sh/server/config"
+	"github.com/esm-dev/esm.sh/server/storage"
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+func TestBuildIntegrity(t *testing.T) {
+	dir := t.TempDir()
+	var err error
+	db, err = storage.OpenDB("bolt:" + path.Join(dir, "esm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err = storage.OpenCache("memory:test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err = storage.OpenFS("local:" + path.Join(dir, "storage"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		db.Close()
+		db = nil
+		cache = nil
+		fs = nil
+	}()
+
+	content := "export default 42;\n"
+	sum := sha512.Sum384([]byte(content))
+	integrity := "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+
+	// the integrity computed at build time
+	id := fmt.Sprintf("v%d/foo@1.0.0/es2022/foo.mjs", VERSION)
+	fs.WriteFile(path.Join("builds", id), strings.NewReader(content))
+	db.Put(id, []byte(`{"i":"sha384-stored"}`))
+	if v, err := getBuildIntegrity(id); err != nil || v != "sha384-stored" {
+		t.Fatalf("invalid integrity: %s, %v", v, err)
+	}
+
+	// the builds before the integrity was stored are hashed from the storage
+	id = fmt.Sprintf("v%d/bar@1.0.0/es2022/bar.mjs", VERSION)
+	fs.WriteFile(path.Join("builds", id), strings.NewReader(content))
+	if v, err := getBuildIntegrity(id); err != nil || v != integrity {
+		t.Fatalf("invalid integrity: %s, %v", v, err)
+	}
+	fs.WriteFile(path.Join("builds", fmt.Sprintf("v%d", STABLE_VERSION), "vue@3.0.0/es2022/vue.mjs"), strings.NewReader(content))
+	if v, err := getBuildIntegrity("stable/vue@3.0.0/es2022/vue.mjs"); err != nil || v != integrity {
+		t.Fatalf("invalid integrity of the stable build: %s, %v", v, err)
+	}
+
+	if _, err := getBuildIntegrity(fmt.Sprintf("v%d/baz@1.0.0/es2022/baz.mjs", VERSION)); err != storage.ErrNotFound {
+		t.Fatalf("the missing build should be not found: %v", err)
+	}
+}
+
+func TestFixSourceMap(t *testing.T) {
+	outputFiles := []api.OutputFile{
+		{Path: "/esbuild/stdin.js", Contents: []byte("console.log(1);")},
+		{Path: "/esbuild/stdin.js.map", Contents: []byte(`{"version":3,"sources":["index.js"],"mappings":"AAAA"}`)},
+	}
+	sourceMap := getSourceMapOutput(outputFiles, 2)
+	if sourceMap == nil || sourceMap["mappings"] != ";;AAAA" {
+		t.Fatalf("invalid source map: %v", sourceMap)
+	}
+	if sourceMap := getSourceMapOutput(outputFiles[:1], 2); sourceMap != nil {
+		t.Fatalf("the source map should not be found: %v", sourceMap)
+	}
+	if sourceMap := fixSourceMap([]byte("invalid"), 0); sourceMap != nil {
+		t.Fatalf("the invalid source map should be nil: %v", sourceMap)
+	}
+}
+
+func TestAnalyzeSourceSubmodule(t *testing.T) {
+	dir := t.TempDir()
+	withConfig(t, &config.Config{})
+
+	files := map[string]string{
+		"node_modules/foo/package.json": `{"name":"foo","version":"1.0.0","main":"index.js"}`,
+		"node_modules/foo/index.js":     "module.exports = {};\n",
+		"node_modules/foo/src/a.ts":     "export interface A { a: string }\nexport const a = <T>(v: T): T => v;\n",
+		"node_modules/foo/src/a.js":     "exports.a = (v) => v;\n",
+		"node_modules/foo/src/b.jsx":    "export default function B() { return <div />; }\n",
+	}
+	for name, content := range files {
+		filename := path.Join(dir, name)
+		os.MkdirAll(path.Dir(filename), 0755)
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for subpath, exports := range map[string]string{"src/a.ts": "a", "src/b.jsx": "default"} {
+		task := newTestBuildTask(Pkg{Name: "foo", Version: "1.0.0", Subpath: subpath, Submodule: toModuleName(subpath)}, "esnext")
+		task.wd = dir
+		esm, npm, _, err := task.analyze()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the source file is used instead of the compiled file with the same name
+		if npm.Module != subpath || strings.Join(esm.NamedExports, ",") != exports {
+			t.Fatalf("%s: invalid module %s, exports %v", subpath, npm.Module, esm.NamedExports)
+		}
+	}
+}
+
+func TestEsbuildCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	task := newTestBuildTask(Pkg{Name: "foo", Version: "1.0.0"}, "es2022")
+	task.ctx = ctx
+	result, err := task.esbuild(api.BuildOptions{
+		Stdin:  &api.StdinOptions{Contents: "export default 42;\n"},
+		Bundle: true,
+		Write:  false,
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the context error, got %v", err)
+	}
+	if len(result.OutputFiles) > 0 {
+		t.Fatal("the canceled build should not return any output")
+	}
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ije/gox/utils"
+)
+
+var (
+	regexpExportStar   = regexp.MustCompile(`export\s*\*\s*from\s*["'](\.{1,2}/[^"']+)["']`)
+	regexpExportStarAs = regexp.MustCompile(`export\s*\*\s*as\s+([\w$]+)\s+from\s*["'](\.{1,2}/[^"']+)["']`)
+	regexpExportFrom   = regexp.MustCompile(`export\s*\{([^}]*)\}\s*from\s*["'](\.{1,2}/[^"']+)["']`)
+	regexpExportList   = regexp.MustCompile(`export\s*\{([^}]*)\}\s*(?:;|\n|$)`)
+	regexpExportDecl   = regexp.MustCompile(`export\s+(?:async\s+)?(?:const|let|var|function\s*\*?|class)\s+([\w$]+)`)
+)
+
+// the max depth of `export * from` to scan the barrel module
+const maxBarrelDepth = 4
+
+// lazyExport is an export of the lazy index, the `name` is empty for the module namespace.
+type lazyExport struct {
+	file string
+	name string
+}
+
+// buildLazyIndex builds an index module for the barrel package with the `?lazy` query, the
+// index loads the submodules on demand by `import()` behind a Proxy:
+//
+//	import pkg from "https://esm.sh/@aws-sdk/client-s3?lazy";
+//	const PutObjectCommand = await pkg.PutObjectCommand;
+//
+// The submodules are built with the `?lazy` query as well, that imports the local modules of
+// the package instead of bundling them, so the submodules share the same module instances.
+func (task *BuildTask) buildLazyIndex(esm *ESMBuild, npm NpmPackage) (err error) {
+	args := task.BuildArgs
+
+	// key: property name, value: [import url, export name]
+	modules := map[string][2]string{}
+
+	// the submodules of the `exports` field of package.json
+	if m, ok := npm.DefinedExports.(map[string]interface{}); ok {
+		for key := range m {
+			if !strings.HasPrefix(key, "./") || strings.ContainsRune(key, '*') || endsWith(key, ".json", ".css") {
+				continue
+			}
+			subPath := strings.TrimPrefix(key, "./")
+			subPkg := Pkg{
+				Name:      task.Pkg.Name,
+				Version:   task.Pkg.Version,
+				Subpath:   subPath,
+				Submodule: toModuleName(subPath),
+			}
+			modules[subPkg.Submodule] = [2]string{task.getImportPath(subPkg, encodeBuildArgsPrefix(args, subPkg, false)), ""}
+		}
+	}
+
+	// the named exports of the barrel module
+	if npm.Module != "" {
+		pkgDir := path.Join(task.getRealWD(), "node_modules", npm.Name)
+		entry := strings.TrimPrefix(path.Clean(npm.Module), "./")
+		exports := map[string]lazyExport{}
+		scanBarrelExports(pkgDir, entry, exports, newStringSet(), 0)
+		for name, e := range exports {
+			if _, ok := modules[name]; ok || name == "default" {
+				continue
+			}
+			subPkg := Pkg{
+				Name:      task.Pkg.Name,
+				Version:   task.Pkg.Version,
+				Subpath:   e.file,
+				Submodule: toModuleName(e.file),
+			}
+			modules[name] = [2]string{task.getImportPath(subPkg, encodeBuildArgsPrefix(args, subPkg, false)), e.name}
+		}
+	}
+
+	if len(modules) == 0 {
+		return fmt.Errorf("lazy: no submodules found in %s", task.Pkg)
+	}
+
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString("const modules = {\n")
+	for _, name := range names {
+		fmt.Fprintf(buf, "  %s: %s,\n", bytes.TrimSpace(utils.MustEncodeJSON(name)), bytes.TrimSpace(utils.MustEncodeJSON(modules[name])))
+	}
+	buf.WriteString("};\n")
+	buf.WriteString("const cache = {};\n")
+	buf.WriteString("const has = (name) => typeof name === \"string\" && Object.prototype.hasOwnProperty.call(modules, name);\n")
+	fmt.Fprintf(buf, "export function load(name) {\n")
+	fmt.Fprintf(buf, "  if (!has(name)) return Promise.reject(new Error(`\"${name}\" is not exported by %s`));\n", task.Pkg.Name)
+	buf.WriteString("  const [url, key] = modules[name];\n")
+	buf.WriteString("  return (cache[url] || (cache[url] = import(url))).then((m) => key ? m[key] : m);\n")
+	buf.WriteString("}\n")
+	buf.WriteString("export default new Proxy({}, {\n")
+	buf.WriteString("  get: (_, name) => has(name) ? load(name) : undefined,\n")
+	buf.WriteString("  has: (_, name) => has(name),\n")
+	buf.WriteString("  ownKeys: () => Object.keys(modules),\n")
+	buf.WriteString("  getOwnPropertyDescriptor: (_, name) => has(name) ? { enumerable: true, configurable: true } : undefined,\n")
+	buf.WriteString("});\n")
+
+	_, err = fs.WriteFile(task.getSavepath(), buf)
+	if err != nil {
+		return
+	}
+	esm.HasExportDefault = true
+	esm.NamedExports = []string{"load"}
+	esm.CJS = false
+	return
+}
+
+// buildLazyIndexDTS writes the types of the lazy index that wrap the types of the package, the
+// exports are typed as promises to match the Proxy of the index.
+func (task *BuildTask) buildLazyIndexDTS(esm *ESMBuild) (err error) {
+	if esm.Dts == "" {
+		return
+	}
+	bv := task.BuildVersion
+	if stableBuild[task.Pkg.Name] {
+		bv = STABLE_VERSION
+	}
+	dts := fmt.Sprintf("%s@%s/%sindex.lazy.d.ts", task.Pkg.Name, task.Pkg.Version, encodeBuildArgsPrefix(task.BuildArgs, task.Pkg, true))
+	savePath := path.Join("types", getTypesRoot(task.CdnOrigin), fmt.Sprintf("v%d", bv)+task.ghPrefix(), dts)
+	_, err = fs.WriteFile(savePath, bytes.NewReader(generateLazyIndexDTSContent(task.Pkg, fmt.Sprintf("%s%s%s", task.CdnOrigin, cfg.BasePath, esm.Dts))))
+	if err != nil {
+		return
+	}
+	esm.Dts = fmt.Sprintf("%s%s/%s", getTypesPathPrefix(bv), task.ghPrefix(), dts)
+	return
+}
+
+// generateLazyIndexDTSContent returns the types of the lazy index of the package, the submodules that
+// are not exported by the root module are typed as `any`.
+func generateLazyIndexDTSContent(pkg Pkg, dtsUrl string) []byte {
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "/* esm.sh - the types of the lazy index of %s */\n", pkg)
+	fmt.Fprintf(buf, "import type * as __module from %s;\n", bytes.TrimSpace(utils.MustEncodeJSON(dtsUrl)))
+	buf.WriteString("type __Exports = Omit<typeof __module, \"default\">;\n")
+	buf.WriteString("type __Lazy = { readonly [K in keyof __Exports]: Promise<__Exports[K]> } & { readonly [name: string]: Promise<any> | undefined };\n")
+	buf.WriteString("export declare function load<K extends keyof __Exports>(name: K): Promise<__Exports[K]>;\n")
+	buf.WriteString("export declare function load(name: string): Promise<any>;\n")
+	buf.WriteString("declare const __default: __Lazy;\n")
+	buf.WriteString("export default __default;\n")
+	return buf.Bytes()
+}
+
+// scanBarrelExports scans the named exports of the module and the modules of `export * from`
+// statements, the exports are mapped to the files that declare them.
+func scanBarrelExports(pkgDir string, file string, exports map[string]lazyExport, visited *stringSet, depth int) {
+	if depth > maxBarrelDepth || visited.Has(file) {
+		return
+	}
+	visited.Add(file)
+	data, err := os.ReadFile(path.Join(pkgDir, file))
+	if err != nil {
+		return
+	}
+	code := stripJSComments(string(data))
+
+	add := func(name string, e lazyExport) {
+		if _, ok := exports[name]; !ok && regexpJSIdent.MatchString(name) {
+			exports[name] = e
+		}
+	}
+	for _, m := range regexpExportDecl.FindAllStringSubmatch(code, -1) {
+		add(m[1], lazyExport{file, m[1]})
+	}
+	for _, m := range regexpExportList.FindAllStringSubmatch(code, -1) {
+		for _, local := range strings.Split(m[1], ",") {
+			_, exported := splitExportSpecifier(local)
+			add(exported, lazyExport{file, exported})
+		}
+	}
+	for _, m := range regexpExportFrom.FindAllStringSubmatch(code, -1) {
+		if f, ok := resolveBarrelFile(pkgDir, file, m[2]); ok {
+			for _, specifier := range strings.Split(m[1], ",") {
+				imported, exported := splitExportSpecifier(specifier)
+				add(exported, lazyExport{f, imported})
+			}
+		}
+	}
+	for _, m := range regexpExportStarAs.FindAllStringSubmatch(code, -1) {
+		if f, ok := resolveBarrelFile(pkgDir, file, m[2]); ok {
+			add(m[1], lazyExport{f, ""})
+		}
+	}
+	for _, m := range regexpExportStar.FindAllStringSubmatch(code, -1) {
+		if f, ok := resolveBarrelFile(pkgDir, file, m[1]); ok {
+			scanBarrelExports(pkgDir, f, exports, visited, depth+1)
+		}
+	}
+}
+
+// splitExportSpecifier splits `a as b` to `a` and `b`.
+func splitExportSpecifier(s string) (imported string, exported string) {
+	s = strings.TrimSpace(s)
+	if a := strings.Fields(s); len(a) == 3 && a[1] == "as" {
+		return a[0], a[2]
+	}
+	return s, s
+}
+
+func resolveBarrelFile(pkgDir string, importer string, specifier string) (string, bool) {
+	file := path.Join(path.Dir(importer), specifier)
+	for _, f := range []string{file, file + ".js", file + ".mjs", path.Join(file, "index.js"), path.Join(file, "index.mjs")} {
+		fi, err := os.Stat(path.Join(pkgDir, f))
+		if err == nil && !fi.IsDir() {
+			return f, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestScanBarrelExports(t *testing.T) {
+	pkgDir := t.TempDir()
+	files := map[string]string{
+		"dist-es/index.js":                     "export * from \"./Client\";\nexport * from \"./commands\";\nexport * as models from \"./models/index.js\";\nexport { default as Paginator, helper } from \"./paginator\";\nexport const VERSION = \"1.0.0\";\n",
+		"dist-es/Client.js":                    "class Client {}\nexport { Client };\n",
+		"dist-es/commands/index.js":            "export * from \"./GetObjectCommand\";\nexport * from \"./PutObjectCommand\";\n",
+		"dist-es/commands/GetObjectCommand.js": "export class GetObjectCommand {}\n",
+		"dist-es/commands/PutObjectCommand.js": "/* export const Foo = 1; */\nexport class PutObjectCommand {}\n",
+		"dist-es/models/index.js":              "export const A = 1;\n",
+		"dist-es/paginator.js":                 "export default function () {}\nexport function helper() {}\n",
+	}
+	for name, content := range files {
+		filename := path.Join(pkgDir, name)
+		os.MkdirAll(path.Dir(filename), 0755)
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exports := map[string]lazyExport{}
+	scanBarrelExports(pkgDir, "dist-es/index.js", exports, newStringSet(), 0)
+	expected := map[string]lazyExport{
+		"VERSION":          {"dist-es/index.js", "VERSION"},
+		"Client":           {"dist-es/Client.js", "Client"},
+		"GetObjectCommand": {"dist-es/commands/GetObjectCommand.js", "GetObjectCommand"},
+		"PutObjectCommand": {"dist-es/commands/PutObjectCommand.js", "PutObjectCommand"},
+		"models":           {"dist-es/models/index.js", ""},
+		"Paginator":        {"dist-es/paginator.js", "default"},
+		"helper":           {"dist-es/paginator.js", "helper"},
+	}
+	if len(exports) != len(expected) {
+		t.Fatalf("expected %d exports, got %v", len(expected), exports)
+	}
+	for name, e := range expected {
+		if exports[name] != e {
+			t.Fatalf("export %s: expected %v, got %v", name, e, exports[name])
+		}
+	}
+}
+
+func TestGenerateLazyIndexDTSContent(t *testing.T) {
+	dts := string(generateLazyIndexDTSContent(Pkg{Name: "barrel", Version: "1.0.0"}, "https://esm.sh/v135/barrel@1.0.0/index.d.ts"))
+	for _, s := range []string{
+		"import type * as __module from \"https://esm.sh/v135/barrel@1.0.0/index.d.ts\";\n",
+		"export declare function load<K extends keyof __Exports>(name: K): Promise<__Exports[K]>;\n",
+		"export default __default;\n",
+	} {
+		if !strings.Contains(dts, s) {
+			t.Fatalf("missing %q in:\n%s", s, dts)
+		}
+	}
+}
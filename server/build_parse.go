@@ -0,0 +1,93 @@
+package server
+
+import (
+	"sort"
+)
+
+// ParseOutput is the response of the `?parse` query, it reports how the url is interpreted.
+type ParseOutput struct {
+	Package      Pkg          `json:"package"`
+	Target       string       `json:"target"`
+	TargetFromUA bool         `json:"targetFromUA,omitempty"`
+	Dev          bool         `json:"dev,omitempty"`
+	Bundle       bool         `json:"bundle,omitempty"`
+	Worker       bool         `json:"worker,omitempty"`
+	BuildVersion int          `json:"buildVersion"`
+	Options      ParseOptions `json:"options"`
+	// the id of the build, e.g. "v135/react@18.3.1/es2022/react.mjs"
+	BuildID string `json:"buildId"`
+	// the canonical url of the build
+	URL string `json:"url"`
+}
+
+// ParseOptions is the build options that are parsed from the url.
+type ParseOptions struct {
+	Alias             map[string]string `json:"alias,omitempty"`
+	Deps              []string          `json:"deps,omitempty"`
+	External          []string          `json:"external,omitempty"`
+	Exports           []string          `json:"exports,omitempty"`
+	Conditions        []string          `json:"conditions,omitempty"`
+	BundleScopes      []string          `json:"bundleScopes,omitempty"`
+	BundleDepsUnder   int64             `json:"bundleDepsUnder,omitempty"`
+	DenoStdVersion    string            `json:"denoStdVersion,omitempty"`
+	Registry          string            `json:"registry,omitempty"`
+	Global            string            `json:"global,omitempty"`
+	IgnoreRequire     bool              `json:"ignoreRequire,omitempty"`
+	IgnoreAnnotations bool              `json:"ignoreAnnotations,omitempty"`
+	KeepNames         bool              `json:"keepNames,omitempty"`
+	MV3               bool              `json:"mv3,omitempty"`
+	Lazy              bool              `json:"lazy,omitempty"`
+}
+
+// parse returns how the url of the build task is interpreted for the `?parse` query.
+func (task *BuildTask) parse(targetFromUA bool, isWorker bool) ParseOutput {
+	sortedValues := func(set *stringSet) []string {
+		if set == nil || set.Len() == 0 {
+			return nil
+		}
+		values := set.Values()
+		sort.Strings(values)
+		return values
+	}
+	args := task.BuildArgs
+	options := ParseOptions{
+		External:          sortedValues(args.external),
+		Exports:           sortedValues(args.treeShaking),
+		Conditions:        sortedValues(args.conditions),
+		BundleScopes:      sortedValues(args.bundleScopes),
+		BundleDepsUnder:   args.bundleDepsUnder,
+		Registry:          args.registry,
+		Global:            args.global,
+		IgnoreRequire:     args.ignoreRequire,
+		IgnoreAnnotations: args.ignoreAnnotations,
+		KeepNames:         args.keepNames,
+		MV3:               args.mv3,
+		Lazy:              args.lazy,
+	}
+	if len(args.alias) > 0 {
+		options.Alias = args.alias
+	}
+	for _, dep := range args.deps {
+		options.Deps = append(options.Deps, dep.Name+"@"+dep.Version)
+	}
+	sort.Strings(options.Deps)
+	if task.Target == "deno" {
+		options.DenoStdVersion = args.denoStdVersion
+	}
+	url := task.CdnOrigin + cfg.BasePath + "/" + task.ID()
+	if isWorker {
+		url += "?worker"
+	}
+	return ParseOutput{
+		Package:      task.Pkg,
+		Target:       task.Target,
+		TargetFromUA: targetFromUA,
+		Dev:          task.Dev,
+		Bundle:       task.Bundle,
+		Worker:       isWorker,
+		BuildVersion: task.BuildVersion,
+		Options:      options,
+		BuildID:      task.ID(),
+		URL:          url,
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/esm-dev/esm.sh/server/config"
+)
+
+func TestParse(t *testing.T) {
+	withConfig(t, &config.Config{BasePath: "/cdn"})
+
+	task := &BuildTask{
+		BuildArgs: BuildArgs{
+			alias:        map[string]string{"react": "preact/compat"},
+			deps:         PkgSlice{{Name: "preact", Version: "10.19.0"}, {Name: "htm", Version: "3.1.1"}},
+			external:     newStringSet(),
+			treeShaking:  newStringSet("useState", "useEffect"),
+			conditions:   newStringSet(),
+			bundleScopes: newStringSet(),
+			keepNames:    true,
+		},
+		Pkg:          Pkg{Name: "swr", Version: "2.2.5", Subpath: "infinite", Submodule: "infinite"},
+		CdnOrigin:    "https://esm.sh",
+		Target:       "es2022",
+		BuildVersion: 135,
+		Dev:          true,
+	}
+	out := task.parse(true, false)
+	if out.BuildID != task.ID() || out.URL != "https://esm.sh/cdn/"+task.ID() {
+		t.Fatalf("invalid build id or url: %s, %s", out.BuildID, out.URL)
+	}
+	data, err := json.Marshal(out.Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"alias":{"react":"preact/compat"},"deps":["htm@3.1.1","preact@10.19.0"],"exports":["useEffect","useState"],"keepNames":true}` {
+		t.Fatalf("invalid options: %s", data)
+	}
+	if !out.Dev || out.Bundle || !out.TargetFromUA || out.Package.Submodule != "infinite" {
+		t.Fatalf("invalid output: %v", out)
+	}
+
+	if out := task.parse(false, true); out.URL != "https://esm.sh/cdn/"+task.ID()+"?worker" {
+		t.Fatalf("invalid worker url: %s", out.URL)
+	}
+}
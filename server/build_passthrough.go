@@ -0,0 +1,380 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var regexpSourceMappingURL = regexp.MustCompile(`(?m)^//[#@] sourceMappingURL=.*$`)
+
+// the globals of Node.js that are replaced by the build, the module that uses them can't be passed through
+var passthroughUnsafeIdents = newStringSet(
+	"require", "module", "exports", "process", "Buffer", "global",
+	"__filename", "__dirname", "setImmediate", "clearImmediate",
+)
+
+// esmImportSpecifier is the module specifier of the import statement or the `import()` expression,
+// `start` and `end` are the offsets of the string literal including the quotes.
+type esmImportSpecifier struct {
+	specifier string
+	start     int
+	end       int
+}
+
+// passthrough serves the ES module entry of the package without esbuild if it's a single file that only
+// imports the bare specifiers, the specifiers are rewritten to the urls with the pinned versions.
+// It returns false if the module needs to be built by esbuild, e.g. the module imports local files, uses
+// the Node.js globals, or the build options need the bundler.
+func (task *BuildTask) passthrough(esm *ESMBuild, npm NpmPackage) (ok bool, err error) {
+	switch task.Target {
+	case "esnext", "denonext", "deno", "node":
+	default:
+		// the syntax may need to be lowered
+		return
+	}
+	if npm.Module == "" || !endsWith(npm.Module, ".mjs", ".js") || task.Bundle || task.mv3 || task.bundleDepsUnder > 0 || task.treeShaking.Len() > 0 {
+		return
+	}
+	if task.bundleScopes != nil && task.bundleScopes.Len() > 0 {
+		return
+	}
+	if len(npm.Browser) > 0 && !task.isServerTarget() {
+		return
+	}
+	recipe, _ := task.getRecipe()
+	if recipe == nil {
+		recipe = &Recipe{}
+	}
+	if len(recipe.Define) > 0 {
+		return
+	}
+
+	entry := path.Join(task.wd, "node_modules", npm.Name, npm.Module)
+	if _, err = realpathIn(task.wd, entry); err != nil {
+		return
+	}
+	code, err := os.ReadFile(entry)
+	if err != nil {
+		return
+	}
+	imports, idents, valid := scanESMImports(code)
+	if !valid || len(idents) > 0 {
+		debugf("build", task.Pkg.Name, "passthrough %s: skipped, globals: %v", task.ID(), idents)
+		return
+	}
+
+	task.imports = PkgSlice{}
+	importPaths := make([]string, len(imports))
+	for i, imp := range imports {
+		specifier := imp.specifier
+		if isRemoteSpecifier(specifier) || strings.HasPrefix(specifier, "data:") {
+			importPaths[i] = specifier
+			continue
+		}
+		if isLocalSpecifier(specifier) || strings.HasPrefix(specifier, "#") || strings.HasPrefix(specifier, "file:") {
+			return
+		}
+		specifier = strings.TrimSuffix(specifier, "/")
+		specifier = strings.TrimPrefix(specifier, "node:")
+		specifier = strings.TrimPrefix(specifier, "npm:")
+		if name, ok := task.alias[specifier]; ok {
+			specifier = name
+		} else if name, ok := recipe.Alias[specifier]; ok {
+			specifier = name
+		}
+		if specifier == task.Pkg.ImportPath() {
+			return
+		}
+		pkgName, subpath := splitPkgPath(specifier)
+		if ext := path.Ext(subpath); ext != "" && ext != ".js" && ext != ".mjs" {
+			// e.g. json, css, wasm
+			return
+		}
+		if v := npm.Dependencies[pkgName]; isLocalDependencyVersion(v) || strings.HasPrefix(v, "git") {
+			return
+		}
+		for _, name := range nativeNodePackages {
+			if pkgName == name {
+				return
+			}
+		}
+		importPaths[i], err = task.resolveExternalImportPath(specifier, npm)
+		if err != nil {
+			return
+		}
+	}
+
+	// rewrite the import specifiers
+	buf := bytes.NewBuffer(nil)
+	buf.Grow(len(code))
+	offset := 0
+	for i, imp := range imports {
+		buf.Write(code[offset:imp.start])
+		fmt.Fprintf(buf, "\"%s\"", importPaths[i])
+		offset = imp.end
+	}
+	buf.Write(code[offset:])
+	jsContent := buf.Bytes()
+
+	// remove shebang
+	if bytes.HasPrefix(jsContent, []byte("#!")) {
+		jsContent = jsContent[bytes.IndexByte(jsContent, '\n')+1:]
+	}
+	// the source map of the package is not served
+	jsContent = regexpSourceMappingURL.ReplaceAll(jsContent, nil)
+	jsContent = rewriteJS(task, jsContent)
+
+	footer := bytes.NewBuffer(nil)
+	if task.Deprecated != "" {
+		fmt.Fprintf(footer, `console.warn("[npm] %%cdeprecated%%c %s@%s: %s", "color:red", "");%s`, task.Pkg.Name, task.Pkg.Version, task.Deprecated, "\n")
+	}
+	size := len(jsContent) + footer.Len()
+	err = task.writeJS(esm, io.MultiReader(bytes.NewReader(jsContent), footer))
+	if err != nil {
+		return
+	}
+
+	// the metafile of the passthrough build has only one input for the `?analyze` query
+	if cfg.BuildAnalysis {
+		input := path.Join("node_modules", npm.Name, npm.Module)
+		var metafile []byte
+		metafile, err = json.Marshal(Metafile{
+			Inputs: map[string]MetafileInput{input: {Bytes: len(code)}},
+			Outputs: map[string]MetafileOutput{path.Base(task.getSavepath()): {
+				Bytes:      size,
+				Inputs:     map[string]MetafileOutputInput{input: {BytesInOutput: size}},
+				Exports:    esm.NamedExports,
+				EntryPoint: input,
+			}},
+		})
+		if err != nil {
+			return
+		}
+		_, err = fs.WriteFile(task.getSavepath()+".metafile.json", bytes.NewReader(metafile))
+		if err != nil {
+			return
+		}
+	}
+
+	if cfg.GenerateTypes {
+		task.exports = esm.NamedExports
+	}
+	esm.Passthrough = true
+	debugf("build", task.Pkg.Name, "passthrough %s", task.ID())
+	return true, nil
+}
+
+// scanESMImports scans the import specifiers of the ES module and the Node.js globals that the module
+// uses, the strings, comments, template literals and regular expressions are skipped. It returns false
+// if the module can't be scanned reliably, e.g. the specifier of `import()` is not a string literal.
+func scanESMImports(code []byte) (imports []esmImportSpecifier, idents []string, ok bool) {
+	// the last token, `lastWord` is empty if the last token is not an identifier or keyword
+	var lastChar byte
+	var lastWord string
+	var lastWordAfterDot bool
+	// the `import(` expression expects a string literal and then `)` or `,`
+	var inImportCall, expectImportCallEnd bool
+	// the braces of the code, `true` for the `${` of the template literal
+	var braces []bool
+	seen := newStringSet()
+
+	i := 0
+	if bytes.HasPrefix(code, []byte("#!")) {
+		if i = bytes.IndexByte(code, '\n'); i < 0 {
+			return nil, nil, true
+		}
+	}
+
+	for i < len(code) {
+		c := code[i]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			i++
+			continue
+		}
+		if c == '/' && i+1 < len(code) && code[i+1] == '/' {
+			j := bytes.IndexByte(code[i:], '\n')
+			if j < 0 {
+				break
+			}
+			i += j
+			continue
+		}
+		if c == '/' && i+1 < len(code) && code[i+1] == '*' {
+			j := bytes.Index(code[i+2:], []byte("*/"))
+			if j < 0 {
+				return
+			}
+			i += j + 4
+			continue
+		}
+
+		if inImportCall && c != '"' && c != '\'' {
+			return
+		}
+		if expectImportCallEnd {
+			if c != ')' && c != ',' {
+				return
+			}
+			expectImportCallEnd = false
+		}
+
+		switch {
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(code) && code[j] != c {
+				if code[j] == '\\' {
+					j++
+				} else if code[j] == '\n' {
+					return
+				}
+				j++
+			}
+			if j >= len(code) {
+				return
+			}
+			isImport := inImportCall || (!lastWordAfterDot && (lastWord == "from" || lastWord == "import"))
+			if isImport {
+				specifier := string(code[i+1 : j])
+				if strings.ContainsRune(specifier, '\\') {
+					return
+				}
+				imports = append(imports, esmImportSpecifier{specifier, i, j + 1})
+				expectImportCallEnd = inImportCall
+				inImportCall = false
+			}
+			i = j + 1
+			lastChar, lastWord = c, ""
+		case c == '`':
+			var closed bool
+			i, closed = skipTemplateLiteral(code, i+1)
+			if i < 0 {
+				return
+			}
+			if !closed {
+				braces = append(braces, true)
+			}
+			lastChar, lastWord = c, ""
+		case c == '}' && len(braces) > 0 && braces[len(braces)-1]:
+			// the end of the `${...}` of the template literal
+			braces = braces[:len(braces)-1]
+			var closed bool
+			i, closed = skipTemplateLiteral(code, i+1)
+			if i < 0 {
+				return
+			}
+			if !closed {
+				braces = append(braces, true)
+			}
+			lastChar, lastWord = '`', ""
+		case c == '/':
+			if isRegexpStart(lastChar, lastWord) {
+				j := i + 1
+				inClass := false
+				for j < len(code) && (code[j] != '/' || inClass) {
+					switch code[j] {
+					case '\\':
+						j++
+					case '[':
+						inClass = true
+					case ']':
+						inClass = false
+					case '\n':
+						return
+					}
+					j++
+				}
+				if j >= len(code) {
+					return
+				}
+				// skip the flags
+				j++
+				for j < len(code) && isJSIdentChar(code[j]) {
+					j++
+				}
+				i = j
+			} else {
+				i++
+			}
+			lastChar, lastWord = '/', ""
+		case isJSIdentChar(c) || c >= 0x80:
+			j := i
+			for j < len(code) && (isJSIdentChar(code[j]) || code[j] >= 0x80) {
+				j++
+			}
+			word := string(code[i:j])
+			if c >= '0' && c <= '9' {
+				// number literal, e.g. `1e5`, `0x1f`, `1.5`
+				for j < len(code) && (isJSIdentChar(code[j]) || code[j] == '.') {
+					j++
+				}
+				word = ""
+			} else {
+				// the spread `...` is not a member access
+				afterDot := lastChar == '.' && !(i >= 3 && string(code[i-3:i]) == "...")
+				if !afterDot && passthroughUnsafeIdents.Has(word) && !seen.Has(word) {
+					seen.Add(word)
+					idents = append(idents, word)
+				}
+				lastWordAfterDot = afterDot
+			}
+			i = j
+			lastChar, lastWord = code[j-1], word
+		default:
+			switch c {
+			case '(':
+				inImportCall = lastWord == "import" && !lastWordAfterDot
+			case '{':
+				braces = append(braces, false)
+			case '}':
+				if len(braces) > 0 {
+					braces = braces[:len(braces)-1]
+				}
+			}
+			i++
+			lastChar, lastWord = c, ""
+		}
+	}
+	if inImportCall || expectImportCallEnd || len(braces) > 0 {
+		return
+	}
+	return imports, idents, true
+}
+
+// skipTemplateLiteral skips the template literal from the offset after the "`" or "}", it returns the
+// offset after the "`" or the "${", and whether the template literal is closed. The returned offset
+// is -1 if the template literal is not terminated.
+func skipTemplateLiteral(code []byte, i int) (int, bool) {
+	for i < len(code) {
+		switch code[i] {
+		case '\\':
+			i += 2
+			continue
+		case '`':
+			return i + 1, true
+		case '$':
+			if i+1 < len(code) && code[i+1] == '{' {
+				return i + 2, false
+			}
+		}
+		i++
+	}
+	return -1, false
+}
+
+// isRegexpStart checks whether the `/` is the start of a regular expression by the last token.
+func isRegexpStart(lastChar byte, lastWord string) bool {
+	if lastWord != "" {
+		switch lastWord {
+		case "return", "typeof", "instanceof", "in", "of", "new", "delete", "void", "throw", "case", "do", "else", "yield", "await":
+			return true
+		}
+		return false
+	}
+	return lastChar == 0 || strings.IndexByte("(,=:[!&|?{};+-*%<>~^", lastChar) >= 0
+}
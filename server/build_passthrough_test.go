@@ -0,0 +1,141 @@
+package server
+
+import (
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/esm-dev/esm.sh/server/config"
+	"github.com/esm-dev/esm.sh/server/storage"
+)
+
+func TestScanESMImports(t *testing.T) {
+	code := strings.Join([]string{
+		`#!/usr/bin/env node`,
+		`import React, { useState } from "react";`,
+		`import 'polyfill'`,
+		`export * from "@scope/pkg/sub";`,
+		`export { a as from } from "a";`,
+		`// import x from "comment";`,
+		`/* export * from "block" */`,
+		`const s = "import x from 'string'";`,
+		"const tpl = `from \"template\" ${ { a: `nested ${ \"x\" }` }.a } import(\"tpl\")`;",
+		`const re = /from "regexp"['"]/g, n = 4 / 2 / 1;`,
+		`const lazy = () => import("lazy-dep");`,
+		`const obj = { process: 1 }.process, meta = import.meta.url;`,
+		`Array.from("abc");`,
+		`foo.import("method");`,
+	}, "\n")
+	imports, idents, ok := scanESMImports([]byte(code))
+	if !ok {
+		t.Fatal("should be scanned")
+	}
+	specifiers := make([]string, len(imports))
+	for i, imp := range imports {
+		specifiers[i] = imp.specifier
+		if literal := code[imp.start:imp.end]; literal[1:len(literal)-1] != imp.specifier {
+			t.Fatalf("invalid offsets of %s: %s", imp.specifier, literal)
+		}
+	}
+	if strings.Join(specifiers, ",") != "react,polyfill,@scope/pkg/sub,a,lazy-dep" {
+		t.Fatalf("invalid imports: %v", specifiers)
+	}
+	if len(idents) != 1 || idents[0] != "process" {
+		t.Fatalf("invalid idents: %v", idents)
+	}
+
+	for _, code := range []string{
+		`const m = await import(name);`,
+		`const m = await import("./locale/" + lang);`,
+		"const m = await import(`./${lang}`);",
+		`const s = "unterminated`,
+		"const s = `unterminated ${ x }",
+	} {
+		if _, _, ok := scanESMImports([]byte(code)); ok {
+			t.Fatalf("should not be scanned: %s", code)
+		}
+	}
+
+	_, idents, _ = scanESMImports([]byte(`export const cwd = process.cwd(); const env = { ...process.env }; module.exports = require("x");`))
+	if strings.Join(idents, ",") != "process,module,require" {
+		t.Fatalf("invalid idents: %v", idents)
+	}
+}
+
+func TestPassthrough(t *testing.T) {
+	dir := t.TempDir()
+	var err error
+	fs, err = storage.OpenFS("local:" + path.Join(dir, "storage"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	withConfig(t, &config.Config{BuildAnalysis: true})
+	defer func() {
+		fs = nil
+	}()
+
+	files := map[string]string{
+		"node_modules/foo/index.mjs": "import bar from \"bar\";\nimport { h } from 'https://example.com/h.js';\nexport default bar + h;\n//# sourceMappingURL=index.mjs.map\n",
+		"node_modules/foo/local.mjs": "export * from \"./index.mjs\";\n",
+		"node_modules/foo/env.mjs":   "export const dev = process.env.NODE_ENV !== \"production\";\n",
+	}
+	for name, content := range files {
+		filename := path.Join(dir, name)
+		os.MkdirAll(path.Dir(filename), 0755)
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newTask := func(target string) *BuildTask {
+		task := newTestBuildTask(Pkg{Name: "foo", Version: "1.0.0"}, target)
+		task.deps = PkgSlice{{Name: "bar", Version: "1.2.3"}}
+		task.BuildVersion = 135
+		task.wd = dir
+		return task
+	}
+	npm := NpmPackage{Name: "foo", Version: "1.0.0", Dependencies: map[string]string{"bar": "^1.0.0"}}
+
+	for _, module := range []string{"local.mjs", "env.mjs"} {
+		npm.Module = module
+		if ok, err := newTask("esnext").passthrough(&ESMBuild{}, npm); ok || err != nil {
+			t.Fatalf("%s should not be passed through: %v", module, err)
+		}
+	}
+
+	npm.Module = "index.mjs"
+	if ok, err := newTask("es2022").passthrough(&ESMBuild{}, npm); ok || err != nil {
+		t.Fatalf("the es2022 target should not be passed through: %v", err)
+	}
+
+	task := newTask("esnext")
+	esm := &ESMBuild{}
+	ok, err := task.passthrough(esm, npm)
+	if !ok || err != nil {
+		t.Fatalf("should be passed through: %v", err)
+	}
+	if !esm.Passthrough || !strings.HasPrefix(esm.Integrity, "sha384-") {
+		t.Fatalf("invalid esm build: %v", esm)
+	}
+	if len(task.imports) != 1 || task.imports[0].Name != "bar" {
+		t.Fatalf("invalid imports: %v", task.imports)
+	}
+	r, err := fs.OpenFile(task.getSavepath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := string(data)
+	if js != "import bar from \"/v135/bar@1.2.3/esnext/bar.mjs\";\nimport { h } from \"https://example.com/h.js\";\nexport default bar + h;\n\n" {
+		t.Fatalf("invalid js: %q", js)
+	}
+	if _, err := fs.Stat(task.getSavepath() + ".metafile.json"); err != nil {
+		t.Fatalf("the metafile should be stored: %v", err)
+	}
+}
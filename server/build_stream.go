@@ -0,0 +1,155 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"sync"
+)
+
+// the pending streams of the build artifacts by the save path, the stream is subscribed by the
+// request of the bare build path before the build starts, and taken by the build when it writes
+// the artifact to the storage.
+var (
+	buildStreams     = map[string]*buildStream{}
+	buildStreamsLock sync.Mutex
+)
+
+// buildStream is an in-memory buffer of the artifact that is being written to the storage, the
+// readers receive the content as it's written instead of waiting for the whole build.
+type buildStream struct {
+	cond  *sync.Cond
+	buf   []byte
+	err   error
+	done  bool
+	ready chan struct{}
+	once  sync.Once
+}
+
+func newBuildStream() *buildStream {
+	return &buildStream{
+		cond:  sync.NewCond(&sync.Mutex{}),
+		ready: make(chan struct{}),
+	}
+}
+
+// subscribeBuildStream returns the pending stream of the save path, a new stream is created if
+// no one is waiting for it.
+func subscribeBuildStream(savePath string) *buildStream {
+	buildStreamsLock.Lock()
+	defer buildStreamsLock.Unlock()
+
+	s, ok := buildStreams[savePath]
+	if !ok {
+		s = newBuildStream()
+		buildStreams[savePath] = s
+	}
+	return s
+}
+
+// unsubscribeBuildStream removes the stream if it's not taken by a build, e.g. the build failed
+// before writing the artifact.
+func unsubscribeBuildStream(savePath string, s *buildStream) {
+	buildStreamsLock.Lock()
+	defer buildStreamsLock.Unlock()
+
+	if buildStreams[savePath] == s {
+		delete(buildStreams, savePath)
+	}
+}
+
+// takeBuildStream returns the stream that the requests are waiting for, or nil if there is none.
+func takeBuildStream(savePath string) *buildStream {
+	buildStreamsLock.Lock()
+	defer buildStreamsLock.Unlock()
+
+	s, ok := buildStreams[savePath]
+	if ok {
+		delete(buildStreams, savePath)
+	}
+	return s
+}
+
+// Ready returns a channel that is closed when the first chunk is written or the stream is closed,
+// the channel of a nil stream is never closed.
+func (s *buildStream) Ready() <-chan struct{} {
+	if s == nil {
+		return nil
+	}
+	return s.ready
+}
+
+func (s *buildStream) Write(p []byte) (int, error) {
+	s.cond.L.Lock()
+	s.buf = append(s.buf, p...)
+	s.cond.L.Unlock()
+	s.cond.Broadcast()
+	s.once.Do(func() { close(s.ready) })
+	return len(p), nil
+}
+
+// Close ends the stream, the readers get the error if the write to the storage failed.
+func (s *buildStream) Close(err error) {
+	s.cond.L.Lock()
+	s.done = true
+	s.err = err
+	s.cond.L.Unlock()
+	s.cond.Broadcast()
+	s.once.Do(func() { close(s.ready) })
+}
+
+// NewReader returns a reader of the stream from the beginning.
+func (s *buildStream) NewReader() io.Reader {
+	return &buildStreamReader{s: s}
+}
+
+// ServeHTTP sends the stream with the chunked transfer encoding, the chunks are flushed as
+// they are written. The response is truncated if the write to the storage failed, so it must
+// not be cached.
+func (s *buildStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, _ := w.(http.Flusher)
+	reader := s.NewReader()
+	buf := make([]byte, 32*1024)
+	w.WriteHeader(200)
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			if _, e := w.Write(buf[:n]); e != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Errorf("stream %s: %v", r.URL.Path, err)
+			return
+		}
+	}
+}
+
+type buildStreamReader struct {
+	s      *buildStream
+	offset int
+}
+
+func (r *buildStreamReader) Read(p []byte) (int, error) {
+	s := r.s
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+
+	for r.offset >= len(s.buf) && !s.done {
+		s.cond.Wait()
+	}
+	if r.offset < len(s.buf) {
+		n := copy(p, s.buf[r.offset:])
+		r.offset += n
+		return n, nil
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+	return 0, io.EOF
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestBuildStream(t *testing.T) {
+	savePath := "builds/v126/react@18.3.1/es2022/react.mjs"
+	s := subscribeBuildStream(savePath)
+	if subscribeBuildStream(savePath) != s {
+		t.Fatal("the requests of the same path should share the stream")
+	}
+	select {
+	case <-s.Ready():
+		t.Fatal("the stream should not be ready before the first write")
+	default:
+	}
+	if takeBuildStream(savePath) != s || takeBuildStream(savePath) != nil {
+		t.Fatal("the stream should be taken once")
+	}
+	unsubscribeBuildStream(savePath, s)
+
+	done := make(chan string)
+	go func() {
+		data, err := io.ReadAll(s.NewReader())
+		if err != nil {
+			t.Error(err)
+		}
+		done <- string(data)
+	}()
+	s.Write([]byte("export "))
+	<-s.Ready()
+	s.Write([]byte("default 1;"))
+	s.Close(nil)
+	if data := <-done; data != "export default 1;" {
+		t.Fatalf("invalid stream content: %q", data)
+	}
+	// the late reader gets the whole content
+	if data, _ := io.ReadAll(s.NewReader()); string(data) != "export default 1;" {
+		t.Fatalf("invalid stream content: %q", data)
+	}
+
+	s = newBuildStream()
+	s.Write([]byte("export"))
+	s.Close(errors.New("storage: write failed"))
+	data, err := io.ReadAll(s.NewReader())
+	if string(data) != "export" || err == nil {
+		t.Fatalf("the reader should get the write error: %q, %v", data, err)
+	}
+
+	var nilStream *buildStream
+	if nilStream.Ready() != nil {
+		t.Fatal("the nil stream should never be ready")
+	}
+}
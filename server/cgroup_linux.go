@@ -0,0 +1,160 @@
+//go:build linux
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// the period of the cpu bandwidth control in microseconds
+const cgroupCPUPeriod = 100000
+
+var (
+	cgroupSeq     uint64
+	cgroupOnce    sync.Once
+	cgroupInitErr error
+	cgroupCloneFD bool
+)
+
+// initCgroupRoot creates the root cgroup and enables the controllers of its children, the root
+// cgroup has no processes so the controllers can be enabled by the "no internal processes" rule.
+func initCgroupRoot(root string, cpu float64, memory uint32) error {
+	if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err != nil {
+		return fmt.Errorf("cgroup v2 is unavailable: %v", err)
+	}
+	err := os.MkdirAll(root, 0755)
+	if err != nil {
+		return err
+	}
+	var controllers []string
+	if cpu > 0 {
+		controllers = append(controllers, "+cpu")
+	}
+	if memory > 0 {
+		controllers = append(controllers, "+memory")
+	}
+	return os.WriteFile(filepath.Join(root, "cgroup.subtree_control"), []byte(strings.Join(controllers, " ")), 0644)
+}
+
+// supportsCloneIntoCgroup checks if the kernel can start a process in a cgroup directly
+// (clone3 with CLONE_INTO_CGROUP), which requires Linux 5.7+.
+func supportsCloneIntoCgroup() bool {
+	var uts syscall.Utsname
+	if syscall.Uname(&uts) != nil {
+		return false
+	}
+	var release strings.Builder
+	for _, c := range uts.Release {
+		if c == 0 {
+			break
+		}
+		release.WriteByte(byte(c))
+	}
+	major, rest, _ := strings.Cut(release.String(), ".")
+	minor, _, _ := strings.Cut(rest, ".")
+	x, err := strconv.Atoi(major)
+	if err != nil {
+		return false
+	}
+	y, _ := strconv.Atoi(strings.TrimRightFunc(minor, func(r rune) bool { return r < '0' || r > '9' }))
+	return x > 5 || (x == 5 && y >= 7)
+}
+
+// cgroup is a transient cgroup of a subprocess.
+type cgroup struct {
+	dir string
+}
+
+// newCgroup creates a cgroup with the cpu(cores) and memory(MB) limits, zero means unlimited.
+func newCgroup(root string, name string, cpu float64, memory uint32) (cg *cgroup, err error) {
+	dir := filepath.Join(root, name)
+	err = os.Mkdir(dir, 0755)
+	if err != nil {
+		return
+	}
+	cg = &cgroup{dir}
+	if cpu > 0 {
+		err = cg.write("cpu.max", fmt.Sprintf("%d %d", int64(cpu*cgroupCPUPeriod), cgroupCPUPeriod))
+	}
+	if err == nil && memory > 0 {
+		err = cg.write("memory.max", strconv.FormatUint(uint64(memory)*1024*1024, 10))
+	}
+	if err != nil {
+		cg.remove()
+		return nil, err
+	}
+	return
+}
+
+func (cg *cgroup) write(name string, value string) error {
+	return os.WriteFile(filepath.Join(cg.dir, name), []byte(value), 0644)
+}
+
+// add moves the process to the cgroup, the child processes that are forked after are in the cgroup too.
+func (cg *cgroup) add(pid int) error {
+	return cg.write("cgroup.procs", strconv.Itoa(pid))
+}
+
+// remove kills the leftover processes(e.g. the orphaned children) and removes the cgroup.
+func (cg *cgroup) remove() {
+	cg.write("cgroup.kill", "1")
+	os.Remove(cg.dir)
+}
+
+// runInCgroup runs the command in a transient cgroup with the `cgroup` limits, the command
+// runs without limits if the limits are not set or the cgroup is unavailable.
+func runInCgroup(cmd *exec.Cmd, name string) error {
+	limits := cfg.Cgroup
+	if limits.CPU <= 0 && limits.Memory == 0 {
+		return cmd.Run()
+	}
+	cgroupOnce.Do(func() {
+		cgroupInitErr = initCgroupRoot(limits.Root, limits.CPU, limits.Memory)
+		if cgroupInitErr != nil {
+			log.Warnf("cgroup: %v, the subprocesses run without limits", cgroupInitErr)
+		}
+		cgroupCloneFD = supportsCloneIntoCgroup()
+	})
+	if cgroupInitErr != nil {
+		return cmd.Run()
+	}
+	cg, err := newCgroup(limits.Root, fmt.Sprintf("%s-%d-%d", name, os.Getpid(), atomic.AddUint64(&cgroupSeq, 1)), limits.CPU, limits.Memory)
+	if err != nil {
+		log.Warnf("cgroup: %v", err)
+		return cmd.Run()
+	}
+	defer cg.remove()
+
+	// start the process in the cgroup directly, otherwise the children forked before it's moved
+	// into the cgroup run without limits.
+	if cgroupCloneFD {
+		f, err := os.Open(cg.dir)
+		if err == nil {
+			defer f.Close()
+			if cmd.SysProcAttr == nil {
+				cmd.SysProcAttr = &syscall.SysProcAttr{}
+			}
+			cmd.SysProcAttr.UseCgroupFD = true
+			cmd.SysProcAttr.CgroupFD = int(f.Fd())
+			return cmd.Run()
+		}
+		log.Warnf("cgroup: %v", err)
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	if err := cg.add(cmd.Process.Pid); err != nil {
+		log.Warnf("cgroup: add %s(%d): %v", name, cmd.Process.Pid, err)
+	}
+	return cmd.Wait()
+}
@@ -0,0 +1,46 @@
+//go:build linux
+
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewCgroup(t *testing.T) {
+	root := t.TempDir()
+	cg, err := newCgroup(root, "pnpm-1", 1.5, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{
+		"cpu.max":    "150000 100000",
+		"memory.max": "536870912",
+	} {
+		data, err := os.ReadFile(filepath.Join(root, "pnpm-1", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Fatalf("%s: expected %q, got %q", name, expected, data)
+		}
+	}
+	err = cg.add(1234)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(filepath.Join(root, "pnpm-1", "cgroup.procs"))
+	if string(data) != "1234" {
+		t.Fatalf("cgroup.procs: expected %q, got %q", "1234", data)
+	}
+
+	// only the cpu limit
+	_, err = newCgroup(root, "pnpm-2", 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(filepath.Join(root, "pnpm-2", "memory.max")) {
+		t.Fatal("memory.max should not be written")
+	}
+}
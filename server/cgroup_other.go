@@ -0,0 +1,10 @@
+//go:build !linux
+
+package server
+
+import "os/exec"
+
+// runInCgroup runs the command, the cgroup limits are only supported on Linux.
+func runInCgroup(cmd *exec.Cmd, name string) error {
+	return cmd.Run()
+}
@@ -0,0 +1,456 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ije/gox/utils"
+)
+
+// the native cjs lexer detects the exports of a CommonJS module statically without Node.js,
+// it supports the common patterns of the `cjs-module-lexer`:
+//   - `exports.foo = ...`, `module.exports.foo = ...`, `exports["foo"] = ...`
+//   - `Object.defineProperty(exports, "foo", ...)`
+//   - `module.exports = { foo, bar: ..., ...require("./baz") }`
+//   - `module.exports = require("./foo")`, `__exportStar(require("./foo"), exports)`
+//   - `if (process.env.NODE_ENV === "production") module.exports = require("./a") else module.exports = require("./b")`
+
+var (
+	regexpCJSExportAssign     = regexp.MustCompile(`(?:^|[^.\w$])(?:module\.)?exports\s*(?:\.\s*([a-zA-Z_$][\w$]*)|\[\s*["']([^"']+)["']\s*\])\s*=[^=]`)
+	regexpCJSDefineProperty   = regexp.MustCompile(`Object\.defineProperty\(\s*(?:module\.)?exports\s*,\s*["']([^"']+)["']`)
+	regexpCJSReexport         = regexp.MustCompile(`(?:^|[^.\w$])module\.exports\s*=\s*require\(\s*["']([^"']+)["']\s*\)\s*(\(\s*\))?`)
+	regexpCJSExportStar       = regexp.MustCompile(`__export(?:Star)?\(\s*require\(\s*["']([^"']+)["']\s*\)`)
+	regexpCJSObjectAssign     = regexp.MustCompile(`(?:^|[^.\w$])module\.exports\s*=\s*\{`)
+	regexpCJSObjectKey        = regexp.MustCompile(`^(?:([a-zA-Z_$][\w$]*)|["']([^"']+)["'])\s*(?:[:(,]|$)`)
+	regexpCJSObjectSpread     = regexp.MustCompile(`^\.\.\.\s*require\(\s*["']([^"']+)["']\s*\)$`)
+	regexpCJSNodeEnvCondition = regexp.MustCompile(`if\s*\(\s*(?:process\.env\.NODE_ENV\s*(===?|!==?)\s*["'](\w+)["']|["'](\w+)["']\s*(===?|!==?)\s*process\.env\.NODE_ENV)\s*\)\s*\{?\s*module\.exports\s*=\s*require\(\s*["']([^"']+)["']\s*\)\s*;?\s*\}?\s*else\s*\{?\s*module\.exports\s*=\s*require\(\s*["']([^"']+)["']\s*\)\s*;?\s*\}?`)
+)
+
+var jsReservedWords = newStringSet(
+	"abstract", "arguments", "await", "boolean", "break", "byte", "case", "catch",
+	"char", "class", "const", "continue", "debugger", "default", "delete", "do",
+	"double", "else", "enum", "eval", "export", "extends", "false", "final",
+	"finally", "float", "for", "function", "goto", "if", "implements", "import",
+	"in", "instanceof", "int", "interface", "let", "long", "native", "new",
+	"null", "package", "private", "protected", "public", "return", "short", "static",
+	"super", "switch", "synchronized", "this", "throw", "throws", "transient", "true",
+	"try", "typeof", "var", "void", "volatile", "while", "with", "yield",
+)
+
+// parseCJSModuleExportsNative is the Node.js-free version of `parseCJSModuleExports`.
+func parseCJSModuleExportsNative(buildDir string, importPath string, nodeEnv string) (ret cjsExportsResult, err error) {
+	var entry string
+	if strings.HasPrefix(importPath, "/") && endsWith(importPath, ".js", ".cjs", ".mjs") {
+		entry = importPath
+	} else {
+		entry, err = resolveCJSModule(buildDir, importPath)
+		if err != nil {
+			return
+		}
+	}
+
+	exports := []string{}
+	if strings.HasSuffix(entry, ".json") {
+		exports, err = getJSONKeys(entry)
+		ret.ExportDefault, ret.Exports = verifyCJSExports(exports)
+		return
+	}
+	if !endsWith(entry, ".js", ".cjs", ".mjs") {
+		ret.Exports = exports
+		return
+	}
+
+	requires := []string{entry}
+	visited := newStringSet()
+	for len(requires) > 0 {
+		filename := requires[len(requires)-1]
+		requires = requires[:len(requires)-1]
+		if visited.Has(filename) {
+			continue
+		}
+		visited.Add(filename)
+
+		var code []byte
+		code, err = os.ReadFile(filename)
+		if err != nil {
+			err = fmt.Errorf("could not read file '%s'", filename)
+			return
+		}
+		names, reexports := lexCJSExports(string(code), nodeEnv)
+		if len(reexports) == 1 && len(names) == 0 && len(exports) == 0 && !strings.HasPrefix(reexports[0], ".") && !strings.HasPrefix(reexports[0], "/") {
+			ret.Reexport = reexports[0]
+			ret.Exports = []string{}
+			return
+		}
+		exports = append(exports, names...)
+		dir := path.Dir(filename)
+		for _, reexport := range reexports {
+			// the exports of node builtin modules can't be detected without Node.js
+			if builtInNodeModules[strings.TrimPrefix(reexport, "node:")] {
+				continue
+			}
+			var resolved string
+			resolved, err = resolveCJSModule(dir, reexport)
+			if err != nil {
+				return
+			}
+			if strings.HasSuffix(resolved, ".json") {
+				var keys []string
+				keys, err = getJSONKeys(resolved)
+				if err != nil {
+					return
+				}
+				exports = append(exports, keys...)
+			} else {
+				requires = append(requires, resolved)
+			}
+		}
+	}
+	ret.ExportDefault, ret.Exports = verifyCJSExports(exports)
+	return
+}
+
+// lexCJSExports returns the export names and the re-exported modules of the CommonJS code.
+func lexCJSExports(code string, nodeEnv string) (exports []string, reexports []string) {
+	code = stripJSComments(code)
+	if nodeEnv == "" {
+		nodeEnv = "production"
+	}
+
+	// resolve the `process.env.NODE_ENV` conditional re-exports
+	code = regexpCJSNodeEnvCondition.ReplaceAllStringFunc(code, func(s string) string {
+		m := regexpCJSNodeEnvCondition.FindStringSubmatch(s)
+		op, value := m[1], m[2]
+		if op == "" {
+			op, value = m[4], m[3]
+		}
+		matched := value == nodeEnv
+		if strings.HasPrefix(op, "!") {
+			matched = !matched
+		}
+		if matched {
+			return fmt.Sprintf(`module.exports = require("%s");`, m[5])
+		}
+		return fmt.Sprintf(`module.exports = require("%s");`, m[6])
+	})
+
+	set := newStringSet()
+	for _, m := range regexpCJSExportAssign.FindAllStringSubmatch(code, -1) {
+		if m[1] != "" {
+			set.Add(m[1])
+		} else {
+			set.Add(m[2])
+		}
+	}
+	for _, m := range regexpCJSDefineProperty.FindAllStringSubmatch(code, -1) {
+		set.Add(m[1])
+	}
+	for _, loc := range regexpCJSObjectAssign.FindAllStringIndex(code, -1) {
+		body, ok := matchBrace(code[loc[1]-1:])
+		if !ok {
+			continue
+		}
+		for _, prop := range splitTopLevel(body[1 : len(body)-1]) {
+			prop = strings.TrimSpace(prop)
+			if m := regexpCJSObjectSpread.FindStringSubmatch(prop); m != nil {
+				reexports = append(reexports, m[1])
+			} else if m := regexpCJSObjectKey.FindStringSubmatch(prop); m != nil {
+				if m[1] != "" {
+					set.Add(m[1])
+				} else {
+					set.Add(m[2])
+				}
+			}
+		}
+	}
+	for _, m := range regexpCJSReexport.FindAllStringSubmatch(code, -1) {
+		// skip the call mode `module.exports = require("foo")()`
+		if m[2] == "" {
+			reexports = append(reexports, m[1])
+		}
+	}
+	for _, m := range regexpCJSExportStar.FindAllStringSubmatch(code, -1) {
+		reexports = append(reexports, m[1])
+	}
+	exports = set.Values()
+	sort.Strings(exports)
+	return exports, reexports
+}
+
+// lexUMDGlobalExports returns the property names that are assigned to the global variable of the
+// UMD code statically, e.g. `Chart.helpers = ...` of the `Chart` global.
+func lexUMDGlobalExports(code string, name string) []string {
+	re, err := regexp.Compile(`(?:^|[^.\w$])` + regexp.QuoteMeta(name) + `\s*\.\s*([a-zA-Z_$][\w$]*)\s*=[^=]`)
+	if err != nil {
+		return nil
+	}
+	names := []string{}
+	for _, m := range re.FindAllStringSubmatch(stripJSComments(code), -1) {
+		names = append(names, m[1])
+	}
+	_, exports := verifyCJSExports(names)
+	sort.Strings(exports)
+	return exports
+}
+
+// resolveCJSModule resolves the module path with the `require`, `node` and `default` conditions.
+func resolveCJSModule(dir string, specifier string) (string, error) {
+	if strings.HasPrefix(specifier, "./") || strings.HasPrefix(specifier, "../") || strings.HasPrefix(specifier, "/") || specifier == "." || specifier == ".." {
+		filename := specifier
+		if !strings.HasPrefix(specifier, "/") {
+			filename = path.Join(dir, specifier)
+		}
+		if ret, ok := resolveCJSFile(filename); ok {
+			return ret, nil
+		}
+		return "", fmt.Errorf("could not resolve '%s' in '%s'", specifier, dir)
+	}
+
+	pkgName, subpath := splitPkgPath(specifier)
+	for d := dir; ; d = path.Dir(d) {
+		pkgDir := path.Join(d, "node_modules", pkgName)
+		if isDir(pkgDir) {
+			var p struct {
+				Main    string      `json:"main"`
+				Exports interface{} `json:"exports"`
+			}
+			if fileExists(path.Join(pkgDir, "package.json")) {
+				err := utils.ParseJSONFile(path.Join(pkgDir, "package.json"), &p)
+				if err != nil {
+					return "", err
+				}
+			}
+			if p.Exports != nil {
+				if target, ok := resolveCJSExports(p.Exports, "./"+subpath); ok {
+					if ret, ok := resolveCJSFile(path.Join(pkgDir, target)); ok {
+						return ret, nil
+					}
+				}
+			}
+			if subpath == "" && p.Main != "" {
+				if ret, ok := resolveCJSFile(path.Join(pkgDir, p.Main)); ok {
+					return ret, nil
+				}
+			}
+			if ret, ok := resolveCJSFile(path.Join(pkgDir, subpath)); ok {
+				return ret, nil
+			}
+			break
+		}
+		if d == "/" || d == "." || path.Dir(d) == d {
+			break
+		}
+	}
+	return "", fmt.Errorf("could not resolve '%s' in '%s'", specifier, dir)
+}
+
+func resolveCJSExports(exports interface{}, subpath string) (string, bool) {
+	subpath = strings.TrimSuffix(subpath, "/")
+	switch v := exports.(type) {
+	case string:
+		if subpath == "." {
+			return v, true
+		}
+	case map[string]interface{}:
+		isSubpathMap := false
+		for key := range v {
+			isSubpathMap = strings.HasPrefix(key, ".")
+			break
+		}
+		if isSubpathMap {
+			if target, ok := v[subpath]; ok {
+				return resolveCJSExports(target, ".")
+			}
+			return "", false
+		}
+		if subpath != "." {
+			return "", false
+		}
+		for _, condition := range []string{"require", "node", "default"} {
+			if target, ok := v[condition]; ok {
+				if ret, ok := resolveCJSExports(target, "."); ok {
+					return ret, true
+				}
+			}
+		}
+	case []interface{}:
+		for _, target := range v {
+			if ret, ok := resolveCJSExports(target, subpath); ok {
+				return ret, true
+			}
+		}
+	}
+	return "", false
+}
+
+func resolveCJSFile(filename string) (string, bool) {
+	if fileExists(filename) {
+		return filename, true
+	}
+	for _, ext := range []string{".cjs", ".js", ".json"} {
+		if fileExists(filename + ext) {
+			return filename + ext, true
+		}
+	}
+	if isDir(filename) {
+		var p struct {
+			Main string `json:"main"`
+		}
+		if utils.ParseJSONFile(path.Join(filename, "package.json"), &p) == nil && p.Main != "" {
+			if ret, ok := resolveCJSFile(path.Join(filename, p.Main)); ok {
+				return ret, true
+			}
+		}
+		for _, name := range []string{"index.cjs", "index.js", "index.json"} {
+			if fileExists(path.Join(filename, name)) {
+				return path.Join(filename, name), true
+			}
+		}
+	}
+	return "", false
+}
+
+// isDir checks whether the path is a directory, unlike `dirExists` the symlinks are followed.
+func isDir(filename string) bool {
+	fi, err := os.Stat(filename)
+	return err == nil && fi.IsDir()
+}
+
+// getJSONKeys returns the keys of the JSON object in the source order.
+func getJSONKeys(filename string) (keys []string, err error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	t, err := dec.Token()
+	if err != nil || t != json.Delim('{') {
+		return
+	}
+	for dec.More() {
+		t, err = dec.Token()
+		if err != nil {
+			return
+		}
+		keys = append(keys, t.(string))
+		// skip the value
+		var v json.RawMessage
+		err = dec.Decode(&v)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func verifyCJSExports(names []string) (exportDefault bool, exports []string) {
+	set := newStringSet()
+	exports = []string{}
+	for _, name := range names {
+		if name == "default" {
+			exportDefault = true
+		}
+		if regexpJSIdent.MatchString(name) && !jsReservedWords.Has(name) && !set.Has(name) {
+			set.Add(name)
+			exports = append(exports, name)
+		}
+	}
+	return
+}
+
+// stripJSComments removes the comments of the code, the strings are kept as they are.
+func stripJSComments(code string) string {
+	var b strings.Builder
+	b.Grow(len(code))
+	for i := 0; i < len(code); i++ {
+		c := code[i]
+		switch {
+		case c == '"' || c == '\'' || c == '`':
+			j := i + 1
+			for j < len(code) && code[j] != c {
+				if code[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(code) {
+				j = len(code) - 1
+			}
+			b.WriteString(code[i : j+1])
+			i = j
+		case c == '/' && i+1 < len(code) && code[i+1] == '/':
+			j := strings.IndexByte(code[i:], '\n')
+			if j < 0 {
+				return b.String()
+			}
+			b.WriteByte('\n')
+			i += j
+		case c == '/' && i+1 < len(code) && code[i+1] == '*':
+			j := strings.Index(code[i+2:], "*/")
+			if j < 0 {
+				return b.String()
+			}
+			b.WriteByte(' ')
+			i += j + 3
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// matchBrace returns the code block starts with `{` and ends with the matched `}`.
+func matchBrace(code string) (string, bool) {
+	depth := 0
+	for i := 0; i < len(code); i++ {
+		switch c := code[i]; c {
+		case '"', '\'', '`':
+			for i++; i < len(code) && code[i] != c; i++ {
+				if code[i] == '\\' {
+					i++
+				}
+			}
+		case '{', '(', '[':
+			depth++
+		case '}', ')', ']':
+			depth--
+			if depth == 0 {
+				return code[:i+1], true
+			}
+		}
+	}
+	return "", false
+}
+
+// splitTopLevel splits the code by the top level commas.
+func splitTopLevel(code string) []string {
+	var parts []string
+	depth := 0
+	start := 0
+	for i := 0; i < len(code); i++ {
+		switch c := code[i]; c {
+		case '"', '\'', '`':
+			for i++; i < len(code) && code[i] != c; i++ {
+				if code[i] == '\\' {
+					i++
+				}
+			}
+		case '{', '(', '[':
+			depth++
+		case '}', ')', ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, code[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, code[start:])
+}
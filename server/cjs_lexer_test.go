@@ -0,0 +1,115 @@
+package server
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestLexCJSExports(t *testing.T) {
+	testCases := []struct {
+		code      string
+		exports   string
+		reexports string
+	}{
+		{
+			code:    `exports.foo = 1; module.exports.bar = 2; exports["baz-qux"] = 3; exports.a == 1;`,
+			exports: "bar,baz-qux,foo",
+		},
+		{
+			code:    `Object.defineProperty(exports, "__esModule", { value: true }); Object.defineProperty(exports, 'default', {});`,
+			exports: "__esModule,default",
+		},
+		{
+			code:      `module.exports = { foo, bar: 1, "baz": function() { return { x: 1, y: 2 } }, qux() {}, ...require("./more") }`,
+			exports:   "bar,baz,foo,qux",
+			reexports: "./more",
+		},
+		{
+			code:      "// exports.comment = 1\n/* exports.block = 1 */ module.exports = require(\"./lib\")",
+			exports:   "",
+			reexports: "./lib",
+		},
+		{
+			code:      "'use strict';\nif (process.env.NODE_ENV === 'production') {\n  module.exports = require('./cjs/react.production.min.js');\n} else {\n  module.exports = require('./cjs/react.development.js');\n}",
+			reexports: "./cjs/react.production.min.js",
+		},
+		{
+			code:      `module.exports = require("./call")(); __exportStar(require("./star"), exports);`,
+			reexports: "./star",
+		},
+	}
+	for _, tc := range testCases {
+		exports, reexports := lexCJSExports(tc.code, "production")
+		if strings.Join(exports, ",") != tc.exports {
+			t.Fatalf("invalid exports %v of `%s`, should be [%s]", exports, tc.code, tc.exports)
+		}
+		if strings.Join(reexports, ",") != tc.reexports {
+			t.Fatalf("invalid reexports %v of `%s`, should be [%s]", reexports, tc.code, tc.reexports)
+		}
+	}
+
+	_, reexports := lexCJSExports(testCases[4].code, "development")
+	if len(reexports) != 1 || reexports[0] != "./cjs/react.development.js" {
+		t.Fatalf("invalid reexports %v, should be [./cjs/react.development.js]", reexports)
+	}
+}
+
+func TestLexUMDGlobalExports(t *testing.T) {
+	code := `(function (root) {
+  var Chart = function () {};
+  Chart.defaults = {}; Chart.helpers = helpers; Chart.Type.extend = extend; Chart.default = Chart;
+  // Chart.comment = 1
+  if (Chart.version == "1") {}
+  myChart.foo = 1;
+  root.Chart = Chart;
+}).call(this);`
+	exports := lexUMDGlobalExports(code, "Chart")
+	if strings.Join(exports, ",") != "defaults,helpers" {
+		t.Fatalf("invalid exports %v, should be [defaults,helpers]", exports)
+	}
+}
+
+func TestParseCJSModuleExportsNative(t *testing.T) {
+	dir, err := os.MkdirTemp("", "esm-cjs-lexer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"node_modules/foo/package.json": `{"name":"foo","exports":{".":{"import":"./index.mjs","require":"./index.js"}}}`,
+		"node_modules/foo/index.js":     `if (process.env.NODE_ENV !== "production") { module.exports = require("./dev") } else { module.exports = require("./prod") }`,
+		"node_modules/foo/prod.js":      `exports.foo = 1; exports.default = 2; module.exports = { ...require("./data.json"), class: 1 }`,
+		"node_modules/foo/data.json":    `{"bar":1,"baz":2}`,
+		"node_modules/bar/package.json": `{"name":"bar","main":"lib"}`,
+		"node_modules/bar/lib/index.js": `module.exports = require("foo")`,
+	}
+	for name, content := range files {
+		filename := path.Join(dir, name)
+		err = os.MkdirAll(path.Dir(filename), 0755)
+		if err == nil {
+			err = os.WriteFile(filename, []byte(content), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ret, err := parseCJSModuleExportsNative(dir, "foo", "production")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ret.ExportDefault || strings.Join(ret.Exports, ",") != "foo,bar,baz" {
+		t.Fatalf("invalid exports %v(default: %v), should be [foo bar baz](default: true)", ret.Exports, ret.ExportDefault)
+	}
+
+	ret, err = parseCJSModuleExportsNative(dir, "bar", "production")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.Reexport != "foo" {
+		t.Fatalf("invalid reexport '%s', should be 'foo'", ret.Reexport)
+	}
+}
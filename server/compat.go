@@ -222,3 +222,23 @@ func getTargetByUA(ua string) string {
 	}
 	return "es2015"
 }
+
+// the es targets in ascending order, the build falls back to the next target if esbuild can't
+// lower the syntax of the package to the target
+var loweringTargets = []string{"es2015", "es2016", "es2017", "es2018", "es2019", "es2020", "es2021", "es2022", "esnext"}
+
+// isLoweringError checks if the esbuild error is caused by the syntax that can't be lowered to
+// the target, e.g. `Transforming async generator functions to the configured target environment ("es2017") is not supported yet`.
+func isLoweringError(msg string) bool {
+	return strings.Contains(msg, "the configured target environment") && (strings.Contains(msg, "is not supported yet") || strings.Contains(msg, "not available"))
+}
+
+// getNextLoweringTarget returns the next higher es target of the target.
+func getNextLoweringTarget(target string) (string, bool) {
+	for i, t := range loweringTargets {
+		if t == target && i < len(loweringTargets)-1 {
+			return loweringTargets[i+1], true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestLoweringFallback(t *testing.T) {
+	for msg, ok := range map[string]bool{
+		`Transforming async generator functions to the configured target environment ("es2017") is not supported yet`: true,
+		`Big integer literals are not available in the configured target environment ("es2019")`:                      true,
+		`Could not resolve "react"`: false,
+	} {
+		if isLoweringError(msg) != ok {
+			t.Fatalf("isLoweringError(%q) should be %v", msg, ok)
+		}
+	}
+	if next, ok := getNextLoweringTarget("es2017"); !ok || next != "es2018" {
+		t.Fatalf("invalid next target of es2017: %s", next)
+	}
+	if next, ok := getNextLoweringTarget("es2022"); !ok || next != "esnext" {
+		t.Fatalf("invalid next target of es2022: %s", next)
+	}
+	for _, target := range []string{"esnext", "deno", "node"} {
+		if _, ok := getNextLoweringTarget(target); ok {
+			t.Fatalf("the target %s should not fall back", target)
+		}
+	}
+}
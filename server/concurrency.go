@@ -0,0 +1,75 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/esm-dev/esm.sh/server/config"
+)
+
+// systemLoad is a sample of the system load to tune the build concurrency.
+type systemLoad struct {
+	// the 1-minute load average
+	Load1 float64
+	CPUs  int
+	// the available memory in bytes
+	FreeMemory uint64
+	// the percentage of the time that tasks stall on io in the last 10 seconds, -1 if unavailable
+	IOPressure float64
+}
+
+// overloaded returns the reason if the system load exceeds the thresholds of the config.
+func (l systemLoad) overloaded(c config.AutoConcurrency) string {
+	if l.CPUs > 0 && l.Load1/float64(l.CPUs) > c.MaxLoad {
+		return fmt.Sprintf("load average %.2f of %d cpus", l.Load1, l.CPUs)
+	}
+	if l.FreeMemory < uint64(c.MinFreeMemory)*1024*1024 {
+		return fmt.Sprintf("free memory %dMB", l.FreeMemory/1024/1024)
+	}
+	if l.IOPressure > c.MaxIOPressure {
+		return fmt.Sprintf("io pressure %.2f%%", l.IOPressure)
+	}
+	return ""
+}
+
+// tuneConcurrency returns the next effective build concurrency, it halves the concurrency if the
+// system is overloaded and increases it by one otherwise, the result is between `min` and `max`.
+func tuneConcurrency(current int, min int, max int, overloaded bool) int {
+	n := current + 1
+	if overloaded {
+		n = current / 2
+	}
+	if n < min {
+		n = min
+	}
+	if n > max {
+		n = max
+	}
+	return n
+}
+
+// startAutoConcurrency samples the system load periodically and adjusts the max processes of the
+// build queue, the running builds are not interrupted when the concurrency drops.
+func startAutoConcurrency() {
+	c := cfg.AutoConcurrency
+	interval := time.Duration(c.Interval) * time.Second
+	for {
+		time.Sleep(interval)
+		load, err := getSystemLoad()
+		if err != nil {
+			log.Warnf("auto concurrency is disabled: %v", err)
+			return
+		}
+		reason := load.overloaded(c)
+		current := buildQueue.MaxProcesses()
+		n := tuneConcurrency(current, int(c.Min), int(cfg.BuildConcurrency), reason != "")
+		if n != current {
+			if reason != "" {
+				log.Infof("auto concurrency: %d -> %d, %s", current, n, reason)
+			} else {
+				log.Debugf("auto concurrency: %d -> %d", current, n)
+			}
+			buildQueue.SetMaxProcesses(n)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/esm-dev/esm.sh/server/config"
+)
+
+func TestTuneConcurrency(t *testing.T) {
+	for _, tt := range []struct {
+		current    int
+		overloaded bool
+		want       int
+	}{
+		{8, true, 4},
+		{4, false, 5},
+		{2, true, 2},
+		{16, false, 16},
+		{20, false, 16},
+	} {
+		if n := tuneConcurrency(tt.current, 2, 16, tt.overloaded); n != tt.want {
+			t.Fatalf("tuneConcurrency(%d, %v) = %d, want %d", tt.current, tt.overloaded, n, tt.want)
+		}
+	}
+
+	c := config.AutoConcurrency{MaxLoad: 1.5, MinFreeMemory: 512, MaxIOPressure: 20}
+	for load, want := range map[systemLoad]string{
+		{Load1: 4, CPUs: 4, FreeMemory: 1 << 30, IOPressure: -1}:   "",
+		{Load1: 8, CPUs: 4, FreeMemory: 1 << 30, IOPressure: 5}:    "load average",
+		{Load1: 1, CPUs: 4, FreeMemory: 256 << 20, IOPressure: 5}:  "free memory 256MB",
+		{Load1: 1, CPUs: 4, FreeMemory: 1 << 30, IOPressure: 35.5}: "io pressure 35.50%",
+		{Load1: 1, CPUs: 4, FreeMemory: 1 << 30, IOPressure: 19.9}: "",
+	} {
+		reason := load.overloaded(c)
+		if (want == "" && reason != "") || !strings.HasPrefix(reason, want) {
+			t.Fatalf("overloaded(%+v) = %q, want %q", load, reason, want)
+		}
+	}
+}
@@ -1,8 +1,8 @@
 package config
 
 import (
-	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 	"path"
 	"path/filepath"
@@ -11,26 +11,287 @@ import (
 )
 
 type Config struct {
-	Port             uint16  `json:"port,omitempty"`
-	TlsPort          uint16  `json:"tlsPort,omitempty"`
-	NsPort           uint16  `json:"nsPort,omitempty"`
-	BuildConcurrency uint16  `json:"buildConcurrency,omitempty"`
-	BanList          BanList `json:"banList,omitempty"`
-	WorkDir          string  `json:"workDir,omitempty"`
-	Cache            string  `json:"cache,omitempty"`
-	Database         string  `json:"database,omitempty"`
-	Storage          string  `json:"storage,omitempty"`
-	LogLevel         string  `json:"logLevel,omitempty"`
-	LogDir           string  `json:"logDir,omitempty"`
-	Origin           string  `json:"origin,omitempty"`
-	BasePath         string  `json:"basePath,omitempty"`
-	NpmRegistry      string  `json:"npmRegistry,omitempty"`
-	NpmToken         string  `json:"npmToken,omitempty"`
-	NpmRegistryScope string  `json:"npmRegistryScope,omitempty"`
-	NpmUser          string  `json:"npmUser,omitempty"`
-	NpmPassword      string  `json:"npmPassword,omitempty"`
-	AuthSecret       string  `json:"authSecret,omitempty"`
-	NoCompress       bool    `json:"noCompress,omitempty"`
+	Port             uint16                 `json:"port,omitempty"`
+	TlsPort          uint16                 `json:"tlsPort,omitempty"`
+	NsPort           uint16                 `json:"nsPort,omitempty"`
+	BuildConcurrency uint16                 `json:"buildConcurrency,omitempty"`
+	AutoConcurrency  AutoConcurrency        `json:"autoConcurrency,omitempty"`
+	BuildTimeout     uint32                 `json:"buildTimeout,omitempty"`
+	BanList          BanList                `json:"banList,omitempty"`
+	WorkDir          string                 `json:"workDir,omitempty"`
+	Cache            string                 `json:"cache,omitempty"`
+	Database         string                 `json:"database,omitempty"`
+	Storage          string                 `json:"storage,omitempty"`
+	LogLevel         string                 `json:"logLevel,omitempty"`
+	LogDir           string                 `json:"logDir,omitempty"`
+	Origin           string                 `json:"origin,omitempty"`
+	BasePath         string                 `json:"basePath,omitempty"`
+	NpmRegistry      string                 `json:"npmRegistry,omitempty"`
+	NpmToken         string                 `json:"npmToken,omitempty"`
+	NpmRegistryScope string                 `json:"npmRegistryScope,omitempty"`
+	NpmUser          string                 `json:"npmUser,omitempty"`
+	NpmPassword      string                 `json:"npmPassword,omitempty"`
+	NpmRegistries    map[string]NpmRegistry `json:"npmRegistries,omitempty"`
+	NpmScopes        map[string]NpmRegistry `json:"npmScopes,omitempty"`
+	JsrRegistry      string                 `json:"jsrRegistry,omitempty"`
+	Install          Install                `json:"install,omitempty"`
+	Proxy            Proxy                  `json:"proxy,omitempty"`
+	DNS              DNS                    `json:"dns,omitempty"`
+	SecurityHeaders  SecurityHeaders        `json:"securityHeaders,omitempty"`
+	AuthSecret       string                 `json:"authSecret,omitempty"`
+	NoCompress       bool                   `json:"noCompress,omitempty"`
+	MinFreeDiskSpace uint32                 `json:"minFreeDiskSpace,omitempty"`
+	Cgroup           Cgroup                 `json:"cgroup,omitempty"`
+	ReadOnly         bool                   `json:"readOnly,omitempty"`
+	Sync             Sync                   `json:"sync,omitempty"`
+	CacheTTL         CacheTTL               `json:"cacheTTL,omitempty"`
+	MaxQueueDepth    uint32                 `json:"maxQueueDepth,omitempty"`
+	BuildPriority    BuildPriority          `json:"buildPriority,omitempty"`
+	PriorityTokens   map[string]int         `json:"priorityTokens,omitempty"`
+	AdminToken       string                 `json:"adminToken,omitempty"`
+	Webhooks         []Webhook              `json:"webhooks,omitempty"`
+	Alert            Alert                  `json:"alert,omitempty"`
+	Audit            Audit                  `json:"audit,omitempty"`
+	Analytics        Analytics              `json:"analytics,omitempty"`
+	Github           Github                 `json:"github,omitempty"`
+	SigningKey       string                 `json:"signingKey,omitempty"`
+	JournalFile      string                 `json:"journalFile,omitempty"`
+	Warmup           string                 `json:"warmup,omitempty"`
+	Refresh          Refresh                `json:"refresh,omitempty"`
+	CjsLexer         string                 `json:"cjsLexer,omitempty"`
+	GenerateTypes    bool                   `json:"generateTypes,omitempty"`
+	BuildAnalysis    bool                   `json:"buildAnalysis,omitempty"`
+	StreamBuilds     bool                   `json:"streamBuilds,omitempty"`
+	EsmPassthrough   bool                   `json:"esmPassthrough,omitempty"`
+	RecipesDir       string                 `json:"recipesDir,omitempty"`
+	RecipesSync      RecipesSync            `json:"recipesSync,omitempty"`
+	AssetsDir        string                 `json:"assetsDir,omitempty"`
+	Hosts            map[string]HostConfig  `json:"hosts,omitempty"`
+
+	// the warnings of the config file, e.g. the unknown options
+	Warnings []string `json:"-"`
+}
+
+// HostConfig is the config for the requests of a hostname, it overrides the global config.
+type HostConfig struct {
+	// AuthSecret overrides the global `authSecret` for the host.
+	AuthSecret string `json:"authSecret,omitempty"`
+	// AllowList is the list of the scopes(e.g. "@org") and packages that can be served, default is all.
+	// The dependencies that the builds of the allowed packages import are served as well.
+	AllowList []string `json:"allowList,omitempty"`
+	// DefaultTarget is the build target if the `?target` query is not specified, default is detected by the `User-Agent` header.
+	DefaultTarget string `json:"defaultTarget,omitempty"`
+	// BuildVersion pins the build version(e.g. 118) if the `?pin` query is not specified.
+	BuildVersion int `json:"buildVersion,omitempty"`
+}
+
+// NpmRegistry is an alternate npm registry that can be selected by the `?registry` query,
+// or the registry of the packages of a scope.
+type NpmRegistry struct {
+	// Registry is the url of the registry.
+	Registry string `json:"registry"`
+	// Token is the auth token of the registry, default is empty.
+	Token string `json:"token,omitempty"`
+	// User and Password are used for basic authentication towards the registry, default for both is empty.
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// Install is the config of the network settings of the package install step.
+type Install struct {
+	// NetworkConcurrency is the max number of the concurrent registry requests of an install, default is 16.
+	NetworkConcurrency uint32 `json:"networkConcurrency,omitempty"`
+	// FetchTimeout is the timeout in seconds of a registry request, default is 60.
+	FetchTimeout uint32 `json:"fetchTimeout,omitempty"`
+	// FetchRetries is the number of the retries of a failed registry request of an install, default is 2.
+	FetchRetries uint32 `json:"fetchRetries,omitempty"`
+	// NormalizeLineEndings converts the CRLF line endings of the package sources to LF after install, default is false.
+	NormalizeLineEndings bool `json:"normalizeLineEndings,omitempty"`
+	// Deprecated: use `proxy.http`, `proxy.https` and `proxy.noProxy` instead, they are still used
+	// if the `proxy` config is not set.
+	HttpProxy  string `json:"httpProxy,omitempty"`
+	HttpsProxy string `json:"httpsProxy,omitempty"`
+	NoProxy    string `json:"noProxy,omitempty"`
+}
+
+// Cgroup is the config of the cgroup(v2) limits of the subprocesses(e.g. pnpm, node) on Linux, each
+// subprocess runs in a transient cgroup so one huge build can't starve the concurrent requests.
+type Cgroup struct {
+	// Root is the parent cgroup of the transient cgroups, default is "/sys/fs/cgroup/esm.sh".
+	Root string `json:"root,omitempty"`
+	// CPU is the max cpu cores of a subprocess, e.g. 1.5, default is 0(unlimited).
+	CPU float64 `json:"cpu,omitempty"`
+	// Memory is the max memory in MB of a subprocess, default is 0(unlimited).
+	Memory uint32 `json:"memory,omitempty"`
+}
+
+// AutoConcurrency is the config of the build concurrency auto-tuning, the effective concurrency is
+// adjusted between `min` and `buildConcurrency` by the system load, the free memory and the io pressure.
+type AutoConcurrency struct {
+	// Enabled enables the auto-tuning, it's only supported on Linux.
+	Enabled bool `json:"enabled,omitempty"`
+	// Min is the lower bound of the effective build concurrency, default is 1.
+	Min uint16 `json:"min,omitempty"`
+	// MaxLoad is the max 1-minute load average per cpu core, default is 1.5.
+	MaxLoad float64 `json:"maxLoad,omitempty"`
+	// MinFreeMemory is the min available memory in MB, default is 512.
+	MinFreeMemory uint32 `json:"minFreeMemory,omitempty"`
+	// MaxIOPressure is the max percentage of the time that tasks stall on io in the last 10 seconds, default is 20.
+	MaxIOPressure float64 `json:"maxIOPressure,omitempty"`
+	// Interval is the sampling interval in seconds, default is 10.
+	Interval uint32 `json:"interval,omitempty"`
+}
+
+// Proxy is the config of the proxy for the upstream traffic: the registry metadata, the tarball
+// downloads and the github requests.
+type Proxy struct {
+	// Http and Https are the proxy urls(http, https or socks5) of the http and https upstreams, default are
+	// read from the `HTTP_PROXY` and `HTTPS_PROXY` environment variables.
+	Http  string `json:"http,omitempty"`
+	Https string `json:"https,omitempty"`
+	// NoProxy is the comma-separated hosts that bypass the proxy, default is read from the `NO_PROXY` environment variable.
+	NoProxy string `json:"noProxy,omitempty"`
+	// Upstreams overrides the proxy url by the upstream hostname(e.g. "registry.npmjs.org", "github.com"),
+	// "direct" to bypass the proxy.
+	Upstreams map[string]string `json:"upstreams,omitempty"`
+}
+
+// SecurityHeaders is the config of the security headers of the responses.
+type SecurityHeaders struct {
+	// Disabled disables the security headers, default is false.
+	Disabled bool `json:"disabled,omitempty"`
+	// ContentSecurityPolicy is the `Content-Security-Policy` header of the HTML pages, the `{origin}`
+	// placeholder is replaced with the origin of the server.
+	ContentSecurityPolicy string `json:"contentSecurityPolicy,omitempty"`
+	// ReferrerPolicy is the `Referrer-Policy` header of the responses, default is "strict-origin-when-cross-origin".
+	ReferrerPolicy string `json:"referrerPolicy,omitempty"`
+}
+
+// DNS is the config of the DNS resolution of the upstreams, e.g. for the networks with split-horizon DNS.
+type DNS struct {
+	// Server is the address of the DNS server to resolve the upstream hostnames, e.g. "10.0.0.53:53",
+	// default is the system resolver.
+	Server string `json:"server,omitempty"`
+	// Hosts maps the upstream hostnames to the static ip addresses, e.g. {"registry.npmjs.org": "10.0.0.1"}.
+	Hosts map[string]string `json:"hosts,omitempty"`
+	// IPv6Only connects to the upstreams over IPv6 only, default is false.
+	IPv6Only bool `json:"ipv6Only,omitempty"`
+}
+
+// IsPackageAllowed checks if the package is allowed by the allow list of the host.
+func (h *HostConfig) IsPackageAllowed(pkgName string) bool {
+	if len(h.AllowList) == 0 {
+		return true
+	}
+	for _, name := range h.AllowList {
+		if pkgName == name || (strings.HasPrefix(name, "@") && !strings.Contains(name, "/") && strings.HasPrefix(pkgName, name+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// CacheTTL is the config of the cache TTLs in seconds.
+type CacheTTL struct {
+	// Redirect is the TTL of the redirects from un-versioned urls, default is 600.
+	Redirect uint32 `json:"redirect,omitempty"`
+	// DistTag is the TTL of the dist-tag/semver range resolutions, default is 600.
+	DistTag uint32 `json:"distTag,omitempty"`
+	// GithubRef is the TTL of the github refs(branches and tags) resolutions, default is 600.
+	GithubRef uint32 `json:"githubRef,omitempty"`
+	// Error is the TTL of the error responses, default is 0 (no cache).
+	Error uint32 `json:"error,omitempty"`
+	// Registry is the TTL of the package metadata of exact versions, default is 86400.
+	Registry uint32 `json:"registry,omitempty"`
+	// Failure is the TTL of the failed build records, the requests of a failed build get the
+	// error without rebuilding until the record expires or the package is purged, default is 0 (disabled).
+	Failure uint32 `json:"failure,omitempty"`
+}
+
+// Webhook is a url that gets POSTed on build success/failure.
+type Webhook struct {
+	URL string `json:"url"`
+	// Secret is used to sign the payload, the signature is sent in the `X-Esm-Signature-256` header.
+	Secret string `json:"secret,omitempty"`
+	// Events is the list of the events to notify: "success" and "failure", default is all.
+	Events []string `json:"events,omitempty"`
+}
+
+// Alert is the config of the alerting to a slack/discord webhook.
+type Alert struct {
+	// Webhook is the incoming webhook url of slack or discord, the alerting is disabled if it's empty.
+	Webhook string `json:"webhook,omitempty"`
+	// Format is the payload format: "slack" or "discord", default is detected by the webhook url.
+	Format string `json:"format,omitempty"`
+	// FailureThreshold is the number of the build failures of a package to trigger an alert, default is 3.
+	FailureThreshold uint32 `json:"failureThreshold,omitempty"`
+	// FailureWindow is the time window in seconds to count the build failures, default is 600.
+	FailureWindow uint32 `json:"failureWindow,omitempty"`
+	// QueueThreshold is the build queue length to trigger an alert, default is 0 (disabled).
+	QueueThreshold uint32 `json:"queueThreshold,omitempty"`
+	// DiskThreshold is the free disk space in MB to trigger an alert, default is 0 (disabled).
+	DiskThreshold uint32 `json:"diskThreshold,omitempty"`
+}
+
+// Audit is the config of the audit mode that records the upstream urls fetched by builds.
+type Audit struct {
+	// Enabled enables the audit mode, the records can be read from the `/_provenance/BUILD_ID` endpoint.
+	Enabled bool `json:"enabled,omitempty"`
+	// AllowHosts is the list of the upstream hosts that can be fetched by builds, default is all.
+	AllowHosts []string `json:"allowHosts,omitempty"`
+}
+
+// Github is the config of the builds of the GitHub repositories(`/gh/` modules), the limits
+// prevent the public instances from being abused as a CI farm.
+type Github struct {
+	// MaxCommitsPerHour is the max number of the commits of a repository to build per hour, default is 0(unlimited).
+	// A commit counts once no matter how many modules of it are built.
+	MaxCommitsPerHour uint32 `json:"maxCommitsPerHour,omitempty"`
+	// RequirePinnedSha requires the commit sha(at least 10 characters) in the `/gh/` urls, the branches, tags
+	// and HEAD are rejected, default is false.
+	RequirePinnedSha bool `json:"requirePinnedSha,omitempty"`
+}
+
+// Analytics is the config of the opt-in usage analytics that counts the module requests by
+// package, version and target in daily rollups, no client info(e.g. the ip address) is stored.
+type Analytics struct {
+	// Enabled enables the analytics, the rollups can be exported from the `/_admin/analytics` endpoint.
+	Enabled bool `json:"enabled,omitempty"`
+	// Retention is the number of days to keep the daily rollups, default is 90.
+	Retention uint32 `json:"retention,omitempty"`
+}
+
+// Refresh is the config of the scheduled re-resolution of the most requested dist-tags/semver ranges,
+// the new versions are pre-built so the un-pinned users don't wait for a cold build.
+type Refresh struct {
+	// Top is the number of the most requested dist-tags/semver ranges to refresh, default is 0(disabled).
+	Top uint32 `json:"top,omitempty"`
+	// Interval is the refresh interval in seconds, default is 86400(nightly).
+	Interval uint32 `json:"interval,omitempty"`
+	// Targets is the build targets of the new versions, default is ["es2022"].
+	Targets []string `json:"targets,omitempty"`
+}
+
+// Sync is the config of the artifact synchronization between a build server and its read-only replicas.
+type Sync struct {
+	// Token is the shared secret to access the sync endpoints of the build server.
+	Token string `json:"token,omitempty"`
+	// From is the origin of the build server to pull artifacts from.
+	From string `json:"from,omitempty"`
+	// Prefixes is the list of storage path prefixes to sync, default is all.
+	Prefixes []string `json:"prefixes,omitempty"`
+	// Interval is the pull interval in seconds, default is 60.
+	Interval uint32 `json:"interval,omitempty"`
+}
+
+// RecipesSync is the config to pull the signed recipe bundle from a remote url periodically.
+type RecipesSync struct {
+	// URL is the url of the recipe bundle, the signature is fetched from `URL.sig`.
+	URL string `json:"url,omitempty"`
+	// PublicKey is the file of the Ed25519 public key(PKIX PEM) to verify the bundle signature.
+	PublicKey string `json:"publicKey,omitempty"`
+	// Interval is the pull interval in seconds, default is 3600.
+	Interval uint32 `json:"interval,omitempty"`
 }
 
 type BanList struct {
@@ -43,24 +304,25 @@ type BanScope struct {
 	Excludes []string `json:"excludes"`
 }
 
-// Load loads config from the given file. Panic if failed to load.
+// Load loads config from the given file, the file is JSON with comments(JSONC), or YAML/TOML by the
+// `.yaml`, `.yml` or `.toml` extension. An error is returned if the file has invalid values, the unknown
+// options are recorded in the `Warnings` of the config.
 func Load(filename string) (*Config, error) {
-	var (
-		cfg     *Config
-		cfgFile *os.File
-		err     error
-	)
+	var cfg *Config
 
-	cfgFile, err = os.Open(filename)
+	data, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, fmt.Errorf("fail to read config file: %w", err)
 	}
-	defer cfgFile.Close()
 
-	err = json.NewDecoder(cfgFile).Decode(&cfg)
+	warnings, err := parseConfig(filename, data, &cfg)
 	if err != nil {
 		return nil, fmt.Errorf("fail to parse config: %w", err)
 	}
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	cfg.Warnings = warnings
 
 	// fix config
 	if cfg.WorkDir == "" {
@@ -68,12 +330,14 @@ func Load(filename string) (*Config, error) {
 		if err != nil {
 			return nil, fmt.Errorf("fail to get current user home directory: %w", err)
 		}
-		cfg.WorkDir = path.Join(homeDir, ".esmd")
+		cfg.WorkDir = path.Join(filepath.ToSlash(homeDir), ".esmd")
 	} else {
 		cfg.WorkDir, err = filepath.Abs(cfg.WorkDir)
 		if err != nil {
 			return nil, fmt.Errorf("fail to get absolute path of the work directory: %w", err)
 		}
+		// use forward slashes on windows, the paths are joined with the `path` package
+		cfg.WorkDir = filepath.ToSlash(cfg.WorkDir)
 	}
 	if cfg.Port == 0 {
 		cfg.Port = 8080
@@ -106,6 +370,24 @@ func Load(filename string) (*Config, error) {
 	if cfg.BuildConcurrency < 4 {
 		cfg.BuildConcurrency = 4
 	}
+	if cfg.BuildTimeout == 0 {
+		cfg.BuildTimeout = 600
+	}
+	if cfg.AutoConcurrency.Min == 0 {
+		cfg.AutoConcurrency.Min = 1
+	}
+	if cfg.AutoConcurrency.MaxLoad == 0 {
+		cfg.AutoConcurrency.MaxLoad = 1.5
+	}
+	if cfg.AutoConcurrency.MinFreeMemory == 0 {
+		cfg.AutoConcurrency.MinFreeMemory = 512
+	}
+	if cfg.AutoConcurrency.MaxIOPressure == 0 {
+		cfg.AutoConcurrency.MaxIOPressure = 20
+	}
+	if cfg.AutoConcurrency.Interval == 0 {
+		cfg.AutoConcurrency.Interval = 10
+	}
 	if cfg.Cache == "" {
 		cfg.Cache = "memory:default"
 	}
@@ -121,18 +403,134 @@ func Load(filename string) (*Config, error) {
 	if cfg.LogLevel == "" {
 		cfg.LogLevel = "info"
 	}
+	if cfg.JournalFile == "" {
+		cfg.JournalFile = path.Join(cfg.WorkDir, "journal.jsonl")
+	}
+	if cfg.CjsLexer == "" {
+		cfg.CjsLexer = "node"
+	}
 	if cfg.NpmRegistry != "" {
 		cfg.NpmRegistry = strings.TrimRight(cfg.NpmRegistry, "/") + "/"
 	}
+	if cfg.JsrRegistry == "" {
+		cfg.JsrRegistry = "https://npm.jsr.io/"
+	} else {
+		cfg.JsrRegistry = strings.TrimRight(cfg.JsrRegistry, "/") + "/"
+	}
+	for name, reg := range cfg.NpmRegistries {
+		if reg.Registry != "" {
+			reg.Registry = strings.TrimRight(reg.Registry, "/") + "/"
+			cfg.NpmRegistries[name] = reg
+		}
+	}
+	for scope, reg := range cfg.NpmScopes {
+		if reg.Registry != "" {
+			reg.Registry = strings.TrimRight(reg.Registry, "/") + "/"
+			cfg.NpmScopes[scope] = reg
+		}
+	}
+	// the hostnames of the requests are matched in lowercase
+	if len(cfg.Hosts) > 0 {
+		hosts := make(map[string]HostConfig, len(cfg.Hosts))
+		for host, hc := range cfg.Hosts {
+			hosts[strings.ToLower(host)] = hc
+		}
+		cfg.Hosts = hosts
+	}
+	if cfg.MinFreeDiskSpace == 0 {
+		cfg.MinFreeDiskSpace = 1024
+	}
+	if cfg.Cgroup.Root == "" {
+		cfg.Cgroup.Root = "/sys/fs/cgroup/esm.sh"
+	}
+	if cfg.Install.NetworkConcurrency == 0 {
+		cfg.Install.NetworkConcurrency = 16
+	}
+	if cfg.Install.FetchTimeout == 0 {
+		cfg.Install.FetchTimeout = 60
+	}
+	if cfg.Install.FetchRetries == 0 {
+		cfg.Install.FetchRetries = 2
+	}
+	if cfg.SecurityHeaders.ContentSecurityPolicy == "" {
+		cfg.SecurityHeaders.ContentSecurityPolicy = DefaultContentSecurityPolicy
+	}
+	if cfg.SecurityHeaders.ReferrerPolicy == "" {
+		cfg.SecurityHeaders.ReferrerPolicy = "strict-origin-when-cross-origin"
+	}
+	if cfg.DNS.Server != "" {
+		if _, _, err := net.SplitHostPort(cfg.DNS.Server); err != nil {
+			cfg.DNS.Server = net.JoinHostPort(strings.Trim(cfg.DNS.Server, "[]"), "53")
+		}
+	}
+	if cfg.Proxy.Http == "" {
+		cfg.Proxy.Http = cfg.Install.HttpProxy
+	}
+	if cfg.Proxy.Https == "" {
+		cfg.Proxy.Https = cfg.Install.HttpsProxy
+	}
+	if cfg.Proxy.NoProxy == "" {
+		cfg.Proxy.NoProxy = cfg.Install.NoProxy
+	}
+	if cfg.Proxy.Http == "" {
+		cfg.Proxy.Http = getEnv("HTTP_PROXY", "http_proxy")
+	}
+	if cfg.Proxy.Https == "" {
+		cfg.Proxy.Https = getEnv("HTTPS_PROXY", "https_proxy")
+	}
+	if cfg.Proxy.NoProxy == "" {
+		cfg.Proxy.NoProxy = getEnv("NO_PROXY", "no_proxy")
+	}
+	if cfg.Sync.From != "" {
+		cfg.Sync.From = strings.TrimSuffix(cfg.Sync.From, "/")
+	}
+	if cfg.Sync.Interval == 0 {
+		cfg.Sync.Interval = 60
+	}
+	if cfg.RecipesSync.Interval == 0 {
+		cfg.RecipesSync.Interval = 3600
+	}
+	if cfg.Refresh.Interval == 0 {
+		cfg.Refresh.Interval = 24 * 3600
+	}
+	if cfg.Analytics.Retention == 0 {
+		cfg.Analytics.Retention = 90
+	}
+	if cfg.Alert.FailureThreshold == 0 {
+		cfg.Alert.FailureThreshold = 3
+	}
+	if cfg.Alert.FailureWindow == 0 {
+		cfg.Alert.FailureWindow = 600
+	}
+	if cfg.CacheTTL.Redirect == 0 {
+		cfg.CacheTTL.Redirect = 600
+	}
+	if cfg.CacheTTL.DistTag == 0 {
+		cfg.CacheTTL.DistTag = 600
+	}
+	if cfg.CacheTTL.GithubRef == 0 {
+		cfg.CacheTTL.GithubRef = 600
+	}
+	if cfg.CacheTTL.Registry == 0 {
+		cfg.CacheTTL.Registry = 24 * 3600
+	}
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
 
+// DefaultContentSecurityPolicy is the CSP of the landing page, the page loads its scripts
+// and the modules of the README examples from the origin.
+const DefaultContentSecurityPolicy = "default-src 'self'; script-src 'self' 'unsafe-inline' {origin}; style-src 'self' 'unsafe-inline'; img-src * data:; connect-src 'self' {origin}; object-src 'none'; base-uri 'self'; frame-ancestors 'none'"
+
 func Default() *Config {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		panic(err)
 	}
-	workDir := path.Join(homeDir, ".esmd")
+	workDir := path.Join(filepath.ToSlash(homeDir), ".esmd")
 	buildConcurrency := 2 * runtime.NumCPU()
 	if buildConcurrency < 4 {
 		buildConcurrency = 4
@@ -141,12 +539,52 @@ func Default() *Config {
 		Port:             8080,
 		NsPort:           8088,
 		BuildConcurrency: uint16(buildConcurrency),
+		BuildTimeout:     600,
+		AutoConcurrency: AutoConcurrency{
+			Min:           1,
+			MaxLoad:       1.5,
+			MinFreeMemory: 512,
+			MaxIOPressure: 20,
+			Interval:      10,
+		},
 		WorkDir:          workDir,
 		Cache:            "memory:default",
 		Database:         fmt.Sprintf("bolt:%s", path.Join(workDir, "esm.db")),
 		Storage:          fmt.Sprintf("local:%s", path.Join(workDir, "storage")),
 		LogDir:           path.Join(workDir, "log"),
 		LogLevel:         "info",
+		JournalFile:      path.Join(workDir, "journal.jsonl"),
+		CjsLexer:         "node",
+		JsrRegistry:      "https://npm.jsr.io/",
+		MinFreeDiskSpace: 1024,
+		Install: Install{
+			NetworkConcurrency: 16,
+			FetchTimeout:       60,
+			FetchRetries:       2,
+		},
+		Cgroup: Cgroup{
+			Root: "/sys/fs/cgroup/esm.sh",
+		},
+		SecurityHeaders: SecurityHeaders{
+			ContentSecurityPolicy: DefaultContentSecurityPolicy,
+			ReferrerPolicy:        "strict-origin-when-cross-origin",
+		},
+		Proxy: Proxy{
+			Http:    getEnv("HTTP_PROXY", "http_proxy"),
+			Https:   getEnv("HTTPS_PROXY", "https_proxy"),
+			NoProxy: getEnv("NO_PROXY", "no_proxy"),
+		},
+		Sync:        Sync{Interval: 60},
+		RecipesSync: RecipesSync{Interval: 3600},
+		Refresh:     Refresh{Interval: 24 * 3600},
+		Analytics:   Analytics{Retention: 90},
+		Alert:       Alert{FailureThreshold: 3, FailureWindow: 600},
+		CacheTTL: CacheTTL{
+			Redirect:  600,
+			DistTag:   600,
+			GithubRef: 600,
+			Registry:  24 * 3600,
+		},
 	}
 }
 
@@ -185,6 +623,30 @@ func (banList *BanList) IsPackageBanned(fullName string) bool {
 	return false
 }
 
+// BuildPriority maps package name patterns to build priorities, the higher priority builds are
+// processed first. The pattern can be an exact package name, or a prefix ends with `*` like `@scope/*`.
+type BuildPriority map[string]int
+
+// Get returns the build priority of the package, the exact name takes precedence over
+// patterns, and the longest matched pattern wins. It returns 0 if no pattern is matched.
+func (bp BuildPriority) Get(pkgName string) int {
+	if p, ok := bp[pkgName]; ok {
+		return p
+	}
+	priority := 0
+	matched := -1
+	for pattern, p := range bp {
+		if strings.HasSuffix(pattern, "*") {
+			prefix := strings.TrimSuffix(pattern, "*")
+			if strings.HasPrefix(pkgName, prefix) && len(prefix) > matched {
+				priority = p
+				matched = len(prefix)
+			}
+		}
+	}
+	return priority
+}
+
 func isPackageExcluded(name string, excludes []string) bool {
 	for _, exclude := range excludes {
 		if name == exclude {
@@ -194,3 +656,13 @@ func isPackageExcluded(name string, excludes []string) bool {
 
 	return false
 }
+
+// getEnv returns the value of the first environment variable that is set.
+func getEnv(keys ...string) string {
+	for _, key := range keys {
+		if v := os.Getenv(key); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -71,3 +71,58 @@ func TestBanList_IsPackageBanned(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildPriority_Get(t *testing.T) {
+	bp := BuildPriority{
+		"*":           -1,
+		"@corp/*":     10,
+		"@corp/cli":   1,
+		"react":       5,
+		"react-dom*":  3,
+		"@corp/ui-*":  20,
+		"@corp/ui-x*": 30,
+	}
+	tests := []struct {
+		pkgName string
+		want    int
+	}{
+		{"lodash", -1},
+		{"react", 5},
+		{"react-dom", 3},
+		{"@corp/utils", 10},
+		{"@corp/cli", 1},
+		{"@corp/ui-button", 20},
+		{"@corp/ui-xbutton", 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pkgName, func(t *testing.T) {
+			if got := bp.Get(tt.pkgName); got != tt.want {
+				t.Errorf("Get() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got := (BuildPriority{}).Get("react"); got != 0 {
+		t.Errorf("Get() = %v, want 0", got)
+	}
+}
+
+func TestHostConfig_IsPackageAllowed(t *testing.T) {
+	hc := HostConfig{AllowList: []string{"@acme", "react"}}
+	tests := []struct {
+		pkgName string
+		want    bool
+	}{
+		{"@acme/ui", true},
+		{"react", true},
+		{"react-dom", false},
+		{"@acme-evil/ui", false},
+	}
+	for _, tt := range tests {
+		if got := hc.IsPackageAllowed(tt.pkgName); got != tt.want {
+			t.Errorf("IsPackageAllowed(%s) = %v, want %v", tt.pkgName, got, tt.want)
+		}
+	}
+	if !(&HostConfig{}).IsPackageAllowed("anything") {
+		t.Error("empty allow list should allow all packages")
+	}
+}
@@ -0,0 +1,385 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+var regexpRegistryName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+var regexpNpmScope = regexp.MustCompile(`^@[a-z0-9][a-z0-9._-]*$`)
+
+// Validate checks the values of the config, all the invalid options are reported.
+func (cfg *Config) Validate() error {
+	var errs []string
+	invalid := func(option string, format string, v ...interface{}) {
+		errs = append(errs, fmt.Sprintf("`%s` %s", option, fmt.Sprintf(format, v...)))
+	}
+
+	if cfg.TlsPort > 0 && cfg.TlsPort == cfg.Port {
+		invalid("tlsPort", "must be different from `port`(%d)", cfg.Port)
+	}
+	if cfg.NsPort == cfg.Port || (cfg.TlsPort > 0 && cfg.NsPort == cfg.TlsPort) {
+		invalid("nsPort", "must be different from `port` and `tlsPort`")
+	}
+	for option, value := range map[string]string{"cache": cfg.Cache, "database": cfg.Database, "storage": cfg.Storage} {
+		if name, addr, ok := strings.Cut(value, ":"); !ok || name == "" || addr == "" {
+			invalid(option, "must be an url like \"name:address?options\", got %q", value)
+		}
+	}
+	switch cfg.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		invalid("logLevel", "must be one of \"debug\", \"info\", \"warn\" or \"error\", got %q", cfg.LogLevel)
+	}
+	switch cfg.CjsLexer {
+	case "node", "native":
+	default:
+		invalid("cjsLexer", "must be \"node\" or \"native\", got %q", cfg.CjsLexer)
+	}
+	if cfg.Origin != "" && !isHTTPURL(cfg.Origin) {
+		invalid("origin", "must be a http(s) url, got %q", cfg.Origin)
+	}
+	if cfg.NpmRegistry != "" && !isHTTPURL(cfg.NpmRegistry) {
+		invalid("npmRegistry", "must be a http(s) url, got %q", cfg.NpmRegistry)
+	}
+	if cfg.JsrRegistry != "" && !isHTTPURL(cfg.JsrRegistry) {
+		invalid("jsrRegistry", "must be a http(s) url, got %q", cfg.JsrRegistry)
+	}
+	if cfg.NpmRegistryScope != "" && !strings.HasPrefix(cfg.NpmRegistryScope, "@") {
+		invalid("npmRegistryScope", "must start with \"@\", got %q", cfg.NpmRegistryScope)
+	}
+	for scope, reg := range cfg.NpmScopes {
+		if !regexpNpmScope.MatchString(scope) || scope == "@jsr" {
+			invalid("npmScopes", "must be keyed by npm scopes(e.g. \"@my-org\") except \"@jsr\", got %q", scope)
+		}
+		if !isHTTPURL(reg.Registry) {
+			invalid(fmt.Sprintf("npmScopes[%q].registry", scope), "must be a http(s) url, got %q", reg.Registry)
+		}
+	}
+	for name, reg := range cfg.NpmRegistries {
+		if !regexpRegistryName.MatchString(name) {
+			invalid("npmRegistries", "must be keyed by names of `[a-z0-9_-]`, got %q", name)
+		}
+		if !isHTTPURL(reg.Registry) {
+			invalid(fmt.Sprintf("npmRegistries[%q].registry", name), "must be a http(s) url, got %q", reg.Registry)
+		}
+	}
+	for option, value := range map[string]string{"proxy.http": cfg.Proxy.Http, "proxy.https": cfg.Proxy.Https} {
+		if value != "" && !isProxyURL(value) {
+			invalid(option, "must be a http(s) or socks5 url, got %q", value)
+		}
+	}
+	for host, value := range cfg.Proxy.Upstreams {
+		if host == "" || strings.ContainsAny(host, "/:") {
+			invalid("proxy.upstreams", "must be keyed by hostnames(without port), got %q", host)
+		}
+		if value != "direct" && !isProxyURL(value) {
+			invalid(fmt.Sprintf("proxy.upstreams[%q]", host), "must be a http(s) or socks5 url or \"direct\", got %q", value)
+		}
+	}
+	if cfg.DNS.Server != "" {
+		if host, _, err := net.SplitHostPort(cfg.DNS.Server); err != nil || net.ParseIP(host) == nil {
+			invalid("dns.server", "must be an ip address with an optional port, got %q", cfg.DNS.Server)
+		}
+	}
+	for host, ip := range cfg.DNS.Hosts {
+		if host == "" || strings.ContainsAny(host, "/:") {
+			invalid("dns.hosts", "must be keyed by hostnames(without port), got %q", host)
+		}
+		if addr := net.ParseIP(ip); addr == nil || (cfg.DNS.IPv6Only && addr.To4() != nil) {
+			invalid(fmt.Sprintf("dns.hosts[%q]", host), "must be an ip address, got %q", ip)
+		}
+	}
+	if cfg.AutoConcurrency.Enabled {
+		if cfg.AutoConcurrency.Min > cfg.BuildConcurrency {
+			invalid("autoConcurrency.min", "must not exceed `buildConcurrency`(%d), got %d", cfg.BuildConcurrency, cfg.AutoConcurrency.Min)
+		}
+		if cfg.AutoConcurrency.MaxLoad < 0 {
+			invalid("autoConcurrency.maxLoad", "must be a positive number, got %v", cfg.AutoConcurrency.MaxLoad)
+		}
+		if cfg.AutoConcurrency.MaxIOPressure < 0 || cfg.AutoConcurrency.MaxIOPressure > 100 {
+			invalid("autoConcurrency.maxIOPressure", "must be a percentage between 0 and 100, got %v", cfg.AutoConcurrency.MaxIOPressure)
+		}
+	}
+	if cfg.Cgroup.CPU < 0 {
+		invalid("cgroup.cpu", "must be a positive number, got %v", cfg.Cgroup.CPU)
+	}
+	if !strings.HasPrefix(cfg.Cgroup.Root, "/") {
+		invalid("cgroup.root", "must be an absolute path, got %q", cfg.Cgroup.Root)
+	}
+	switch cfg.SecurityHeaders.ReferrerPolicy {
+	case "", "no-referrer", "no-referrer-when-downgrade", "origin", "origin-when-cross-origin", "same-origin", "strict-origin", "strict-origin-when-cross-origin", "unsafe-url":
+	default:
+		invalid("securityHeaders.referrerPolicy", "must be a valid referrer policy, got %q", cfg.SecurityHeaders.ReferrerPolicy)
+	}
+	for token, priority := range cfg.PriorityTokens {
+		if len(token) < 16 {
+			invalid("priorityTokens", "must be keyed by tokens of at least 16 characters, got %q", token)
+		}
+		if priority <= 0 {
+			invalid("priorityTokens", "must be positive priorities, got %d", priority)
+		}
+	}
+	for i, scope := range cfg.BanList.Scopes {
+		if !strings.HasPrefix(scope.Name, "@") {
+			invalid(fmt.Sprintf("banList.scopes[%d].name", i), "must start with \"@\", got %q", scope.Name)
+		}
+	}
+	if cfg.Sync.From != "" {
+		if !isHTTPURL(cfg.Sync.From) {
+			invalid("sync.from", "must be a http(s) url, got %q", cfg.Sync.From)
+		}
+		if cfg.Sync.Token == "" {
+			invalid("sync.token", "is required to sync from %q", cfg.Sync.From)
+		}
+	}
+	if cfg.RecipesSync.URL != "" {
+		if !isHTTPURL(cfg.RecipesSync.URL) {
+			invalid("recipesSync.url", "must be a http(s) url, got %q", cfg.RecipesSync.URL)
+		}
+		if cfg.RecipesSync.PublicKey == "" {
+			invalid("recipesSync.publicKey", "is required to verify the recipe bundle of %q", cfg.RecipesSync.URL)
+		}
+	}
+	if cfg.Refresh.Top > 0 && cfg.Refresh.Interval < 60 {
+		invalid("refresh.interval", "must be at least 60 seconds, got %d", cfg.Refresh.Interval)
+	}
+	for i, hook := range cfg.Webhooks {
+		if !isHTTPURL(hook.URL) {
+			invalid(fmt.Sprintf("webhooks[%d].url", i), "must be a http(s) url, got %q", hook.URL)
+		}
+		for _, event := range hook.Events {
+			if event != "success" && event != "failure" {
+				invalid(fmt.Sprintf("webhooks[%d].events", i), "must be \"success\" or \"failure\", got %q", event)
+			}
+		}
+	}
+	if cfg.Alert.Webhook != "" && !isHTTPURL(cfg.Alert.Webhook) {
+		invalid("alert.webhook", "must be a http(s) url, got %q", cfg.Alert.Webhook)
+	}
+	switch cfg.Alert.Format {
+	case "", "slack", "discord":
+	default:
+		invalid("alert.format", "must be \"slack\" or \"discord\", got %q", cfg.Alert.Format)
+	}
+
+	for host, hc := range cfg.Hosts {
+		if host == "" || strings.ContainsAny(host, "/:") {
+			invalid("hosts", "must be keyed by hostnames(without port), got %q", host)
+		}
+		if hc.BuildVersion < 0 {
+			invalid(fmt.Sprintf("hosts[%q].buildVersion", host), "must be a positive number, got %d", hc.BuildVersion)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New("invalid config:\n  - " + strings.Join(errs, "\n  - "))
+	}
+	return nil
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func isProxyURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https" || u.Scheme == "socks5") && u.Host != ""
+}
+
+// parseConfig decodes the config file by the extension: YAML(`.yaml`, `.yml`), TOML(`.toml`) or JSON
+// with comments. The unknown options are returned as the warnings, e.g. the options of a newer server.
+func parseConfig(filename string, data []byte, v interface{}) (warnings []string, err error) {
+	var doc interface{}
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".toml":
+		var table map[string]interface{}
+		err = toml.Unmarshal(data, &table)
+		doc = table
+	default:
+		return parseJSONC(data, v)
+	}
+	if err != nil {
+		return
+	}
+	// the YAML/TOML document is decoded as JSON to share the checks of the JSON config
+	data, err = json.Marshal(doc)
+	if err != nil {
+		return
+	}
+	return decodeJSON(data, v, false)
+}
+
+// parseJSONC decodes the JSON with comments and trailing commas into v, the errors
+// contain the line and column of the bad value.
+func parseJSONC(data []byte, v interface{}) (warnings []string, err error) {
+	return decodeJSON(stripJSONC(data), v, true)
+}
+
+func decodeJSON(data []byte, v interface{}, withPosition bool) (warnings []string, err error) {
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &syntaxErr) {
+			line, col := getLineColumn(data, syntaxErr.Offset)
+			return nil, fmt.Errorf("%v (line %d, column %d)", syntaxErr, line, col)
+		}
+		if errors.As(err, &typeErr) {
+			err = fmt.Errorf("`%s` must be %s, got %s", typeErr.Field, typeErr.Type, typeErr.Value)
+			if withPosition {
+				line, col := getLineColumn(data, typeErr.Offset)
+				err = fmt.Errorf("%v (line %d, column %d)", err, line, col)
+			}
+		}
+		return nil, err
+	}
+	var doc interface{}
+	if json.Unmarshal(data, &doc) == nil {
+		warnings = findUnknownOptions(reflect.TypeOf(v), doc, "")
+	}
+	return
+}
+
+// findUnknownOptions returns the options of the decoded JSON that are not defined in the type,
+// the field names are matched case-insensitively like `encoding/json`.
+func findUnknownOptions(t reflect.Type, v interface{}, prefix string) (unknown []string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return
+		}
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			name := key
+			if prefix != "" {
+				name = prefix + "." + key
+			}
+			field, ok := findJSONField(t, key)
+			if !ok {
+				unknown = append(unknown, fmt.Sprintf("unknown option %q", name))
+				continue
+			}
+			unknown = append(unknown, findUnknownOptions(field.Type, obj[key], name)...)
+		}
+	case reflect.Map:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return
+		}
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			unknown = append(unknown, findUnknownOptions(t.Elem(), obj[key], fmt.Sprintf("%s[%q]", prefix, key))...)
+		}
+	case reflect.Slice, reflect.Array:
+		arr, ok := v.([]interface{})
+		if !ok {
+			return
+		}
+		for i, item := range arr {
+			unknown = append(unknown, findUnknownOptions(t.Elem(), item, fmt.Sprintf("%s[%d]", prefix, i))...)
+		}
+	}
+	return
+}
+
+func findJSONField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// stripJSONC replaces the comments and the trailing commas with spaces, so the offsets
+// of the JSON tokens are not changed.
+func stripJSONC(data []byte) []byte {
+	out := make([]byte, len(data))
+	copy(out, data)
+	lastComma := -1
+	for i := 0; i < len(out); i++ {
+		switch c := out[i]; {
+		case c == '"':
+			lastComma = -1
+			for i++; i < len(out) && out[i] != '"'; i++ {
+				if out[i] == '\\' {
+					i++
+				}
+			}
+		case c == '/' && i+1 < len(out) && out[i+1] == '/':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		case c == '/' && i+1 < len(out) && out[i+1] == '*':
+			for ; i < len(out) && !(out[i] == '*' && i+1 < len(out) && out[i+1] == '/'); i++ {
+				if out[i] != '\n' {
+					out[i] = ' '
+				}
+			}
+			if i+1 < len(out) {
+				out[i] = ' '
+				out[i+1] = ' '
+				i++
+			}
+		case c == ',':
+			lastComma = i
+		case c == '}' || c == ']':
+			if lastComma >= 0 {
+				out[lastComma] = ' '
+			}
+			lastComma = -1
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+		default:
+			lastComma = -1
+		}
+	}
+	return out
+}
+
+func getLineColumn(data []byte, offset int64) (line int, col int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	line = 1 + bytes.Count(data[:offset], []byte{'\n'})
+	col = int(offset) - bytes.LastIndexByte(data[:offset], '\n')
+	return
+}
@@ -0,0 +1,222 @@
+package config
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestLoadJSONC(t *testing.T) {
+	dir, err := os.MkdirTemp("", "esm-config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "JSONC",
+			content: "{\n  // comment\n  \"port\": 8081, /* block */\n  \"logLevel\": \"debug\",\n  \"banList\": { \"packages\": [\"a\",], },\n  \"hosts\": { \"ESM.Acme.com\": { \"allowList\": [\"@acme\"] } },\n}",
+		},
+		{
+			name:    "BadType",
+			content: "{\n  \"port\": \"8080\"\n}",
+			wantErr: "`port` must be uint16, got string (line 2, column",
+		},
+		{
+			name:    "SyntaxError",
+			content: "{\n  \"port\": 8080\n  \"logLevel\": \"debug\"\n}",
+			wantErr: "(line 3, column",
+		},
+		{
+			name:    "InvalidValues",
+			content: `{"logLevel": "verbose", "cjsLexer": "goja", "npmRegistry": "registry.npmjs.org", "alert": {"format": "teams"}}`,
+			wantErr: "`logLevel` must be one of",
+		},
+		{
+			name:    "InvalidJsrRegistry",
+			content: `{"jsrRegistry": "npm.jsr.io"}`,
+			wantErr: "`jsrRegistry` must be a http(s) url",
+		},
+		{
+			name:    "InvalidNpmScope",
+			content: `{"npmScopes": {"my-org": {"registry": "https://npm.acme.com/"}}}`,
+			wantErr: "`npmScopes` must be keyed by npm scopes",
+		},
+		{
+			name:    "UnverifiedRecipesSync",
+			content: `{"recipesSync": {"url": "https://recipes.esm.sh/bundle.json"}}`,
+			wantErr: "`recipesSync.publicKey` is required",
+		},
+		{
+			name:    "InvalidProxy",
+			content: `{"proxy": {"http": "proxy.acme.com:8080"}}`,
+			wantErr: "`proxy.http` must be a http(s) or socks5 url",
+		},
+		{
+			name:    "DeprecatedProxy",
+			content: `{"install": {"httpProxy": "proxy.acme.com:8080"}}`,
+			wantErr: "`proxy.http` must be a http(s) or socks5 url",
+		},
+		{
+			name:    "InvalidDNS",
+			content: `{"dns": {"server": "dns.acme.com", "hosts": {"registry.npmjs.org": "10.0.0.1"}}}`,
+			wantErr: "`dns.server` must be an ip address",
+		},
+		{
+			name:    "InvalidReferrerPolicy",
+			content: `{"securityHeaders": {"referrerPolicy": "never"}}`,
+			wantErr: "`securityHeaders.referrerPolicy` must be a valid referrer policy",
+		},
+		{
+			name:    "InvalidAutoConcurrencyMin",
+			content: `{"buildConcurrency": 8, "autoConcurrency": {"enabled": true, "min": 16}}`,
+			wantErr: "`autoConcurrency.min` must not exceed `buildConcurrency`(8)",
+		},
+		{
+			name:    "InvalidRefreshInterval",
+			content: `{"refresh": {"top": 100, "interval": 10}}`,
+			wantErr: "`refresh.interval` must be at least 60 seconds",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := path.Join(dir, tt.name+".jsonc")
+			err := os.WriteFile(filename, []byte(tt.content), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := Load(filename)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if cfg.Port != 8081 || cfg.LogLevel != "debug" || len(cfg.BanList.Packages) != 1 || len(cfg.Hosts["esm.acme.com"].AllowList) != 1 {
+					t.Fatalf("invalid config: %+v", cfg)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Load() error = %v, should contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadUnknownOptions(t *testing.T) {
+	dir, err := os.MkdirTemp("", "esm-config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := path.Join(dir, "config.json")
+	os.WriteFile(filename, []byte(`{"prot": 8080, "Port": 8081, "install": {"fetchTimout": 10}, "hosts": {"esm.acme.com": {"allowlist": ["@acme"], "defaultTaget": "es2022"}}}`), 0644)
+	cfg, err := Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`unknown option "hosts[\"esm.acme.com\"].defaultTaget"`,
+		`unknown option "install.fetchTimout"`,
+		`unknown option "prot"`,
+	}
+	if cfg.Port != 8081 || len(cfg.Hosts["esm.acme.com"].AllowList) != 1 || strings.Join(cfg.Warnings, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("invalid warnings: %q", cfg.Warnings)
+	}
+}
+
+func TestLoadYAMLAndTOML(t *testing.T) {
+	dir, err := os.MkdirTemp("", "esm-config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"config.yaml": `
+# the server options
+port: 8081
+logLevel: "debug" # inline comment
+banList:
+  packages: [a, "b#c"]
+  scopes:
+    - name: "@evil"
+      excludes:
+        - "@evil/good"
+buildConcurrency: 4
+npmScopes:
+  "@acme":
+    registry: https://npm.acme.com/
+    token: 'it''s a token'
+priorityTokens: {xxxxxxxxxxxxxxxxxxxxxxxx: 2}
+cgroup:
+  root: /sys/fs/cgroup/acme
+`,
+		"config.toml": `
+# the server options
+port = 8081
+logLevel = "debug" # inline comment
+buildConcurrency = 4
+priorityTokens = { xxxxxxxxxxxxxxxxxxxxxxxx = 2 }
+
+[banList]
+packages = [
+  "a",
+  "b#c", # trailing comma
+]
+
+[[banList.scopes]]
+name = "@evil"
+excludes = ["@evil/good"]
+
+[npmScopes."@acme"]
+registry = "https://npm.acme.com/"
+token = "it's a token"
+
+[cgroup]
+root = "/sys/fs/cgroup/acme"
+`,
+	}
+	for name, content := range files {
+		filename := path.Join(dir, name)
+		os.WriteFile(filename, []byte(content), 0644)
+		cfg, err := Load(filename)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if cfg.Port != 8081 || cfg.LogLevel != "debug" || cfg.BuildConcurrency != 4 || cfg.PriorityTokens["xxxxxxxxxxxxxxxxxxxxxxxx"] != 2 {
+			t.Fatalf("%s: invalid config: %+v", name, cfg)
+		}
+		if strings.Join(cfg.BanList.Packages, ",") != "a,b#c" || len(cfg.BanList.Scopes) != 1 || cfg.BanList.Scopes[0].Name != "@evil" || cfg.BanList.Scopes[0].Excludes[0] != "@evil/good" {
+			t.Fatalf("%s: invalid ban list: %+v", name, cfg.BanList)
+		}
+		if cfg.NpmScopes["@acme"].Registry != "https://npm.acme.com/" || cfg.NpmScopes["@acme"].Token != "it's a token" || cfg.Cgroup.Root != "/sys/fs/cgroup/acme" || len(cfg.Warnings) != 0 {
+			t.Fatalf("%s: invalid config: %+v", name, cfg)
+		}
+	}
+
+	// the type errors and the syntax errors
+	for name, content := range map[string]string{
+		"bad-type.yaml":   "port: \"8080\"\n",
+		"bad-indent.yaml": "banList:\n  packages:\n    - a\n   - b\n",
+		"bad-type.toml":   "port = \"8080\"\n",
+		"bad-value.toml":  "port = 80 80\n",
+	} {
+		filename := path.Join(dir, name)
+		os.WriteFile(filename, []byte(content), 0644)
+		if _, err := Load(filename); err == nil {
+			t.Fatalf("%s: should be an error", name)
+		}
+	}
+}
+
+func TestLoadExampleConfig(t *testing.T) {
+	_, err := Load("../../config.example.jsonc")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -13,6 +13,8 @@ const (
 	nodejsLatestLTS  = "18.16.0"
 	nodeTypesVersion = "18.16.9"
 	denoStdVersion   = "0.177.1"
+	// the typescript version to select the `typesVersions` of package.json
+	typescriptVersion = "5.1.6"
 )
 
 // fix some npm package versions
@@ -41,6 +43,16 @@ var stableBuild = map[string]bool{
 	"vue":      true,
 }
 
+// the extensions of the files that can be rendered as documents by browsers, the raw files are
+// served in a sandbox to avoid running the scripts of the packages on the CDN origin
+var markupExts = map[string]bool{
+	"html":  true,
+	"htm":   true,
+	"xhtml": true,
+	"svg":   true,
+	"xml":   true,
+}
+
 var assetExts = map[string]bool{
 	"wasm":       true,
 	"css":        true,
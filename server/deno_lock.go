@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DenoLock is the lockfile(v3) of Deno, the remote modules are locked by the
+// hex-encoded SHA-256 hash of their source.
+// ref https://github.com/denoland/deno_lockfile
+type DenoLock struct {
+	Version   string            `json:"version"`
+	Redirects map[string]string `json:"redirects,omitempty"`
+	Remote    map[string]string `json:"remote"`
+}
+
+const (
+	// the max number of entries of a lockfile request
+	maxDenoLockEntries = 10
+	// the max number of modules of a generated lockfile
+	maxDenoLockModules = 500
+	// the max time to generate a lockfile
+	denoLockTimeout = 5 * time.Minute
+)
+
+var (
+	regexpImportSpecifier    = regexp.MustCompile(`(?:\bfrom|\bimport)\s*\(?\s*["']([^"'\s]+)["']`)
+	regexpReferenceSpecifier = regexp.MustCompile(`<reference\s+(?:path|types)\s*=\s*["']([^"'\s]+)["']`)
+)
+
+// sha256Hex returns the hash of the data in the format of the deno lockfile.
+func sha256Hex(data []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+// generateDenoLock walks the module graph of the entries like Deno does and returns
+// the lockfile of the modules served by this server, the modules are fetched from
+// the server itself as `User-Agent: Deno/*` with the headers of the request.
+func generateDenoLock(r *http.Request, origin string, entries []string) (lock *DenoLock, err error) {
+	lock = &DenoLock{
+		Version:   "3",
+		Redirects: map[string]string{},
+		Remote:    map[string]string{},
+	}
+	if len(entries) > maxDenoLockEntries {
+		return nil, fmt.Errorf("too many modules(>%d)", maxDenoLockEntries)
+	}
+	// the modules may be built on the fly, so the timeout of each module is the build timeout,
+	// and the whole walk is bound to the lockfile timeout or the request is canceled
+	ctx, cancel := context.WithTimeout(r.Context(), denoLockTimeout)
+	defer cancel()
+	client := &http.Client{
+		Timeout: getBuildTimeout() + 10*time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	userAgent := r.UserAgent()
+	if !strings.HasPrefix(userAgent, "Deno/") {
+		userAgent = "Deno/1.40.0"
+	}
+
+	queue := make([]string, len(entries))
+	isEntry := map[string]bool{}
+	for i, entry := range entries {
+		queue[i] = origin + cfg.BasePath + "/" + strings.TrimPrefix(entry, "/")
+		isEntry[queue[i]] = true
+	}
+	visited := map[string]bool{}
+	for len(queue) > 0 {
+		moduleUrl := queue[0]
+		queue = queue[1:]
+		if visited[moduleUrl] {
+			continue
+		}
+		visited[moduleUrl] = true
+		if len(visited) > maxDenoLockModules {
+			return nil, fmt.Errorf("too many modules(>%d)", maxDenoLockModules)
+		}
+
+		req, e := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("http://localhost:%d%s", cfg.Port, strings.TrimPrefix(moduleUrl, origin)), nil)
+		if e != nil {
+			return nil, e
+		}
+		req.Host = r.Host
+		req.Header.Set("User-Agent", userAgent)
+		for _, key := range []string{"Authorization", "X-Real-Origin"} {
+			if v := r.Header.Get(key); v != "" {
+				req.Header.Set(key, v)
+			}
+		}
+		res, e := client.Do(req)
+		if e != nil {
+			return nil, e
+		}
+		data, e := io.ReadAll(res.Body)
+		res.Body.Close()
+		if e != nil {
+			return nil, e
+		}
+
+		switch {
+		case res.StatusCode >= 300 && res.StatusCode < 400:
+			if location, ok := resolveDenoLockSpecifier(moduleUrl, res.Header.Get("Location"), origin); ok {
+				lock.Redirects[moduleUrl] = location
+				queue = append(queue, location)
+			}
+		case res.StatusCode == 200:
+			lock.Remote[moduleUrl] = sha256Hex(data)
+			var specifiers []string
+			if types := res.Header.Get("X-TypeScript-Types"); types != "" {
+				specifiers = append(specifiers, types)
+			}
+			for _, m := range regexpImportSpecifier.FindAllSubmatch(data, -1) {
+				specifiers = append(specifiers, string(m[1]))
+			}
+			for _, m := range regexpReferenceSpecifier.FindAllSubmatch(data, -1) {
+				specifiers = append(specifiers, string(m[1]))
+			}
+			for _, specifier := range specifiers {
+				if u, ok := resolveDenoLockSpecifier(moduleUrl, specifier, origin); ok && !visited[u] {
+					queue = append(queue, u)
+				}
+			}
+		default:
+			if isEntry[moduleUrl] {
+				return nil, fmt.Errorf("%s: %s", moduleUrl, strings.TrimSpace(string(data)))
+			}
+			// the specifier may be a false positive of the regexp
+			debugf("resolver", "", "deno.lock: skip %s(%d)", moduleUrl, res.StatusCode)
+		}
+	}
+	return
+}
+
+// resolveDenoLockSpecifier resolves the import specifier of the module, only the modules
+// of the origin are returned.
+func resolveDenoLockSpecifier(moduleUrl string, specifier string, origin string) (string, bool) {
+	if !isRemoteSpecifier(specifier) && !isLocalSpecifier(specifier) {
+		return "", false
+	}
+	base, err := url.Parse(moduleUrl)
+	if err != nil {
+		return "", false
+	}
+	ref, err := url.Parse(specifier)
+	if err != nil {
+		return "", false
+	}
+	resolved := base.ResolveReference(ref)
+	resolved.Fragment = ""
+	s := resolved.String()
+	if !strings.HasPrefix(s, origin+"/") {
+		return "", false
+	}
+	return s, true
+}
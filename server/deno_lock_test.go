@@ -0,0 +1,37 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestResolveDenoLockSpecifier(t *testing.T) {
+	origin := "https://esm.sh"
+	moduleUrl := "https://esm.sh/v135/react-dom@18.2.0/denonext/client.js"
+	for specifier, expected := range map[string]string{
+		"/v135/react@18.2.0/denonext/react.mjs": "https://esm.sh/v135/react@18.2.0/denonext/react.mjs",
+		"./react-dom.mjs":                       "https://esm.sh/v135/react-dom@18.2.0/denonext/react-dom.mjs",
+		"https://esm.sh/scheduler@0.23.0":       "https://esm.sh/scheduler@0.23.0",
+		"https://deno.land/std/path/mod.ts":     "",
+		"react":                                 "",
+	} {
+		u, ok := resolveDenoLockSpecifier(moduleUrl, specifier, origin)
+		if ok != (expected != "") || u != expected {
+			t.Fatalf("resolve %s: expected %q, got %q", specifier, expected, u)
+		}
+	}
+}
+
+func TestImportSpecifierRegexp(t *testing.T) {
+	code := `/// <reference types="./index.d.ts" />
+import*as a from"/v135/a@1.0.0/denonext/a.mjs";import"/v135/b@1.0.0/denonext/b.mjs";export*from "/v135/c@1.0.0/denonext/c.mjs";const d=await import('/v135/d@1.0.0/denonext/d.mjs');`
+	var specifiers []string
+	for _, m := range regexpImportSpecifier.FindAllStringSubmatch(code, -1) {
+		specifiers = append(specifiers, m[1])
+	}
+	for _, m := range regexpReferenceSpecifier.FindAllStringSubmatch(code, -1) {
+		specifiers = append(specifiers, m[1])
+	}
+	if len(specifiers) != 5 {
+		t.Fatalf("expected 5 specifiers, got %v", specifiers)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package server
+
+import "errors"
+
+func getDiskFreeSpace(dir string) (uint64, error) {
+	return 0, errors.New("unsupported platform")
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/ije/gox/utils"
+)
+
+// the Node.js preload script to apply the `dns` config to pnpm, it patches the `dns.lookup`
+// function that is used by the http agents.
+const dnsPreloadJS = `const dns = require("dns");
+const net = require("net");
+const { server, hosts, ipv6Only } = JSON.parse(process.env.ESM_DNS_CONFIG || "{}");
+const resolver = server ? new dns.Resolver() : null;
+if (resolver) {
+  resolver.setServers([server]);
+}
+const lookup = dns.lookup;
+dns.lookup = function (hostname, options, callback) {
+  if (typeof options === "function") {
+    callback = options;
+    options = {};
+  } else if (typeof options === "number") {
+    options = { family: options };
+  } else {
+    options = { ...options };
+  }
+  if (ipv6Only) {
+    options.family = 6;
+  }
+  const reply = (address) => {
+    const family = net.isIPv6(address) ? 6 : 4;
+    if (options.all) {
+      callback(null, [{ address, family }]);
+    } else {
+      callback(null, address, family);
+    }
+  };
+  if (hosts && hosts[hostname]) {
+    return reply(hosts[hostname]);
+  }
+  if (resolver && !net.isIP(hostname)) {
+    const resolve = options.family === 6 ? "resolve6" : "resolve4";
+    return resolver[resolve](hostname, (err, addresses) => {
+      if (err || addresses.length === 0) {
+        return lookup.call(dns, hostname, options, callback);
+      }
+      reply(addresses[0]);
+    });
+  }
+  return lookup.call(dns, hostname, options, callback);
+};
+`
+
+var (
+	dnsResolverOnce sync.Once
+	dnsResolver     *net.Resolver
+	dnsPreloadOnce  sync.Once
+	dnsPreloadFile  string
+)
+
+// transportDialContext applies the `dns` config to the dialer of the http client.
+func transportDialContext(dialer *net.Dialer) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		if cfg == nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		if cfg.DNS.IPv6Only && network == "tcp" {
+			network = "tcp6"
+		}
+		if host, port, err := net.SplitHostPort(addr); err == nil {
+			if ip, ok := cfg.DNS.Hosts[host]; ok {
+				addr = net.JoinHostPort(ip, port)
+			}
+		}
+		if cfg.DNS.Server != "" {
+			d := *dialer
+			d.Resolver = getDNSResolver()
+			return d.DialContext(ctx, network, addr)
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
+
+// getDNSResolver returns the resolver that uses the DNS server of the `dns` config.
+func getDNSResolver() *net.Resolver {
+	dnsResolverOnce.Do(func() {
+		dnsResolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, cfg.DNS.Server)
+			},
+		}
+	})
+	return dnsResolver
+}
+
+// getDNSEnv returns the environment variables to apply the `dns` config to pnpm.
+func getDNSEnv() []string {
+	if cfg == nil || (cfg.DNS.Server == "" && len(cfg.DNS.Hosts) == 0 && !cfg.DNS.IPv6Only) {
+		return nil
+	}
+	dnsPreloadOnce.Do(func() {
+		filename := path.Join(cfg.WorkDir, "dns-preload.cjs")
+		err := os.WriteFile(filename, []byte(dnsPreloadJS), 0644)
+		if err != nil {
+			log.Errorf("Failed to write %s: %v", filename, err)
+			return
+		}
+		dnsPreloadFile = filename
+	})
+	if dnsPreloadFile == "" {
+		return nil
+	}
+	nodeOptions := strings.TrimSpace(os.Getenv("NODE_OPTIONS") + fmt.Sprintf(` --require "%s"`, dnsPreloadFile))
+	return []string{
+		"NODE_OPTIONS=" + nodeOptions,
+		"ESM_DNS_CONFIG=" + string(utils.MustEncodeJSON(cfg.DNS)),
+	}
+}
+
+// getGitDNSArgs returns the git config args to resolve the hosts of the `dns` config.
+func getGitDNSArgs() (args []string) {
+	if cfg == nil {
+		return
+	}
+	for host, ip := range cfg.DNS.Hosts {
+		if strings.ContainsRune(ip, ':') {
+			ip = "[" + ip + "]"
+		}
+		args = append(args, "-c", fmt.Sprintf("http.curloptResolve=%s:443:%s", host, ip))
+	}
+	return
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/esm-dev/esm.sh/server/config"
+)
+
+func TestTransportDialContext(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	withConfig(t, &config.Config{
+		DNS: config.DNS{
+			Hosts: map[string]string{"registry.npmjs.test": "127.0.0.1"},
+		},
+	})
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	dial := transportDialContext(&net.Dialer{})
+	conn, err := dial(context.Background(), "tcp", net.JoinHostPort("registry.npmjs.test", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != l.Addr().String() {
+		t.Fatalf("expected to connect to %s, got %s", l.Addr(), conn.RemoteAddr())
+	}
+}
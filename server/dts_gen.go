@@ -0,0 +1,76 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// parseMetafileExports returns the exports of the js output in the esbuild metafile.
+func parseMetafileExports(metafile string) []string {
+	var meta struct {
+		Outputs map[string]struct {
+			Exports []string `json:"exports"`
+		} `json:"outputs"`
+	}
+	if json.Unmarshal([]byte(metafile), &meta) != nil {
+		return nil
+	}
+	for name, output := range meta.Outputs {
+		if strings.HasSuffix(name, ".js") {
+			if output.Exports == nil {
+				return []string{}
+			}
+			return output.Exports
+		}
+	}
+	return nil
+}
+
+// generateDTS generates the type definitions of the untyped module from the exports of the build
+// and saves it in the storage, the returned dts path is relative to the build version.
+func (task *BuildTask) generateDTS(buildVersion int) (dts string, err error) {
+	name := task.Pkg.Submodule
+	if name == "" {
+		name = "index"
+	}
+	dts = fmt.Sprintf("%s@%s/%s%s.gen.d.ts", task.Pkg.Name, task.Pkg.Version, encodeBuildArgsPrefix(task.BuildArgs, task.Pkg, true), name)
+	savePath := path.Join("types", getTypesRoot(task.CdnOrigin), fmt.Sprintf("v%d", buildVersion)+task.ghPrefix(), dts)
+	_, err = fs.WriteFile(savePath, bytes.NewReader(generateDTSContent(task.Pkg, task.exports)))
+	return
+}
+
+// generateDTSContent returns the type definitions of the exports typed as `any`, the exports are
+// declared with aliases since the export names can be reserved words, e.g. `export { __0 as delete }`.
+func generateDTSContent(pkg Pkg, exports []string) []byte {
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "/* esm.sh - generated types of the untyped module %s, the exports are typed as `any` */\n", pkg)
+	names := make([]string, 0, len(exports))
+	hasDefault := false
+	for _, name := range exports {
+		if name == "default" {
+			hasDefault = true
+			buf.WriteString("declare const __default: any;\n")
+			buf.WriteString("export default __default;\n")
+		} else if regexpJSIdent.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if len(names) > 0 {
+		specifiers := make([]string, len(names))
+		for i, name := range names {
+			fmt.Fprintf(buf, "declare const __%d: any;\n", i)
+			specifiers[i] = fmt.Sprintf("__%d as %s", i, name)
+		}
+		fmt.Fprintf(buf, "export { %s };\n", strings.Join(specifiers, ", "))
+	}
+	if !hasDefault && len(names) == 0 {
+		// keep the file a module
+		buf.WriteString("export {};\n")
+	}
+	return buf.Bytes()
+}
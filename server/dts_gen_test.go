@@ -0,0 +1,36 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestGenerateDTS(t *testing.T) {
+	exports := parseMetafileExports(`{
+		"inputs": {},
+		"outputs": {
+			"/esm/foo.js.map": { "imports": [], "exports": [] },
+			"/esm/foo.js": { "imports": [], "exports": ["default", "parse", "delete", "a-b"] }
+		}
+	}`)
+	if len(exports) != 4 {
+		t.Fatalf("invalid exports: %v", exports)
+	}
+	dts := string(generateDTSContent(Pkg{Name: "foo", Version: "1.0.0"}, exports))
+	expected := "/* esm.sh - generated types of the untyped module foo@1.0.0, the exports are typed as `any` */\n" +
+		"declare const __default: any;\n" +
+		"export default __default;\n" +
+		"declare const __0: any;\n" +
+		"declare const __1: any;\n" +
+		"export { __0 as delete, __1 as parse };\n"
+	if dts != expected {
+		t.Fatalf("invalid dts:\n%s", dts)
+	}
+
+	if exports := parseMetafileExports(`{"outputs": {"/esm/foo.js": {}}}`); exports == nil || len(exports) != 0 {
+		t.Fatalf("the module without exports should have empty exports, got %v", exports)
+	}
+	dts = string(generateDTSContent(Pkg{Name: "foo", Version: "1.0.0"}, []string{"a-b"}))
+	if dts != "/* esm.sh - generated types of the untyped module foo@1.0.0, the exports are typed as `any` */\nexport {};\n" {
+		t.Fatalf("invalid dts:\n%s", dts)
+	}
+}
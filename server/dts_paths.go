@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// TSConfigPaths is the path aliases of the `tsconfig.json` shipped in a package, the aliases
+// (e.g. `@/utils`) are left unresolved in the types by some build tools.
+type TSConfigPaths struct {
+	pkgDir  string
+	baseDir string
+	paths   map[string]interface{}
+	// the source dir and the declaration dir to map the source paths to the types
+	rootDir string
+	outDir  string
+}
+
+// loadTSConfigPaths loads the `compilerOptions.paths` of the `tsconfig.json` in the package
+// directory, nil is returned if the package has no path aliases.
+func loadTSConfigPaths(pkgDir string) *TSConfigPaths {
+	data, err := os.ReadFile(path.Join(pkgDir, "tsconfig.json"))
+	if err != nil {
+		return nil
+	}
+	var tsconfig struct {
+		CompilerOptions struct {
+			BaseUrl        string                 `json:"baseUrl"`
+			Paths          map[string]interface{} `json:"paths"`
+			RootDir        string                 `json:"rootDir"`
+			OutDir         string                 `json:"outDir"`
+			DeclarationDir string                 `json:"declarationDir"`
+		} `json:"compilerOptions"`
+	}
+	if json.Unmarshal([]byte(stripJSComments(string(data))), &tsconfig) != nil {
+		return nil
+	}
+	options := tsconfig.CompilerOptions
+	if len(options.Paths) == 0 {
+		return nil
+	}
+	outDir := options.DeclarationDir
+	if outDir == "" {
+		outDir = options.OutDir
+	}
+	return &TSConfigPaths{
+		pkgDir:  pkgDir,
+		baseDir: path.Join(pkgDir, options.BaseUrl),
+		paths:   options.Paths,
+		rootDir: path.Join(pkgDir, options.RootDir),
+		outDir:  path.Join(pkgDir, outDir),
+	}
+}
+
+// Resolve resolves the path alias to the relative specifier of the dts file in the dir,
+// the resolved file must exist in the package directory.
+func (p *TSConfigPaths) Resolve(dir string, specifier string) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	targets, match, ok := matchPathMappings(p.paths, specifier)
+	if !ok {
+		return "", false
+	}
+	for _, t := range targets {
+		s, ok := t.(string)
+		if !ok || s == "" {
+			continue
+		}
+		filename := path.Join(p.baseDir, strings.ReplaceAll(s, "*", match))
+		candidates := []string{filename}
+		// map the source path(e.g. `src/utils`) to the declaration dir(e.g. `dist/utils`)
+		if p.outDir != p.rootDir && strings.HasPrefix(filename, p.rootDir+"/") {
+			candidates = append(candidates, path.Join(p.outDir, strings.TrimPrefix(filename, p.rootDir+"/")))
+		}
+		for _, c := range candidates {
+			// the dependencies are resolved by the package names instead
+			if f, ok := findDtsFile(c); ok && strings.HasPrefix(f, p.pkgDir+"/") && !strings.Contains(f[len(p.pkgDir):], "/node_modules/") {
+				rel, err := filepath.Rel(dir, f)
+				if err != nil {
+					continue
+				}
+				rel = filepath.ToSlash(rel)
+				if !strings.HasPrefix(rel, "../") {
+					rel = "./" + rel
+				}
+				return rel, true
+			}
+		}
+	}
+	return "", false
+}
+
+// findDtsFile finds the dts file of the module path, e.g. `utils` -> `utils.d.ts`, `utils/index.d.ts`.
+func findDtsFile(filename string) (string, bool) {
+	if isDtsFile(filename) {
+		return filename, fileExists(filename)
+	}
+	basename := filename
+	for _, ext := range []string{".ts", ".tsx", ".mts", ".cts", ".js", ".mjs", ".cjs"} {
+		if strings.HasSuffix(filename, ext) {
+			basename = strings.TrimSuffix(filename, ext)
+			break
+		}
+	}
+	for _, f := range []string{basename + ".d.ts", basename + ".d.mts", basename + ".d.cts", path.Join(filename, "index.d.ts")} {
+		if fileExists(f) {
+			return f, true
+		}
+	}
+	return "", false
+}
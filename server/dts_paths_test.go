@@ -0,0 +1,78 @@
+package server
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestTSConfigPaths(t *testing.T) {
+	dir, err := os.MkdirTemp("", "esm-dts-paths-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pkgDir := path.Join(dir, "node_modules", "foo")
+	files := map[string]string{
+		"tsconfig.json": `{
+			// the path aliases of the sources
+			"compilerOptions": {
+				"baseUrl": ".",
+				"rootDir": "src",
+				"declarationDir": "dist/types",
+				"paths": { "@/*": ["src/*"], "#config": ["config/index.d.ts"] },
+			}
+		}`,
+		"dist/types/index.d.ts":       `export * from "@/utils";`,
+		"dist/types/utils/index.d.ts": `export {}`,
+		"dist/types/lib/core.d.ts":    `export {}`,
+		"config/index.d.ts":           `export {}`,
+	}
+	for name, content := range files {
+		ensureDir(path.Dir(path.Join(pkgDir, name)))
+		err = os.WriteFile(path.Join(pkgDir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the trailing commas are not allowed in the json
+	if loadTSConfigPaths(pkgDir) != nil {
+		t.Fatal("the invalid tsconfig.json should be ignored")
+	}
+	err = os.WriteFile(path.Join(pkgDir, "tsconfig.json"), []byte(`{
+		// the path aliases of the sources
+		"compilerOptions": {
+			"baseUrl": ".",
+			"rootDir": "src",
+			"declarationDir": "dist/types",
+			"paths": { "@/*": ["src/*"], "#config": ["config/index.d.ts"] }
+		}
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tsPaths := loadTSConfigPaths(pkgDir)
+	if tsPaths == nil {
+		t.Fatal("should load the paths of tsconfig.json")
+	}
+	dtsDir := path.Join(pkgDir, "dist/types")
+	for specifier, want := range map[string]string{
+		"@/utils":    "./utils/index.d.ts",
+		"@/lib/core": "./lib/core.d.ts",
+		"#config":    "../../config/index.d.ts",
+		"@/missing":  "",
+		"react":      "",
+	} {
+		resolved, _ := tsPaths.Resolve(dtsDir, specifier)
+		if resolved != want {
+			t.Fatalf("Resolve(%q) = %q, want %q", specifier, resolved, want)
+		}
+	}
+
+	var nilPaths *TSConfigPaths
+	if _, ok := nilPaths.Resolve(dtsDir, "@/utils"); ok {
+		t.Fatal("the nil paths should not resolve anything")
+	}
+}
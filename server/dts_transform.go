@@ -31,6 +31,11 @@ func (task *BuildTask) transformDTS(dts string, aliasDepsPrefix string, marker *
 	}
 	marker.Add(aliasDepsPrefix + dts)
 
+	// stop copying the dts files of the canceled build
+	if err = task.context().Err(); err != nil {
+		return
+	}
+
 	var pkgInfo NpmPackage
 	pkgJsonPath := path.Join(task.wd, "node_modules", task.Pkg.Name, "package.json")
 	err = utils.ParseJSONFile(pkgJsonPath, &pkgInfo)
@@ -59,9 +64,17 @@ func (task *BuildTask) transformDTS(dts string, aliasDepsPrefix string, marker *
 		return
 	}
 
-	dtsFilePath := path.Join(task.wd, "node_modules", regexpFullVersionPath.ReplaceAllString(dts, "$1/"))
+	// the dts path may be resolved from a crafted reference path of the types
+	dtsFilePath, err := securejoin(path.Join(task.wd, "node_modules"), regexpFullVersionPath.ReplaceAllString(dts, "$1/"))
+	if err != nil {
+		return fmt.Errorf("invalid dts path '%s'", dts)
+	}
 	dtsDir := path.Dir(dtsFilePath)
-	dtsFile, err := os.Open(dtsFilePath)
+	realDtsFilePath, err := realpathIn(task.wd, dtsFilePath)
+	if err != nil {
+		return
+	}
+	dtsFile, err := os.Open(realDtsFilePath)
 	if err != nil {
 		return
 	}
@@ -98,7 +111,8 @@ func (task *BuildTask) transformDTS(dts string, aliasDepsPrefix string, marker *
 	wd := task.getRealWD()
 	buf := bytes.NewBuffer(nil)
 	imports := newStringSet()
-	dtsBasePath := fmt.Sprintf("%s%s/v%d", task.CdnOrigin, cfg.BasePath, task.BuildVersion)
+	tsPaths := loadTSConfigPaths(path.Join(task.wd, "node_modules", pkgName))
+	dtsBasePath := fmt.Sprintf("%s%s%s", task.CdnOrigin, cfg.BasePath, getTypesPathPrefix(task.BuildVersion))
 	if pkgName == "@types/node" {
 		fmt.Fprintf(buf, "/// <reference path=\"%s/node.ns.d.ts\" />\n", dtsBasePath)
 	}
@@ -113,6 +127,13 @@ func (task *BuildTask) transformDTS(dts string, aliasDepsPrefix string, marker *
 			}
 		}
 
+		// resolve the path aliases of the `tsconfig.json` shipped in the package, e.g. `@/utils`
+		if !isLocalSpecifier(importPath) {
+			if specifier, ok := tsPaths.Resolve(dtsDir, importPath); ok {
+				importPath = specifier
+			}
+		}
+
 		if task.external.Has("*") && !strings.HasPrefix(pkgName, "@types/") && !isLocalSpecifier(importPath) {
 			return importPath
 		}
@@ -152,25 +173,7 @@ func (task *BuildTask) transformDTS(dts string, aliasDepsPrefix string, marker *
 			if importPath == ".." {
 				importPath = "../index.d.ts"
 			}
-			// some types is using `.js` extname
-			importPath = strings.TrimSuffix(importPath, ".js")
-			if !strings.HasSuffix(importPath, ".d.ts") {
-				if fileExists(path.Join(dtsDir, importPath, "index.d.ts")) {
-					importPath = strings.TrimSuffix(importPath, "/") + "/index.d.ts"
-				} else if fileExists(path.Join(dtsDir, importPath+".d.ts")) {
-					importPath = importPath + ".d.ts"
-				} else {
-					var p NpmPackage
-					packageJSONFile := path.Join(dtsDir, importPath, "package.json")
-					if fileExists(packageJSONFile) && utils.ParseJSONFile(packageJSONFile, &p) == nil {
-						if p.Types != "" {
-							importPath = strings.TrimSuffix(importPath, "/") + utils.CleanPath(p.Types)
-						} else if p.Typings != "" {
-							importPath = strings.TrimSuffix(importPath, "/") + utils.CleanPath(p.Typings)
-						}
-					}
-				}
-			}
+			importPath = resolveDtsSpecifier(dtsDir, importPath)
 			if strings.HasSuffix(dts, ".d.ts") && !strings.HasSuffix(dts, "~.d.ts") {
 				imports.Add(importPath)
 			}
@@ -256,7 +259,7 @@ func (task *BuildTask) transformDTS(dts string, aliasDepsPrefix string, marker *
 				bv = STABLE_VERSION
 			}
 			pkgPath := info.Name + "@" + info.Version + "/" + encodeBuildArgsPrefix(task.BuildArgs, Pkg{Name: info.Name}, true)
-			importPath = fmt.Sprintf("%s%s/v%d/%s%s", task.CdnOrigin, cfg.BasePath, bv, pkgPath, importPath)
+			importPath = fmt.Sprintf("%s%s%s/%s%s", task.CdnOrigin, cfg.BasePath, getTypesPathPrefix(bv), pkgPath, importPath)
 		}
 		return importPath
 	})
@@ -314,6 +317,10 @@ func (task *BuildTask) transformDTS(dts string, aliasDepsPrefix string, marker *
 				importDts = path.Join(path.Dir(dts), importDts)
 			}
 		}
+		// skip the reference paths that escape the node_modules directory
+		if isUnsafePath(importDts) {
+			continue
+		}
 		wg.Add(1)
 		go func(importDts string) {
 			err := task.transformDTS(importDts, aliasDepsPrefix, marker)
@@ -395,10 +402,12 @@ func (task *BuildTask) toTypesPath(wd string, p NpmPackage, version string, buil
 			types = types + ".ts"
 		} else if fileExists(path.Join(pkgDir, types+".mts")) {
 			types = types + ".mts"
+		} else if fileExists(path.Join(pkgDir, types+".cts")) {
+			types = types + ".cts"
 		}
 	}
 
-	if !endsWith(types, ".d.ts", ".d.mts") && !strings.HasSuffix(types, "/*") {
+	if !isDtsFile(types) && !strings.HasSuffix(types, "/*") {
 		pkgDir := path.Join(wd, "node_modules", p.Name)
 		if fileExists(path.Join(pkgDir, types, "index.d.ts")) {
 			types = types + "/index.d.ts"
@@ -415,3 +424,52 @@ func (task *BuildTask) toTypesPath(wd string, p NpmPackage, version string, buil
 
 	return fmt.Sprintf("%s@%s/%s%s", p.Name, version, buildArgsPrefix, utils.CleanPath(types)[1:])
 }
+
+// the extensions of the modules and their declaration files
+var dtsExtensions = [][2]string{
+	{".js", ".d.ts"},
+	{".jsx", ".d.ts"},
+	{".ts", ".d.ts"},
+	{".tsx", ".d.ts"},
+	{".mjs", ".d.mts"},
+	{".mts", ".d.mts"},
+	{".cjs", ".d.cts"},
+	{".cts", ".d.cts"},
+}
+
+// resolveDtsSpecifier resolves the relative specifier in the dts file to the declaration file with
+// the explicit extension, e.g. `./foo.js` -> `./foo.d.ts`, `./foo.mjs` -> `./foo.d.mts`, `./dir` -> `./dir/index.d.ts`,
+// the `moduleResolution: bundler/nodenext` of TypeScript 5 can't resolve the extensionless specifiers.
+// The specifier is returned as it is if the declaration file is not found.
+func resolveDtsSpecifier(dtsDir string, specifier string) string {
+	if isDtsFile(specifier) {
+		return specifier
+	}
+	basename := specifier
+	for _, e := range dtsExtensions {
+		if strings.HasSuffix(specifier, e[0]) {
+			basename = strings.TrimSuffix(specifier, e[0])
+			if fileExists(path.Join(dtsDir, basename+e[1])) {
+				return basename + e[1]
+			}
+			break
+		}
+	}
+	if fileExists(path.Join(dtsDir, basename+".d.ts")) {
+		return basename + ".d.ts"
+	}
+	dir := strings.TrimSuffix(basename, "/")
+	if fileExists(path.Join(dtsDir, dir, "index.d.ts")) {
+		return dir + "/index.d.ts"
+	}
+	var p NpmPackage
+	packageJSONFile := path.Join(dtsDir, dir, "package.json")
+	if fileExists(packageJSONFile) && utils.ParseJSONFile(packageJSONFile, &p) == nil {
+		if p.Types != "" {
+			return dir + utils.CleanPath(p.Types)
+		} else if p.Typings != "" {
+			return dir + utils.CleanPath(p.Typings)
+		}
+	}
+	return specifier
+}
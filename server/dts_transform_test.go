@@ -0,0 +1,58 @@
+package server
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestResolveDtsSpecifier(t *testing.T) {
+	dir, err := os.MkdirTemp("", "esm-dts-specifier-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"utils.d.ts":          `export {}`,
+		"esm.d.mts":           `export {}`,
+		"cjs.d.cts":           `export {}`,
+		"lib/index.d.ts":      `export {}`,
+		"sub/package.json":    `{ "types": "./types.d.ts" }`,
+		"sub/types.d.ts":      `export {}`,
+		"both.d.ts":           `export {}`,
+		"both/index.d.ts":     `export {}`,
+		"already.d.ts":        `export {}`,
+		"explicit/index.d.ts": `export {}`,
+	}
+	for name, content := range files {
+		ensureDir(path.Dir(path.Join(dir, name)))
+		err = os.WriteFile(path.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for specifier, want := range map[string]string{
+		"./utils":            "./utils.d.ts",
+		"./utils.js":         "./utils.d.ts",
+		"./utils.ts":         "./utils.d.ts",
+		"./esm.mjs":          "./esm.d.mts",
+		"./cjs.cjs":          "./cjs.d.cts",
+		"./cjs.cts":          "./cjs.d.cts",
+		"./lib":              "./lib/index.d.ts",
+		"./lib/":             "./lib/index.d.ts",
+		"./lib/index.js":     "./lib/index.d.ts",
+		"./sub":              "./sub/types.d.ts",
+		"./both":             "./both.d.ts",
+		"./already.d.ts":     "./already.d.ts",
+		"./explicit/index":   "./explicit/index.d.ts",
+		"./missing.js":       "./missing.js",
+		"../parent/utils.js": "../parent/utils.js",
+	} {
+		resolved := resolveDtsSpecifier(dir, specifier)
+		if resolved != want {
+			t.Fatalf("resolveDtsSpecifier(%q) = %q, want %q", specifier, resolved, want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ije/gox/valid"
+	"github.com/ije/rex"
+)
+
+// githubBuildLimiter limits the number of the commits of a repository to build per hour,
+// a commit counts once in the window no matter how many modules of it are built.
+type githubBuildLimiter struct {
+	lock      sync.Mutex
+	repos     map[string]map[string]time.Time // repo -> commit -> the time of the first build
+	lastPrune time.Time
+}
+
+var ghBuildLimiter = &githubBuildLimiter{repos: map[string]map[string]time.Time{}}
+
+// allow checks whether the commit of the repository can be built, the `retryAfter` is the
+// duration until the oldest commit in the window expires if it's not allowed.
+func (l *githubBuildLimiter) allow(repo string, commit string, limit int, now time.Time) (ok bool, retryAfter time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// github repository names are case-insensitive
+	repo = strings.ToLower(repo)
+	if now.Sub(l.lastPrune) >= time.Minute {
+		l.prune(now)
+	}
+
+	commits := l.repos[repo]
+	var oldest time.Time
+	for c, t := range commits {
+		if now.Sub(t) >= time.Hour {
+			delete(commits, c)
+		} else if oldest.IsZero() || t.Before(oldest) {
+			oldest = t
+		}
+	}
+	if _, ok := commits[commit]; ok {
+		return true, 0
+	}
+	if len(commits) >= limit {
+		return false, oldest.Add(time.Hour).Sub(now)
+	}
+	if commits == nil {
+		commits = map[string]time.Time{}
+		l.repos[repo] = commits
+	}
+	commits[commit] = now
+	return true, 0
+}
+
+// prune removes the expired commits, and the repositories that have no commits in the window.
+func (l *githubBuildLimiter) prune(now time.Time) {
+	for repo, commits := range l.repos {
+		for c, t := range commits {
+			if now.Sub(t) >= time.Hour {
+				delete(commits, c)
+			}
+		}
+		if len(commits) == 0 {
+			delete(l.repos, repo)
+		}
+	}
+	l.lastPrune = now
+}
+
+// allowGithubBuild checks whether the github build task is allowed by the `github.maxCommitsPerHour` limit,
+// the high priority task and the task that is already in the queue are always allowed.
+func allowGithubBuild(task *BuildTask) (ok bool, retryAfter time.Duration) {
+	if !task.Pkg.FromGithub || cfg.Github.MaxCommitsPerHour == 0 || task.priority > 0 || buildQueue.Has(task.ID()) {
+		return true, 0
+	}
+	return ghBuildLimiter.allow(task.Pkg.Name, task.Pkg.Version, int(cfg.Github.MaxCommitsPerHour), time.Now())
+}
+
+// githubBuildLimitError returns a `429` response with the `Retry-After` header.
+func githubBuildLimitError(ctx *rex.Context, repo string, retryAfter time.Duration) interface{} {
+	ctx.SetHeader("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
+	return rex.Status(http.StatusTooManyRequests, fmt.Sprintf("too many builds of github.com/%s, the limit is %d commits per hour", repo, cfg.Github.MaxCommitsPerHour))
+}
+
+// isPinnedGithubPath checks whether the `/gh/` pathname is pinned to a commit sha.
+func isPinnedGithubPath(pathname string, pkg Pkg) bool {
+	return len(pkg.Version) >= 10 && valid.IsHexString(pkg.Version) && strings.HasPrefix(pathname, fmt.Sprintf("/gh/%s@%s", pkg.Name, pkg.Version))
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGithubBuildLimiter(t *testing.T) {
+	l := &githubBuildLimiter{repos: map[string]map[string]time.Time{}}
+	now := time.Now()
+
+	for i, commit := range []string{"0123456789", "1123456789"} {
+		if ok, _ := l.allow("esm-dev/esm.sh", commit, 2, now.Add(time.Duration(i)*time.Minute)); !ok {
+			t.Fatalf("the commit %s should be allowed", commit)
+		}
+	}
+	// the built commit counts once
+	if ok, _ := l.allow("esm-dev/esm.sh", "0123456789", 2, now.Add(2*time.Minute)); !ok {
+		t.Fatal("the built commit should be allowed")
+	}
+	ok, retryAfter := l.allow("esm-dev/esm.sh", "2123456789", 2, now.Add(10*time.Minute))
+	if ok {
+		t.Fatal("the third commit should be limited")
+	}
+	if retryAfter != 50*time.Minute {
+		t.Fatalf("expected retry after 50m, got %v", retryAfter)
+	}
+	// the repository names are case-insensitive
+	if ok, _ := l.allow("ESM-dev/ESM.sh", "2123456789", 2, now.Add(10*time.Minute)); ok {
+		t.Fatal("the third commit should be limited in any case of the repository name")
+	}
+	// other repos are not limited
+	if ok, _ := l.allow("esm-dev/tsx", "2123456789", 2, now.Add(10*time.Minute)); !ok {
+		t.Fatal("the commit of other repo should be allowed")
+	}
+	// the oldest commit expires after an hour
+	if ok, _ := l.allow("esm-dev/esm.sh", "2123456789", 2, now.Add(time.Hour)); !ok {
+		t.Fatal("the commit should be allowed after the oldest commit expires")
+	}
+	// the repositories that have no commits in the window are pruned
+	l.allow("esm-dev/esm.sh", "2123456789", 2, now.Add(3*time.Hour))
+	if _, ok := l.repos["esm-dev/tsx"]; ok || len(l.repos) != 1 {
+		t.Fatalf("the expired repositories should be pruned: %v", l.repos)
+	}
+}
+
+func TestIsPinnedGithubPath(t *testing.T) {
+	tests := []struct {
+		pathname string
+		pkg      Pkg
+		pinned   bool
+	}{
+		{"/gh/esm-dev/esm.sh@0123456789/server", Pkg{Name: "esm-dev/esm.sh", Version: "0123456789"}, true},
+		{"/gh/esm-dev/esm.sh@0123456789abcdef", Pkg{Name: "esm-dev/esm.sh", Version: "0123456789abcdef"}, true},
+		{"/gh/esm-dev/esm.sh", Pkg{Name: "esm-dev/esm.sh", Version: "0123456789"}, false},
+		{"/gh/esm-dev/esm.sh@main", Pkg{Name: "esm-dev/esm.sh", Version: "0123456789"}, false},
+		{"/gh/esm-dev/esm.sh@v1.0.0", Pkg{Name: "esm-dev/esm.sh", Version: "v1.0.0"}, false},
+		{"/gh/esm-dev/esm.sh@abc", Pkg{Name: "esm-dev/esm.sh", Version: "abc"}, false},
+	}
+	for _, test := range tests {
+		if isPinnedGithubPath(test.pathname, test.pkg) != test.pinned {
+			t.Fatalf("isPinnedGithubPath(%q): expected %v", test.pathname, test.pinned)
+		}
+	}
+}
@@ -4,15 +4,18 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"os/exec"
 	"path"
 	"strings"
 	"time"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/esm-dev/esm.sh/server/storage"
 	"github.com/ije/gox/utils"
 )
@@ -41,7 +44,8 @@ func listRepoRefs(repo string) (refs []GitRef, err error) {
 		}
 	}
 
-	cmd := exec.Command("git", "ls-remote", repo)
+	cmd := exec.Command("git", append(getGitDNSArgs(), "ls-remote", repo)...)
+	cmd.Env = append(os.Environ(), getProxyEnv(repo)...)
 	out := bytes.NewBuffer(nil)
 	errOut := bytes.NewBuffer(nil)
 	cmd.Stdout = out
@@ -66,14 +70,87 @@ func listRepoRefs(repo string) (refs []GitRef, err error) {
 	}
 
 	if cache != nil {
-		cache.Set(cacheKey, utils.MustEncodeJSON(refs), 10*time.Minute)
+		cache.Set(cacheKey, utils.MustEncodeJSON(refs), time.Duration(cfg.CacheTTL.GithubRef)*time.Second)
 	}
 	return
 }
 
-func ghInstall(wd, name, hash string) (err error) {
+// resolveGithubSemver resolves the semver range(e.g. `^1.2.0` of `/gh/owner/repo@semver:^1.2.0`) to the
+// commit sha of the highest matched tag of the repo, the resolution is cached for `cacheTTL.githubRef` seconds.
+func resolveGithubSemver(repo string, versionRange string) (sha string, err error) {
+	c, err := semver.NewConstraint(versionRange)
+	if err != nil {
+		return "", fmt.Errorf("invalid semver range '%s'", versionRange)
+	}
+
+	cacheKey := fmt.Sprintf("gh-semver:%s@%s", repo, versionRange)
+	if cache != nil {
+		data, err := cache.Get(cacheKey)
+		if err == nil {
+			return string(data), nil
+		}
+		if err != storage.ErrNotFound && err != storage.ErrExpired {
+			log.Error("cache:", err)
+		}
+	}
+
+	refs, err := listRepoRefs(fmt.Sprintf("https://github.com/%s", repo))
+	if err != nil {
+		return
+	}
+	ref, ok := matchSemverTag(refs, c)
+	if !ok {
+		return "", fmt.Errorf("tag of semver range '%s' not found", versionRange)
+	}
+	debugf("resolver", repo, "resolve semver:%s to %s(%s)", versionRange, ref.Ref, ref.Sha)
+
+	if cache != nil {
+		cache.Set(cacheKey, []byte(ref.Sha), time.Duration(cfg.CacheTTL.GithubRef)*time.Second)
+	}
+	return ref.Sha, nil
+}
+
+// matchSemverTag returns the highest tag(e.g. `v1.2.0`, `1.2.0`) that matches the semver constraints,
+// the prereleases are matched only if the constraints have a prerelease version, like npm does.
+// The sha of the commit is used for the annotated tags instead of the sha of the tag object.
+func matchSemverTag(refs []GitRef, c *semver.Constraints) (ref GitRef, ok bool) {
+	var best *semver.Version
+	shas := map[string]string{}
+	for _, r := range refs {
+		if !strings.HasPrefix(r.Ref, "refs/tags/") {
+			continue
+		}
+		tag := strings.TrimPrefix(r.Ref, "refs/tags/")
+		if strings.HasSuffix(tag, "^{}") {
+			// the peeled ref of the annotated tag
+			shas[strings.TrimSuffix(tag, "^{}")] = r.Sha
+			continue
+		}
+		v, err := semver.NewVersion(tag)
+		if err != nil || !c.Check(v) {
+			continue
+		}
+		if best == nil || v.GreaterThan(best) {
+			best = v
+			ref = r
+			ok = true
+		}
+	}
+	if ok {
+		if sha, has := shas[strings.TrimPrefix(ref.Ref, "refs/tags/")]; has {
+			ref.Sha = sha
+		}
+	}
+	return
+}
+
+func ghInstall(ctx context.Context, wd, name, hash string) (err error) {
 	url := fmt.Sprintf(`https://codeload.github.com/%s/tar.gz/%s`, name, hash)
-	res, err := fetch(url)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return
+	}
+	res, err := httpClient.Do(req)
 	if err != nil {
 		return
 	}
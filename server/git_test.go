@@ -1,14 +1,17 @@
 package server
 
 import (
+	"context"
 	"os"
 	"path"
 	"testing"
+
+	"github.com/Masterminds/semver/v3"
 )
 
 func TestGhInstall(t *testing.T) {
 	dir := os.TempDir()
-	err := ghInstall(dir, "esm-dev/esm.sh", "main")
+	err := ghInstall(context.Background(), dir, "esm-dev/esm.sh", "main")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -24,3 +27,33 @@ func TestListRepoRefs(t *testing.T) {
 	}
 	t.Log(refs)
 }
+
+func TestMatchSemverTag(t *testing.T) {
+	refs := []GitRef{
+		{Ref: "HEAD", Sha: "0000000000000000000000000000000000000000"},
+		{Ref: "refs/heads/main", Sha: "0000000000000000000000000000000000000000"},
+		{Ref: "refs/tags/v1.2.0", Sha: "1200000000000000000000000000000000000000"},
+		{Ref: "refs/tags/v1.3.0", Sha: "1300000000000000000000000000000000000000"},
+		{Ref: "refs/tags/v1.3.0^{}", Sha: "13c0000000000000000000000000000000000000"},
+		{Ref: "refs/tags/1.4.0-beta.1", Sha: "14b1000000000000000000000000000000000000"},
+		{Ref: "refs/tags/v2.0.0", Sha: "2000000000000000000000000000000000000000"},
+		{Ref: "refs/tags/nightly", Sha: "ffff000000000000000000000000000000000000"},
+	}
+	for versionRange, want := range map[string]string{
+		"^1.2.0":        "13c0000000000000000000000000000000000000",
+		"~1.2.0":        "1200000000000000000000000000000000000000",
+		">=1.4.0-beta":  "2000000000000000000000000000000000000000",
+		"~1.4.0-beta.0": "14b1000000000000000000000000000000000000",
+		"*":             "2000000000000000000000000000000000000000",
+		"^3.0.0":        "",
+	} {
+		c, err := semver.NewConstraint(versionRange)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ref, ok := matchSemverTag(refs, c)
+		if ok != (want != "") || ref.Sha != want {
+			t.Fatalf("matchSemverTag(%q) = %v, want %q", versionRange, ref, want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// the max number of packages of a generated import map, every package costs a registry lookup
+const (
+	maxImportMapPackages         = 100
+	maxPriorityImportMapPackages = 500 // for the requests with a priority token
+)
+
+// ImportMapInput is the request of the `POST /v{N}/import-map` endpoint.
+type ImportMapInput struct {
+	// the packages to import, e.g. ["react@18.2.0", "react-dom@18"]
+	Packages []string `json:"packages"`
+	Target   string   `json:"target,omitempty"`
+	Dev      bool     `json:"dev,omitempty"`
+}
+
+// ImportMap is the import map that maps the bare specifiers to the module urls.
+// ref https://github.com/WICG/import-maps
+type ImportMap struct {
+	Imports map[string]string `json:"imports"`
+}
+
+// generateImportMap resolves the dependency graph of the packages and returns the import map of
+// all the packages in the graph. The dependencies(and the peer dependencies that are in the graph)
+// are marked as external if the versions are compatible, so every package is imported once.
+func generateImportMap(origin string, input ImportMapInput, maxPackages int) (im *ImportMap, err error) {
+	if len(input.Packages) == 0 {
+		return nil, errors.New("packages is required")
+	}
+	if input.Target != "" {
+		if _, ok := targets[input.Target]; !ok {
+			return nil, fmt.Errorf("invalid target '%s'", input.Target)
+		}
+	}
+
+	queue := make([]Pkg, 0, len(input.Packages))
+	for _, spec := range input.Packages {
+		pkg, _, e := validatePkgPath("/" + strings.TrimPrefix(strings.TrimPrefix(spec, "npm:"), "/"))
+		if e != nil {
+			return nil, fmt.Errorf("invalid package '%s': %v", spec, e)
+		}
+		if pkg.FromGithub || pkg.FromEsmsh {
+			return nil, fmt.Errorf("unsupported package '%s'", spec)
+		}
+		queue = append(queue, pkg)
+	}
+
+	// the first resolved version of a package is used, the packages of the input come first
+	resolved := map[string]NpmPackage{}
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		if _, ok := resolved[pkg.Name]; ok {
+			continue
+		}
+		if len(resolved) >= maxPackages {
+			return nil, fmt.Errorf("too many packages(>%d)", maxPackages)
+		}
+		info, _, e := getPackageInfo("", pkg.Name, pkg.Version)
+		if e != nil {
+			return nil, e
+		}
+		resolved[pkg.Name] = info
+		names := make([]string, 0, len(info.Dependencies))
+		for name := range info.Dependencies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			version := info.Dependencies[name]
+			// the aliased, git or local dependencies are resolved by the build
+			if _, ok := resolved[name]; ok || strings.Contains(version, ":") || strings.Contains(version, "/") {
+				continue
+			}
+			queue = append(queue, Pkg{Name: name, Version: version})
+		}
+	}
+	return newImportMap(origin, resolved, input.Target, input.Dev), nil
+}
+
+// newImportMap returns the import map of the resolved packages.
+func newImportMap(origin string, resolved map[string]NpmPackage, target string, dev bool) *ImportMap {
+	im := &ImportMap{Imports: map[string]string{}}
+	for name, info := range resolved {
+		external := []string{}
+		for _, deps := range []map[string]string{info.Dependencies, info.PeerDependencies} {
+			for dep, version := range deps {
+				if p, ok := resolved[dep]; ok && isVersionSatisfied(p.Version, version) && !includes(external, dep) {
+					external = append(external, dep)
+				}
+			}
+		}
+		sort.Strings(external)
+		query := []string{}
+		if len(external) > 0 {
+			query = append(query, "external="+strings.Join(external, ","))
+		}
+		if target != "" {
+			query = append(query, "target="+target)
+		}
+		if dev {
+			query = append(query, "dev")
+		}
+		url := fmt.Sprintf("%s%s/%s@%s", origin, cfg.BasePath, name, info.Version)
+		if len(query) > 0 {
+			url += "&" + strings.Join(query, "&")
+		}
+		im.Imports[name] = url
+		im.Imports[name+"/"] = url + "/"
+	}
+	return im
+}
+
+// isVersionSatisfied checks whether the version satisfies the semver range of the dependency.
+func isVersionSatisfied(version string, versionRange string) bool {
+	if versionRange == "" || versionRange == "latest" {
+		return true
+	}
+	c, err := semver.NewConstraint(versionRange)
+	if err != nil {
+		return false
+	}
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return false
+	}
+	return c.Check(v)
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/esm-dev/esm.sh/server/config"
+)
+
+func TestImportMap(t *testing.T) {
+	withConfig(t, &config.Config{})
+
+	resolved := map[string]NpmPackage{
+		"react":     {Name: "react", Version: "18.2.0", Dependencies: map[string]string{"loose-envify": "^1.1.0"}},
+		"react-dom": {Name: "react-dom", Version: "18.2.0", Dependencies: map[string]string{"loose-envify": "^1.1.0", "scheduler": "^0.23.0"}, PeerDependencies: map[string]string{"react": "^18.2.0"}},
+		"scheduler": {Name: "scheduler", Version: "0.22.0", Dependencies: map[string]string{"loose-envify": "^1.1.0"}},
+		// the dependency `js-tokens` is not in the graph, it is resolved by the build
+		"loose-envify": {Name: "loose-envify", Version: "1.4.0", Dependencies: map[string]string{"js-tokens": "^3.0.0 || ^4.0.0"}},
+	}
+	im := newImportMap("https://esm.sh", resolved, "es2022", true)
+	expected := map[string]string{
+		"react":         "https://esm.sh/react@18.2.0&external=loose-envify&target=es2022&dev",
+		"react/":        "https://esm.sh/react@18.2.0&external=loose-envify&target=es2022&dev/",
+		"react-dom":     "https://esm.sh/react-dom@18.2.0&external=loose-envify,react&target=es2022&dev",
+		"react-dom/":    "https://esm.sh/react-dom@18.2.0&external=loose-envify,react&target=es2022&dev/",
+		"scheduler":     "https://esm.sh/scheduler@0.22.0&external=loose-envify&target=es2022&dev",
+		"scheduler/":    "https://esm.sh/scheduler@0.22.0&external=loose-envify&target=es2022&dev/",
+		"loose-envify":  "https://esm.sh/loose-envify@1.4.0&target=es2022&dev",
+		"loose-envify/": "https://esm.sh/loose-envify@1.4.0&target=es2022&dev/",
+	}
+	if len(im.Imports) != len(expected) {
+		t.Fatalf("invalid imports: %v", im.Imports)
+	}
+	for specifier, url := range expected {
+		if im.Imports[specifier] != url {
+			t.Fatalf("invalid url of %s: %s, should be %s", specifier, im.Imports[specifier], url)
+		}
+	}
+
+	for _, test := range []struct {
+		version      string
+		versionRange string
+		ok           bool
+	}{
+		{"18.2.0", "^18.0.0", true},
+		{"4.0.0", "^3.0.0 || ^4.0.0", true},
+		{"0.22.0", "^0.23.0", false},
+		{"1.0.0", "latest", true},
+		{"1.0.0", "npm:foo@^1.0.0", false},
+	} {
+		if isVersionSatisfied(test.version, test.versionRange) != test.ok {
+			t.Fatalf("isVersionSatisfied(%q, %q) should be %v", test.version, test.versionRange, test.ok)
+		}
+	}
+}
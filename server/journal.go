@@ -0,0 +1,302 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/ije/gox/utils"
+)
+
+// BuildJournal is an append-only log of build jobs, it can be replayed to rebuild
+// the hot set after storage loss.
+type BuildJournal struct {
+	lock     sync.Mutex
+	file     *os.File
+	filename string
+	size     int64
+	limit    int64
+}
+
+type JournalRecord struct {
+	Time         int64  `json:"time"`
+	ID           string `json:"id"`
+	Pkg          Pkg    `json:"pkg"`
+	Args         string `json:"args,omitempty"`
+	CdnOrigin    string `json:"origin"`
+	Target       string `json:"target"`
+	BuildVersion int    `json:"bv"`
+	Dev          bool   `json:"dev,omitempty"`
+	Bundle       bool   `json:"bundle,omitempty"`
+	Error        string `json:"error,omitempty"`
+	Duration     int64  `json:"duration"`
+	RequestID    string `json:"requestId,omitempty"`
+}
+
+// the db key of the recent build failures reported by the `/_admin/failures` endpoint
+const recentFailuresDBKey = "_failures"
+
+// the journal is compacted when it grows larger than the size
+const maxJournalSize = 64 * 1024 * 1024
+
+// the max number of the recent build failures to keep
+const maxRecentFailures = 20
+
+var recentFailuresLock sync.Mutex
+
+func openBuildJournal(filename string) (*BuildJournal, error) {
+	err := ensureDir(path.Dir(filename))
+	if err != nil {
+		return nil, err
+	}
+	j := &BuildJournal{filename: filename}
+	if fi, err := os.Stat(filename); err == nil && fi.Size() > maxJournalSize {
+		err = compactBuildJournal(filename)
+		if err != nil {
+			log.Errorf("compact journal: %v", err)
+		}
+	}
+	err = j.open()
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *BuildJournal) open() error {
+	file, err := os.OpenFile(j.filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	j.file = file
+	j.size = fi.Size()
+	// compact again when the journal doubles, to avoid compacting on every write if most
+	// of the records are alive
+	j.limit = 2 * j.size
+	if j.limit < maxJournalSize {
+		j.limit = maxJournalSize
+	}
+	return nil
+}
+
+// Write appends a build job with its outcome to the journal.
+func (j *BuildJournal) Write(task *BuildTask, output BuildOutput, duration time.Duration) {
+	if j == nil {
+		return
+	}
+	record := newJournalRecord(task)
+	record.Duration = duration.Milliseconds()
+	if output.err != nil {
+		record.Error = output.err.Error()
+	}
+	line := append(utils.MustEncodeJSON(record), '\n')
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	n, err := j.file.Write(line)
+	if err != nil {
+		log.Errorf("journal: %v", err)
+	}
+	j.size += int64(n)
+	if j.size > j.limit {
+		j.file.Close()
+		err = compactBuildJournal(j.filename)
+		if err != nil {
+			log.Errorf("compact journal: %v", err)
+		}
+		err = j.open()
+		if err != nil {
+			log.Errorf("journal: %v", err)
+		}
+	}
+}
+
+func (j *BuildJournal) Close() error {
+	if j == nil {
+		return nil
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.file.Close()
+}
+
+func newJournalRecord(task *BuildTask) JournalRecord {
+	return JournalRecord{
+		Time:         time.Now().Unix(),
+		ID:           task.ID(),
+		Pkg:          task.Pkg,
+		Args:         task.encodeBuildArgsPrefix(),
+		CdnOrigin:    task.CdnOrigin,
+		Target:       task.Target,
+		BuildVersion: task.BuildVersion,
+		Dev:          task.Dev,
+		Bundle:       task.Bundle,
+		RequestID:    task.requestID,
+	}
+}
+
+// recordRecentFailure saves the failed build to the recent failures in the db, the oldest
+// failure is dropped if there are more than `maxRecentFailures`.
+func recordRecentFailure(task *BuildTask, output BuildOutput, duration time.Duration) {
+	if db == nil {
+		return
+	}
+	record := newJournalRecord(task)
+	record.Duration = duration.Milliseconds()
+	record.Error = output.err.Error()
+
+	recentFailuresLock.Lock()
+	defer recentFailuresLock.Unlock()
+	failures := append([]JournalRecord{record}, getRecentFailures()...)
+	if len(failures) > maxRecentFailures {
+		failures = failures[:maxRecentFailures]
+	}
+	err := db.Put(recentFailuresDBKey, utils.MustEncodeJSON(failures))
+	if err != nil {
+		log.Errorf("db: %v", err)
+	}
+}
+
+// getRecentFailures returns the recent build failures, the latest first.
+func getRecentFailures() []JournalRecord {
+	failures := []JournalRecord{}
+	if db == nil {
+		return failures
+	}
+	data, err := db.Get(recentFailuresDBKey)
+	if err == nil && data != nil {
+		json.Unmarshal(data, &failures)
+	}
+	return failures
+}
+
+// toBuildTask restores the build task of the record.
+func (record *JournalRecord) toBuildTask() (*BuildTask, error) {
+	args, err := decodeBuildArgsPrefix(record.Args)
+	if err != nil {
+		return nil, err
+	}
+	if args.alias == nil {
+		args.alias = map[string]string{}
+	}
+	if args.deps == nil {
+		args.deps = PkgSlice{}
+	}
+	return &BuildTask{
+		BuildArgs:    args,
+		Pkg:          record.Pkg,
+		CdnOrigin:    record.CdnOrigin,
+		Target:       record.Target,
+		BuildVersion: record.BuildVersion,
+		Dev:          record.Dev,
+		Bundle:       record.Bundle,
+	}, nil
+}
+
+// readBuildJournal reads the last outcome of each build in the journal, the ids are
+// in the order of the first occurrence.
+func readBuildJournal(file *os.File) (ids []string, records map[string]JournalRecord, err error) {
+	records = map[string]JournalRecord{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record JournalRecord
+		if json.Unmarshal(scanner.Bytes(), &record) != nil || record.ID == "" {
+			continue
+		}
+		if _, ok := records[record.ID]; !ok {
+			ids = append(ids, record.ID)
+		}
+		records[record.ID] = record
+	}
+	err = scanner.Err()
+	return
+}
+
+// compactBuildJournal rewrites the journal with the last successful outcome of each build,
+// the builds that failed last are dropped since they are never replayed.
+func compactBuildJournal(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	ids, records, err := readBuildJournal(file)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(path.Dir(filename), path.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	tmp.Chmod(0644)
+	w := bufio.NewWriter(tmp)
+	for _, id := range ids {
+		record := records[id]
+		if record.Error != "" {
+			continue
+		}
+		w.Write(utils.MustEncodeJSON(record))
+		w.WriteByte('\n')
+	}
+	err = w.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// replayBuildJournal reads the journal and rebuilds the modules that were built successfully
+// but are missing in the storage.
+func replayBuildJournal(filename string) {
+	file, err := os.Open(filename)
+	if err != nil {
+		log.Errorf("replay journal: %v", err)
+		return
+	}
+	defer file.Close()
+
+	ids, records, err := readBuildJournal(file)
+	if err != nil {
+		log.Errorf("replay journal: %v", err)
+	}
+
+	n := 0
+	for _, id := range ids {
+		record := records[id]
+		if record.Error != "" || record.Target == "raw" {
+			continue
+		}
+		if record.Target != "types" {
+			if _, ok := queryESMBuild(id); ok {
+				continue
+			}
+		}
+		task, err := record.toBuildTask()
+		if err != nil {
+			log.Warnf("replay journal: %s: %v", id, err)
+			continue
+		}
+		buildQueue.Add(task, "")
+		n++
+	}
+	log.Infof("replay journal: %d builds queued", n)
+}
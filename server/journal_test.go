@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/esm-dev/esm.sh/server/storage"
+)
+
+func TestJournalRecord(t *testing.T) {
+	external := newStringSet()
+	external.Add("react")
+	conditions := newStringSet()
+	conditions.Add("react-server")
+	task := &BuildTask{
+		BuildArgs: BuildArgs{
+			alias:       map[string]string{"a": "b"},
+			deps:        PkgSlice{},
+			external:    external,
+			treeShaking: newStringSet(),
+			conditions:  conditions,
+			keepNames:   true,
+		},
+		Pkg:          Pkg{Name: "foo", Version: "1.0.0", Subpath: "bar", Submodule: "bar"},
+		CdnOrigin:    "https://esm.sh",
+		Target:       "es2022",
+		BuildVersion: VERSION,
+		Dev:          true,
+	}
+
+	data, err := json.Marshal(newJournalRecord(task))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record JournalRecord
+	err = json.Unmarshal(data, &record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := record.toBuildTask()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.ID() != task.ID() {
+		t.Fatalf("invalid task id '%s', should be '%s'", restored.ID(), task.ID())
+	}
+	if restored.CdnOrigin != task.CdnOrigin || !restored.Dev || restored.Bundle {
+		t.Fatal("invalid task options")
+	}
+}
+
+func TestRecentFailures(t *testing.T) {
+	dir, err := os.MkdirTemp("", "esm-failures-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err = storage.OpenDB("bolt:" + path.Join(dir, "esm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		db.Close()
+		db = nil
+	}()
+
+	if failures := getRecentFailures(); len(failures) != 0 {
+		t.Fatalf("invalid failures: %v", failures)
+	}
+	for i := 0; i < maxRecentFailures+5; i++ {
+		task := newTestBuildTask(Pkg{Name: "foo", Version: fmt.Sprintf("1.0.%d", i)}, "es2022")
+		recordRecentFailure(task, BuildOutput{err: errors.New("oops")}, time.Second)
+	}
+	failures := getRecentFailures()
+	if len(failures) != maxRecentFailures {
+		t.Fatalf("should keep %d failures, got %d", maxRecentFailures, len(failures))
+	}
+	if failures[0].Pkg.Version != fmt.Sprintf("1.0.%d", maxRecentFailures+4) || failures[0].Error != "oops" || failures[0].Duration != 1000 {
+		t.Fatalf("invalid latest failure: %+v", failures[0])
+	}
+}
+
+func TestCompactBuildJournal(t *testing.T) {
+	dir, err := os.MkdirTemp("", "esm-journal-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := path.Join(dir, "journal.jsonl")
+	journal, err := openBuildJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newTask := func(version string) *BuildTask {
+		return newTestBuildTask(Pkg{Name: "foo", Version: version}, "es2022")
+	}
+	journal.Write(newTask("1.0.0"), BuildOutput{}, time.Second)
+	journal.Write(newTask("1.0.1"), BuildOutput{}, time.Second)
+	journal.Write(newTask("1.0.0"), BuildOutput{}, 2*time.Second)
+	journal.Write(newTask("1.0.1"), BuildOutput{err: errors.New("oops")}, time.Second)
+	journal.Write(newTask("1.0.2"), BuildOutput{}, time.Second)
+	journal.Close()
+
+	err = compactBuildJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	ids, records, err := readBuildJournal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || records[ids[0]].Pkg.Version != "1.0.0" || records[ids[1]].Pkg.Version != "1.0.2" {
+		t.Fatalf("invalid compacted journal: %v", ids)
+	}
+	if records[ids[0]].Duration != 2000 {
+		t.Fatalf("should keep the last outcome of the build: %+v", records[ids[0]])
+	}
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+)
+
+// the subsystems that support targeted debug logging
+var logSubsystems = []string{"resolver", "install", "build", "dts", "storage"}
+
+// logFilter enables debug logs for some packages or subsystems without changing
+// the log level of the server.
+var logFilter = struct {
+	sync.RWMutex
+	level      string
+	packages   map[string]bool
+	subsystems map[string]bool
+}{
+	packages:   map[string]bool{},
+	subsystems: map[string]bool{},
+}
+
+// setLogLevel changes the log level at runtime.
+func setLogLevel(level string) {
+	logFilter.Lock()
+	defer logFilter.Unlock()
+
+	logFilter.level = level
+	log.SetLevelByName(level)
+}
+
+// setLogFilter sets the packages and subsystems to enable debug logs.
+func setLogFilter(packages []string, subsystems []string) {
+	logFilter.Lock()
+	defer logFilter.Unlock()
+
+	logFilter.packages = map[string]bool{}
+	for _, name := range packages {
+		logFilter.packages[name] = true
+	}
+	logFilter.subsystems = map[string]bool{}
+	for _, name := range subsystems {
+		logFilter.subsystems[name] = true
+	}
+}
+
+func getLogFilter() LogFilterOptions {
+	logFilter.RLock()
+	defer logFilter.RUnlock()
+
+	packages := make([]string, 0, len(logFilter.packages))
+	for name := range logFilter.packages {
+		packages = append(packages, name)
+	}
+	subsystems := make([]string, 0, len(logFilter.subsystems))
+	for name := range logFilter.subsystems {
+		subsystems = append(subsystems, name)
+	}
+	return LogFilterOptions{
+		Level:      logFilter.level,
+		Packages:   packages,
+		Subsystems: subsystems,
+	}
+}
+
+// debugf logs a debug message of the subsystem for the package, the message is logged with
+// the info level if the package or the subsystem is enabled by the log filter.
+func debugf(subsystem string, pkgName string, format string, v ...interface{}) {
+	logFilter.RLock()
+	isDebug := logFilter.level == "debug"
+	matched := logFilter.subsystems[subsystem] || (pkgName != "" && logFilter.packages[pkgName])
+	logFilter.RUnlock()
+
+	if isDebug {
+		log.Debugf("[%s] %s", subsystem, fmt.Sprintf(format, v...))
+	} else if matched {
+		log.Infof("[debug:%s] %s", subsystem, fmt.Sprintf(format, v...))
+	}
+}
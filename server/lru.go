@@ -0,0 +1,85 @@
+package server
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lruCache is an in-process LRU cache that is bounded by the total size of the values,
+// the least recently used values are evicted when the size exceeds the limit.
+type lruCache struct {
+	lock     sync.Mutex
+	maxBytes int
+	size     int
+	list     *list.List
+	items    map[string]*list.Element
+}
+
+type lruEntry struct {
+	key   string
+	value []byte
+}
+
+func newLRUCache(maxBytes int) *lruCache {
+	return &lruCache{
+		maxBytes: maxBytes,
+		list:     list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+// Get returns the value of the key and marks it as recently used.
+func (c *lruCache) Get(key string) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.list.MoveToFront(el)
+	return el.Value.(*lruEntry).value, true
+}
+
+// Set sets the value of the key, the value that is larger than the limit is not cached.
+func (c *lruCache) Set(key string, value []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	if len(key)+len(value) > c.maxBytes {
+		return
+	}
+	c.items[key] = c.list.PushFront(&lruEntry{key, value})
+	c.size += len(key) + len(value)
+	for c.size > c.maxBytes {
+		c.remove(c.list.Back())
+	}
+}
+
+// Delete removes the key from the cache.
+func (c *lruCache) Delete(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+}
+
+// Len returns the number of the cached values.
+func (c *lruCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.list.Len()
+}
+
+func (c *lruCache) remove(el *list.Element) {
+	entry := el.Value.(*lruEntry)
+	c.list.Remove(el)
+	delete(c.items, entry.key)
+	c.size -= len(entry.key) + len(entry.value)
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache(8)
+	c.Set("a", []byte("1"))
+	c.Set("b", []byte("2"))
+	c.Set("c", []byte("3"))
+	c.Set("d", []byte("4"))
+	if c.Len() != 4 {
+		t.Fatalf("expected 4 values, got %d", c.Len())
+	}
+	// "a" is recently used, "b" is evicted
+	c.Get("a")
+	c.Set("e", []byte("5"))
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("the least recently used value should be evicted")
+	}
+	if v, ok := c.Get("a"); !ok || string(v) != "1" {
+		t.Fatal("the recently used value should be kept")
+	}
+	c.Set("f", []byte("too large to cache"))
+	if _, ok := c.Get("f"); ok || c.Len() != 4 {
+		t.Fatal("the value larger than the limit should not be cached")
+	}
+	c.Delete("a")
+	if _, ok := c.Get("a"); ok || c.Len() != 3 {
+		t.Fatal("the value should be deleted")
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/esm-dev/esm.sh/server/storage"
+	"github.com/ije/gox/utils"
+)
+
+// the db key that stores the schema version of the database
+const schemaVersionDBKey = "_schemaVersion"
+
+// A dbMigration upgrades the database records to the schema `version`.
+type dbMigration struct {
+	version int
+	name    string
+	migrate func(db storage.DataBase) error
+}
+
+// dbMigrations are applied in order on boot, new migrations must be appended with
+// an increasing version, and never be changed once released.
+var dbMigrations = []dbMigration{
+	{
+		version: 1,
+		name:    "baseline",
+		migrate: func(db storage.DataBase) error {
+			// the layout before the schema version was introduced
+			return nil
+		},
+	},
+	{
+		version: 2,
+		name:    "esm build options",
+		migrate: backfillBuildOptions,
+	},
+}
+
+// backfillBuildOptions records the build options parsed from the build id in the
+// existing esm build records.
+func backfillBuildOptions(db storage.DataBase) error {
+	keys, err := db.Keys("")
+	if err != nil {
+		return err
+	}
+	n := 0
+	for _, key := range keys {
+		target, dev, args, ok := parseBuildID(key)
+		if !ok {
+			continue
+		}
+		value, err := db.Get(key)
+		if err != nil {
+			return err
+		}
+		var esm ESMBuild
+		if value == nil || json.Unmarshal(value, &esm) != nil {
+			continue
+		}
+		esm.setBuildOptions(target, dev, args)
+		err = db.Put(key, utils.MustEncodeJSON(esm))
+		if err != nil {
+			return err
+		}
+		n++
+	}
+	log.Infof("migrate database: %d esm build records updated", n)
+	return nil
+}
+
+// parseBuildID parses the build options from the build id, e.g.
+// `v118/react-dom@18.2.0/X-ZC9yZWFjdEAxOC4yLjA/es2022/client.development.js`
+func parseBuildID(id string) (target string, dev bool, args BuildArgs, ok bool) {
+	segments := strings.Split(id, "/")
+	if len(segments) < 4 || !(segments[0] == "stable" || regexpBuildVersionPath.MatchString("/"+segments[0]+"/")) {
+		return
+	}
+	segments = segments[1:]
+	if segments[0] == "gh" {
+		segments = segments[1:]
+	}
+	if strings.HasPrefix(segments[0], "@") {
+		segments = segments[1:]
+	}
+	// pkg@version/[args/]target/filename
+	if len(segments) < 3 || !strings.Contains(segments[0], "@") {
+		return
+	}
+	segments = segments[1:]
+	if strings.HasPrefix(segments[0], "X-") {
+		var err error
+		args, err = decodeBuildArgsPrefix(segments[0])
+		if err != nil {
+			return
+		}
+		segments = segments[1:]
+	}
+	if len(segments) < 2 {
+		return
+	}
+	target = segments[0]
+	dev = strings.Contains(segments[len(segments)-1], ".development.")
+	ok = true
+	return
+}
+
+func getSchemaVersion(db storage.DataBase) (int, error) {
+	data, err := db.Get(schemaVersionDBKey)
+	if err != nil {
+		return 0, err
+	}
+	if data == nil {
+		return 0, nil
+	}
+	return strconv.Atoi(string(data))
+}
+
+// migrateDB applies the migrations that are newer than the schema version of the database.
+func migrateDB(db storage.DataBase, migrations []dbMigration) error {
+	version, err := getSchemaVersion(db)
+	if err != nil {
+		return fmt.Errorf("invalid schema version: %v", err)
+	}
+	if n := len(migrations); n > 0 && version > migrations[n-1].version {
+		return fmt.Errorf("the database schema version %d is newer than the server supports(%d)", version, migrations[n-1].version)
+	}
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		log.Infof("migrate database: v%d(%s)", m.version, m.name)
+		err = m.migrate(db)
+		if err != nil {
+			return fmt.Errorf("migration v%d(%s): %v", m.version, m.name, err)
+		}
+		err = db.Put(schemaVersionDBKey, []byte(strconv.Itoa(m.version)))
+		if err != nil {
+			return err
+		}
+		version = m.version
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/esm-dev/esm.sh/server/storage"
+)
+
+func TestMigrateDB(t *testing.T) {
+	dir, err := os.MkdirTemp("", "esm-migration-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := storage.OpenDB("bolt:" + path.Join(dir, "esm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	applied := []int{}
+	migration := func(version int) dbMigration {
+		return dbMigration{version, "test", func(db storage.DataBase) error {
+			applied = append(applied, version)
+			return nil
+		}}
+	}
+
+	err = migrateDB(db, []dbMigration{migration(1), migration(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = migrateDB(db, []dbMigration{migration(1), migration(2), migration(3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 3 || applied[0] != 1 || applied[1] != 2 || applied[2] != 3 {
+		t.Fatalf("invalid applied migrations %v, should be [1 2 3]", applied)
+	}
+
+	// stop at the failed migration
+	err = migrateDB(db, []dbMigration{migration(3), {4, "failed", func(db storage.DataBase) error {
+		return errors.New("oops")
+	}}})
+	if err == nil {
+		t.Fatal("should fail")
+	}
+	version, _ := getSchemaVersion(db)
+	if version != 3 {
+		t.Fatalf("invalid schema version %d, should be 3", version)
+	}
+
+	// the database is newer than the server
+	err = migrateDB(db, []dbMigration{migration(1)})
+	if err == nil {
+		t.Fatal("should fail")
+	}
+}
+
+func TestParseBuildID(t *testing.T) {
+	args := BuildArgs{
+		alias:       map[string]string{"react": "preact/compat"},
+		deps:        PkgSlice{{Name: "preact", Version: "10.19.2"}},
+		external:    newStringSet(),
+		treeShaking: newStringSet(),
+		conditions:  newStringSet(),
+	}
+	prefix := encodeBuildArgsPrefix(args, Pkg{Name: "swr"}, false)
+	target, dev, parsed, ok := parseBuildID("v118/swr@2.2.4/" + prefix + "es2022/swr.development.mjs")
+	if !ok {
+		t.Fatal("should be a build id")
+	}
+	if target != "es2022" || !dev {
+		t.Fatalf("invalid target(%s) or dev(%v)", target, dev)
+	}
+	if len(parsed.deps) != 1 || parsed.deps[0].Name != "preact" || parsed.alias["react"] != "preact/compat" {
+		t.Fatalf("invalid build args: %v %v", parsed.deps, parsed.alias)
+	}
+
+	target, dev, _, ok = parseBuildID("stable/@vue/shared@3.3.4/deno/shared.mjs")
+	if !ok || target != "deno" || dev {
+		t.Fatalf("invalid target(%s) or dev(%v)", target, dev)
+	}
+
+	for _, key := range []string{"_webhooks", "publish-0a1b2c", "v118/react@18.2.0"} {
+		if _, _, _, ok := parseBuildID(key); ok {
+			t.Fatalf("'%s' should not be a build id", key)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// the code patterns that are disallowed by the CSP of browser extensions(manifest v3)
+// ref https://developer.chrome.com/docs/extensions/develop/migrate/improve-security
+var mv3DisallowedPatterns = []struct {
+	regexp *regexp.Regexp
+	reason string
+}{
+	{regexp.MustCompile(`(?:^|[^\w$.])eval\s*\(`), "uses `eval()`"},
+	{regexp.MustCompile(`\bnew\s+Function\s*\(`), "uses `new Function()`"},
+	{regexp.MustCompile(`\bnew\s+(?:Shared)?Worker\s*\(\s*URL\.createObjectURL\b`), "creates blob workers"},
+}
+
+// checkMV3Constraints returns the reasons why the code can't run in a browser extension(manifest v3),
+// the extension can only load the scripts that are packaged in the extension.
+func checkMV3Constraints(code []byte) (problems []string) {
+	for _, p := range mv3DisallowedPatterns {
+		if p.regexp.Match(code) {
+			problems = append(problems, p.reason)
+		}
+	}
+	for _, m := range regexpImportSpecifier.FindAllSubmatch(code, -1) {
+		specifier := string(m[1])
+		if isRemoteSpecifier(specifier) || isLocalSpecifier(specifier) {
+			problems = append(problems, fmt.Sprintf("imports '%s' that must be packaged in the extension", specifier))
+		}
+	}
+	return
+}
@@ -0,0 +1,20 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestCheckMV3Constraints(t *testing.T) {
+	problems := checkMV3Constraints([]byte(`var a=1;export{a as default};`))
+	if len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v", problems)
+	}
+	problems = checkMV3Constraints([]byte(`import"/v135/node_process.js";const f=new Function("return this");obj.eval(x);eval("1");`))
+	if len(problems) != 3 {
+		t.Fatalf("expected 3 problems, got %v", problems)
+	}
+	problems = checkMV3Constraints([]byte(`const w=new Worker(URL.createObjectURL(blob));`))
+	if len(problems) != 1 {
+		t.Fatalf("expected 1 problem, got %v", problems)
+	}
+}
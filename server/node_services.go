@@ -151,15 +151,9 @@ func startNodeServices() (err error) {
 	cmd.Dir = wd
 	cmd.Stderr = errBuf
 
-	err = cmd.Start()
-	if err != nil {
-		return
-	}
-
-	log.Debug("node services process started, pid is", cmd.Process.Pid)
-
-	// wait the process to exit
-	err = cmd.Wait()
+	// run the process in a cgroup and wait it to exit
+	log.Debug("node services process started")
+	err = runInCgroup(cmd, "ns")
 	if errBuf.Len() > 0 {
 		err = errors.New(strings.TrimSpace(errBuf.String()))
 	}
@@ -175,6 +169,10 @@ type cjsExportsResult struct {
 }
 
 func parseCJSModuleExports(buildDir string, importPath string, nodeEnv string) (ret cjsExportsResult, err error) {
+	if cfg.CjsLexer == "native" {
+		return parseCJSModuleExportsNative(buildDir, importPath, nodeEnv)
+	}
+
 	args := map[string]interface{}{
 		"buildDir":   buildDir,
 		"importPath": importPath,
@@ -1,12 +1,14 @@
 package server
 
 import (
+	"archive/zip"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
@@ -101,6 +103,10 @@ CheckNodejs:
 	if err != nil || major < nodejsMinVersion {
 		PATH := os.Getenv("PATH")
 		nodeBinDir := path.Join(installDir, "bin")
+		if runtime.GOOS == "windows" {
+			// the windows distribution has no `bin` directory
+			nodeBinDir = filepath.FromSlash(installDir)
+		}
 		if !strings.Contains(PATH, nodeBinDir) {
 			os.Setenv("PATH", fmt.Sprintf("%s%c%s", nodeBinDir, os.PathListSeparator, PATH))
 			goto CheckNodejs
@@ -163,7 +169,13 @@ func installNodejs(dir string, version string) (err error) {
 	case "386":
 		arch = "x86"
 	}
-	dlURL := fmt.Sprintf("https://nodejs.org/dist/v%s/node-v%s-%s-%s.tar.xz", version, version, runtime.GOOS, arch)
+	platform := runtime.GOOS
+	extname := ".tar.xz"
+	if platform == "windows" {
+		platform = "win"
+		extname = ".zip"
+	}
+	dlURL := fmt.Sprintf("https://nodejs.org/dist/v%s/node-v%s-%s-%s%s", version, version, platform, arch, extname)
 	resp, err := fetch(dlURL)
 	if err != nil {
 		err = fmt.Errorf("download nodejs: %v", err)
@@ -171,7 +183,7 @@ func installNodejs(dir string, version string) (err error) {
 	}
 	defer resp.Body.Close()
 
-	savePath := path.Join(os.TempDir(), path.Base(dlURL))
+	savePath := filepath.Join(os.TempDir(), path.Base(dlURL))
 	f, err := os.Create(savePath)
 	if err != nil {
 		return
@@ -179,6 +191,11 @@ func installNodejs(dir string, version string) (err error) {
 	io.Copy(f, resp.Body)
 	f.Close()
 
+	// `tar` and `mv` are not available on windows
+	if extname == ".zip" {
+		return extractZip(savePath, dir, strings.TrimSuffix(path.Base(dlURL), extname)+"/")
+	}
+
 	cmd := exec.Command("tar", "-xJf", path.Base(dlURL))
 	cmd.Dir = os.TempDir()
 	output, err := cmd.CombinedOutput()
@@ -199,3 +216,46 @@ func installNodejs(dir string, version string) (err error) {
 	}
 	return
 }
+
+// extractZip extracts the zip file to the dir, the `stripPrefix` is removed from the file names.
+func extractZip(zipFile string, dir string, stripPrefix string) (err error) {
+	zr, err := zip.OpenReader(zipFile)
+	if err != nil {
+		return
+	}
+	defer zr.Close()
+
+	for _, file := range zr.File {
+		name := strings.TrimPrefix(file.Name, stripPrefix)
+		if name == "" || strings.HasSuffix(name, "/") || strings.Contains(name, "..") {
+			continue
+		}
+		savePath := filepath.Join(dir, filepath.FromSlash(name))
+		err = ensureDir(filepath.Dir(savePath))
+		if err != nil {
+			return
+		}
+		err = extractZipFile(file, savePath)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func extractZipFile(file *zip.File, savePath string) error {
+	r, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f, err := os.OpenFile(savePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, r)
+	return err
+}
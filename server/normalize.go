@@ -0,0 +1,104 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// the marker file of the normalized node_modules
+const normalizedMarker = ".esm-normalized"
+
+var sourceExts = []string{".js", ".mjs", ".cjs", ".jsx", ".ts", ".mts", ".cts", ".tsx", ".json", ".css"}
+
+// normalizeSource converts the source to UTF-8 without BOM, the UTF-16 sources are detected
+// by the BOM or the zero bytes, the invalid UTF-8 sources are treated as Latin-1. The CRLF line
+// endings are converted to LF if `crlf` is true. The `changed` is false if the source is not changed.
+func normalizeSource(data []byte, crlf bool) (normalized []byte, changed bool) {
+	normalized = data
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		normalized = data[3:]
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		normalized = decodeUTF16(data[2:], false)
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		normalized = decodeUTF16(data[2:], true)
+	case len(data) >= 4 && len(data)%2 == 0 && data[0] != 0 && data[1] == 0 && data[3] == 0:
+		normalized = decodeUTF16(data, false)
+	case len(data) >= 4 && len(data)%2 == 0 && data[0] == 0 && data[2] == 0 && data[1] != 0:
+		normalized = decodeUTF16(data, true)
+	case !utf8.Valid(data):
+		normalized = decodeLatin1(data)
+	}
+	if crlf && bytes.Contains(normalized, []byte{'\r', '\n'}) {
+		normalized = bytes.ReplaceAll(normalized, []byte{'\r', '\n'}, []byte{'\n'})
+	}
+	changed = len(normalized) != len(data) || !bytes.Equal(normalized, data)
+	return
+}
+
+func decodeUTF16(data []byte, bigEndian bool) []byte {
+	u16 := make([]uint16, len(data)/2)
+	for i := range u16 {
+		if bigEndian {
+			u16[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+		} else {
+			u16[i] = uint16(data[2*i+1])<<8 | uint16(data[2*i])
+		}
+	}
+	return []byte(string(utf16.Decode(u16)))
+}
+
+func decodeLatin1(data []byte) []byte {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return []byte(string(runes))
+}
+
+// normalizeSources normalizes the sources in the node_modules directory after install,
+// it runs once per install.
+func normalizeSources(nodeModulesDir string, crlf bool) (n int, err error) {
+	marker := filepath.Join(nodeModulesDir, normalizedMarker)
+	if !dirExists(nodeModulesDir) || fileExists(marker) {
+		return
+	}
+	// the packages are symlinked to the `.pnpm` directory by pnpm
+	root := nodeModulesDir
+	if dirExists(filepath.Join(nodeModulesDir, ".pnpm")) {
+		root = filepath.Join(nodeModulesDir, ".pnpm")
+	}
+	err = filepath.WalkDir(root, func(filename string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !d.Type().IsRegular() || !endsWith(filename, sourceExts...) {
+			return err
+		}
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		normalized, changed := normalizeSource(data, crlf)
+		if !changed {
+			return nil
+		}
+		// the files are hard linked to the pnpm store, replace the file instead of
+		// writing it in place to keep the store intact
+		tmp := filename + ".esm-tmp"
+		err = os.WriteFile(tmp, normalized, 0644)
+		if err == nil {
+			err = os.Rename(tmp, filename)
+		}
+		if err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		n++
+		return nil
+	})
+	if err == nil {
+		err = os.WriteFile(marker, nil, 0644)
+	}
+	return
+}
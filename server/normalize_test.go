@@ -0,0 +1,71 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalizeSource(t *testing.T) {
+	tests := []struct {
+		input    []byte
+		crlf     bool
+		expected string
+		changed  bool
+	}{
+		{[]byte("export default 1;\n"), false, "export default 1;\n", false},
+		{[]byte("\xEF\xBB\xBFexport default 1;"), false, "export default 1;", true},
+		{[]byte{0xFF, 0xFE, 'a', 0, '=', 0, 0xE9, 0, ';', 0}, false, "a=é;", true},
+		{[]byte{0xFE, 0xFF, 0, 'a', 0, '=', 0, 0xE9, 0, ';'}, false, "a=é;", true},
+		{[]byte{'a', 0, '=', 0, '1', 0, ';', 0}, false, "a=1;", true},
+		{[]byte{0, 'a', 0, '=', 0, '1', 0, ';'}, false, "a=1;", true},
+		{[]byte("a=\"caf\xE9\";"), false, "a=\"café\";", true},
+		{[]byte("a=1;\r\nb=2;\r\n"), false, "a=1;\r\nb=2;\r\n", false},
+		{[]byte("\xEF\xBB\xBFa=1;\r\nb=2;\r\n"), true, "a=1;\nb=2;\n", true},
+	}
+	for _, test := range tests {
+		normalized, changed := normalizeSource(test.input, test.crlf)
+		if string(normalized) != test.expected || changed != test.changed {
+			t.Fatalf("normalizeSource(%q): expected %q(%v), got %q(%v)", test.input, test.expected, test.changed, normalized, changed)
+		}
+	}
+}
+
+func TestNormalizeSources(t *testing.T) {
+	dir := t.TempDir()
+	nodeModulesDir := filepath.Join(dir, "node_modules")
+	pkgDir := filepath.Join(nodeModulesDir, ".pnpm", "foo@1.0.0", "node_modules", "foo")
+	ensureDir(pkgDir)
+	store := filepath.Join(dir, "store.js")
+	os.WriteFile(store, []byte("\xEF\xBB\xBFexport default 1;"), 0644)
+	// the files are hard linked to the pnpm store
+	os.Link(store, filepath.Join(pkgDir, "index.js"))
+	os.WriteFile(filepath.Join(pkgDir, "README.md"), []byte("\xEF\xBB\xBF# foo"), 0644)
+
+	n, err := normalizeSources(nodeModulesDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 file normalized, got %d", n)
+	}
+	data, _ := os.ReadFile(filepath.Join(pkgDir, "index.js"))
+	if string(data) != "export default 1;" {
+		t.Fatalf("unexpected index.js: %q", data)
+	}
+	data, _ = os.ReadFile(store)
+	if string(data) != "\xEF\xBB\xBFexport default 1;" {
+		t.Fatalf("the store file should not be changed: %q", data)
+	}
+	data, _ = os.ReadFile(filepath.Join(pkgDir, "README.md"))
+	if string(data) != "\xEF\xBB\xBF# foo" {
+		t.Fatalf("README.md should not be changed: %q", data)
+	}
+
+	// it runs once per install
+	os.WriteFile(filepath.Join(pkgDir, "index.js"), []byte("\xEF\xBB\xBFexport default 2;"), 0644)
+	n, err = normalizeSources(nodeModulesDir, false)
+	if err != nil || n != 0 {
+		t.Fatalf("expected no files normalized, got %d(%v)", n, err)
+	}
+}
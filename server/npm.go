@@ -1,19 +1,26 @@
 package server
 
 import (
+	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
+	"github.com/esm-dev/esm.sh/server/config"
 	"github.com/esm-dev/esm.sh/server/storage"
 
 	"github.com/Masterminds/semver/v3"
@@ -95,8 +102,8 @@ func (a *NpmPackageTemp) ToNpmPackage() *NpmPackage {
 		Typings:          a.Typings,
 		Browser:          browser,
 		SideEffects:      sideEffects,
-		Dependencies:     a.Dependencies,
-		PeerDependencies: a.PeerDependencies,
+		Dependencies:     fixLocalDependencies(a.Dependencies),
+		PeerDependencies: fixLocalDependencies(a.PeerDependencies),
 		Imports:          a.Imports,
 		TypesVersions:    a.TypesVersions,
 		DefinedExports:   a.DefinedExports,
@@ -123,6 +130,9 @@ type NpmPackage struct {
 	TypesVersions    map[string]interface{}
 	DefinedExports   interface{}
 	Deprecated       string
+
+	// the version is resolved from the last known resolution since the registry is unavailable
+	stale bool
 }
 
 func (a *NpmPackage) UnmarshalJSON(b []byte) error {
@@ -135,6 +145,18 @@ func (a *NpmPackage) UnmarshalJSON(b []byte) error {
 }
 
 func getPackageInfo(wd string, name string, version string) (info NpmPackage, fromPackageJSON bool, err error) {
+	return getRegistryPackageInfo("", wd, name, version)
+}
+
+// getRegistryPackageInfo gets the package info from the registry of `npmRegistries` config,
+// the default registry is used if the registry name is empty.
+func getRegistryPackageInfo(registry string, wd string, name string, version string) (info NpmPackage, fromPackageJSON bool, err error) {
+	return getRegistryPackageInfoContext(context.Background(), registry, wd, name, version)
+}
+
+// getRegistryPackageInfoContext is the `getRegistryPackageInfo` that stops fetching the registry
+// when the context is done, e.g. the build is canceled or timed out.
+func getRegistryPackageInfoContext(ctx context.Context, registry string, wd string, name string, version string) (info NpmPackage, fromPackageJSON bool, err error) {
 	if name == "@types/node" {
 		info = NpmPackage{
 			Name:    "@types/node",
@@ -153,14 +175,14 @@ func getPackageInfo(wd string, name string, version string) (info NpmPackage, fr
 		}
 	}
 
-	info, err = fetchPackageInfo(name, version)
+	info, err = fetchPackageInfo(ctx, registry, name, version)
 	if err == nil {
 		info, err = fixPkgVersion(info)
 	}
 	return
 }
 
-func fetchPackageInfo(name string, version string) (info NpmPackage, err error) {
+func fetchPackageInfo(ctx context.Context, registry string, name string, version string) (info NpmPackage, err error) {
 	a := strings.Split(strings.Trim(name, "/"), "/")
 	name = a[0]
 	if strings.HasPrefix(name, "@") && len(a) > 1 {
@@ -175,11 +197,46 @@ func fetchPackageInfo(name string, version string) (info NpmPackage, err error)
 	}
 	isFullVersion := regexpFullVersion.MatchString(version)
 
+	reg, ok := getNpmRegistry(registry)
+	if !ok {
+		err = fmt.Errorf("npm: registry '%s' not found", registry)
+		return
+	}
+	if registry == "" {
+		if scoped, ok := getScopedRegistry(name); ok {
+			reg = scoped
+		}
+	}
+
 	cacheKey := fmt.Sprintf("npm:%s@%s", name, version)
+	if registry != "" {
+		cacheKey = fmt.Sprintf("npm(%s):%s@%s", registry, name, version)
+	}
+
 	lock := getFetchLock(cacheKey)
 	lock.Lock()
 	defer lock.Unlock()
 
+	// serve the last known resolution of the dist-tag if the registry is unavailable, the `not found`
+	// errors are not recovered.
+	if !isFullVersion {
+		// don't wait for the fetch timeout again if the registry failed recently
+		if isRegistryUnavailable(reg.Registry) {
+			if last, e := getLastResolution(cacheKey); e == nil {
+				return last, nil
+			}
+		}
+		defer func() {
+			if err != nil && ctx.Err() == nil && !strings.HasSuffix(err.Error(), "not found") {
+				markRegistryUnavailable(reg.Registry)
+				if last, e := getLastResolution(cacheKey); e == nil {
+					log.Warnf("npm: serve the stale resolution of %s@%s: %v", name, version, err)
+					info, err = last, nil
+				}
+			}
+		}()
+	}
+
 	// check cache firstly
 	if cache != nil {
 		var data []byte
@@ -195,30 +252,28 @@ func fetchPackageInfo(name string, version string) (info NpmPackage, err error)
 	start := time.Now()
 	defer func() {
 		if err == nil {
-			log.Debugf("lookup package(%s@%s) in %v", name, info.Version, time.Since(start))
+			debugf("resolver", name, "lookup package(%s@%s) in %v", name, info.Version, time.Since(start))
 		}
 	}()
 
-	url := cfg.NpmRegistry + name
-	if cfg.NpmRegistryScope != "" {
-		isInScope := strings.HasPrefix(name, cfg.NpmRegistryScope)
-		if !isInScope {
-			url = "https://registry.npmjs.org/" + name
-		}
-	}
-
-	if isFullVersion {
+	// the npm compatible registry of JSR serves the metadata of all the versions only
+	fromJsr := registry == "" && isJsrPackage(name)
+	url := getRegistryURL(registry, name)
+	if isFullVersion && !fromJsr {
 		url += "/" + version
 	}
-	req, err := http.NewRequest("GET", url, nil)
+	fetchCtx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Install.FetchTimeout)*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(fetchCtx, "GET", url, nil)
 	if err != nil {
 		return
 	}
-	if cfg.NpmToken != "" {
-		req.Header.Set("Authorization", "Bearer "+cfg.NpmToken)
+	// don't send the credentials of the npm registry to JSR
+	if reg.Token != "" && !fromJsr {
+		req.Header.Set("Authorization", "Bearer "+reg.Token)
 	}
-	if cfg.NpmUser != "" && cfg.NpmPassword != "" {
-		req.SetBasicAuth(cfg.NpmUser, cfg.NpmPassword)
+	if reg.User != "" && reg.Password != "" && !fromJsr {
+		req.SetBasicAuth(reg.User, reg.Password)
 	}
 	resp, err := httpClient.Do(req)
 	if err != nil {
@@ -237,13 +292,13 @@ func fetchPackageInfo(name string, version string) (info NpmPackage, err error)
 		return
 	}
 
-	if isFullVersion {
+	if isFullVersion && !fromJsr {
 		err = json.NewDecoder(resp.Body).Decode(&info)
 		if err != nil {
 			return
 		}
 		if cache != nil {
-			cache.Set(cacheKey, utils.MustEncodeJSON(info), 24*time.Hour)
+			cache.Set(cacheKey, utils.MustEncodeJSON(info), time.Duration(cfg.CacheTTL.Registry)*time.Second)
 		}
 		return
 	}
@@ -259,14 +314,14 @@ func fetchPackageInfo(name string, version string) (info NpmPackage, err error)
 		return
 	}
 
-	distVersion, ok := h.DistTags[version]
-	if ok {
+	distVersion, isDistTag := h.DistTags[version]
+	if isDistTag {
 		info = h.Versions[distVersion]
 	} else {
 		var c *semver.Constraints
 		c, err = semver.NewConstraint(version)
 		if err != nil && version != "latest" {
-			return fetchPackageInfo(name, "latest")
+			return fetchPackageInfo(ctx, registry, name, "latest")
 		}
 		vs := make([]*semver.Version, len(h.Versions))
 		i := 0
@@ -299,15 +354,71 @@ func fetchPackageInfo(name string, version string) (info NpmPackage, err error)
 		return
 	}
 
-	// cache package info for 10 minutes
+	// cache the dist-tag/semver resolution
 	if cache != nil {
-		cache.Set(cacheKey, utils.MustEncodeJSON(info), 10*time.Minute)
+		cache.Set(cacheKey, utils.MustEncodeJSON(info), time.Duration(cfg.CacheTTL.DistTag)*time.Second)
+	}
+	// only the dist-tags are persisted, the semver ranges are unbounded
+	if isDistTag {
+		if e := saveLastResolution(cacheKey, info); e != nil {
+			log.Errorf("db: %v", e)
+		}
+	}
+	return
+}
+
+// the registries that failed recently, the requests of the dist-tags are served from the last known
+// resolutions without fetching the registry until the time.
+var unavailableRegistries sync.Map
+
+// the time to retry the registry that failed
+const registryRetryInterval = 30 * time.Second
+
+func markRegistryUnavailable(registry string) {
+	unavailableRegistries.Store(registry, time.Now().Add(registryRetryInterval))
+}
+
+func isRegistryUnavailable(registry string) bool {
+	if v, ok := unavailableRegistries.Load(registry); ok {
+		if time.Now().Before(v.(time.Time)) {
+			return true
+		}
+		unavailableRegistries.Delete(registry)
+	}
+	return false
+}
+
+// saveLastResolution saves the resolution of the dist-tag in the database, it never expires.
+func saveLastResolution(key string, info NpmPackage) error {
+	if db == nil {
+		return nil
+	}
+	return db.Put("_resolution:"+key, utils.MustEncodeJSON(info))
+}
+
+// getLastResolution returns the last known resolution of the dist-tag, the
+// returned package info is marked as stale.
+func getLastResolution(key string) (info NpmPackage, err error) {
+	if db == nil {
+		err = storage.ErrNotFound
+		return
+	}
+	data, err := db.Get("_resolution:" + key)
+	if err != nil {
+		return
 	}
+	if data == nil {
+		err = storage.ErrNotFound
+		return
+	}
+	err = json.Unmarshal(data, &info)
+	info.stale = true
 	return
 }
 
-func installPackage(wd string, pkg Pkg) (err error) {
+func installPackage(ctx context.Context, wd string, pkg Pkg, registry string) (err error) {
 	pkgVersionName := pkg.VersionName()
+	debugf("install", pkg.Name, "install %s in %s", pkgVersionName, wd)
 	lock := getInstallLock(pkgVersionName)
 	lock.Lock()
 	defer lock.Unlock()
@@ -331,10 +442,18 @@ func installPackage(wd string, pkg Pkg) (err error) {
 	if err != nil {
 		return fmt.Errorf("ensure package.json failed: %s", pkgVersionName)
 	}
+	err = writePnpmfile(wd)
+	if err != nil {
+		return fmt.Errorf("ensure .pnpmfile.cjs failed: %s", pkgVersionName)
+	}
+	err = writeNpmrc(wd, registry)
+	if err != nil {
+		return fmt.Errorf("ensure .npmrc failed: %s", pkgVersionName)
+	}
 
 	for i := 0; i < 3; i++ {
 		if pkg.FromEsmsh {
-			err = pnpmInstall(wd)
+			err = pnpmInstall(ctx, wd, registry)
 			if err == nil {
 				installDir := path.Join(wd, "node_modules", pkg.Name)
 				for _, name := range []string{"package.json", "index.mjs", "index.d.ts"} {
@@ -345,15 +464,15 @@ func installPackage(wd string, pkg Pkg) (err error) {
 				}
 			}
 		} else if pkg.FromGithub {
-			err = pnpmInstall(wd)
+			err = pnpmInstall(ctx, wd, registry)
 			// pnpm will ignore github package which has been installed without `package.json` file
 			if err == nil && !dirExists(path.Join(wd, "node_modules", pkg.Name)) {
-				err = ghInstall(wd, pkg.Name, pkg.Version)
+				err = ghInstall(ctx, wd, pkg.Name, pkg.Version)
 			}
 		} else if regexpFullVersion.MatchString(pkg.Version) {
-			err = pnpmInstall(wd, pkgVersionName, "--prefer-offline")
+			err = pnpmInstall(ctx, wd, registry, pkgVersionName, "--prefer-offline")
 		} else {
-			err = pnpmInstall(wd, pkgVersionName)
+			err = pnpmInstall(ctx, wd, registry, pkgVersionName)
 		}
 		packageFilePath := path.Join(wd, "node_modules", pkg.Name, "package.json")
 		if err == nil && !fileExists(packageFilePath) {
@@ -367,14 +486,74 @@ func installPackage(wd string, pkg Pkg) (err error) {
 		if err == nil {
 			break
 		}
+		// don't retry if the build is canceled
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		if i < 2 {
 			time.Sleep(100 * time.Millisecond)
 		}
 	}
+	if err == nil {
+		// some old packages ship the sources in UTF-16 or with BOM that esbuild can't handle
+		n, e := normalizeSources(path.Join(wd, "node_modules"), cfg.Install.NormalizeLineEndings)
+		if e != nil {
+			log.Warnf("normalize sources of %s: %v", pkgVersionName, e)
+		} else if n > 0 {
+			debugf("install", pkg.Name, "normalized %d source files of %s", n, pkgVersionName)
+		}
+	}
 	return
 }
 
-func pnpmInstall(wd string, packages ...string) (err error) {
+// reinstallPackage installs the package from scratch, it's used to recover from a corrupt
+// install(e.g. flaky tarball extraction). The files linked from the pnpm store are verified
+// by pnpm, so the modified files in the store are re-fetched.
+// The caller must hold the write lock of the work directory(see `getWorkDirLock`), so the other
+// builds don't read the directory while it's removed.
+func reinstallPackage(ctx context.Context, wd string, pkg Pkg, registry string) (err error) {
+	lock := getInstallLock(pkg.VersionName())
+	lock.Lock()
+	for _, name := range []string{"node_modules", "pnpm-lock.yaml"} {
+		err = os.RemoveAll(path.Join(wd, name))
+		if err != nil {
+			break
+		}
+	}
+	lock.Unlock()
+	if err != nil {
+		return
+	}
+	return installPackage(ctx, wd, pkg, registry)
+}
+
+// the esbuild errors of reading a missing file, the path of "Cannot read file" is relative to the cwd
+var regexpEsbuildReadError = regexp.MustCompile(`esbuild: (?:Could not read from file: (.+)|Cannot read file "(.+)": no such file or directory)$`)
+
+// isPartialInstallError checks if the build error is caused by a missing file of the work directory.
+func isPartialInstallError(err error, wd string) bool {
+	var filename string
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		if !errors.Is(pathErr.Err, os.ErrNotExist) {
+			return false
+		}
+		filename = pathErr.Path
+	} else if m := regexpEsbuildReadError.FindStringSubmatch(err.Error()); m != nil {
+		filename = m[1] + m[2]
+		if !filepath.IsAbs(filename) {
+			filename, _ = filepath.Abs(filename)
+		}
+	}
+	return filename != "" && strings.HasPrefix(path.Clean(filename), path.Clean(wd)+"/")
+}
+
+func pnpmInstall(ctx context.Context, wd string, registry string, packages ...string) (err error) {
+	reg, ok := getNpmRegistry(registry)
+	if !ok {
+		return fmt.Errorf("npm: registry '%s' not found", registry)
+	}
+
 	var args []string
 	if len(packages) > 0 {
 		args = append([]string{"add"}, packages...)
@@ -385,35 +564,258 @@ func pnpmInstall(wd string, packages ...string) (err error) {
 		args,
 		"--ignore-scripts",
 		"--loglevel", "error",
+		fmt.Sprintf("--network-concurrency=%d", cfg.Install.NetworkConcurrency),
+		fmt.Sprintf("--fetch-timeout=%d", cfg.Install.FetchTimeout*1000),
+		fmt.Sprintf("--fetch-retries=%d", cfg.Install.FetchRetries),
 	)
 	start := time.Now()
-	cmd := exec.Command("pnpm", args...)
+	cmd := exec.CommandContext(ctx, "pnpm", args...)
 	cmd.Dir = wd
-	if cfg.NpmToken != "" {
-		cmd.Env = append(os.Environ(), "ESM_NPM_TOKEN="+cfg.NpmToken)
-	}
-	if cfg.NpmUser != "" && cfg.NpmPassword != "" {
-		data := []byte(cfg.NpmPassword)
-		password := make([]byte, base64.StdEncoding.EncodedLen(len(data)))
-		base64.StdEncoding.Encode(password, data)
-		cmd.Env = append(
-			os.Environ(),
-			"ESM_NPM_USER="+cfg.NpmUser,
-			"ESM_NPM_PASSWORD="+string(password),
-		)
+	registryURL := reg.Registry
+	if registryURL == "" {
+		registryURL = "https://registry.npmjs.org/"
+	}
+	cmd.Env = append(os.Environ(), getProxyEnv(registryURL)...)
+	cmd.Env = append(cmd.Env, getDNSEnv()...)
+	cmd.Env = append(cmd.Env, getNpmAuthEnv(reg, "")...)
+	if registry == "" {
+		for scope, scoped := range cfg.NpmScopes {
+			cmd.Env = append(cmd.Env, getNpmAuthEnv(scoped, getScopeEnvSuffix(scope))...)
+		}
 	}
-	output, err := cmd.CombinedOutput()
+	output := bytes.NewBuffer(nil)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	err = runInCgroup(cmd, "pnpm")
 	if err != nil {
-		return fmt.Errorf("pnpm add %s: %s", strings.Join(packages, ","), string(output))
+		return fmt.Errorf("pnpm add %s: %s", strings.Join(packages, ","), output.String())
 	}
 	if len(packages) > 0 {
-		log.Debug("pnpm add", strings.Join(packages, ","), "in", time.Since(start))
+		debugf("install", "", "pnpm add %s in %v", strings.Join(packages, ","), time.Since(start))
 	} else {
-		log.Debug("pnpm install in", time.Since(start))
+		debugf("install", "", "pnpm install in %v", time.Since(start))
 	}
 	return
 }
 
+// getNpmRegistry returns the registry of the `npmRegistries` config by the name,
+// the default registry(`npmRegistry`) is returned if the name is empty.
+func getNpmRegistry(name string) (reg config.NpmRegistry, ok bool) {
+	if name == "" {
+		return config.NpmRegistry{
+			Registry: cfg.NpmRegistry,
+			Token:    cfg.NpmToken,
+			User:     cfg.NpmUser,
+			Password: cfg.NpmPassword,
+		}, true
+	}
+	reg, ok = cfg.NpmRegistries[name]
+	return
+}
+
+// getScopedRegistry returns the registry of the `npmScopes` config by the scope of the package name.
+func getScopedRegistry(name string) (reg config.NpmRegistry, ok bool) {
+	if !strings.HasPrefix(name, "@") {
+		return
+	}
+	scope, _ := utils.SplitByFirstByte(name, '/')
+	reg, ok = cfg.NpmScopes[scope]
+	return
+}
+
+// getScopeEnvSuffix returns the suffix of the `ESM_NPM_*` environment variables of the scoped
+// registry, the other chars than letters and digits are hex-escaped so the different scopes never
+// share a suffix, e.g. "@my-org" -> "_MY_2DORG", "@my_org" -> "_MY_5FORG".
+func getScopeEnvSuffix(scope string) string {
+	var sb strings.Builder
+	sb.WriteByte('_')
+	for _, r := range strings.TrimPrefix(scope, "@") {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			sb.WriteRune(unicode.ToUpper(r))
+		} else {
+			fmt.Fprintf(&sb, "_%02X", r)
+		}
+	}
+	return sb.String()
+}
+
+// getNpmAuthEnv returns the `ESM_NPM_*` environment variables of the registry credentials that
+// are referenced by the `.npmrc` file.
+func getNpmAuthEnv(reg config.NpmRegistry, suffix string) (env []string) {
+	if reg.Token != "" {
+		env = append(env, "ESM_NPM_TOKEN"+suffix+"="+reg.Token)
+	}
+	if reg.User != "" && reg.Password != "" {
+		env = append(
+			env,
+			"ESM_NPM_USER"+suffix+"="+reg.User,
+			"ESM_NPM_PASSWORD"+suffix+"="+base64.StdEncoding.EncodeToString([]byte(reg.Password)),
+		)
+	}
+	return
+}
+
+// getRegistryURL returns the metadata url of the package in the registry.
+func getRegistryURL(registry string, name string) string {
+	if registry == "" && isJsrPackage(name) {
+		return cfg.JsrRegistry + name
+	}
+	if scoped, ok := getScopedRegistry(name); ok && registry == "" {
+		return scoped.Registry + name
+	}
+	reg, _ := getNpmRegistry(registry)
+	if registry == "" && cfg.NpmRegistryScope != "" && !strings.HasPrefix(name, cfg.NpmRegistryScope) {
+		return "https://registry.npmjs.org/" + name
+	}
+	return reg.Registry + name
+}
+
+// writeNpmrc writes the `.npmrc` file of the registry to the work directory, the credentials
+// are passed to pnpm by the `ESM_NPM_*` environment variables.
+func writeNpmrc(wd string, registry string) (err error) {
+	reg, ok := getNpmRegistry(registry)
+	if !ok {
+		return fmt.Errorf("npm: registry '%s' not found", registry)
+	}
+	rcFilePath := path.Join(wd, ".npmrc")
+
+	var output bytes.Buffer
+	if registry == "" {
+		// install the JSR packages(`@jsr/*`) from the npm compatible registry of JSR
+		output.WriteString(fmt.Sprintf("@jsr:registry=%s\n", cfg.JsrRegistry))
+	}
+	if registry == "" && cfg.NpmRegistryScope != "" && reg.Registry != "" {
+		output.WriteString(fmt.Sprintf("%s:registry=%s\n", cfg.NpmRegistryScope, reg.Registry))
+	} else if reg.Registry != "" {
+		output.WriteString(fmt.Sprintf("registry=%s\n", reg.Registry))
+	}
+	err = writeNpmrcAuth(&output, reg, "")
+	if err != nil {
+		return
+	}
+
+	if registry == "" {
+		scopes := make([]string, 0, len(cfg.NpmScopes))
+		for scope := range cfg.NpmScopes {
+			scopes = append(scopes, scope)
+		}
+		sort.Strings(scopes)
+		for _, scope := range scopes {
+			scoped := cfg.NpmScopes[scope]
+			output.WriteString(fmt.Sprintf("%s:registry=%s\n", scope, scoped.Registry))
+			err = writeNpmrcAuth(&output, scoped, getScopeEnvSuffix(scope))
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	// rewrite the file only if the config is changed, e.g. a scope is added after a restart
+	if data, err := os.ReadFile(rcFilePath); err == nil && bytes.Equal(data, output.Bytes()) {
+		return nil
+	}
+	return os.WriteFile(rcFilePath, output.Bytes(), 0644)
+}
+
+// writeNpmrcAuth writes the credentials of the registry to the `.npmrc` file, the credentials
+// reference the `ESM_NPM_*` environment variables, see `getNpmAuthEnv`.
+func writeNpmrcAuth(output *bytes.Buffer, reg config.NpmRegistry, suffix string) error {
+	if reg.Registry == "" || (reg.Token == "" && (reg.User == "" || reg.Password == "")) {
+		return nil
+	}
+	tokenReg, err := removeHttpPrefix(reg.Registry)
+	if err != nil {
+		return fmt.Errorf("invalid npm registry in config: %v", err)
+	}
+	if reg.Token != "" {
+		output.WriteString(fmt.Sprintf("%s:_authToken=${ESM_NPM_TOKEN%s}\n", tokenReg, suffix))
+	}
+	if reg.User != "" && reg.Password != "" {
+		output.WriteString(fmt.Sprintf("%s:username=${ESM_NPM_USER%s}\n", tokenReg, suffix))
+		output.WriteString(fmt.Sprintf("%s:_password=${ESM_NPM_PASSWORD%s}\n", tokenReg, suffix))
+	}
+	return nil
+}
+
+// the `.pnpmfile.cjs` to rewrite the stray local dependencies of the installed packages,
+// see `fixLocalDependencyVersion`.
+const pnpmfile = `function fixVersion(version) {
+  if (version.startsWith("workspace:")) {
+    const range = version.slice(10);
+    if (range === "" || range === "*" || range === "^" || range === "~") {
+      return "latest";
+    }
+    const i = range.lastIndexOf("@");
+    return i > 0 ? "npm:" + range : range;
+  }
+  if (version.startsWith("file:") || version.startsWith("link:")) {
+    return null;
+  }
+  return version;
+}
+function readPackage(pkg) {
+  for (const field of ["dependencies", "optionalDependencies", "peerDependencies"]) {
+    const deps = pkg[field];
+    if (deps) {
+      for (const [name, version] of Object.entries(deps)) {
+        if (typeof version === "string") {
+          const v = fixVersion(version);
+          if (v === null) {
+            delete deps[name];
+          } else {
+            deps[name] = v;
+          }
+        }
+      }
+    }
+  }
+  return pkg;
+}
+module.exports = { hooks: { readPackage } };
+`
+
+// writePnpmfile writes the `.pnpmfile.cjs` to the work directory.
+func writePnpmfile(wd string) (err error) {
+	filename := path.Join(wd, ".pnpmfile.cjs")
+	if fileExists(filename) {
+		return
+	}
+	return os.WriteFile(filename, []byte(pnpmfile), 0644)
+}
+
+// fixLocalDependencyVersion rewrites the `workspace:` protocol version that is published by
+// mistake to the registry semver equivalent, the `file:` and `link:` versions can't be rewritten.
+func fixLocalDependencyVersion(version string) (string, bool) {
+	if strings.HasPrefix(version, "workspace:") {
+		switch r := strings.TrimPrefix(version, "workspace:"); r {
+		case "", "*", "^", "~":
+			return "latest", true
+		default:
+			// e.g. "workspace:foo@^1.0.0"
+			if strings.LastIndexByte(r, '@') > 0 {
+				return "npm:" + r, true
+			}
+			return r, true
+		}
+	}
+	return version, !isLocalDependencyVersion(version)
+}
+
+// isLocalDependencyVersion checks if the version of the dependency is a local path.
+func isLocalDependencyVersion(version string) bool {
+	return strings.HasPrefix(version, "file:") || strings.HasPrefix(version, "link:")
+}
+
+// fixLocalDependencies rewrites the `workspace:` protocol versions of the dependencies.
+func fixLocalDependencies(deps map[string]string) map[string]string {
+	for name, version := range deps {
+		if strings.HasPrefix(version, "workspace:") {
+			deps[name], _ = fixLocalDependencyVersion(version)
+		}
+	}
+	return deps
+}
+
 // ref https://github.com/npm/validate-npm-package-name
 func validatePackageName(name string) bool {
 	scope := ""
@@ -428,6 +830,11 @@ func validatePackageName(name string) bool {
 	return true
 }
 
+// isJsrPackage checks if the package is a JSR package of the npm compatible registry, e.g. "@jsr/std__path".
+func isJsrPackage(name string) bool {
+	return strings.HasPrefix(name, "@jsr/")
+}
+
 // added by @jimisaacs
 func toTypesPackageName(pkgName string) string {
 	if strings.HasPrefix(pkgName, "@") {
@@ -439,7 +846,7 @@ func toTypesPackageName(pkgName string) string {
 func fixPkgVersion(info NpmPackage) (NpmPackage, error) {
 	for prefix, ver := range fixedPkgVersions {
 		if strings.HasPrefix(info.Name+"@"+info.Version, prefix) {
-			return fetchPackageInfo(info.Name, ver)
+			return fetchPackageInfo(context.Background(), "", info.Name, ver)
 		}
 	}
 	return info, nil
@@ -449,11 +856,28 @@ func isTypesOnlyPackage(p NpmPackage) bool {
 	return p.Main == "" && p.Module == "" && p.Types != ""
 }
 
+// isMainEntrySubpath checks whether the subpath is the `main` or `module` entry of the package,
+// e.g. "index.js" of `{"main": "./index.js"}`. The package with the `exports` or `browser` field
+// is not checked since the main entry is resolved by the build conditions.
+func isMainEntrySubpath(p NpmPackage, subpath string) bool {
+	if subpath == "" || p.DefinedExports != nil || len(p.Browser) > 0 {
+		return false
+	}
+	return (p.Main != "" && subpath == path.Clean(p.Main)) || (p.Module != "" && subpath == path.Clean(p.Module))
+}
+
 func getInstallLock(key string) *sync.Mutex {
 	v, _ := installLocks.LoadOrStore(key, &sync.Mutex{})
 	return v.(*sync.Mutex)
 }
 
+// getWorkDirLock returns the lock of the work directory, the builds hold the read lock and the
+// reinstall holds the write lock.
+func getWorkDirLock(wd string) *sync.RWMutex {
+	v, _ := workDirLocks.LoadOrStore(wd, &sync.RWMutex{})
+	return v.(*sync.RWMutex)
+}
+
 func getFetchLock(key string) *sync.Mutex {
 	v, _ := fetchLocks.LoadOrStore(key, &sync.Mutex{})
 	return v.(*sync.Mutex)
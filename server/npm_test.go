@@ -0,0 +1,198 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/esm-dev/esm.sh/server/config"
+	"github.com/esm-dev/esm.sh/server/storage"
+)
+
+func TestFixLocalDependencyVersion(t *testing.T) {
+	tests := []struct {
+		version  string
+		expected string
+		ok       bool
+	}{
+		{"^1.0.0", "^1.0.0", true},
+		{"workspace:*", "latest", true},
+		{"workspace:^", "latest", true},
+		{"workspace:~", "latest", true},
+		{"workspace:^1.2.0", "^1.2.0", true},
+		{"workspace:1.2.0", "1.2.0", true},
+		{"workspace:foo@^1.0.0", "npm:foo@^1.0.0", true},
+		{"workspace:@org/foo@^1.0.0", "npm:@org/foo@^1.0.0", true},
+		{"file:../foo", "file:../foo", false},
+		{"link:../foo", "link:../foo", false},
+	}
+	for _, test := range tests {
+		version, ok := fixLocalDependencyVersion(test.version)
+		if version != test.expected || ok != test.ok {
+			t.Fatalf("fixLocalDependencyVersion(%q): expected %q(%v), got %q(%v)", test.version, test.expected, test.ok, version, ok)
+		}
+	}
+}
+
+func TestIsMainEntrySubpath(t *testing.T) {
+	tests := []struct {
+		pkg      NpmPackage
+		subpath  string
+		expected bool
+	}{
+		{NpmPackage{Main: "./index.js"}, "index.js", true},
+		{NpmPackage{Main: "index.js", Module: "esm/index.mjs"}, "esm/index.mjs", true},
+		{NpmPackage{Main: "./index.js"}, "index", false},
+		{NpmPackage{Main: "./index.js"}, "cjs/index.js", false},
+		{NpmPackage{Main: "./index.js", DefinedExports: map[string]interface{}{".": "./esm/index.js"}}, "index.js", false},
+		{NpmPackage{Main: "./index.js", Browser: map[string]string{"./index.js": "./browser.js"}}, "index.js", false},
+		{NpmPackage{}, "", false},
+	}
+	for _, test := range tests {
+		if isMainEntrySubpath(test.pkg, test.subpath) != test.expected {
+			t.Fatalf("isMainEntrySubpath(%v, %q): expected %v", test.pkg, test.subpath, test.expected)
+		}
+	}
+}
+
+func TestIsPartialInstallError(t *testing.T) {
+	wd := "/esmd/npm/react@18.2.0"
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{&os.PathError{Op: "open", Path: wd + "/node_modules/react/package.json", Err: syscall.ENOENT}, true},
+		{fmt.Errorf("build: %w", &os.PathError{Op: "open", Path: wd + "/node_modules/react/index.js", Err: syscall.ENOENT}), true},
+		{&os.PathError{Op: "open", Path: "/etc/esmd.json", Err: syscall.ENOENT}, false},
+		{&os.PathError{Op: "open", Path: wd + "-canary/node_modules/react/package.json", Err: syscall.ENOENT}, false},
+		{&os.PathError{Op: "open", Path: wd + "/node_modules/react/package.json", Err: syscall.EACCES}, false},
+		{errors.New(`could not resolve "react/missing"`), false},
+		{errors.New("esbuild: Could not read from file: " + wd + "/node_modules/react/index.js"), true},
+		{fmt.Errorf("build: %w", errors.New("esbuild: Cannot read file \""+wd+"/node_modules/react/package.json\": no such file or directory")), true},
+		{errors.New("esbuild: Could not read from file: /etc/esmd.json"), false},
+		{errors.New("esbuild: Cannot read file \"" + wd + "/node_modules/react/index.js\": permission denied"), false},
+		{errors.New("esbuild: Expected \";\" but found \"}\""), false},
+	}
+	for _, test := range tests {
+		if isPartialInstallError(test.err, wd) != test.expected {
+			t.Fatalf("isPartialInstallError(%v): expected %v", test.err, test.expected)
+		}
+	}
+}
+
+func TestLastResolution(t *testing.T) {
+	dir, err := os.MkdirTemp("", "esm-resolution-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err = storage.OpenDB("bolt:" + path.Join(dir, "esm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		db.Close()
+		db = nil
+	}()
+
+	_, err = getLastResolution("npm:react@latest")
+	if err != storage.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	err = saveLastResolution("npm:react@latest", NpmPackage{Name: "react", Version: "18.2.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := getLastResolution("npm:react@latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "react" || info.Version != "18.2.0" || !info.stale {
+		t.Fatalf("invalid resolution: %+v", info)
+	}
+
+	markRegistryUnavailable("https://registry.example/")
+	defer unavailableRegistries.Delete("https://registry.example/")
+	if !isRegistryUnavailable("https://registry.example/") {
+		t.Fatal("the registry should be unavailable")
+	}
+	if isRegistryUnavailable("https://registry.npmjs.org/") {
+		t.Fatal("the registry should be available")
+	}
+}
+
+func TestScopedRegistry(t *testing.T) {
+	dir, err := os.MkdirTemp("", "esm-npmrc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	withConfig(t, &config.Config{
+		NpmRegistry: "https://registry.npmjs.org/",
+		JsrRegistry: "https://npm.jsr.io/",
+		NpmScopes: map[string]config.NpmRegistry{
+			"@my-org": {Registry: "https://npm.pkg.github.com/", Token: "secret"},
+			"@acme":   {Registry: "https://npm.acme.com/", User: "bot", Password: "pass"},
+		},
+	})
+
+	for name, url := range map[string]string{
+		"react":          "https://registry.npmjs.org/react",
+		"@my-org/ui":     "https://npm.pkg.github.com/@my-org/ui",
+		"@my-orgs/ui":    "https://registry.npmjs.org/@my-orgs/ui",
+		"@jsr/std__path": "https://npm.jsr.io/@jsr/std__path",
+	} {
+		if u := getRegistryURL("", name); u != url {
+			t.Fatalf("invalid registry url of %s: %s", name, u)
+		}
+	}
+
+	err = writeNpmrc(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path.Join(dir, ".npmrc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	npmrc := string(data)
+	for _, line := range []string{
+		"@acme:registry=https://npm.acme.com/",
+		"//npm.acme.com/:username=${ESM_NPM_USER_ACME}",
+		"//npm.acme.com/:_password=${ESM_NPM_PASSWORD_ACME}",
+		"@my-org:registry=https://npm.pkg.github.com/",
+		"//npm.pkg.github.com/:_authToken=${ESM_NPM_TOKEN_MY_2DORG}",
+	} {
+		if !strings.Contains(npmrc, line+"\n") {
+			t.Fatalf("missing %q in .npmrc:\n%s", line, npmrc)
+		}
+	}
+
+	env := getNpmAuthEnv(cfg.NpmScopes["@acme"], getScopeEnvSuffix("@acme"))
+	if strings.Join(env, ",") != "ESM_NPM_USER_ACME=bot,ESM_NPM_PASSWORD_ACME=cGFzcw==" {
+		t.Fatalf("invalid auth env: %v", env)
+	}
+	if getScopeEnvSuffix("@my-org") == getScopeEnvSuffix("@my_org") {
+		t.Fatal("the env suffixes of the scopes should not collide")
+	}
+
+	// the .npmrc file is rewritten if the config is changed
+	delete(cfg.NpmScopes, "@acme")
+	err = writeNpmrc(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = os.ReadFile(path.Join(dir, ".npmrc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "@acme:registry") {
+		t.Fatalf("the .npmrc file should be rewritten:\n%s", data)
+	}
+}
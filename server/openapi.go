@@ -0,0 +1,295 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/esm-dev/esm.sh/server/config"
+)
+
+// PublishOutput is the response of the `POST /build` endpoint.
+type PublishOutput struct {
+	ID        string `json:"id"`
+	URL       string `json:"url"`
+	BundleURL string `json:"bundleUrl"`
+}
+
+// StatusOutput is the response of the `GET /status.json` endpoint.
+type StatusOutput struct {
+	BuildQueue       []QueueTaskStatus `json:"buildQueue"`
+	BuildConcurrency int               `json:"buildConcurrency"`
+	CachedBuilds     int               `json:"cachedBuilds"`
+	PurgeTimers      int               `json:"purgeTimers"`
+	NS               string            `json:"ns"`
+	Version          int               `json:"version"`
+	Uptime           string            `json:"uptime"`
+	ReadOnly         bool              `json:"readOnly"`
+}
+
+// QueueTaskStatus is the status of a task in the build queue.
+type QueueTaskStatus struct {
+	ID           string                `json:"id"`
+	Bundle       bool                  `json:"bundle"`
+	BuildVersion int                   `json:"bv"`
+	Consumers    []*BuildQueueConsumer `json:"consumers"`
+	CreatedAt    string                `json:"createdAt"`
+	StartedAt    string                `json:"startedAt,omitempty"`
+	Elapsed      string                `json:"elapsed,omitempty"`
+	Dev          bool                  `json:"dev"`
+	InProcess    bool                  `json:"inProcess"`
+	Priority     int                   `json:"priority"`
+	Pkg          string                `json:"pkg"`
+	Deps         string                `json:"deps,omitempty"`
+	Stage        string                `json:"stage"`
+	Target       string                `json:"target"`
+}
+
+// LogFilterOptions is the request and the response of the `/_admin/log` endpoint.
+type LogFilterOptions struct {
+	Level      string   `json:"level"`
+	Packages   []string `json:"packages"`
+	Subsystems []string `json:"subsystems"`
+}
+
+type openAPIParam struct {
+	name        string
+	in          string
+	description string
+	required    bool
+}
+
+// openAPIOperation describes an endpoint of the server, the request/response bodies are the
+// zero values of the structs that the handlers use, their schemas are generated by reflection.
+type openAPIOperation struct {
+	method   string
+	path     string
+	summary  string
+	params   []openAPIParam
+	request  interface{}
+	response interface{}
+	// the content type of the response if it's not JSON
+	contentType string
+	// the endpoint requires the `Authorization: Bearer <token>` header
+	auth string
+	// the endpoint is served under the `basePath` of the config
+	basePath bool
+}
+
+var openAPIOperations = []openAPIOperation{
+	{method: "get", path: "/", summary: "The home page", contentType: "text/html", basePath: true},
+	{method: "get", path: "/openapi.json", summary: "The OpenAPI document of the server", response: map[string]interface{}{}, basePath: true},
+	{method: "get", path: "/status.json", summary: "The status of the server", response: StatusOutput{}, basePath: true},
+	{method: "get", path: "/esma-target", summary: "The build target of the user agent", contentType: "text/plain", basePath: true},
+	{method: "get", path: "/.well-known/esm-signing-key", summary: "The public key to verify the signatures of the builds", contentType: "application/x-pem-file", basePath: true},
+	{
+		method:   "get",
+		path:     "/_deno.lock",
+		summary:  "Generate the deno lockfile of the modules",
+		params:   []openAPIParam{{name: "modules", in: "query", description: "The comma-separated module urls", required: true}},
+		response: DenoLock{},
+		basePath: true,
+	},
+	{
+		method:   "get",
+		path:     "/_provenance/{id}",
+		summary:  "The upstream urls fetched by a build, requires the audit mode",
+		params:   []openAPIParam{{name: "id", in: "path", description: "The build ID", required: true}},
+		response: BuildProvenance{},
+		basePath: true,
+	},
+	{
+		method:      "get",
+		path:        "/error.js",
+		summary:     "The module that throws the build error",
+		params:      []openAPIParam{{name: "type", in: "query"}, {name: "name", in: "query"}, {name: "importer", in: "query"}},
+		contentType: "application/javascript",
+		basePath:    true,
+	},
+	{method: "get", path: "/build", summary: "The client of the build API", contentType: "application/javascript", basePath: true},
+	{method: "post", path: "/build", summary: "Build and publish a module with custom input(code)", request: BuildInput{}, response: PublishOutput{}},
+	{method: "post", path: fmt.Sprintf("/v%d/import-map", VERSION), summary: "Generate the import map of the packages and their dependencies", request: ImportMapInput{}, response: ImportMap{}},
+	{method: "get", path: "/server", summary: "The server script for deno", contentType: "application/typescript", basePath: true},
+	{
+		method:  "get",
+		path:    "/{package}",
+		summary: "Import a module from npm(`/PKG@VERSION/SUBPATH`) or github(`/gh/OWNER/REPO@TAG/SUBPATH`)",
+		params: []openAPIParam{
+			{name: "package", in: "path", description: "The package path, e.g. `react@18.2.0`, `react-dom@18.2.0/client`", required: true},
+			{name: "target", in: "query", description: "The build target, e.g. `es2022`, `deno`"},
+			{name: "deps", in: "query", description: "The comma-separated pinned dependencies, e.g. `react@17.0.2`"},
+			{name: "alias", in: "query", description: "The comma-separated dependency aliases, e.g. `react:preact/compat`"},
+			{name: "exports", in: "query", description: "The comma-separated exports for tree shaking"},
+			{name: "external", in: "query", description: "The comma-separated external dependencies"},
+			{name: "conditions", in: "query", description: "The comma-separated export conditions"},
+			{name: "bundle", in: "query", description: "Bundle the dependencies"},
+			{name: "dev", in: "query", description: "Use the development build"},
+			{name: "pin", in: "query", description: "Pin the build version, e.g. `v126`"},
+			{name: "raw", in: "query", description: "Serve the file of the package as it is instead of the build"},
+		},
+		contentType: "application/javascript",
+		basePath:    true,
+	},
+	{method: "get", path: "/_sync/manifest", summary: "List the files changed since the given time", params: []openAPIParam{{name: "prefix", in: "query"}, {name: "since", in: "query", description: "The time in milliseconds"}}, response: SyncManifest{}, auth: "sync"},
+	{method: "get", path: "/_sync/file", summary: "Download a file of the storage", params: []openAPIParam{{name: "path", in: "query", required: true}}, contentType: "application/octet-stream", auth: "sync"},
+	{method: "get", path: "/_admin/webhooks", summary: "List the webhooks", response: []config.Webhook{}, auth: "admin"},
+	{method: "post", path: "/_admin/webhooks", summary: "Add a webhook", request: config.Webhook{}, response: []config.Webhook{}, auth: "admin"},
+	{method: "delete", path: "/_admin/webhooks", summary: "Remove a webhook", params: []openAPIParam{{name: "url", in: "query", required: true}}, response: []config.Webhook{}, auth: "admin"},
+	{method: "get", path: "/_admin/failures", summary: "List the recent build failures, the latest first", response: []JournalRecord{}, auth: "admin"},
+	{method: "get", path: "/_admin/dependents", summary: "List the builds that import a package version", params: []openAPIParam{{name: "package", in: "query", description: "The package version, e.g. `react@18.3.1`", required: true}}, response: DependentsOutput{}, auth: "admin"},
+	{method: "post", path: "/_admin/warmup", summary: "Queue the builds of a warmup manifest that are missing in the storage", request: WarmupManifest{}, response: WarmupOutput{}, auth: "admin"},
+	{method: "post", path: "/_admin/purge", summary: "Purge the builds of a package version and optionally its dependents", request: PurgeInput{}, response: PurgeOutput{}, auth: "admin"},
+	{
+		method:  "get",
+		path:    "/_admin/analytics",
+		summary: "Export the daily rollups of the usage analytics",
+		params: []openAPIParam{
+			{name: "from", in: "query", description: "The start date(inclusive), e.g. `2024-01-01`"},
+			{name: "to", in: "query", description: "The end date(inclusive), e.g. `2024-01-31`"},
+			{name: "format", in: "query", description: "The export format: `json` or `csv`, default is `json`"},
+		},
+		response: []AnalyticsRecord{},
+		auth:     "admin",
+	},
+	{method: "get", path: "/_admin/log", summary: "Get the log filter", response: LogFilterOptions{}, auth: "admin"},
+	{method: "post", path: "/_admin/log", summary: "Update the log filter", request: LogFilterOptions{}, response: LogFilterOptions{}, auth: "admin"},
+}
+
+// generateOpenAPI generates the OpenAPI document of the server endpoints.
+func generateOpenAPI(origin string, basePath string) map[string]interface{} {
+	schemas := map[string]interface{}{}
+	paths := map[string]interface{}{}
+	for _, op := range openAPIOperations {
+		pathname := op.path
+		if op.basePath {
+			pathname = basePath + pathname
+		}
+		operation := map[string]interface{}{
+			"summary": op.summary,
+		}
+		if len(op.params) > 0 {
+			params := make([]map[string]interface{}, len(op.params))
+			for i, p := range op.params {
+				params[i] = map[string]interface{}{
+					"name":     p.name,
+					"in":       p.in,
+					"required": p.required,
+					"schema":   map[string]interface{}{"type": "string"},
+				}
+				if p.description != "" {
+					params[i]["description"] = p.description
+				}
+			}
+			operation["parameters"] = params
+		}
+		if op.request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": openAPISchema(reflect.TypeOf(op.request), schemas)},
+				},
+			}
+		}
+		response := map[string]interface{}{"description": "OK"}
+		if op.response != nil {
+			response["content"] = map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": openAPISchema(reflect.TypeOf(op.response), schemas)},
+			}
+		} else if op.contentType != "" {
+			response["content"] = map[string]interface{}{
+				op.contentType: map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+			}
+		}
+		operation["responses"] = map[string]interface{}{"200": response}
+		if op.auth != "" {
+			operation["security"] = []map[string]interface{}{{op.auth: []string{}}}
+		}
+		item, ok := paths[pathname].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[pathname] = item
+		}
+		item[op.method] = operation
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "esm.sh",
+			"version": fmt.Sprintf("v%d", VERSION),
+		},
+		"servers": []map[string]interface{}{{"url": origin}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"admin": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "The `adminToken` of the config"},
+				"sync":  map[string]interface{}{"type": "http", "scheme": "bearer", "description": "The `sync.token` of the config"},
+			},
+		},
+	}
+}
+
+var typeRawMessage = reflect.TypeOf(json.RawMessage{})
+
+// openAPISchema generates the json schema of the type by the `json` tags of the struct fields,
+// the named structs are added to the `schemas` and referenced by `$ref`.
+func openAPISchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == typeRawMessage {
+		return map[string]interface{}{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": openAPISchema(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": openAPISchema(t.Elem(), schemas)}
+	case reflect.Struct:
+		name := t.Name()
+		if name != "" {
+			if _, ok := schemas[name]; ok {
+				return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+			}
+			// a placeholder for the recursive types
+			schemas[name] = nil
+		}
+		properties := map[string]interface{}{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if f.PkgPath != "" || tag == "-" || f.Type.Kind() == reflect.Chan || f.Type.Kind() == reflect.Func {
+				continue
+			}
+			key, opts, _ := strings.Cut(tag, ",")
+			if key == "" {
+				key = f.Name
+			}
+			properties[key] = openAPISchema(f.Type, schemas)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, key)
+			}
+		}
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		if name == "" {
+			return schema
+		}
+		schemas[name] = schema
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	default:
+		return map[string]interface{}{}
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPI(t *testing.T) {
+	doc := generateOpenAPI("https://esm.sh", "/cdn")
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var spec struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+				Required   []string                   `json:"required"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	err = json.Unmarshal(data, &spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, op := range openAPIOperations {
+		pathname := op.path
+		if op.basePath {
+			pathname = "/cdn" + pathname
+		}
+		if _, ok := spec.Paths[pathname][op.method]; !ok {
+			t.Fatalf("missing operation %s %s", op.method, pathname)
+		}
+	}
+	if _, ok := spec.Paths["/cdn/_admin/log"]; ok {
+		t.Fatal("the admin endpoints are not served under the base path")
+	}
+
+	// all the `$ref` should be resolved
+	for _, ref := range strings.Split(string(data), `"$ref":"#/components/schemas/`)[1:] {
+		name := ref[:strings.IndexByte(ref, '"')]
+		if _, ok := spec.Components.Schemas[name]; !ok {
+			t.Fatalf("unresolved schema %s", name)
+		}
+	}
+
+	status := spec.Components.Schemas["QueueTaskStatus"]
+	if _, ok := status.Properties["consumers"]; !ok {
+		t.Fatal("missing `consumers` property of QueueTaskStatus")
+	}
+	for _, name := range status.Required {
+		if name == "startedAt" || name == "deps" {
+			t.Fatalf("the omitempty property %s should not be required", name)
+		}
+	}
+	if consumer := spec.Components.Schemas["BuildQueueConsumer"]; len(consumer.Properties) != 1 {
+		t.Fatalf("the channel of BuildQueueConsumer should be ignored: %v", consumer.Properties)
+	}
+	if _, ok := spec.Components.Schemas["SyncFileInfo"].Properties["meta"]; !ok {
+		t.Fatal("missing `meta` property of SyncFileInfo")
+	}
+
+	var output PublishOutput
+	err = json.Unmarshal([]byte(`{"id":"abc","url":"https://esm.sh/~abc","bundleUrl":"https://esm.sh/~abc?bundle"}`), &output)
+	if err != nil || output.BundleURL != "https://esm.sh/~abc?bundle" {
+		t.Fatalf("invalid publish output: %v", output)
+	}
+	if props := spec.Components.Schemas["PublishOutput"].Properties; len(props) != 3 || props["bundleUrl"] == nil {
+		t.Fatalf("invalid PublishOutput schema: %v", props)
+	}
+}
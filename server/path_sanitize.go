@@ -0,0 +1,38 @@
+package server
+
+import (
+	"errors"
+	"path"
+	"strings"
+)
+
+var errUnsafePath = errors.New("unsafe path")
+
+// isUnsafePath reports whether the path may escape the directory it's joined to: the `..`
+// segments, backslashes(treated as separators on windows) and NUL bytes are not allowed.
+func isUnsafePath(p string) bool {
+	if strings.ContainsAny(p, "\\\x00") {
+		return true
+	}
+	for _, segment := range strings.Split(p, "/") {
+		if segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// securejoin joins the path elements to the root directory, the `errUnsafePath` error is
+// returned if the joined path escapes the root directory.
+func securejoin(root string, elem ...string) (string, error) {
+	for _, e := range elem {
+		if isUnsafePath(e) {
+			return "", errUnsafePath
+		}
+	}
+	joined := path.Join(append([]string{root}, elem...)...)
+	if joined != path.Clean(root) && !strings.HasPrefix(joined, strings.TrimSuffix(path.Clean(root), "/")+"/") {
+		return "", errUnsafePath
+	}
+	return joined, nil
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestIsUnsafePath(t *testing.T) {
+	tests := map[string]bool{
+		"":                            false,
+		"/react@18.2.0/index.js":      false,
+		"lib/..foo/bar.d.ts":          false,
+		"/pkg@1.0.0/../../etc/passwd": true,
+		"..":                          true,
+		"../index.d.ts":               true,
+		"lib/../../index.d.ts":        true,
+		"lib\\..\\index.js":           true,
+		"index.js\x00.map":            true,
+	}
+	for p, expected := range tests {
+		if isUnsafePath(p) != expected {
+			t.Fatalf("isUnsafePath(%q): expected %v", p, expected)
+		}
+	}
+}
+
+func TestSecurejoin(t *testing.T) {
+	p, err := securejoin("/esmd/npm/react@18.2.0/node_modules/react", "cjs/react.development.js")
+	if err != nil || p != "/esmd/npm/react@18.2.0/node_modules/react/cjs/react.development.js" {
+		t.Fatalf("unexpected result: %s(%v)", p, err)
+	}
+	p, err = securejoin("/esmd/npm/react@18.2.0/node_modules/react", "")
+	if err != nil || p != "/esmd/npm/react@18.2.0/node_modules/react" {
+		t.Fatalf("unexpected result: %s(%v)", p, err)
+	}
+	for _, elem := range []string{"../../../etc/passwd", "a/../../b", "/../b", "a\\..\\..\\b"} {
+		_, err = securejoin("/esmd/npm/react@18.2.0/node_modules/react", elem)
+		if err != errUnsafePath {
+			t.Fatalf("securejoin(%q): expected errUnsafePath, got %v", elem, err)
+		}
+	}
+}
+
+func TestValidatePkgPathTraversal(t *testing.T) {
+	_, _, err := validatePkgPath("/react@18.2.0/../../etc/passwd")
+	if err == nil {
+		t.Fatal("the subpath with `..` segments should be rejected")
+	}
+}
@@ -16,15 +16,34 @@ type Pkg struct {
 	Submodule  string `json:"submodule"`
 	FromGithub bool   `json:"fromGithub"`
 	FromEsmsh  bool   `json:"fromEsmsh"`
+
+	// the version is resolved from the last known resolution, see `getLastResolution`
+	stale bool
 }
 
 func validatePkgPath(pathname string) (pkg Pkg, query string, err error) {
+	return validateRegistryPkgPath("", pathname)
+}
+
+// validateRegistryPkgPath validates the package path and resolves the version
+// of the package from the registry of `npmRegistries` config.
+func validateRegistryPkgPath(registry string, pathname string) (pkg Pkg, query string, err error) {
 	fromGithub := strings.HasPrefix(pathname, "/gh/") && strings.Count(pathname, "/") >= 3
 	if fromGithub {
 		pathname = "/@" + pathname[4:]
+	} else if strings.HasPrefix(pathname, "/jsr/@") {
+		// the JSR packages are served as the npm compatible packages, e.g. "/jsr/@std/path@1.0.0" -> "/@jsr/std__path@1.0.0"
+		scope, rest := utils.SplitByFirstByte(pathname[6:], '/')
+		if scope == "" || rest == "" || strings.HasPrefix(rest, "@") {
+			return Pkg{}, "", fmt.Errorf("invalid path")
+		}
+		pathname = "/@jsr/" + scope + "__" + rest
 	}
 
 	pkgName, subpath := splitPkgPath(pathname)
+	if isUnsafePath(subpath) {
+		return Pkg{}, "", fmt.Errorf("invalid subpath '%s'", subpath)
+	}
 	name, maybeVersion := utils.SplitByLastByte(pkgName, '@')
 	if strings.HasPrefix(pkgName, "@") {
 		name, maybeVersion = utils.SplitByLastByte(pkgName[1:], '@')
@@ -60,6 +79,14 @@ func validatePkgPath(pathname string) (pkg Pkg, query string, err error) {
 		if (valid.IsHexString(pkg.Version) && len(pkg.Version) >= 10) || regexpFullVersion.MatchString(strings.TrimPrefix(pkg.Version, "v")) {
 			return
 		}
+		if strings.HasPrefix(pkg.Version, "semver:") {
+			var sha string
+			sha, err = resolveGithubSemver(pkg.Name, strings.TrimPrefix(pkg.Version, "semver:"))
+			if err == nil {
+				pkg.Version = sha[:10]
+			}
+			return
+		}
 		var refs []GitRef
 		refs, err = listRepoRefs(fmt.Sprintf("https://github.com/%s", pkg.Name))
 		if err != nil {
@@ -72,8 +99,6 @@ func validatePkgPath(pathname string) (pkg Pkg, query string, err error) {
 					return
 				}
 			}
-		} else if strings.HasPrefix(pkg.Version, "semver:") {
-			// TODO: support semver
 		} else {
 			for _, ref := range refs {
 				if ref.Ref == "refs/tags/"+pkg.Version || ref.Ref == "refs/heads/"+pkg.Version {
@@ -98,9 +123,10 @@ func validatePkgPath(pathname string) (pkg Pkg, query string, err error) {
 		return
 	}
 
-	p, _, err := getPackageInfo("", name, version)
+	p, _, err := getRegistryPackageInfo(registry, "", name, version)
 	if err == nil {
 		pkg.Version = p.Version
+		pkg.stale = p.stale
 	}
 	return
 }
@@ -150,7 +176,7 @@ func (a PkgSlice) Less(i, j int) bool { return a[i].String() < a[j].String() }
 func (a PkgSlice) Has(name string) bool {
 	for _, m := range a {
 		if m.Name == name {
-			return false
+			return true
 		}
 	}
 	return false
@@ -175,6 +201,14 @@ func (a PkgSlice) String() string {
 
 func toModuleName(path string) string {
 	if path != "" {
+		// the directory index keeps the `/index` suffix, e.g. "dist/" -> "dist/index", so it's not
+		// resolved as the "dist.js" file
+		if strings.HasSuffix(path, "/") {
+			if submodule := toModuleName(strings.TrimRight(path, "/")); submodule != "" {
+				return submodule + "/index"
+			}
+			return ""
+		}
 		submodule := path
 		if strings.HasSuffix(submodule, ".mjs") {
 			submodule = strings.TrimSuffix(submodule, ".mjs")
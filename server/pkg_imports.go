@@ -0,0 +1,172 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// resolvePackageImports resolves the `#` specifier by the `imports` field of package.json,
+// the conditions are matched in the key order of the field like Node.js.
+// ref https://nodejs.org/api/packages.html#subpath-imports
+func resolvePackageImports(imports map[string]interface{}, specifier string, conditions []string) (string, bool) {
+	if !strings.HasPrefix(specifier, "#") || specifier == "#" || strings.HasPrefix(specifier, "#/") {
+		return "", false
+	}
+	if target, ok := imports[specifier]; ok && !strings.ContainsRune(specifier, '*') {
+		return resolvePackageImportsTarget(target, "", conditions)
+	}
+
+	// find the pattern with the longest prefix, e.g. "#internal/*.js"
+	bestKey, bestMatch := "", ""
+	for key := range imports {
+		prefix, suffix, ok := strings.Cut(key, "*")
+		if !ok || strings.ContainsRune(suffix, '*') {
+			continue
+		}
+		if len(specifier) < len(key) || !strings.HasPrefix(specifier, prefix) || !strings.HasSuffix(specifier, suffix) {
+			continue
+		}
+		if bestKey == "" || len(prefix) > strings.IndexByte(bestKey, '*') || (len(prefix) == strings.IndexByte(bestKey, '*') && len(key) > len(bestKey)) {
+			bestKey = key
+			bestMatch = specifier[len(prefix) : len(specifier)-len(suffix)]
+		}
+	}
+	if bestKey != "" {
+		return resolvePackageImportsTarget(imports[bestKey], bestMatch, conditions)
+	}
+	return "", false
+}
+
+func resolvePackageImportsTarget(target interface{}, match string, conditions []string) (string, bool) {
+	switch t := target.(type) {
+	case string:
+		if t == "" || strings.HasPrefix(t, "../") || strings.HasPrefix(t, "/") || strings.HasPrefix(t, "#") {
+			return "", false
+		}
+		return strings.ReplaceAll(t, "*", match), true
+	case []interface{}:
+		// use the first valid target of the fallbacks
+		for _, v := range t {
+			if s, ok := resolvePackageImportsTarget(v, match, conditions); ok {
+				return s, true
+			}
+		}
+	case *jsonObject:
+		for _, key := range t.keys {
+			if key == "default" || includes(conditions, key) {
+				if s, ok := resolvePackageImportsTarget(t.values[key], match, conditions); ok {
+					return s, true
+				}
+			}
+		}
+	}
+	// `null` target excludes the specifier
+	return "", false
+}
+
+// findPackageImports finds the nearest package.json of the resolve dir and returns its `imports` field.
+func findPackageImports(resolveDir string, rootDir string) (pkgDir string, imports map[string]interface{}) {
+	for dir := resolveDir; strings.HasPrefix(dir, rootDir); dir = filepath.Dir(dir) {
+		data, err := os.ReadFile(filepath.Join(dir, "package.json"))
+		if err == nil {
+			return dir, parsePackageImports(data)
+		}
+		if dir == rootDir || dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	return "", nil
+}
+
+// parsePackageImports parses the `imports` field of the package.json, the objects of the conditions
+// are decoded as `*jsonObject` to keep the key order.
+func parsePackageImports(data []byte) map[string]interface{} {
+	var p struct {
+		Imports json.RawMessage `json:"imports"`
+	}
+	if json.Unmarshal(data, &p) != nil || len(p.Imports) == 0 {
+		return nil
+	}
+	v, err := decodeOrderedJSON(json.NewDecoder(bytes.NewReader(p.Imports)))
+	if err != nil {
+		return nil
+	}
+	obj, ok := v.(*jsonObject)
+	if !ok {
+		return nil
+	}
+	return obj.values
+}
+
+// jsonObject is a JSON object that keeps the key order of the source.
+type jsonObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// decodeOrderedJSON decodes the next JSON value of the decoder, the objects are decoded as `*jsonObject`.
+func decodeOrderedJSON(dec *json.Decoder) (interface{}, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t {
+	case json.Delim('{'):
+		obj := &jsonObject{values: map[string]interface{}{}}
+		for dec.More() {
+			t, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key, ok := t.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid object key %v", t)
+			}
+			v, err := decodeOrderedJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := obj.values[key]; !ok {
+				obj.keys = append(obj.keys, key)
+			}
+			obj.values[key] = v
+		}
+		_, err = dec.Token()
+		return obj, err
+	case json.Delim('['):
+		arr := []interface{}{}
+		for dec.More() {
+			v, err := decodeOrderedJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		_, err = dec.Token()
+		return arr, err
+	}
+	return t, nil
+}
+
+// getImportsConditions returns the conditions to resolve the `imports` field for the build target.
+func (task *BuildTask) getImportsConditions() []string {
+	conditions := task.conditions.Values()
+	switch task.Target {
+	case "deno", "denonext":
+		conditions = append(conditions, "deno", "worker", "browser")
+	case "node":
+		conditions = append(conditions, "node")
+	default:
+		conditions = append(conditions, "browser")
+	}
+	if task.Dev {
+		conditions = append(conditions, "development")
+	} else {
+		conditions = append(conditions, "production")
+	}
+	return append(conditions, "module", "import", "default", "require")
+}
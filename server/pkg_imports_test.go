@@ -0,0 +1,63 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestResolvePackageImports(t *testing.T) {
+	imports := parsePackageImports([]byte(`{
+		"name": "pkg",
+		"imports": {
+			"#dep": "lodash",
+			"#util": "./src/util.js",
+			"#platform": {
+				"node": "./src/platform-node.js",
+				"default": "./src/platform-browser.js"
+			},
+			"#internal/*": "./src/internal/*.js",
+			"#internal/private/*": null,
+			"#fallback": ["../outside.js", "./src/fallback.js"],
+			"#nested": {
+				"browser": {
+					"development": "./src/nested.dev.js",
+					"default": "./src/nested.js"
+				}
+			},
+			"#order": {
+				"import": "./src/order.mjs",
+				"browser": "./src/order.js"
+			}
+		}
+	}`))
+	browser := []string{"browser", "production", "module", "import", "default"}
+	node := []string{"node", "production", "module", "import", "default"}
+
+	tests := []struct {
+		specifier  string
+		conditions []string
+		target     string
+		ok         bool
+	}{
+		{"#dep", browser, "lodash", true},
+		{"#util", browser, "./src/util.js", true},
+		{"#platform", browser, "./src/platform-browser.js", true},
+		{"#platform", node, "./src/platform-node.js", true},
+		{"#internal/foo", browser, "./src/internal/foo.js", true},
+		{"#internal/foo/bar", browser, "./src/internal/foo/bar.js", true},
+		{"#internal/private/foo", browser, "", false},
+		{"#fallback", browser, "./src/fallback.js", true},
+		{"#nested", browser, "./src/nested.js", true},
+		{"#nested", append([]string{"development"}, browser...), "./src/nested.dev.js", true},
+		{"#nested", node, "", false},
+		{"#order", browser, "./src/order.mjs", true},
+		{"#missing", browser, "", false},
+		{"#", browser, "", false},
+		{"lodash", browser, "", false},
+	}
+	for _, test := range tests {
+		target, ok := resolvePackageImports(imports, test.specifier, test.conditions)
+		if target != test.target || ok != test.ok {
+			t.Fatalf("resolvePackageImports(%q): expected %q(%v), got %q(%v)", test.specifier, test.target, test.ok, target, ok)
+		}
+	}
+}
@@ -38,6 +38,20 @@ func TestPkgPath(t *testing.T) {
 		t.Fatalf("invalid pkg('%v'), should be 'react-dom@18.2.0/client'", pkg)
 	}
 
+	// the directory index
+	pkg, _, err = validatePkgPath("react-dom@18.2.0/client/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg.String() != "react-dom@18.2.0/client/index" {
+		t.Fatalf("invalid pkg('%v'), should be 'react-dom@18.2.0/client/index'", pkg)
+	}
+	for p, name := range map[string]string{"client": "client", "client/": "client/index", "client/index.js": "client", "client/index/": "client/index", "client.mjs/": "client/index"} {
+		if toModuleName(p) != name {
+			t.Fatalf("toModuleName(%q) should be %q, got %q", p, name, toModuleName(p))
+		}
+	}
+
 	pkg, q, err = validatePkgPath("@types/react@18.2.0")
 	if err != nil {
 		t.Fatal(err)
@@ -48,4 +62,36 @@ func TestPkgPath(t *testing.T) {
 	if pkg.String() != "@types/react@"+fixedPkgVersions["@types/react@18"] {
 		t.Fatalf("invalid pkg('%v'), should be '@types/react@%s'", pkg, fixedPkgVersions["@types/react@18"])
 	}
+
+	pkg, _, err = validatePkgPath("/jsr/@std/path@1.0.8/posix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg.String() != "@jsr/std__path@1.0.8/posix" || !isJsrPackage(pkg.Name) {
+		t.Fatalf("invalid pkg('%v'), should be '@jsr/std__path@1.0.8/posix'", pkg)
+	}
+
+	for _, p := range []string{"/jsr/@std", "/jsr/@/path@1.0.8", "/jsr/@std/@path@1.0.8"} {
+		if _, _, err = validatePkgPath(p); err == nil {
+			t.Fatalf("invalid jsr path('%s') should be rejected", p)
+		}
+	}
+}
+
+func TestPkgSlice(t *testing.T) {
+	deps := PkgSlice{{Name: "react", Version: "17.0.2"}, {Name: "react-dom", Version: "17.0.2"}}
+	if !deps.Has("react") || deps.Has("preact") {
+		t.Fatal("invalid PkgSlice.Has")
+	}
+	if p, ok := deps.Get("react-dom"); !ok || p.Version != "17.0.2" {
+		t.Fatal("invalid PkgSlice.Get")
+	}
+
+	args, err := decodeBuildArgsPrefix("X-" + btoaUrl("d/react@17.0.2,react@18.2.0,react-dom@17.0.2") + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.deps.String() != "react@17.0.2,react-dom@17.0.2" {
+		t.Fatalf("the duplicate deps should be ignored: %s", args.deps.String())
+	}
 }
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+// preflight checks the host environment before the server starts to serve requests.
+// It returns an error if the server can't run at all (e.g. the work directory is not writable),
+// and a list of warnings for the problems that the server can survive by serving cached builds only.
+func preflight() (warnings []string, err error) {
+	err = checkDirWritable(cfg.WorkDir)
+	if err != nil {
+		return nil, fmt.Errorf("the work directory is not writable: %v, please check the permission or change the `workDir` option of the config", err)
+	}
+
+	if strings.HasPrefix(cfg.Storage, "local:") {
+		root := strings.SplitN(strings.TrimPrefix(cfg.Storage, "local:"), "?", 2)[0]
+		err = checkDirWritable(root)
+		if err != nil {
+			return nil, fmt.Errorf("the storage directory is not writable: %v, please check the permission or change the `storage` option of the config", err)
+		}
+	}
+
+	if cfg.MinFreeDiskSpace > 0 {
+		free, err := getDiskFreeSpace(cfg.WorkDir)
+		if err != nil {
+			log.Warnf("preflight: could not get the free disk space: %v", err)
+		} else if min := uint64(cfg.MinFreeDiskSpace) * 1024 * 1024; free < min {
+			warnings = append(warnings, fmt.Sprintf("only %dMB disk space is free in %s, at least %dMB is required", free/1024/1024, cfg.WorkDir, cfg.MinFreeDiskSpace))
+		}
+	}
+
+	// nodejs and the npm registry are not required in read-only mode
+	if cfg.ReadOnly {
+		return warnings, nil
+	}
+
+	nodeInstallDir := os.Getenv("NODE_INSTALL_DIR")
+	if nodeInstallDir == "" {
+		nodeInstallDir = path.Join(cfg.WorkDir, "nodejs")
+	}
+	nodeVer, pnpmVer, err := checkNodejs(nodeInstallDir)
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("nodejs/pnpm is not available: %v, please install nodejs %d+ manually", err, nodejsMinVersion))
+	} else {
+		if cfg.NpmRegistry == "" {
+			output, err := exec.Command("npm", "config", "get", "registry").CombinedOutput()
+			if err == nil {
+				cfg.NpmRegistry = strings.TrimRight(strings.TrimSpace(string(output)), "/") + "/"
+			}
+		}
+		log.Infof("nodejs v%s installed, registry: %s, pnpm: %s", nodeVer, cfg.NpmRegistry, pnpmVer)
+	}
+
+	registry := cfg.NpmRegistry
+	if registry == "" {
+		registry = "https://registry.npmjs.org/"
+	}
+	err = checkRegistry(registry)
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("could not connect to the npm registry(%s): %v, please check the network or the `npmRegistry` option of the config", registry, err))
+	}
+
+	if _, err := exec.LookPath("git"); err != nil {
+		log.Warn("preflight: git not found, packages from github are not available")
+	}
+
+	return warnings, nil
+}
+
+// checkDirWritable checks whether the given directory is writable by creating a temporary file in it.
+func checkDirWritable(dir string) error {
+	err := ensureDir(dir)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".preflight-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkRegistry checks whether the npm registry is reachable, any non-5xx response is considered ok
+// since private registries may require authorization for the root path.
+func checkRegistry(registry string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "HEAD", registry, nil)
+	if err != nil {
+		return err
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 500 {
+		return fmt.Errorf("bad status %s", res.Status)
+	}
+	return nil
+}
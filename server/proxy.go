@@ -0,0 +1,100 @@
+package server
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+var (
+	proxyFuncOnce sync.Once
+	proxyFunc     func(*url.URL) (*url.URL, error)
+)
+
+// getProxyURL returns the proxy url of the upstream url by the `proxy` config,
+// nil is returned for the direct connection.
+func getProxyURL(u *url.URL) (*url.URL, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if proxy, ok := matchUpstreamProxy(u.Hostname()); ok {
+		if proxy == "direct" {
+			return nil, nil
+		}
+		return url.Parse(proxy)
+	}
+	proxyFuncOnce.Do(func() {
+		proxyFunc = (&httpproxy.Config{
+			HTTPProxy:  cfg.Proxy.Http,
+			HTTPSProxy: cfg.Proxy.Https,
+			NoProxy:    cfg.Proxy.NoProxy,
+		}).ProxyFunc()
+	})
+	return proxyFunc(u)
+}
+
+// matchUpstreamProxy returns the proxy of the `proxy.upstreams` config that matches the hostname,
+// the longest(most specific) host wins, e.g. "npm.acme.com" over "acme.com".
+func matchUpstreamProxy(hostname string) (proxy string, ok bool) {
+	if cfg == nil {
+		return
+	}
+	hostname = strings.ToLower(hostname)
+	matched := ""
+	for host, p := range cfg.Proxy.Upstreams {
+		host = strings.ToLower(host)
+		if (hostname == host || strings.HasSuffix(hostname, "."+host)) && len(host) > len(matched) {
+			matched = host
+			proxy = p
+		}
+	}
+	return proxy, matched != ""
+}
+
+// getProxyEnv returns the proxy environment variables of the child process(pnpm and git)
+// that connects to the upstream. The child process may connect to other hosts(e.g. the tarball
+// hosts), so the "direct" upstreams are added to the `no_proxy` list as well.
+func getProxyEnv(upstream string) []string {
+	u, err := url.Parse(upstream)
+	if err != nil || cfg == nil {
+		return nil
+	}
+	httpProxy, httpsProxy := cfg.Proxy.Http, cfg.Proxy.Https
+	if proxy, ok := matchUpstreamProxy(u.Hostname()); ok && proxy != "direct" {
+		httpProxy, httpsProxy = proxy, proxy
+	}
+	if httpProxy == "" && httpsProxy == "" {
+		return []string{"no_proxy=*", "NO_PROXY=*", "npm_config_noproxy=*"}
+	}
+	directHosts := []string{}
+	for host, proxy := range cfg.Proxy.Upstreams {
+		if proxy == "direct" {
+			directHosts = append(directHosts, strings.ToLower(host))
+		}
+	}
+	sort.Strings(directHosts)
+	noProxy := directHosts
+	if cfg.Proxy.NoProxy != "" {
+		noProxy = append([]string{cfg.Proxy.NoProxy}, directHosts...)
+	}
+	env := []string{}
+	if httpProxy != "" {
+		env = append(env, "http_proxy="+httpProxy, "HTTP_PROXY="+httpProxy, "npm_config_proxy="+httpProxy)
+	}
+	if httpsProxy != "" {
+		env = append(env, "https_proxy="+httpsProxy, "HTTPS_PROXY="+httpsProxy, "npm_config_https_proxy="+httpsProxy)
+	}
+	if u.Scheme == "http" && httpProxy != "" {
+		env = append(env, "all_proxy="+httpProxy, "ALL_PROXY="+httpProxy)
+	} else if httpsProxy != "" {
+		env = append(env, "all_proxy="+httpsProxy, "ALL_PROXY="+httpsProxy)
+	}
+	if len(noProxy) > 0 {
+		v := strings.Join(noProxy, ",")
+		env = append(env, "no_proxy="+v, "NO_PROXY="+v, "npm_config_noproxy="+v)
+	}
+	return env
+}
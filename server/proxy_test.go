@@ -0,0 +1,86 @@
+package server
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/esm-dev/esm.sh/server/config"
+)
+
+func TestGetProxyURL(t *testing.T) {
+	defer func() {
+		proxyFuncOnce = sync.Once{}
+	}()
+	withConfig(t, &config.Config{
+		Proxy: config.Proxy{
+			Https:   "http://proxy.acme.com:8080",
+			NoProxy: "localhost,.internal.acme.com",
+			Upstreams: map[string]string{
+				"github.com":       "socks5://127.0.0.1:1080",
+				"npm.acme.com":     "direct",
+				"registry.acme.io": "https://proxy2.acme.com",
+				"acme.io":          "direct",
+			},
+		},
+	})
+	tests := map[string]string{
+		"https://registry.npmjs.org/react":         "http://proxy.acme.com:8080",
+		"http://registry.npmjs.org/react":          "",
+		"https://github.com/esm-dev/esm.sh":        "socks5://127.0.0.1:1080",
+		"https://codeload.github.com/esm-dev/x":    "socks5://127.0.0.1:1080",
+		"https://npm.acme.com/react":               "",
+		"https://registry.acme.io/react":           "https://proxy2.acme.com",
+		"https://registry.internal.acme.com/react": "",
+	}
+	for upstream, expected := range tests {
+		u, _ := url.Parse(upstream)
+		proxy, err := getProxyURL(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (proxy == nil && expected != "") || (proxy != nil && proxy.String() != expected) {
+			t.Fatalf("getProxyURL(%s): expected %q, got %v", upstream, expected, proxy)
+		}
+	}
+}
+
+func TestGetProxyEnv(t *testing.T) {
+	defer func() {
+		proxyFuncOnce = sync.Once{}
+	}()
+	withConfig(t, &config.Config{
+		Proxy: config.Proxy{
+			Https:   "http://proxy.acme.com:8080",
+			NoProxy: "localhost",
+			Upstreams: map[string]string{
+				"acme.com":     "socks5://127.0.0.1:1080",
+				"npm.acme.com": "direct",
+			},
+		},
+	})
+
+	env := strings.Join(getProxyEnv("https://registry.npmjs.org/"), " ")
+	if !strings.Contains(env, "https_proxy=http://proxy.acme.com:8080") || !strings.Contains(env, "no_proxy=localhost,npm.acme.com") {
+		t.Fatalf("unexpected env: %s", env)
+	}
+	// the most specific upstream wins
+	env = strings.Join(getProxyEnv("https://npm.acme.com/"), " ")
+	if !strings.Contains(env, "https_proxy=http://proxy.acme.com:8080") || !strings.Contains(env, "no_proxy=localhost,npm.acme.com") {
+		t.Fatalf("unexpected env: %s", env)
+	}
+	env = strings.Join(getProxyEnv("https://git.acme.com/"), " ")
+	if !strings.Contains(env, "https_proxy=socks5://127.0.0.1:1080") {
+		t.Fatalf("unexpected env: %s", env)
+	}
+}
+
+func TestGetProxyEnvWithoutConfig(t *testing.T) {
+	if env := getProxyEnv("https://github.com/"); env != nil {
+		t.Fatalf("unexpected env: %v", env)
+	}
+	if _, ok := matchUpstreamProxy("github.com"); ok {
+		t.Fatal("should not match any upstream")
+	}
+}
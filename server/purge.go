@@ -0,0 +1,228 @@
+package server
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/esm-dev/esm.sh/server/storage"
+)
+
+// the db key prefix of the reverse-dependency index, the key of an index entry is
+// `_dependents:{pkg}@{version}:{buildID}`.
+const dependentsDBKeyPrefix = "_dependents:"
+
+// PurgeInput is the request of the `POST /_admin/purge` endpoint.
+type PurgeInput struct {
+	// the package to purge, e.g. "react@18.3.1", "gh/owner/repo@sha"
+	Package string `json:"package"`
+	// purge the builds that import the package as well
+	Dependents bool `json:"dependents,omitempty"`
+}
+
+// DependentsOutput is the response of the `GET /_admin/dependents` endpoint.
+type DependentsOutput struct {
+	Package    string   `json:"package"`
+	Dependents []string `json:"dependents"`
+}
+
+// PurgeOutput is the response of the `POST /_admin/purge` endpoint.
+type PurgeOutput struct {
+	Purged []string `json:"purged"`
+}
+
+func getDependentsDBKeyPrefix(pkg Pkg) string {
+	name := pkg.Name
+	if pkg.FromGithub {
+		name = "gh/" + name
+	}
+	return fmt.Sprintf("%s%s@%s:", dependentsDBKeyPrefix, name, pkg.Version)
+}
+
+// parseExactPackage parses the exact package version of the admin API, e.g. "react@18.3.1", "gh/owner/repo@sha".
+func parseExactPackage(spec string) (pkg Pkg, err error) {
+	if strings.HasPrefix(spec, "gh/") {
+		pkg.FromGithub = true
+		spec = strings.TrimPrefix(spec, "gh/")
+	}
+	pkg.Name, pkg.Version = splitPkgSpec(spec)
+	if pkg.FromGithub {
+		if strings.Count(pkg.Name, "/") != 1 || pkg.Version == "latest" || pkg.Version == "" || isUnsafePath(pkg.Name) || strings.ContainsAny(pkg.Version, "/:") {
+			err = fmt.Errorf("invalid package '%s'", spec)
+		}
+	} else if !validatePackageName(pkg.Name) || !regexpFullVersion.MatchString(pkg.Version) {
+		err = fmt.Errorf("invalid package '%s', the exact version is required", spec)
+	}
+	return
+}
+
+// indexDependents records the build as a dependent of the packages that it imports.
+func indexDependents(id string, imports PkgSlice) {
+	for _, pkg := range imports {
+		err := db.Put(getDependentsDBKeyPrefix(pkg)+id, []byte{'1'})
+		if err != nil {
+			log.Errorf("db: %v", err)
+		}
+	}
+}
+
+// parseBuildIDPkg returns the package of the build id, e.g. `v135/react-dom@18.3.1/es2022/react-dom.mjs`.
+func parseBuildIDPkg(id string) (pkg Pkg, ok bool) {
+	segments := strings.Split(id, "/")
+	if len(segments) < 3 {
+		return
+	}
+	segments = segments[1:]
+	if segments[0] == "gh" {
+		pkg.FromGithub = true
+		segments = segments[1:]
+	}
+	spec := segments[0]
+	if strings.HasPrefix(spec, "@") || pkg.FromGithub {
+		if len(segments) < 2 {
+			return
+		}
+		spec += "/" + segments[1]
+	}
+	pkg.Name, pkg.Version = splitPkgSpec(spec)
+	return pkg, pkg.Version != "latest"
+}
+
+// getDependents returns the IDs of the builds that import the package version by url.
+func getDependents(pkg Pkg) (ids []string, err error) {
+	prefix := getDependentsDBKeyPrefix(pkg)
+	keys, err := db.Keys(prefix)
+	if err != nil {
+		return
+	}
+	ids = make([]string, len(keys))
+	for i, key := range keys {
+		ids[i] = strings.TrimPrefix(key, prefix)
+	}
+	return
+}
+
+// purgePackage removes the esm builds of the package version, the builds are rebuilt on the next
+// request. The builds that import the package by url are removed as well if `dependents` is true,
+// that ensures the dependents don't import the purged version after an unpublish.
+func purgePackage(pkg Pkg, dependents bool) (purged []string, err error) {
+	ghPrefix := ""
+	if pkg.FromGithub {
+		ghPrefix = "/gh"
+	}
+	for _, bv := range []string{fmt.Sprintf("v%d", VERSION), "stable"} {
+		var keys []string
+		keys, err = db.Keys(fmt.Sprintf("%s%s/%s@%s/", bv, ghPrefix, pkg.Name, pkg.Version))
+		if err != nil {
+			return
+		}
+		for _, id := range keys {
+			err = purgeBuild(id)
+			if err != nil {
+				return
+			}
+			purged = append(purged, id)
+		}
+		// the build files are served from the storage without the db records, remove all the
+		// variants(target/dev/deps) of the package version
+		dir := fmt.Sprintf("builds/%s%s/%s@%s", bv, ghPrefix, pkg.Name, pkg.Version)
+		if bv == "stable" {
+			dir = fmt.Sprintf("builds/v%d%s/%s@%s", STABLE_VERSION, ghPrefix, pkg.Name, pkg.Version)
+		}
+		var files []string
+		files, err = fs.List(dir)
+		if err != nil && err != storage.ErrNotFound {
+			return
+		}
+		for _, name := range files {
+			err = removeStorageFile(name)
+			if err != nil {
+				return
+			}
+		}
+		err = nil
+	}
+	if dependents {
+		var ids []string
+		ids, err = getDependents(pkg)
+		if err != nil {
+			return
+		}
+		for _, id := range ids {
+			err = purgeBuild(id)
+			if err != nil {
+				return
+			}
+			purged = append(purged, id)
+		}
+	}
+	// the index entries are recorded again when the purged builds are rebuilt
+	err = removeDependentsEntries(purged)
+	if err != nil {
+		return
+	}
+	// the failed builds are retried after the purge
+	err = purgeBuildFailures(pkg)
+	if err != nil {
+		return
+	}
+	cache.Delete(fmt.Sprintf("npm:%s@%s", pkg.Name, pkg.Version))
+	return
+}
+
+// removeDependentsEntries removes the reverse-dependency index entries of the builds.
+func removeDependentsEntries(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	set := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+	keys, err := db.Keys(dependentsDBKeyPrefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		_, id, ok := strings.Cut(strings.TrimPrefix(key, dependentsDBKeyPrefix), ":")
+		if _, purged := set[id]; ok && purged {
+			err = db.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// purgeBuild removes the esm build record and the build files of it.
+func purgeBuild(id string) error {
+	err := db.Delete(id)
+	if err != nil {
+		return err
+	}
+	trackCachedBuild(id, -1)
+	cache.Delete("esm-build:" + id)
+	if strings.HasPrefix(id, "stable/") {
+		id = fmt.Sprintf("v%d/", STABLE_VERSION) + strings.TrimPrefix(id, "stable/")
+	}
+	savePath := path.Join("builds", id)
+	for _, name := range []string{
+		savePath,
+		savePath + ".map",
+		savePath + ".metafile.json",
+		strings.TrimSuffix(savePath, path.Ext(savePath)) + ".css",
+	} {
+		err = removeStorageFile(name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeStorageFile removes the file from the storage and the cache layer.
+func removeStorageFile(name string) error {
+	smallFileCache.Delete(name)
+	return fs.Remove(name)
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/esm-dev/esm.sh/server/config"
+	"github.com/esm-dev/esm.sh/server/storage"
+)
+
+func TestPurgePackage(t *testing.T) {
+	dir, err := os.MkdirTemp("", "esm-purge-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err = storage.OpenDB("bolt:" + path.Join(dir, "esm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err = storage.OpenCache("memory:test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err = storage.OpenFS("local:" + path.Join(dir, "storage"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		db.Close()
+		db = nil
+		cache = nil
+		fs = nil
+	}()
+
+	react := fmt.Sprintf("v%d/react@18.3.1/es2022/react.mjs", VERSION)
+	reactDev := fmt.Sprintf("v%d/react@18.3.1/es2022/react.development.mjs", VERSION)
+	reactNext := fmt.Sprintf("v%d/react@18.3.10/es2022/react.mjs", VERSION)
+	swr := fmt.Sprintf("v%d/swr@2.2.5/es2022/swr.mjs", VERSION)
+	preact := fmt.Sprintf("v%d/preact@10.19.2/es2022/preact.mjs", VERSION)
+	for _, id := range []string{react, reactDev, reactNext, swr, preact} {
+		db.Put(id, []byte("{}"))
+	}
+	indexDependents(swr, PkgSlice{{Name: "react", Version: "18.3.1"}})
+	indexDependents(preact, PkgSlice{{Name: "react", Version: "18.3.10"}})
+
+	dependents, err := getDependents(Pkg{Name: "react", Version: "18.3.1"})
+	if err != nil || len(dependents) != 1 || dependents[0] != swr {
+		t.Fatalf("invalid dependents: %v, %v", dependents, err)
+	}
+
+	// the build files, the variant of es2017 has no db record
+	reactES2017 := fmt.Sprintf("v%d/react@18.3.1/es2017/react.mjs", VERSION)
+	for _, name := range []string{react, react + ".map", react + ".metafile.json", reactDev, reactES2017, reactNext, swr} {
+		fs.WriteFile(path.Join("builds", name), strings.NewReader("export default 1;"))
+	}
+
+	purged, err := purgePackage(Pkg{Name: "react", Version: "18.3.1"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(purged)
+	if strings.Join(purged, ",") != reactDev+","+react {
+		t.Fatalf("invalid purged builds: %v", purged)
+	}
+	for name, exists := range map[string]bool{react: false, react + ".map": false, react + ".metafile.json": false, reactDev: false, reactES2017: false, reactNext: true, swr: true} {
+		if _, err := fs.Stat(path.Join("builds", name)); (err == nil) != exists {
+			t.Fatalf("the build file %s should exist: %v", name, exists)
+		}
+	}
+
+	db.Put(react, []byte("{}"))
+	purged, err = purgePackage(Pkg{Name: "react", Version: "18.3.1"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(purged, ",") != react+","+swr {
+		t.Fatalf("invalid purged builds: %v", purged)
+	}
+	for id, exists := range map[string]bool{react: false, swr: false, reactNext: true, preact: true} {
+		if value, _ := db.Get(id); (value != nil) != exists {
+			t.Fatalf("the build %s should exist: %v", id, exists)
+		}
+	}
+	if _, err := fs.Stat(path.Join("builds", swr)); err != storage.ErrNotFound {
+		t.Fatal("the build file of the dependent should be removed")
+	}
+	if keys, _ := db.Keys(getDependentsDBKeyPrefix(Pkg{Name: "react", Version: "18.3.1"})); len(keys) != 0 {
+		t.Fatalf("the index entries should be removed: %v", keys)
+	}
+
+	// the index entries of the purged builds are removed without `dependents`
+	_, err = purgePackage(Pkg{Name: "preact", Version: "10.19.2"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := db.Keys(dependentsDBKeyPrefix); len(keys) != 0 {
+		t.Fatalf("the stale index entries should be removed: %v", keys)
+	}
+
+	for spec, ok := range map[string]bool{
+		"react@18.3.1":        true,
+		"@types/react@18.3.1": true,
+		"gh/owner/repo@sha":   true,
+		"react":               false,
+		"react@^18":           false,
+		"gh/owner@sha":        false,
+		"gh/owner/../repo@v1": false,
+	} {
+		if _, err := parseExactPackage(spec); (err == nil) != ok {
+			t.Fatalf("parseExactPackage(%q): %v", spec, err)
+		}
+	}
+}
+
+func TestIsImportedByAllowedPackage(t *testing.T) {
+	dir, err := os.MkdirTemp("", "esm-allowlist-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err = storage.OpenDB("bolt:" + path.Join(dir, "esm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		db.Close()
+		db = nil
+	}()
+
+	app := fmt.Sprintf("v%d/@acme/app@1.0.0/es2022/app.mjs", VERSION)
+	swr := fmt.Sprintf("v%d/swr@2.2.5/es2022/swr.mjs", VERSION)
+	indexDependents(app, PkgSlice{{Name: "swr", Version: "2.2.5"}})
+	indexDependents(swr, PkgSlice{{Name: "react", Version: "18.3.1"}})
+
+	if pkg, ok := parseBuildIDPkg(app); !ok || pkg.Name != "@acme/app" || pkg.Version != "1.0.0" {
+		t.Fatalf("invalid package of the build id: %v", pkg)
+	}
+	if pkg, ok := parseBuildIDPkg(fmt.Sprintf("v%d/gh/owner/repo@abc123/es2022/repo.mjs", VERSION)); !ok || !pkg.FromGithub || pkg.Name != "owner/repo" || pkg.Version != "abc123" {
+		t.Fatalf("invalid package of the github build id: %v", pkg)
+	}
+
+	hc := &config.HostConfig{AllowList: []string{"@acme"}}
+	for name, allowed := range map[string]bool{"swr@2.2.5": true, "react@18.3.1": true, "react@18.3.10": false, "preact@10.19.2": false} {
+		pkg, _ := parseExactPackage(name)
+		if isImportedByAllowedPackage(hc, pkg) != allowed {
+			t.Fatalf("%s: expected allowed=%v", name, allowed)
+		}
+	}
+}
@@ -2,11 +2,23 @@ package server
 
 import (
 	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/ije/gox/utils"
 )
 
+// the db key prefix of the persisted queue tasks
+const queueDBKeyPrefix = "_queue:"
+
+// errBuildTimeout is the error of the build that exceeds the `buildTimeout` config.
+var errBuildTimeout = errors.New("timeout")
+
 // A Queue for esm build tasks
 type BuildQueue struct {
 	lock         sync.RWMutex
@@ -14,6 +26,7 @@ type BuildQueue struct {
 	tasks        map[string]*queueTask
 	processes    []*queueTask
 	maxProcesses int
+	avgDuration  time.Duration
 }
 
 type BuildQueueConsumer struct {
@@ -22,42 +35,74 @@ type BuildQueueConsumer struct {
 }
 
 type BuildOutput struct {
-	meta *ESMBuild
-	err  error
+	meta     *ESMBuild
+	err      error
+	duration time.Duration
 }
 
 type queueTask struct {
 	*BuildTask
 	inProcess bool
+	priority  int
 	el        *list.Element
 	createdAt time.Time
 	startedAt time.Time
 	consumers []*BuildQueueConsumer
+	cancel    context.CancelFunc
+	// the detached task is not canceled when all the consumers are gone, e.g. the background
+	// builds, or the builds that the consumers will retry after the timeout
+	detached bool
+	// the output of the task that is finished(or timed out) but not removed from the queue yet
+	output *BuildOutput
+	// the builds of the same ID that are added while the task is stopping, they are added to
+	// the queue again after the task returns
+	requeue []queueRequeue
 }
 
-func (t *queueTask) run() BuildOutput {
+type queueRequeue struct {
+	task       *BuildTask
+	consumer   *BuildQueueConsumer
+	consumerIp string
+}
+
+// run runs the build and returns the output, the done channel is closed when the build
+// goroutine returns, which is later than the output if the build is timed out.
+func (t *queueTask) run() (BuildOutput, chan struct{}) {
 	c := make(chan BuildOutput, 1)
+	done := make(chan struct{})
 	go func(c chan BuildOutput) {
+		defer close(done)
 		meta, err := t.Build()
-		c <- BuildOutput{meta, err}
+		c <- BuildOutput{meta: meta, err: err}
 	}(c)
 
+	reqID := t.requestID
+	if reqID == "" {
+		reqID = "-"
+	}
+
 	var output BuildOutput
 	select {
 	case output = <-c:
 		if output.err == nil {
-			log.Infof("build '%s' done in %v", t.ID(), time.Since(t.startedAt))
+			log.Infof("[%s] build '%s' done in %v", reqID, t.ID(), time.Since(t.startedAt))
+		} else if errors.Is(output.err, context.Canceled) {
+			log.Infof("[%s] build '%s' canceled at the %s stage", reqID, t.ID(), t.getStage())
 		} else {
-			log.Errorf("build '%s': %v", t.ID(), output.err)
+			log.Errorf("[%s] build '%s': %v", reqID, t.ID(), output.err)
+			recordBuildFailure(t.Pkg.Name, output.err)
 		}
-	case <-time.After(10 * time.Minute):
-		log.Errorf("build '%s': timeout(%v)", t.ID(), time.Since(t.startedAt))
+	case <-time.After(getBuildTimeout()):
+		// stop the build at the next stage, the output of the build is dropped
+		t.cancel()
+		log.Errorf("[%s] build '%s': timeout(%v) at the %s stage", reqID, t.ID(), time.Since(t.startedAt), t.getStage())
 		output = BuildOutput{
-			err: fmt.Errorf("build '%s': timeout(%v)", t.ID(), time.Since(t.startedAt)),
+			err: fmt.Errorf("build '%s': %w(%v) at the %s stage", t.ID(), errBuildTimeout, getBuildTimeout(), t.getStage()),
 		}
+		recordBuildFailure(t.Pkg.Name, output.err)
 	}
 
-	return output
+	return output, done
 }
 
 func newBuildQueue(maxProcesses int) *BuildQueue {
@@ -69,6 +114,14 @@ func newBuildQueue(maxProcesses int) *BuildQueue {
 	return q
 }
 
+// getBuildTimeout returns the timeout of a build by the `buildTimeout` config, default is 10 minutes.
+func getBuildTimeout() time.Duration {
+	if cfg.BuildTimeout == 0 {
+		return 10 * time.Minute
+	}
+	return time.Duration(cfg.BuildTimeout) * time.Second
+}
+
 // Len returns the number of tasks of the queue.
 func (q *BuildQueue) Len() int {
 	q.lock.RLock()
@@ -77,87 +130,218 @@ func (q *BuildQueue) Len() int {
 	return q.list.Len()
 }
 
-// Add adds a new build task.
+// Has checks whether the task is in the queue.
+func (q *BuildQueue) Has(id string) bool {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+
+	_, ok := q.tasks[id]
+	return ok
+}
+
+// EstimateWait returns the estimated waiting time of a new task,
+// by the EWMA of build durations and the number of the queued tasks.
+func (q *BuildQueue) EstimateWait() time.Duration {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+
+	avg := q.avgDuration
+	if avg == 0 {
+		avg = 10 * time.Second
+	}
+	rounds := q.list.Len()/q.maxProcesses + 1
+	return time.Duration(rounds) * avg
+}
+
+// Add adds a new build task, the identical builds(same build ID) are coalesced: the consumers
+// of the task in queue share its output instead of rebuilding.
 func (q *BuildQueue) Add(task *BuildTask, consumerIp string) *BuildQueueConsumer {
 	c := &BuildQueueConsumer{consumerIp, make(chan BuildOutput, 1)}
+	q.add(task, c, consumerIp)
+	return c
+}
+
+func (q *BuildQueue) add(task *BuildTask, c *BuildQueueConsumer, consumerIp string) {
 	q.lock.Lock()
 	t, ok := q.tasks[task.ID()]
-	if ok && consumerIp != "" {
-		t.consumers = append(t.consumers, c)
-	}
-	q.lock.Unlock()
-
 	if ok {
-		return c
+		if t.output != nil {
+			// the task is finished(or timed out) and being removed from the queue
+			c.C <- *t.output
+		} else if t.ctx.Err() != nil {
+			// the canceled task is stopping, wait for it instead of writing the same files at once
+			t.requeue = append(t.requeue, queueRequeue{task, c, consumerIp})
+		} else {
+			if consumerIp != "" {
+				t.consumers = append(t.consumers, c)
+			} else {
+				t.detached = true
+			}
+			// the high priority request takes over the pending task
+			if task.priority > t.priority {
+				t.priority = task.priority
+			}
+		}
+		q.lock.Unlock()
+		return
 	}
 
-	task.stage = "pending"
+	var cancel context.CancelFunc
+	task.setStage("pending")
+	priority := cfg.BuildPriority.Get(task.Pkg.Name)
+	if task.priority > priority {
+		priority = task.priority
+	}
+	task.ctx, cancel = context.WithCancel(context.Background())
 	t = &queueTask{
 		BuildTask: task,
+		priority:  priority,
 		createdAt: time.Now(),
 		consumers: []*BuildQueueConsumer{},
+		cancel:    cancel,
+		detached:  consumerIp == "",
 	}
 	if consumerIp != "" {
 		t.consumers = []*BuildQueueConsumer{c}
 	}
-	q.lock.Lock()
 	t.el = q.list.PushBack(t)
 	q.tasks[task.ID()] = t
 	q.lock.Unlock()
 
+	persistQueueTask(task)
 	q.next()
-
-	return c
 }
 
+// RemoveConsumer removes the consumer that stops waiting for the task(e.g. timeout), the task
+// keeps running for the retry of the consumer.
 func (q *BuildQueue) RemoveConsumer(task *BuildTask, c *BuildQueueConsumer) {
 	q.lock.Lock()
 	defer q.lock.Unlock()
 
 	t, ok := q.tasks[task.ID()]
 	if ok {
-		consumers := make([]*BuildQueueConsumer, len(t.consumers))
-		i := 0
-		for _, _c := range t.consumers {
-			if _c != c {
-				consumers[i] = c
-				i++
-			}
+		t.removeConsumer(c)
+		t.detached = true
+	}
+}
+
+// Cancel removes the consumer whose client is disconnected, the task is canceled if there is
+// no other consumer waiting for it and it's not detached. The pending task is removed from the
+// queue, the running task stops at the next stage.
+func (q *BuildQueue) Cancel(task *BuildTask, c *BuildQueueConsumer) {
+	q.lock.Lock()
+	t, ok := q.tasks[task.ID()]
+	if !ok {
+		q.lock.Unlock()
+		return
+	}
+	t.removeConsumer(c)
+	if len(t.consumers) > 0 || t.detached {
+		q.lock.Unlock()
+		return
+	}
+	t.cancel()
+	if t.inProcess {
+		q.lock.Unlock()
+		return
+	}
+	q.list.Remove(t.el)
+	delete(q.tasks, t.ID())
+	q.lock.Unlock()
+
+	unpersistQueueTask(t.BuildTask)
+	log.Infof("build '%s' canceled before start", t.ID())
+}
+
+func (t *queueTask) removeConsumer(c *BuildQueueConsumer) {
+	consumers := make([]*BuildQueueConsumer, 0, len(t.consumers))
+	for _, _c := range t.consumers {
+		if _c != c {
+			consumers = append(consumers, _c)
 		}
-		t.consumers = consumers[0:i]
 	}
+	t.consumers = consumers
 }
 
+// MaxProcesses returns the max number of the running tasks.
+func (q *BuildQueue) MaxProcesses() int {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+
+	return q.maxProcesses
+}
+
+// SetMaxProcesses changes the max number of the running tasks, the pending tasks are started
+// if the number is increased, the running tasks are not interrupted if it's decreased.
+func (q *BuildQueue) SetMaxProcesses(n int) {
+	q.lock.Lock()
+	q.maxProcesses = n
+	q.lock.Unlock()
+
+	q.next()
+}
+
+// next starts the pending tasks with the highest priority while the number of the running
+// tasks is less than `maxProcesses`.
 func (q *BuildQueue) next() {
-	var nextTask *queueTask
 	q.lock.Lock()
-	if len(q.processes) < q.maxProcesses {
+	defer q.lock.Unlock()
+
+	for len(q.processes) < q.maxProcesses {
+		var nextTask *queueTask
 		for el := q.list.Front(); el != nil; el = el.Next() {
 			t, ok := el.Value.(*queueTask)
-			if ok && !t.inProcess {
+			if ok && !t.inProcess && (nextTask == nil || t.priority > nextTask.priority) {
 				nextTask = t
-				break
 			}
 		}
+		if nextTask == nil {
+			return
+		}
+
+		// mark the task in process before releasing the lock, so a task is never started twice
+		nextTask.inProcess = true
+		nextTask.startedAt = time.Now()
+		q.processes = append(q.processes, nextTask)
+		go q.wait(nextTask)
 	}
-	q.lock.Unlock()
+}
 
-	if nextTask == nil {
-		return
+func (q *BuildQueue) wait(t *queueTask) {
+	output, done := t.run()
+
+	duration := time.Since(t.startedAt)
+	output.duration = duration
+	journal.Write(t.BuildTask, output, duration)
+	// the canceled build is not a failure, it's rebuilt on the next request
+	canceled := output.err != nil && errors.Is(output.err, context.Canceled)
+	if !canceled {
+		if output.err != nil {
+			output.err = saveBuildFailure(t.BuildTask, output.err)
+			recordRecentFailure(t.BuildTask, output, duration)
+		}
+		notifyWebhooks(t.BuildTask, output, duration)
 	}
 
+	// the consumers get the output at once, the new consumers of the same build get the output
+	// until the task is removed from the queue. The canceled output is not shared, the build is
+	// started again for the new consumers.
 	q.lock.Lock()
-	nextTask.inProcess = true
-	q.processes = append(q.processes, nextTask)
+	if !canceled {
+		t.output = &output
+	}
+	consumers := t.consumers
+	t.consumers = nil
 	q.lock.Unlock()
 
-	go q.wait(nextTask)
-}
-
-func (q *BuildQueue) wait(t *queueTask) {
-	t.startedAt = time.Now()
+	for _, c := range consumers {
+		c.C <- output
+	}
 
-	output := t.run()
+	// the timed-out build keeps the slot until the build goroutine returns, so the number of
+	// the running builds never exceeds `maxProcesses`
+	<-done
+	t.cancel()
 
 	q.lock.Lock()
 	a := make([]*queueTask, len(q.processes))
@@ -171,12 +355,84 @@ func (q *BuildQueue) wait(t *queueTask) {
 	q.processes = a[0:i]
 	q.list.Remove(t.el)
 	delete(q.tasks, t.ID())
+	if output.err == nil {
+		d := time.Since(t.startedAt)
+		if q.avgDuration == 0 {
+			q.avgDuration = d
+		} else {
+			q.avgDuration = (q.avgDuration*4 + d) / 5
+		}
+	}
+	requeue := t.requeue
 	q.lock.Unlock()
 
+	unpersistQueueTask(t.BuildTask)
+
+	for _, r := range requeue {
+		q.add(r.task, r.consumer, r.consumerIp)
+	}
+
 	// call next task
 	q.next()
+}
 
-	for _, c := range t.consumers {
-		c.C <- output
+// persistQueueTask saves the task to the db, the pending tasks are resumed after restart.
+func persistQueueTask(task *BuildTask) {
+	if db == nil {
+		return
+	}
+	err := db.Put(queueDBKeyPrefix+task.ID(), utils.MustEncodeJSON(newJournalRecord(task)))
+	if err != nil {
+		log.Errorf("db: %v", err)
+	}
+}
+
+func unpersistQueueTask(task *BuildTask) {
+	if db == nil {
+		return
+	}
+	err := db.Delete(queueDBKeyPrefix + task.ID())
+	if err != nil {
+		log.Errorf("db: %v", err)
+	}
+}
+
+// restoreBuildQueue resumes the pending tasks of the queue before restart, the tasks
+// that have been built are skipped.
+func restoreBuildQueue() {
+	keys, err := db.Keys(queueDBKeyPrefix)
+	if err != nil {
+		log.Errorf("restore queue: %v", err)
+		return
+	}
+	n := 0
+	for _, key := range keys {
+		id := strings.TrimPrefix(key, queueDBKeyPrefix)
+		var record JournalRecord
+		data, err := db.Get(key)
+		if err == nil && data != nil {
+			err = json.Unmarshal(data, &record)
+		}
+		var task *BuildTask
+		if err == nil && data != nil {
+			task, err = record.toBuildTask()
+		}
+		if err != nil || data == nil || task.ID() != id {
+			log.Warnf("restore queue: invalid task %s", id)
+			db.Delete(key)
+			continue
+		}
+		if record.Target != "types" && record.Target != "raw" {
+			if _, ok := queryESMBuild(id); ok {
+				db.Delete(key)
+				continue
+			}
+		}
+		task.requestID = record.RequestID
+		buildQueue.Add(task, "")
+		n++
+	}
+	if n > 0 {
+		log.Infof("restore queue: %d pending builds resumed", n)
 	}
 }
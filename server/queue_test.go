@@ -0,0 +1,307 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/esm-dev/esm.sh/server/config"
+	"github.com/esm-dev/esm.sh/server/storage"
+	"github.com/ije/rex"
+)
+
+func TestRestoreBuildQueue(t *testing.T) {
+	dir, err := os.MkdirTemp("", "esm-queue-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	withConfig(t, &config.Config{})
+	db, err = storage.OpenDB("bolt:" + path.Join(dir, "esm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err = storage.OpenCache("memory:test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err = storage.OpenFS("local:" + path.Join(dir, "storage"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		db.Close()
+		db = nil
+		cache = nil
+		fs = nil
+		buildQueue = nil
+	}()
+
+	newTask := func(name string) *BuildTask {
+		task := newTestBuildTask(Pkg{Name: name, Version: "1.0.0"}, "es2022")
+		task.CdnOrigin = "https://esm.sh"
+		return task
+	}
+
+	// the queue without processes never runs the tasks
+	buildQueue = newBuildQueue(0)
+	foo := newTask("foo")
+	bar := newTask("bar")
+	buildQueue.Add(foo, "")
+	buildQueue.Add(bar, "")
+	keys, err := db.Keys(queueDBKeyPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 persisted tasks, got %d", len(keys))
+	}
+
+	// `bar` has been built before restart
+	err = db.Put(bar.ID(), []byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fs.WriteFile(path.Join("builds", bar.ID()), strings.NewReader("export default 1;"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buildQueue = newBuildQueue(0)
+	restoreBuildQueue()
+	if !buildQueue.Has(foo.ID()) {
+		t.Fatalf("the task %s should be resumed", foo.ID())
+	}
+	if buildQueue.Has(bar.ID()) {
+		t.Fatalf("the built task %s should be skipped", bar.ID())
+	}
+	keys, _ = db.Keys(queueDBKeyPrefix)
+	if len(keys) != 1 {
+		t.Fatalf("expected 1 persisted task, got %d", len(keys))
+	}
+}
+
+func TestBuildQueuePriority(t *testing.T) {
+	withConfig(t, &config.Config{
+		BuildPriority:  config.BuildPriority{"react": 5},
+		PriorityTokens: map[string]int{"0123456789abcdef": 100},
+	})
+
+	req, _ := http.NewRequest("GET", "https://esm.sh/react@18.2.0", nil)
+	ctx := &rex.Context{R: req}
+	if getRequestPriority(ctx) != 0 {
+		t.Fatal("the request without priority token should not be prioritized")
+	}
+	req.Header.Set("Authorization", "Bearer 0123456789abcdef")
+	if getRequestPriority(ctx) != 0 {
+		t.Fatal("the request without `X-Esm-Priority` header should not be prioritized")
+	}
+	req.Header.Set("X-Esm-Priority", "high")
+	if getRequestPriority(ctx) != 100 {
+		t.Fatal("the request should be prioritized")
+	}
+	if auth("0123456789secret")(ctx) == nil {
+		t.Fatal("the priority token should not pass the `authSecret` check")
+	}
+	req.Header.Set("Authorization", "Bearer 0123456789secret")
+	if auth("0123456789secret")(ctx) != nil {
+		t.Fatal("the secret should pass the `authSecret` check")
+	}
+
+	q := newBuildQueue(0)
+	newTask := func(priority int) *BuildTask {
+		task := newTestBuildTask(Pkg{Name: "react", Version: "18.2.0"}, "es2022")
+		task.priority = priority
+		return task
+	}
+	task := newTask(0)
+	q.Add(task, "")
+	if p := q.tasks[task.ID()].priority; p != 5 {
+		t.Fatalf("expected priority 5, got %d", p)
+	}
+	// the high priority request takes over the pending task
+	q.Add(newTask(100), "127.0.0.1")
+	if p := q.tasks[task.ID()].priority; p != 100 {
+		t.Fatalf("expected priority 100, got %d", p)
+	}
+}
+
+func TestBuildQueueCoalescing(t *testing.T) {
+	withConfig(t, &config.Config{})
+
+	q := newBuildQueue(0)
+	newTask := func() *BuildTask {
+		return newTestBuildTask(Pkg{Name: "react", Version: "18.2.0"}, "es2022")
+	}
+
+	var wg sync.WaitGroup
+	consumers := make([]*BuildQueueConsumer, 50)
+	for i := range consumers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			consumers[i] = q.Add(newTask(), fmt.Sprintf("10.0.0.%d", i))
+		}(i)
+	}
+	wg.Wait()
+	if q.Len() != 1 {
+		t.Fatalf("the identical builds should be coalesced, got %d tasks", q.Len())
+	}
+	task := newTask()
+	if n := len(q.tasks[task.ID()].consumers); n != 50 {
+		t.Fatalf("expected 50 consumers, got %d", n)
+	}
+
+	q.RemoveConsumer(task, consumers[0])
+	q.RemoveConsumer(task, consumers[1])
+	for _, c := range q.tasks[task.ID()].consumers {
+		if c == consumers[0] || c == consumers[1] {
+			t.Fatal("the removed consumer should not be in the task")
+		}
+	}
+	if n := len(q.tasks[task.ID()].consumers); n != 48 {
+		t.Fatalf("expected 48 consumers, got %d", n)
+	}
+}
+
+func TestBuildQueueCancel(t *testing.T) {
+	withConfig(t, &config.Config{})
+
+	q := newBuildQueue(0)
+	newTask := func(name string) *BuildTask {
+		return newTestBuildTask(Pkg{Name: name, Version: "1.0.0"}, "es2022")
+	}
+
+	a := newTask("a")
+	c1 := q.Add(a, "10.0.0.1")
+	c2 := q.Add(newTask("a"), "10.0.0.2")
+	q.Cancel(a, c1)
+	if !q.Has(a.ID()) || a.context().Err() != nil {
+		t.Fatal("the task should not be canceled while another consumer is waiting")
+	}
+	q.Cancel(a, c2)
+	if q.Has(a.ID()) || a.context().Err() == nil {
+		t.Fatal("the task should be canceled after all the consumers are gone")
+	}
+
+	// the canceled task is replaced by the new request
+	c3 := q.Add(newTask("a"), "10.0.0.3")
+	if !q.Has(a.ID()) || q.tasks[a.ID()].context().Err() != nil {
+		t.Fatal("the new request should start a new task")
+	}
+
+	// the background build is not canceled
+	b := newTask("b")
+	c4 := q.Add(b, "10.0.0.4")
+	q.Add(newTask("b"), "")
+	q.Cancel(b, c4)
+	if !q.Has(b.ID()) || b.context().Err() != nil {
+		t.Fatal("the detached task should not be canceled")
+	}
+
+	// the task is kept for the retry of the consumer after the timeout
+	q.RemoveConsumer(a, c3)
+	q.Cancel(a, q.Add(newTask("a"), "10.0.0.5"))
+	if !q.Has(a.ID()) {
+		t.Fatal("the task should be kept after the timeout")
+	}
+
+	// the new request waits for the running task that is stopping
+	d := newTask("d")
+	c5 := q.Add(d, "10.0.0.6")
+	q.tasks[d.ID()].inProcess = true
+	q.Cancel(d, c5)
+	q.Add(newTask("d"), "10.0.0.7")
+	if rt := q.tasks[d.ID()]; rt.BuildTask != d || len(rt.requeue) != 1 || len(rt.consumers) != 0 {
+		t.Fatal("the new request should wait for the canceled task")
+	}
+
+	// the new request of the finished task gets the output at once
+	e := newTask("e")
+	q.Add(e, "10.0.0.8")
+	q.tasks[e.ID()].output = &BuildOutput{err: errors.New("oops")}
+	select {
+	case output := <-q.Add(newTask("e"), "10.0.0.9").C:
+		if output.err == nil || output.err.Error() != "oops" {
+			t.Fatalf("invalid output: %v", output.err)
+		}
+	default:
+		t.Fatal("the finished task should share the output")
+	}
+}
+
+func TestBuildQueueFailure(t *testing.T) {
+	dir := t.TempDir()
+	var err error
+	withConfig(t, &config.Config{BuildTimeout: 60})
+	db, err = storage.OpenDB("bolt:" + path.Join(dir, "esm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		db.Close()
+		db = nil
+	}()
+
+	newTask := func(name string) *BuildTask {
+		return newTestBuildTask(Pkg{Name: name, Version: "1.0.0"}, "es2022")
+	}
+	if getBuildTimeout() != time.Minute {
+		t.Fatalf("invalid build timeout: %v", getBuildTimeout())
+	}
+
+	// the timed-out build fails fast instead of hanging again
+	task := newTask("hang")
+	err = saveBuildFailure(task, fmt.Errorf("build '%s': %w", task.ID(), errBuildTimeout))
+	if failure, ok := err.(*BuildFailure); !ok || failure.Status != 504 {
+		t.Fatalf("the timeout should be recorded, got %v", err)
+	}
+	if failure := getBuildFailure(task.ID()); failure == nil || failure.Status != 504 {
+		t.Fatalf("should be the timeout failure, got %v", failure)
+	}
+
+	// the other errors are recorded by the `cacheTTL.failure` config
+	task = newTask("missing")
+	if err = saveBuildFailure(task, errors.New("npm: package 'missing' not found")); getBuildFailure(task.ID()) != nil {
+		t.Fatalf("the failure should not be recorded by default, got %v", err)
+	}
+	cfg.CacheTTL.Failure = 60
+	saveBuildFailure(task, errors.New("npm: package 'missing' not found"))
+	if failure := getBuildFailure(task.ID()); failure == nil || failure.Status != 404 {
+		t.Fatalf("invalid failure: %v", failure)
+	}
+	err = purgeBuildFailures(task.Pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if getBuildFailure(task.ID()) != nil {
+		t.Fatal("the failure should be purged")
+	}
+
+	// the expired record is removed
+	data, _ := json.Marshal(BuildFailure{Message: "esbuild: error", Status: 500, ExpiresAt: time.Now().Unix() - 1})
+	db.Put(buildFailureDBKeyPrefix+task.ID(), data)
+	if getBuildFailure(task.ID()) != nil {
+		t.Fatal("the expired record should be ignored")
+	}
+	if data, _ := db.Get(buildFailureDBKeyPrefix + task.ID()); data != nil {
+		t.Fatal("the expired record should be removed")
+	}
+
+	// the expired records that are not requested again are swept
+	db.Put(buildFailureDBKeyPrefix+task.ID(), data)
+	if n, err := removeExpiredBuildFailures(); err != nil || n != 1 {
+		t.Fatalf("should remove 1 expired record, got %d, %v", n, err)
+	}
+	if getBuildFailure(newTask("hang").ID()) == nil {
+		t.Fatal("the unexpired record should be kept")
+	}
+}
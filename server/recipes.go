@@ -0,0 +1,165 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/ije/gox/utils"
+)
+
+// the interval to check the changes of the recipes directory
+const recipesReloadInterval = 10 * time.Second
+
+var (
+	recipes       []*Recipe
+	remoteRecipes []*Recipe // the recipes of the remote bundle, see `recipesSync`
+	recipesLock   sync.RWMutex
+)
+
+// Recipe is the build tweaks of a package that is defined in the `recipesDir` config to fix the
+// broken packages centrally, e.g. `{"package": "some-pkg", "versions": "<2.0.0", "entry": "./dist/index.mjs"}`.
+// The hash of the recipe is folded into the build id, so the builds are rebuilt when it changes.
+type Recipe struct {
+	// the package name
+	Package string `json:"package"`
+	// the semver range of the versions, default is all the versions
+	Versions string `json:"versions,omitempty"`
+	// the forced entry file of the package, e.g. "./dist/index.mjs"
+	Entry string `json:"entry,omitempty"`
+	// the extra defines of esbuild, e.g. `{"process.browser": "true"}`
+	Define map[string]string `json:"define,omitempty"`
+	// the aliases of the imports, e.g. `{"node-fetch": "cross-fetch"}`
+	Alias map[string]string `json:"alias,omitempty"`
+	// disable the minification of the build
+	NoMinify bool `json:"noMinify,omitempty"`
+	// the global variable that the UMD package attaches its exports to, e.g. "Chart"
+	Global string `json:"global,omitempty"`
+
+	hash       string
+	constraint *semver.Constraints
+}
+
+// parseRecipe parses and validates the recipe file.
+func parseRecipe(data []byte) (recipe *Recipe, err error) {
+	recipe = &Recipe{}
+	err = json.Unmarshal(data, recipe)
+	if err != nil {
+		return
+	}
+	if !validatePackageName(recipe.Package) {
+		return nil, fmt.Errorf("invalid package name '%s'", recipe.Package)
+	}
+	if recipe.Versions != "" {
+		recipe.constraint, err = semver.NewConstraint(recipe.Versions)
+		if err != nil {
+			return nil, fmt.Errorf("invalid versions '%s': %v", recipe.Versions, err)
+		}
+	}
+	if recipe.Entry != "" {
+		if isUnsafePath(recipe.Entry) {
+			return nil, fmt.Errorf("invalid entry '%s'", recipe.Entry)
+		}
+		recipe.Entry = "." + utils.CleanPath(recipe.Entry)
+	}
+	if recipe.Global != "" && !isValidGlobalName(recipe.Global) {
+		return nil, fmt.Errorf("invalid global '%s'", recipe.Global)
+	}
+	// the map keys are sorted by the json encoder, the hash is stable
+	sum := sha256.Sum256(utils.MustEncodeJSON(recipe))
+	recipe.hash = hex.EncodeToString(sum[:])[:10]
+	return
+}
+
+// loadRecipes loads the recipe files(`*.json`) of the directory, the invalid recipes are skipped.
+func loadRecipes(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Errorf("load recipes: %v", err)
+		return
+	}
+	list := []*Recipe{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(path.Join(dir, entry.Name()))
+		if err == nil {
+			var recipe *Recipe
+			recipe, err = parseRecipe(data)
+			if err == nil {
+				list = append(list, recipe)
+				continue
+			}
+		}
+		log.Errorf("load recipe %s: %v", entry.Name(), err)
+	}
+
+	recipesLock.Lock()
+	recipes = list
+	recipesLock.Unlock()
+
+	log.Infof("load recipes: %d recipes loaded", len(list))
+}
+
+// watchRecipes reloads the recipes when the files of the directory are changed.
+func watchRecipes(dir string) {
+	fingerprint := getRecipesFingerprint(dir)
+	for {
+		time.Sleep(recipesReloadInterval)
+		fp := getRecipesFingerprint(dir)
+		if fp != fingerprint {
+			fingerprint = fp
+			loadRecipes(dir)
+		}
+	}
+}
+
+func getRecipesFingerprint(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	lines := []string{}
+	for _, entry := range entries {
+		if fi, err := entry.Info(); err == nil && !entry.IsDir() {
+			lines = append(lines, fmt.Sprintf("%s:%d:%d", entry.Name(), fi.Size(), fi.ModTime().UnixNano()))
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, ",")
+}
+
+// getRecipe returns the recipe of the package version, the first matched recipe(by the file name) wins,
+// and the local recipes take precedence over the remote recipes.
+func getRecipe(pkg Pkg) (*Recipe, bool) {
+	if pkg.FromGithub || pkg.FromEsmsh {
+		return nil, false
+	}
+	recipesLock.RLock()
+	defer recipesLock.RUnlock()
+
+	for _, list := range [][]*Recipe{recipes, remoteRecipes} {
+		for _, recipe := range list {
+			if recipe.Package != pkg.Name {
+				continue
+			}
+			if recipe.constraint != nil {
+				v, err := semver.NewVersion(pkg.Version)
+				if err != nil || !recipe.constraint.Check(v) {
+					continue
+				}
+			}
+			return recipe, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// the max size of the recipe bundle
+const maxRecipeBundleSize = 4 * 1024 * 1024
+
+// RecipeBundle is the remote bundle of the recipes.
+type RecipeBundle struct {
+	// the monotonically increasing version of the bundle, e.g. the unix time of the release
+	Version int64             `json:"version"`
+	Recipes []json.RawMessage `json:"recipes"`
+}
+
+// the version of the remote recipes, the older bundles are rejected to prevent the rollback
+var remoteRecipesVersion int64
+
+// recipeBundleSigningMessage returns the signed message of the recipe bundle.
+func recipeBundleSigningMessage(digest []byte) []byte {
+	return []byte(fmt.Sprintf("esm.sh-recipes:v1\n%x", digest))
+}
+
+// loadRecipesPublicKey loads the Ed25519 public key(PKIX PEM) from the file.
+func loadRecipesPublicKey(filename string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("invalid public key: missing PEM block")
+	}
+	k, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := k.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("invalid public key: not an Ed25519 key")
+	}
+	return key, nil
+}
+
+// verifyRecipeBundle verifies the signature of the bundle and parses the recipes, the invalid
+// recipes of the bundle are skipped.
+func verifyRecipeBundle(key ed25519.PublicKey, data []byte, sig []byte) (version int64, list []*Recipe, err error) {
+	signature, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig)))
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid signature: %v", err)
+	}
+	digest := sha256.Sum256(data)
+	if !ed25519.Verify(key, recipeBundleSigningMessage(digest[:]), signature) {
+		return 0, nil, errors.New("signature mismatch")
+	}
+	var bundle RecipeBundle
+	err = json.Unmarshal(data, &bundle)
+	if err != nil {
+		return
+	}
+	if bundle.Version <= 0 {
+		return 0, nil, errors.New("missing bundle version")
+	}
+	version = bundle.Version
+	list = []*Recipe{}
+	for i, raw := range bundle.Recipes {
+		recipe, err := parseRecipe(raw)
+		if err != nil {
+			log.Warnf("recipes sync: skip recipe #%d: %v", i, err)
+			continue
+		}
+		list = append(list, recipe)
+	}
+	return
+}
+
+// getRecipeBundleCachePath returns the path of the verified bundle that is loaded on startup,
+// the signature is saved in the `.sig` file.
+func getRecipeBundleCachePath() string {
+	return path.Join(cfg.WorkDir, "recipes-bundle.json")
+}
+
+// loadCachedRecipeBundle loads the bundle that was pulled before restart.
+func loadCachedRecipeBundle(key ed25519.PublicKey) {
+	filename := getRecipeBundleCachePath()
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	sig, err := os.ReadFile(filename + ".sig")
+	if err != nil {
+		return
+	}
+	version, list, err := verifyRecipeBundle(key, data, sig)
+	if err == nil {
+		err = setRemoteRecipes(version, list)
+	}
+	if err != nil {
+		log.Warnf("recipes sync: invalid cached bundle: %v", err)
+	}
+}
+
+// setRemoteRecipes replaces the remote recipes, the bundle that is older than the current one is rejected.
+func setRemoteRecipes(version int64, list []*Recipe) error {
+	recipesLock.Lock()
+	defer recipesLock.Unlock()
+
+	if version < remoteRecipesVersion {
+		return fmt.Errorf("the bundle version %d is older than the current version %d", version, remoteRecipesVersion)
+	}
+	remoteRecipes = list
+	remoteRecipesVersion = version
+	return nil
+}
+
+// pullRecipeBundle fetches the bundle and the signature, the remote recipes are replaced if the
+// bundle is verified.
+func pullRecipeBundle(key ed25519.PublicKey, url string) (n int, err error) {
+	fetch := func(url string) ([]byte, error) {
+		res, err := httpClient.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != 200 {
+			return nil, fmt.Errorf("fetch %s: unexpected http status %d", url, res.StatusCode)
+		}
+		return io.ReadAll(io.LimitReader(res.Body, maxRecipeBundleSize))
+	}
+	data, err := fetch(url)
+	if err != nil {
+		return
+	}
+	sig, err := fetch(url + ".sig")
+	if err != nil {
+		return
+	}
+	version, list, err := verifyRecipeBundle(key, data, sig)
+	if err != nil {
+		return
+	}
+	err = setRemoteRecipes(version, list)
+	if err != nil {
+		return
+	}
+	if cfg.WorkDir != "" {
+		filename := getRecipeBundleCachePath()
+		if os.WriteFile(filename, data, 0644) == nil {
+			os.WriteFile(filename+".sig", sig, 0644)
+		}
+	}
+	return len(list), nil
+}
+
+// startRecipesSync pulls the recipe bundle periodically, the cached bundle is used until the
+// first pull succeeds.
+func startRecipesSync(key ed25519.PublicKey) {
+	for {
+		n, err := pullRecipeBundle(key, cfg.RecipesSync.URL)
+		if err != nil {
+			log.Errorf("recipes sync: %v", err)
+		} else {
+			log.Debugf("recipes sync: %d recipes pulled", n)
+		}
+		time.Sleep(time.Duration(cfg.RecipesSync.Interval) * time.Second)
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/esm-dev/esm.sh/server/config"
+)
+
+func TestRecipesSync(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := os.MkdirTemp("", "esm-recipes-sync-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	der, _ := x509.MarshalPKIXPublicKey(pub)
+	keyFile := path.Join(dir, "recipes.pub")
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644)
+	key, err := loadRecipesPublicKey(keyFile)
+	if err != nil || !key.Equal(pub) {
+		t.Fatalf("invalid public key: %v", err)
+	}
+
+	sign := func(bundle []byte) string {
+		digest := sha256.Sum256(bundle)
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(priv, recipeBundleSigningMessage(digest[:])))
+	}
+	bundle := []byte(`{"version": 2, "recipes": [{"package": "foo", "entry": "dist/index.mjs"}, {"package": "bar", "noMinify": true}, {"package": "baz", "entry": "../etc"}]}`)
+	sig := sign(bundle)
+	oldBundle := []byte(`{"version": 1, "recipes": [{"package": "foo", "entry": "dist/old.mjs"}]}`)
+	unversioned := []byte(`{"recipes": [{"package": "foo", "entry": "dist/old.mjs"}]}`)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bundle.json":
+			w.Write(bundle)
+		case "/bundle.json.sig":
+			w.Write([]byte(sig))
+		case "/tampered.json":
+			w.Write(append(bundle[:len(bundle)-1], ' ', '}'))
+		case "/tampered.json.sig":
+			w.Write([]byte(sig))
+		case "/old.json":
+			w.Write(oldBundle)
+		case "/old.json.sig":
+			w.Write([]byte(sign(oldBundle)))
+		case "/unversioned.json":
+			w.Write(unversioned)
+		case "/unversioned.json.sig":
+			w.Write([]byte(sign(unversioned)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	withConfig(t, &config.Config{WorkDir: dir})
+	defer func() {
+		recipes = nil
+		remoteRecipes = nil
+		remoteRecipesVersion = 0
+	}()
+
+	if _, err = pullRecipeBundle(key, ts.URL+"/tampered.json"); err == nil || remoteRecipes != nil {
+		t.Fatal("the tampered bundle should be rejected")
+	}
+	if _, err = pullRecipeBundle(key, ts.URL+"/missing.json"); err == nil {
+		t.Fatal("the missing bundle should be an error")
+	}
+	if _, err = pullRecipeBundle(key, ts.URL+"/unversioned.json"); err == nil || remoteRecipes != nil {
+		t.Fatal("the bundle without version should be rejected")
+	}
+	n, err := pullRecipeBundle(key, ts.URL+"/bundle.json")
+	if err != nil || n != 2 {
+		t.Fatalf("invalid bundle: %d, %v", n, err)
+	}
+	recipe, ok := getRecipe(Pkg{Name: "foo", Version: "1.0.0"})
+	if !ok || recipe.Entry != "./dist/index.mjs" {
+		t.Fatalf("invalid remote recipe of foo: %v", recipe)
+	}
+
+	// the older bundle can't roll back the recipes even if it's signed
+	if _, err = pullRecipeBundle(key, ts.URL+"/old.json"); err == nil {
+		t.Fatal("the older bundle should be rejected")
+	}
+	if recipe, _ = getRecipe(Pkg{Name: "foo", Version: "1.0.0"}); recipe.Entry != "./dist/index.mjs" {
+		t.Fatalf("the recipes should not be rolled back: %v", recipe)
+	}
+
+	// the local recipes take precedence over the remote recipes
+	recipes = []*Recipe{{Package: "foo", Entry: "./index.js"}}
+	if recipe, _ = getRecipe(Pkg{Name: "foo", Version: "1.0.0"}); recipe.Entry != "./index.js" {
+		t.Fatalf("the local recipe should win: %v", recipe)
+	}
+
+	// the verified bundle is cached for the restarts
+	remoteRecipes = nil
+	remoteRecipesVersion = 0
+	loadCachedRecipeBundle(key)
+	if _, ok = getRecipe(Pkg{Name: "bar", Version: "1.0.0"}); !ok {
+		t.Fatal("the cached bundle should be loaded")
+	}
+}
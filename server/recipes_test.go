@@ -0,0 +1,83 @@
+package server
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestRecipes(t *testing.T) {
+	dir, err := os.MkdirTemp("", "esm-recipes-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() {
+		recipes = nil
+	}()
+
+	os.WriteFile(path.Join(dir, "a.json"), []byte(`{"package": "foo", "versions": "<2.0.0", "entry": "dist/index.mjs", "noMinify": true}`), 0644)
+	os.WriteFile(path.Join(dir, "b.json"), []byte(`{"package": "foo", "define": {"process.browser": "true"}}`), 0644)
+	os.WriteFile(path.Join(dir, "c.json"), []byte(`{"package": "bar", "entry": "../../etc/passwd"}`), 0644)
+	os.WriteFile(path.Join(dir, "d.json"), []byte(`{"package": "baz", "versions": "not a range"}`), 0644)
+	os.WriteFile(path.Join(dir, "e.json"), []byte(`{"package": "qux", "global": "window.Qux"}`), 0644)
+	os.WriteFile(path.Join(dir, "README.md"), []byte(`# recipes`), 0644)
+	loadRecipes(dir)
+
+	if len(recipes) != 2 {
+		t.Fatalf("the invalid recipes should be skipped, got %d recipes", len(recipes))
+	}
+	recipe, ok := getRecipe(Pkg{Name: "foo", Version: "1.2.3"})
+	if !ok || recipe.Entry != "./dist/index.mjs" || !recipe.NoMinify {
+		t.Fatalf("invalid recipe of foo@1.2.3: %v", recipe)
+	}
+	recipe, ok = getRecipe(Pkg{Name: "foo", Version: "2.0.0"})
+	if !ok || recipe.Define["process.browser"] != "true" {
+		t.Fatalf("invalid recipe of foo@2.0.0: %v", recipe)
+	}
+	if _, ok = getRecipe(Pkg{Name: "foo", Version: "1.2.3", FromGithub: true}); ok {
+		t.Fatal("the recipes should not be applied to the github packages")
+	}
+	if _, ok = getRecipe(Pkg{Name: "bar", Version: "1.0.0"}); ok {
+		t.Fatal("the recipe of bar should be skipped")
+	}
+
+	args := BuildArgs{
+		alias:       map[string]string{},
+		deps:        PkgSlice{},
+		external:    newStringSet(),
+		treeShaking: newStringSet(),
+		conditions:  newStringSet(),
+	}
+	foo := Pkg{Name: "foo", Version: "1.2.3"}
+	prefix := encodeBuildArgsPrefix(args, foo, false)
+	if !strings.HasPrefix(prefix, "X-") {
+		t.Fatalf("the recipe should be folded into the build id: %s", prefix)
+	}
+	if p := encodeBuildArgsPrefix(args, foo, true); p != "" {
+		t.Fatalf("the recipe should not change the types: %s", p)
+	}
+	if p := encodeBuildArgsPrefix(args, Pkg{Name: "bar", Version: "1.0.0"}, false); p != "" {
+		t.Fatalf("the package without recipe should not have a prefix: %s", p)
+	}
+
+	task := &BuildTask{BuildArgs: args, Pkg: foo, Target: "es2022"}
+	id := task.ID()
+
+	// the build id changes when the recipe changes
+	fingerprint := getRecipesFingerprint(dir)
+	os.WriteFile(path.Join(dir, "a.json"), []byte(`{"package": "foo", "versions": "<2.0.0", "entry": "dist/index.js"}`), 0644)
+	if getRecipesFingerprint(dir) == fingerprint {
+		t.Fatal("the fingerprint should change")
+	}
+	loadRecipes(dir)
+	if p := encodeBuildArgsPrefix(args, foo, false); p == prefix || p == "" {
+		t.Fatalf("the build id should change with the recipe: %s", p)
+	}
+
+	// the task keeps the snapshot of the recipe that the build id is computed with
+	if recipe, ok := task.getRecipe(); !ok || recipe.Entry != "./dist/index.mjs" || !strings.Contains(id, "/"+prefix) {
+		t.Fatalf("the task should keep the snapshot of the recipe: %s %v", id, recipe)
+	}
+}
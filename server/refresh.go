@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ije/gox/utils"
+)
+
+// resolutionHits counts the top-level requests of the dist-tags/semver ranges(e.g. `react@latest`,
+// `react@^18`), the most requested ones are refreshed by the `refresh` job. The hits are halved after
+// each refresh, so the ranges that are not requested any more are dropped eventually.
+var resolutionHits = &hitCounter{hits: map[string]int{}, max: 10000}
+
+type hitCounter struct {
+	lock sync.Mutex
+	hits map[string]int
+	// the max number of the keys, 0 is unlimited
+	max int
+}
+
+func (c *hitCounter) add(key string) {
+	c.lock.Lock()
+	if _, ok := c.hits[key]; ok || c.max <= 0 || len(c.hits) < c.max {
+		c.hits[key]++
+	}
+	c.lock.Unlock()
+}
+
+// decay halves the hits and removes the keys without hits.
+func (c *hitCounter) decay() {
+	c.lock.Lock()
+	for key, n := range c.hits {
+		if n /= 2; n == 0 {
+			delete(c.hits, key)
+		} else {
+			c.hits[key] = n
+		}
+	}
+	c.lock.Unlock()
+}
+
+// drain returns the hits and resets the counter.
+func (c *hitCounter) drain() map[string]int {
+	c.lock.Lock()
+	hits := c.hits
+	c.hits = map[string]int{}
+	c.lock.Unlock()
+	return hits
+}
+
+// top returns the n most hit keys, the keys with the same hits are sorted alphabetically.
+func (c *hitCounter) top(n int) []string {
+	c.lock.Lock()
+	keys := make([]string, 0, len(c.hits))
+	hits := make(map[string]int, len(c.hits))
+	for key, v := range c.hits {
+		keys = append(keys, key)
+		hits[key] = v
+	}
+	c.lock.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if hits[keys[i]] != hits[keys[j]] {
+			return hits[keys[i]] > hits[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+// startRefresh re-resolves the most requested dist-tags/semver ranges periodically and pre-builds
+// the new versions, so the un-pinned users don't wait for a cold build after a release.
+func startRefresh() {
+	interval := time.Duration(cfg.Refresh.Interval) * time.Second
+	for {
+		time.Sleep(interval)
+		n := refreshResolutions(int(cfg.Refresh.Top))
+		resolutionHits.decay()
+		log.Infof("refresh: %d builds queued", n)
+	}
+}
+
+// countResolutionHit counts the top-level request of the dist-tag/semver range if the `refresh`
+// job is enabled, the requests of the exact versions are not counted.
+func countResolutionHit(pathname string, pkg Pkg) {
+	if cfg.Refresh.Top == 0 || pkg.FromGithub || pkg.FromEsmsh {
+		return
+	}
+	pkgName, _ := splitPkgPath(pathname)
+	name, version := splitPkgSpec(pkgName)
+	if name != pkg.Name {
+		// e.g. the JSR packages
+		return
+	}
+	version, _ = utils.SplitByFirstByte(version, '&')
+	if v, err := url.QueryUnescape(version); err == nil {
+		version = v
+	}
+	if version == "" {
+		version = "latest"
+	}
+	if !regexpFullVersion.MatchString(version) {
+		resolutionHits.add(pkg.Name + "@" + version)
+	}
+}
+
+// refreshResolutions re-resolves the top n dist-tags/semver ranges bypassing the cache and
+// queues the builds of the changed versions, it returns the number of the queued builds.
+func refreshResolutions(n int) (queued int) {
+	for _, spec := range resolutionHits.top(n) {
+		name, version := splitPkgSpec(spec)
+		cacheKey := fmt.Sprintf("npm:%s@%s", name, version)
+		last, _ := getLastResolution(cacheKey)
+		if cache != nil {
+			cache.Delete(cacheKey)
+		}
+		info, err := fetchPackageInfo(context.Background(), "", name, version)
+		if err != nil {
+			log.Warnf("refresh: %s: %v", spec, err)
+			continue
+		}
+		if info.Version == last.Version {
+			continue
+		}
+		debugf("resolver", name, "refresh: %s resolved to %s(was %s)", spec, info.Version, last.Version)
+		for _, task := range newRefreshBuildTasks(Pkg{Name: info.Name, Version: info.Version}) {
+			if _, ok := queryESMBuild(task.ID()); ok {
+				continue
+			}
+			buildQueue.Add(task, "")
+			queued++
+		}
+	}
+	return
+}
+
+// newRefreshBuildTasks returns the build tasks of the package for the `refresh.targets` config,
+// no task is returned if the origin is not configured.
+func newRefreshBuildTasks(pkg Pkg) (tasks []*BuildTask) {
+	if cfg.Origin == "" {
+		return nil
+	}
+	buildTargets := cfg.Refresh.Targets
+	if len(buildTargets) == 0 {
+		buildTargets = []string{"es2022"}
+	}
+	for _, target := range buildTargets {
+		if _, ok := targets[target]; !ok {
+			continue
+		}
+		tasks = append(tasks, &BuildTask{
+			BuildArgs:    newBuildArgs(),
+			Pkg:          pkg,
+			CdnOrigin:    cfg.Origin,
+			Target:       target,
+			BuildVersion: VERSION,
+		})
+	}
+	return
+}
+
+// splitPkgSpec splits `name@version` to the name and the version, the scope of the
+// name(e.g. `@types/react@latest`) is kept.
+func splitPkgSpec(spec string) (name string, version string) {
+	i := strings.LastIndexByte(spec, '@')
+	if i <= 0 {
+		return spec, "latest"
+	}
+	return spec[:i], spec[i+1:]
+}
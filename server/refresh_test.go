@@ -0,0 +1,64 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/esm-dev/esm.sh/server/config"
+)
+
+func TestRefreshTop(t *testing.T) {
+	c := &hitCounter{hits: map[string]int{}}
+	for _, spec := range []string{"react@latest", "vue@^3", "react@latest", "@types/react@^18", "vue@^3", "react@latest", "preact@latest"} {
+		c.add(spec)
+	}
+	top := c.top(3)
+	if strings.Join(top, ",") != "react@latest,vue@^3,@types/react@^18" {
+		t.Fatalf("invalid top: %v", top)
+	}
+
+	// the hits are halved and the keys without hits are dropped
+	c.decay()
+	if strings.Join(c.top(10), ",") != "react@latest,vue@^3" {
+		t.Fatalf("invalid top after decay: %v", c.top(10))
+	}
+	// the new keys are dropped if the counter is full
+	c = &hitCounter{hits: map[string]int{}, max: 1}
+	c.add("react@latest")
+	c.add("vue@^3")
+	c.add("react@latest")
+	if len(c.hits) != 1 || c.hits["react@latest"] != 2 {
+		t.Fatalf("invalid hits: %v", c.hits)
+	}
+
+	for spec, want := range map[string][2]string{
+		"react@latest":     {"react", "latest"},
+		"@types/react@^18": {"@types/react", "^18"},
+		"@types/react":     {"@types/react", "latest"},
+	} {
+		name, version := splitPkgSpec(spec)
+		if name != want[0] || version != want[1] {
+			t.Fatalf("splitPkgSpec(%q) = %s, %s", spec, name, version)
+		}
+	}
+
+	withConfig(t, &config.Config{Refresh: config.Refresh{Top: 10}})
+	resolutionHits.drain()
+	countResolutionHit("/react@^18/jsx-runtime", Pkg{Name: "react", Version: "18.3.1"})
+	countResolutionHit("/@types/react", Pkg{Name: "@types/react", Version: "18.3.1"})
+	countResolutionHit("/react@18.3.1", Pkg{Name: "react", Version: "18.3.1"})
+	countResolutionHit("/jsr/@std/path@1", Pkg{Name: "@jsr/std__path", Version: "1.0.0"})
+	if hits := resolutionHits.drain(); len(hits) != 2 || hits["react@^18"] != 1 || hits["@types/react@latest"] != 1 {
+		t.Fatalf("invalid resolution hits: %v", hits)
+	}
+
+	withConfig(t, &config.Config{Refresh: config.Refresh{Targets: []string{"es2022", "es3", "deno"}}})
+	if tasks := newRefreshBuildTasks(Pkg{Name: "react", Version: "18.3.1"}); len(tasks) != 0 {
+		t.Fatalf("should not queue builds without the origin: %d", len(tasks))
+	}
+	cfg.Origin = "https://esm.sh"
+	tasks := newRefreshBuildTasks(Pkg{Name: "react", Version: "18.3.1"})
+	if len(tasks) != 2 || tasks[0].ID() != "stable/react@18.3.1/es2022/react.mjs" || tasks[1].ID() != "stable/react@18.3.1/deno/react.mjs" {
+		t.Fatalf("invalid tasks: %v", tasks)
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/ije/rex"
+)
+
+// requestID generates a request id or uses the `X-Request-Id` header of the request,
+// the id is sent back in the response header and prefixed to the logs of the request.
+func requestID(errorLogger rex.Logger, accessLogger rex.Logger) rex.Handle {
+	return func(ctx *rex.Context) interface{} {
+		id := ctx.R.Header.Get("X-Request-Id")
+		if !isValidRequestID(id) {
+			id = newRequestID()
+			ctx.R.Header.Set("X-Request-Id", id)
+		}
+		ctx.SetHeader("X-Request-Id", id)
+		rex.ErrorLogger(&requestLogger{errorLogger, id})(ctx)
+		rex.AccessLogger(&requestLogger{accessLogger, id})(ctx)
+		return nil
+	}
+}
+
+// getRequestID returns the request id that is set by the `requestID` middleware.
+func getRequestID(ctx *rex.Context) string {
+	return ctx.R.Header.Get("X-Request-Id")
+}
+
+func newRequestID() string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// isValidRequestID checks the request id from the client, only `[a-zA-Z0-9_.-]` are allowed
+// to avoid log injection.
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+type requestLogger struct {
+	logger rex.Logger
+	id     string
+}
+
+func (l *requestLogger) Printf(format string, v ...interface{}) {
+	l.logger.Printf("[%s] %s", l.id, fmt.Sprintf(format, v...))
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/ije/rex"
+)
+
+// securityHeaders sets the `X-Content-Type-Options` and `Referrer-Policy` headers of the responses,
+// the modules are always served with the correct content type so `nosniff` is safe for them too.
+func securityHeaders() rex.Handle {
+	return func(ctx *rex.Context) interface{} {
+		if !cfg.SecurityHeaders.Disabled {
+			ctx.SetHeader("X-Content-Type-Options", "nosniff")
+			ctx.SetHeader("Referrer-Policy", cfg.SecurityHeaders.ReferrerPolicy)
+		}
+		return nil
+	}
+}
+
+// setHTMLSecurityHeaders sets the CSP of the HTML pages, the CSP is not sent with the modules
+// since it would restrict the workers that are loaded from the server.
+func setHTMLSecurityHeaders(ctx *rex.Context, origin string) {
+	if !cfg.SecurityHeaders.Disabled && cfg.SecurityHeaders.ContentSecurityPolicy != "" {
+		ctx.SetHeader("Content-Security-Policy", strings.ReplaceAll(cfg.SecurityHeaders.ContentSecurityPolicy, "{origin}", origin))
+	}
+}
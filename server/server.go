@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"net/http"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path"
 	"strings"
@@ -27,11 +26,14 @@ var (
 	db           storage.DataBase
 	fs           storage.FileSystem
 	buildQueue   *BuildQueue
+	journal      *BuildJournal
 	log          *logx.Logger
 	embedFS      EmbedFS
 	fetchLocks   sync.Map
 	installLocks sync.Map
+	workDirLocks sync.Map
 	purgeTimers  sync.Map
+	readOnly     bool
 )
 
 type EmbedFS interface {
@@ -41,13 +43,28 @@ type EmbedFS interface {
 // Serve serves ESM server
 func Serve(efs EmbedFS) {
 	var (
-		cfile string
-		isDev bool
-		err   error
+		cfile          string
+		isDev          bool
+		replay         bool
+		migrateFSPaths bool
+		err            error
 	)
 
+	// sub commands
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		configCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "prebuild" {
+		prebuildCommand(os.Args[2:])
+		return
+	}
+
 	flag.StringVar(&cfile, "config", "config.json", "the config file path")
 	flag.BoolVar(&isDev, "dev", false, "to run server in development mode")
+	flag.BoolVar(&readOnly, "read-only", false, "to run server in read-only mode(serving cached builds only)")
+	flag.BoolVar(&replay, "replay", false, "to replay the build journal to rebuild the missing modules")
+	flag.BoolVar(&migrateFSPaths, "migrate-fs-paths", false, "to escape the file paths of the local storage, then exit")
 	flag.Parse()
 
 	if !fileExists(cfile) {
@@ -59,9 +76,31 @@ func Serve(efs EmbedFS) {
 			fmt.Println(err.Error())
 			os.Exit(1)
 		}
+		for _, warning := range cfg.Warnings {
+			fmt.Println("Warning:", warning)
+		}
 		fmt.Println("Config loaded from", cfile)
 	}
 
+	if readOnly {
+		cfg.ReadOnly = true
+	}
+
+	if migrateFSPaths {
+		if !strings.HasPrefix(cfg.Storage, "local:") {
+			fmt.Println("The storage is not a local file system")
+			os.Exit(1)
+		}
+		root := strings.SplitN(strings.TrimPrefix(cfg.Storage, "local:"), "?", 2)[0]
+		n, err := storage.MigrateLocalFSPaths(root)
+		if err != nil {
+			fmt.Printf("Migrate file paths: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%d files renamed, please add the `?escape=true` option to the `storage` config\n", n)
+		return
+	}
+
 	if isDev {
 		cfg.LogLevel = "debug"
 		cwd, err := os.Getwd()
@@ -74,29 +113,31 @@ func Serve(efs EmbedFS) {
 		os.Setenv("NO_COLOR", "1") // disable log color in production
 		embedFS = efs
 	}
+	if cfg.AssetsDir != "" {
+		embedFS = &overlayFS{cfg.AssetsDir, embedFS}
+	}
 
 	log, err = logx.New(fmt.Sprintf("file:%s?buffer=32k", path.Join(cfg.LogDir, fmt.Sprintf("main-v%d.log", VERSION))))
 	if err != nil {
 		fmt.Printf("initiate logger: %v\n", err)
 		os.Exit(1)
 	}
-	log.SetLevelByName(cfg.LogLevel)
+	setLogLevel(cfg.LogLevel)
 
-	nodeInstallDir := os.Getenv("NODE_INSTALL_DIR")
-	if nodeInstallDir == "" {
-		nodeInstallDir = path.Join(cfg.WorkDir, "nodejs")
-	}
-	nodeVer, pnpmVer, err := checkNodejs(nodeInstallDir)
+	warnings, err := preflight()
 	if err != nil {
-		log.Fatalf("check nodejs: %v", err)
+		log.Fatalf("preflight: %v", err)
 	}
-	if cfg.NpmRegistry == "" {
-		output, err := exec.Command("npm", "config", "get", "registry").CombinedOutput()
-		if err == nil {
-			cfg.NpmRegistry = strings.TrimRight(strings.TrimSpace(string(output)), "/") + "/"
-		}
+	for _, warning := range warnings {
+		log.Warnf("preflight: %s", warning)
+	}
+	if cfg.ReadOnly {
+		readOnly = true
+		log.Info("server is running in read-only mode, only cached builds will be served")
+	} else if len(warnings) > 0 {
+		readOnly = true
+		log.Warn("server is running in degraded read-only mode, only cached builds will be served")
 	}
-	log.Infof("nodejs v%s installed, registry: %s, pnpm: %s", nodeVer, cfg.NpmRegistry, pnpmVer)
 
 	cache, err = storage.OpenCache(cfg.Cache)
 	if err != nil {
@@ -107,13 +148,67 @@ func Serve(efs EmbedFS) {
 	if err != nil {
 		log.Fatalf("init storage(fs,%s): %v", cfg.Storage, err)
 	}
+	// index the storage changes for the sync manifest of the replicas
+	if cfg.Sync.Token != "" {
+		syncIndex = newSyncIndexFS(fs)
+		fs = syncIndex
+	}
+	if cfg.SigningKey != "" {
+		signingKey, err = loadSigningKey(cfg.SigningKey)
+		if err != nil {
+			log.Fatalf("load signing key(%s): %v", cfg.SigningKey, err)
+		}
+		fs = &signedFS{fs, signingKey}
+	}
 
 	db, err = storage.OpenDB(cfg.Database)
 	if err != nil {
 		log.Fatalf("init storage(db,%s): %v", cfg.Database, err)
 	}
+	err = migrateDB(db, dbMigrations)
+	if err != nil {
+		log.Fatalf("migrate database: %v", err)
+	}
+
+	// the recipes are loaded before the build ids are computed
+	if cfg.RecipesDir != "" {
+		loadRecipes(cfg.RecipesDir)
+		go watchRecipes(cfg.RecipesDir)
+	}
+	if cfg.RecipesSync.URL != "" {
+		key, err := loadRecipesPublicKey(cfg.RecipesSync.PublicKey)
+		if err != nil {
+			log.Fatalf("load the public key of recipes sync(%s): %v", cfg.RecipesSync.PublicKey, err)
+		}
+		loadCachedRecipeBundle(key)
+		go startRecipesSync(key)
+	}
 
 	buildQueue = newBuildQueue(int(cfg.BuildConcurrency))
+	restoreWebhooks()
+	if cfg.Analytics.Enabled {
+		go startAnalytics()
+	}
+
+	if !readOnly {
+		journal, err = openBuildJournal(cfg.JournalFile)
+		if err != nil {
+			log.Fatalf("open build journal(%s): %v", cfg.JournalFile, err)
+		}
+		go restoreBuildQueue()
+		if replay {
+			go replayBuildJournal(cfg.JournalFile)
+		}
+		if cfg.Warmup != "" {
+			go warmup(cfg.Warmup)
+		}
+		if cfg.Refresh.Top > 0 {
+			go startRefresh()
+		}
+		if cfg.AutoConcurrency.Enabled {
+			go startAutoConcurrency()
+		}
+	}
 
 	var accessLogger *logx.Logger
 	if cfg.LogDir == "" {
@@ -126,35 +221,47 @@ func Serve(efs EmbedFS) {
 	}
 	accessLogger.SetQuite(true) // quite in terminal
 
-	// start node services process
-	go func() {
-		for {
-			err := startNodeServices()
-			if err != nil && err.Error() != "signal: interrupt" {
-				log.Warnf("node services exit: %v", err)
+	// start node services process, it's not required by the native cjs lexer
+	if !readOnly && cfg.CjsLexer != "native" {
+		go func() {
+			for {
+				err := startNodeServices()
+				if err != nil && err.Error() != "signal: interrupt" {
+					log.Warnf("node services exit: %v", err)
+				}
+				time.Sleep(time.Second / 10)
 			}
-			time.Sleep(time.Second / 10)
-		}
-	}()
+		}()
+	}
 
 	go restorePurgeTimers(path.Join(cfg.WorkDir, "npm"))
+	go countCachedBuilds()
+	go sweepBuildFailures()
+
+	if cfg.Sync.From != "" {
+		go startSync()
+	}
+
+	go startAlertMonitor()
 
 	if !cfg.NoCompress {
 		rex.Use(rex.Compression())
 	}
 	rex.Use(
-		rex.ErrorLogger(log),
-		rex.AccessLogger(accessLogger),
+		requestID(log, accessLogger),
 		rex.Header("Server", "esm.sh"),
+		securityHeaders(),
 		rex.Cors(rex.CORS{
 			AllowedOrigins: []string{"*"},
 			AllowedMethods: []string{
 				http.MethodGet,
 				http.MethodPost,
 			},
-			ExposedHeaders:   []string{"X-TypeScript-Types"},
+			ExposedHeaders:   []string{"X-TypeScript-Types", "X-Request-Id", "X-Esm-Signature", "X-Esm-Signature-Path", "X-Esm-Stale", "X-Esm-Pkg", "X-Esm-Target", "X-Esm-Env", "X-Esm-Version", "X-Esm-Cache", "X-Esm-Build-Duration", "X-Esm-Integrity", "X-Content-Sha256", "X-Esm-Fallback-Target"},
 			AllowCredentials: false,
 		}),
+		syncHandler(),
+		adminHandler(),
 		auth(cfg.AuthSecret),
 		apiHandler(),
 		esmHandler(),
@@ -188,11 +295,37 @@ func Serve(efs EmbedFS) {
 
 	// release resources
 	kill(nsPidFile)
+	journal.Close()
+	if cfg.Analytics.Enabled {
+		flushAnalytics(time.Now())
+	}
 	db.Close()
 	log.FlushBuffer()
 	accessLogger.FlushBuffer()
 }
 
+// configCommand runs the `esmd config <command>` commands.
+func configCommand(args []string) {
+	if len(args) == 0 || args[0] != "validate" {
+		fmt.Println("Usage: esmd config validate [--config=config.json]")
+		os.Exit(1)
+	}
+	var cfile string
+	fset := flag.NewFlagSet("config validate", flag.ExitOnError)
+	fset.StringVar(&cfile, "config", "config.json", "the config file path")
+	fset.Parse(args[1:])
+
+	c, err := config.Load(cfile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	for _, warning := range c.Warnings {
+		fmt.Println("Warning:", warning)
+	}
+	fmt.Printf("%s is valid\n", cfile)
+}
+
 func init() {
 	embedFS = &embed.FS{}
 	log = &logx.Logger{}
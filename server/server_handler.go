@@ -2,13 +2,17 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
+	"crypto/subtle"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -16,8 +20,10 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/esm-dev/esm.sh/server/config"
 	"github.com/esm-dev/esm.sh/server/storage"
 
 	"github.com/evanw/esbuild/pkg/api"
@@ -38,6 +44,9 @@ func apiHandler() rex.Handle {
 		if ctx.R.Method == "POST" || ctx.R.Method == "PUT" {
 			switch ctx.Path.String() {
 			case "/build":
+				if readOnly {
+					return rex.Err(http.StatusServiceUnavailable, "the server is running in read-only mode")
+				}
 				var input BuildInput
 				defer ctx.R.Body.Close()
 				switch ct := ctx.R.Header.Get("Content-Type"); ct {
@@ -168,23 +177,29 @@ func apiHandler() rex.Handle {
 				if err != nil {
 					return rex.Err(500, "failed to save code")
 				}
-				cdnOrigin := ctx.R.Header.Get("X-Real-Origin")
-				if cdnOrigin == "" {
-					cdnOrigin = cfg.Origin
+				cdnOrigin := getCdnOrigin(ctx)
+				return PublishOutput{
+					ID:        id,
+					URL:       fmt.Sprintf("%s/~%s", cdnOrigin, id),
+					BundleURL: fmt.Sprintf("%s/~%s?bundle", cdnOrigin, id),
 				}
-				if cdnOrigin == "" {
-					proto := "http"
-					if ctx.R.TLS != nil {
-						proto = "https"
-					}
-					// use the request host as the origin if not set in config.json
-					cdnOrigin = fmt.Sprintf("%s://%s", proto, ctx.R.Host)
+			case fmt.Sprintf("/v%d/import-map", VERSION):
+				var input ImportMapInput
+				defer ctx.R.Body.Close()
+				err := json.NewDecoder(ctx.R.Body).Decode(&input)
+				if err != nil {
+					return rex.Err(400, "failed to parse input: "+err.Error())
 				}
-				return map[string]interface{}{
-					"id":        id,
-					"url":       fmt.Sprintf("%s/~%s", cdnOrigin, id),
-					"bundleUrl": fmt.Sprintf("%s/~%s?bundle", cdnOrigin, id),
+				maxPackages := maxImportMapPackages
+				if _, ok := isPriorityToken(ctx); ok {
+					maxPackages = maxPriorityImportMapPackages
 				}
+				im, err := generateImportMap(getCdnOrigin(ctx), input, maxPackages)
+				if err != nil {
+					return rex.Err(400, err.Error())
+				}
+				ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
+				return im
 			default:
 				return rex.Err(404, "not found")
 			}
@@ -193,6 +208,24 @@ func apiHandler() rex.Handle {
 	}
 }
 
+// getCdnOrigin returns the origin of the module urls, it's the `X-Real-Origin` header of the
+// proxy or the `origin` config, or the request host if both are not set.
+func getCdnOrigin(ctx *rex.Context) string {
+	cdnOrigin := ctx.R.Header.Get("X-Real-Origin")
+	if cdnOrigin == "" {
+		cdnOrigin = cfg.Origin
+	}
+	if cdnOrigin == "" {
+		proto := "http"
+		if ctx.R.TLS != nil {
+			proto = "https"
+		}
+		// use the request host as the origin if not set in config.json
+		cdnOrigin = fmt.Sprintf("%s://%s", proto, ctx.R.Host)
+	}
+	return cdnOrigin
+}
+
 func esmHandler() rex.Handle {
 	startTime := time.Now()
 
@@ -204,19 +237,14 @@ func esmHandler() rex.Handle {
 			return rex.Status(404, "not found")
 		}
 
-		cdnOrigin := ctx.R.Header.Get("X-Real-Origin")
-		if cdnOrigin == "" {
-			cdnOrigin = cfg.Origin
-		}
-		if cdnOrigin == "" {
-			proto := "http"
-			if ctx.R.TLS != nil {
-				proto = "https"
-			}
-			// use the request host as the origin if not set in config.json
-			cdnOrigin = fmt.Sprintf("%s://%s", proto, ctx.R.Host)
+		// the pathname is cleaned by rex, reject the raw path that tries to traverse
+		// the directories instead of serving the cleaned one
+		if isUnsafePath(ctx.R.URL.Path) {
+			return rex.Status(400, "invalid path")
 		}
 
+		cdnOrigin := getCdnOrigin(ctx)
+
 		CTX_VERSION := VERSION
 		if ewv := ctx.R.Header.Get("X-Esm-Worker-Version"); ewv != "" && strings.HasPrefix(ewv, "v") && valid.IsNumber(ewv[1:]) {
 			CTX_VERSION, _ = strconv.Atoi(ewv[1:])
@@ -261,36 +289,39 @@ func esmHandler() rex.Handle {
 			html = bytes.ReplaceAll(html, []byte("{VERSION}"), []byte(fmt.Sprintf("%d", CTX_VERSION)))
 			html = bytes.ReplaceAll(html, []byte("{basePath}"), []byte(cfg.BasePath))
 			ctx.SetHeader("Cache-Control", fmt.Sprintf("public, max-age=%d", 10*60))
+			setHTMLSecurityHeaders(ctx, cdnOrigin)
 			return rex.Content("index.html", startTime, bytes.NewReader(html))
 
 		case "/status.json":
-			q := make([]map[string]interface{}, buildQueue.list.Len())
-			i := 0
 			buildQueue.lock.RLock()
+			q := make([]QueueTaskStatus, 0, buildQueue.list.Len())
 			for el := buildQueue.list.Front(); el != nil; el = el.Next() {
 				t, ok := el.Value.(*queueTask)
 				if ok {
-					m := map[string]interface{}{
-						"bundle":    t.Bundle,
-						"bv":        t.BuildVersion,
-						"consumers": t.consumers,
-						"createdAt": t.createdAt.Format(http.TimeFormat),
-						"dev":       t.Dev,
-						"inProcess": t.inProcess,
-						"pkg":       t.Pkg.String(),
-						"stage":     t.stage,
-						"target":    t.Target,
+					status := QueueTaskStatus{
+						ID:           t.ID(),
+						Bundle:       t.Bundle,
+						BuildVersion: t.BuildVersion,
+						Consumers:    t.consumers,
+						CreatedAt:    t.createdAt.Format(http.TimeFormat),
+						Dev:          t.Dev,
+						InProcess:    t.inProcess,
+						Priority:     t.priority,
+						Pkg:          t.Pkg.String(),
+						Stage:        t.getStage(),
+						Target:       t.Target,
 					}
 					if !t.startedAt.IsZero() {
-						m["startedAt"] = t.startedAt.Format(http.TimeFormat)
+						status.StartedAt = t.startedAt.Format(http.TimeFormat)
+						status.Elapsed = time.Since(t.startedAt).Round(time.Millisecond).String()
 					}
 					if len(t.deps) > 0 {
-						m["deps"] = t.deps.String()
+						status.Deps = t.deps.String()
 					}
-					q[i] = m
-					i++
+					q = append(q, status)
 				}
 			}
+			buildConcurrency := buildQueue.maxProcesses
 			buildQueue.lock.RUnlock()
 
 			n := 0
@@ -299,25 +330,65 @@ func esmHandler() rex.Handle {
 				return true
 			})
 
-			res, err := fetch(fmt.Sprintf("http://localhost:%d", cfg.NsPort))
-			if err != nil {
-				kill(nsPidFile)
-				return err
+			// node services are not started in read-only mode or with the native cjs lexer
+			var out []byte
+			if !readOnly && cfg.CjsLexer != "native" {
+				res, err := fetch(fmt.Sprintf("http://localhost:%d", cfg.NsPort))
+				if err != nil {
+					kill(nsPidFile)
+					return err
+				}
+				defer res.Body.Close()
+				out, err = io.ReadAll(res.Body)
+				if err != nil {
+					return err
+				}
+			}
+
+			ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
+			return StatusOutput{
+				BuildQueue:       q,
+				BuildConcurrency: buildConcurrency,
+				CachedBuilds:     int(atomic.LoadInt64(&cachedBuilds)),
+				PurgeTimers:      n,
+				NS:               string(out),
+				Version:          CTX_VERSION,
+				Uptime:           time.Since(startTime).String(),
+				ReadOnly:         readOnly,
+			}
+
+		case "/openapi.json":
+			ctx.SetHeader("Cache-Control", fmt.Sprintf("public, max-age=%d", 10*60))
+			return generateOpenAPI(cdnOrigin, cfg.BasePath)
+
+		case "/.well-known/esm-signing-key":
+			if signingKey == nil {
+				return rex.Status(404, "not found")
 			}
-			defer res.Body.Close()
-			out, err := io.ReadAll(res.Body)
+			publicKey, err := getSigningPublicKey()
 			if err != nil {
-				return err
+				return rex.Status(500, err.Error())
 			}
+			ctx.SetHeader("Content-Type", "application/x-pem-file")
+			ctx.SetHeader("Cache-Control", fmt.Sprintf("public, max-age=%d", 10*60))
+			return publicKey
 
-			ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
-			return map[string]interface{}{
-				"buildQueue":  q[:i],
-				"purgeTimers": n,
-				"ns":          string(out),
-				"version":     CTX_VERSION,
-				"uptime":      time.Since(startTime).String(),
+		case "/_deno.lock":
+			var entries []string
+			for _, entry := range strings.Split(ctx.Form.Value("modules"), ",") {
+				if entry = strings.TrimSpace(entry); entry != "" {
+					entries = append(entries, entry)
+				}
+			}
+			if len(entries) == 0 {
+				return rex.Status(400, "Missing `modules` query")
 			}
+			lock, err := generateDenoLock(ctx.R, cdnOrigin, entries)
+			if err != nil {
+				return rex.Status(500, err.Error())
+			}
+			ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
+			return lock
 
 		case "/esma-target":
 			return getTargetByUA(ctx.R.UserAgent())
@@ -356,6 +427,22 @@ func esmHandler() rex.Handle {
 			return rex.Status(404, "not found")
 		}
 
+		// the upstream urls fetched by a build in the audit mode
+		if strings.HasPrefix(pathname, "/_provenance/") {
+			if !cfg.Audit.Enabled {
+				return rex.Status(404, "not found")
+			}
+			provenance, err := getBuildProvenance(strings.TrimPrefix(pathname, "/_provenance/"))
+			if err != nil {
+				if err == storage.ErrNotFound {
+					return rex.Status(404, "not found")
+				}
+				return rex.Status(500, err.Error())
+			}
+			ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
+			return provenance
+		}
+
 		// serve embed assets
 		if strings.HasPrefix(pathname, "/embed/") {
 			data, err := embedFS.ReadFile("server" + pathname)
@@ -376,6 +463,7 @@ func esmHandler() rex.Handle {
 
 		var hasBuildVerPrefix bool
 		var hasStablePrefix bool
+		var hasTypesPrefix bool
 		var outdatedBuildVer string
 
 		// check build version prefix
@@ -394,6 +482,12 @@ func esmHandler() rex.Handle {
 			pathname = "/" + strings.Join(a[2:], "/")
 			hasBuildVerPrefix = true
 			outdatedBuildVer = a[1]
+		} else if strings.HasPrefix(pathname, typesPathPrefix+"/") && isDtsFile(pathname) {
+			// the types of the current build version, the `types` package is not affected
+			// since only the `.d.ts` files are served under the prefix
+			pathname = strings.TrimPrefix(pathname, typesPathPrefix)
+			hasBuildVerPrefix = true
+			hasTypesPrefix = true
 		}
 
 		// check if the request is from Deno runtime for the CLI script
@@ -473,7 +567,7 @@ func esmHandler() rex.Handle {
 				data, err := embedFS.ReadFile("server/embed/types" + pathname)
 				if err == nil {
 					ctx.SetHeader("Content-Type", "application/typescript; charset=utf-8")
-					ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
+					ctx.SetHeader("Cache-Control", getTypesCacheControl(hasTypesPrefix))
 					return rex.Content(pathname, startTime, bytes.NewReader(data))
 				}
 			}
@@ -498,8 +592,18 @@ func esmHandler() rex.Handle {
 			pathname = "/gh/" + pathname[5:]
 		}
 
+		// check `?registry` query
+		registry := ctx.Form.Value("registry")
+		if registry != "" {
+			if ret := checkRequestRegistry(ctx, registry); ret != nil {
+				return ret
+			}
+		} else if ret := checkRequestScope(ctx, strings.TrimPrefix(packageFullName, "*")); ret != nil {
+			return ret
+		}
+
 		// get package info
-		reqPkg, extraQuery, err := validatePkgPath(pathname)
+		reqPkg, extraQuery, err := validateRegistryPkgPath(registry, pathname)
 		if err != nil {
 			status := 500
 			message := err.Error()
@@ -516,12 +620,39 @@ func esmHandler() rex.Handle {
 			return rex.Status(404, "not found")
 		}
 
+		if registry == "" {
+			countResolutionHit(pathname, reqPkg)
+		}
+
+		// the registry is unavailable, the version is resolved from the last known resolution
+		redirectCacheControl := fmt.Sprintf("public, max-age=%d", cfg.CacheTTL.Redirect)
+		if reqPkg.stale {
+			ctx.SetHeader("X-Esm-Stale", "true")
+			redirectCacheControl = "no-cache"
+		}
+
+		// check the allow list of the host, the dependencies of the allowed packages are allowed as well
+		hostConfig := getHostConfig(ctx)
+		if !hostConfig.IsPackageAllowed(reqPkg.Name) && !(regexpFullVersion.MatchString(reqPkg.Version) && isImportedByAllowedPackage(hostConfig, reqPkg)) {
+			return rex.Status(403, "forbidden")
+		}
+
 		// fix url related `import.meta.url`
 		if hasBuildVerPrefix && endsWith(reqPkg.Subpath, ".wasm", ".json") {
 			extname := path.Ext(reqPkg.Subpath)
 			dir := path.Join(cfg.WorkDir, "npm", reqPkg.Name+"@"+reqPkg.Version)
+			if registry != "" {
+				dir = path.Join(cfg.WorkDir, "registries", registry, "npm", reqPkg.Name+"@"+reqPkg.Version)
+			}
 			if !dirExists(dir) {
-				err := installPackage(dir, reqPkg)
+				if readOnly {
+					return readOnlyError(ctx)
+				}
+				// the install is not bound to the request, the disconnected client must not kill
+				// pnpm in the shared work directory
+				installCtx, cancel := context.WithTimeout(context.Background(), getBuildTimeout())
+				err := installPackage(installCtx, dir, reqPkg, registry)
+				cancel()
 				if err != nil {
 					return rex.Status(500, err.Error())
 				}
@@ -562,7 +693,7 @@ func esmHandler() rex.Handle {
 
 		// redirect `/@types/PKG` to main dts files
 		if strings.HasPrefix(reqPkg.Name, "@types/") && (reqPkg.Submodule == "" || !strings.HasSuffix(reqPkg.Submodule, ".d.ts")) {
-			url := fmt.Sprintf("%s%s/v%d%s", cdnOrigin, cfg.BasePath, CTX_VERSION, pathname)
+			url := fmt.Sprintf("%s%s%s%s", cdnOrigin, cfg.BasePath, typesPathPrefix, pathname)
 			if reqPkg.Submodule == "" {
 				info, _, err := getPackageInfo("", reqPkg.Name, reqPkg.Version)
 				if err != nil {
@@ -601,6 +732,9 @@ func esmHandler() rex.Handle {
 		ghPrefix := ""
 		if reqPkg.FromGithub {
 			ghPrefix = "/gh"
+			if cfg.Github.RequirePinnedSha && !isPinnedGithubPath(pathname, reqPkg) {
+				return rex.Status(400, "the github module must be pinned to a commit sha(at least 10 characters), e.g. /gh/owner/repo@0123456789")
+			}
 		}
 
 		// redirect to the url with full package version
@@ -609,11 +743,11 @@ func esmHandler() rex.Handle {
 			eaSign := ""
 			subPath := ""
 			query := ""
-			if endsWith(pathname, ".d.ts", ".d.mts") {
+			if isDtsFile(pathname) {
 				if outdatedBuildVer != "" {
 					bvPrefix = fmt.Sprintf("/%s", outdatedBuildVer)
 				} else {
-					bvPrefix = fmt.Sprintf("/v%d", CTX_VERSION)
+					bvPrefix = typesPathPrefix
 				}
 			}
 			if external.Has("*") {
@@ -625,10 +759,12 @@ func esmHandler() rex.Handle {
 			if ctx.R.URL.RawQuery != "" {
 				if extraQuery != "" {
 					query = "&" + ctx.R.URL.RawQuery
+					ctx.SetHeader("Cache-Control", redirectCacheControl)
 					return rex.Redirect(fmt.Sprintf("%s%s%s%s/%s%s@%s%s%s", cdnOrigin, cfg.BasePath, bvPrefix, ghPrefix, eaSign, reqPkg.Name, reqPkg.Version, query, subPath), http.StatusFound)
 				}
 				query = "?" + ctx.R.URL.RawQuery
 			}
+			ctx.SetHeader("Cache-Control", redirectCacheControl)
 			return rex.Redirect(fmt.Sprintf("%s%s%s%s/%s%s@%s%s%s", cdnOrigin, cfg.BasePath, bvPrefix, ghPrefix, eaSign, reqPkg.Name, reqPkg.Version, subPath, query), http.StatusFound)
 		}
 
@@ -638,7 +774,9 @@ func esmHandler() rex.Handle {
 			subPath := ""
 			query := ""
 			if hasBuildVerPrefix {
-				if stableBuild[reqPkg.Name] {
+				if hasTypesPrefix {
+					bvPrefix = typesPathPrefix
+				} else if stableBuild[reqPkg.Name] {
 					bvPrefix = "/stable"
 				} else if outdatedBuildVer != "" {
 					bvPrefix = fmt.Sprintf("/%s", outdatedBuildVer)
@@ -652,6 +790,7 @@ func esmHandler() rex.Handle {
 			if ctx.R.URL.RawQuery != "" {
 				query = "?" + ctx.R.URL.RawQuery
 			}
+			ctx.SetHeader("Cache-Control", redirectCacheControl)
 			return rex.Redirect(fmt.Sprintf("%s%s%s/%s%s%s", cdnOrigin, cfg.BasePath, bvPrefix, reqPkg.VersionName(), subPath, query), http.StatusFound)
 		}
 
@@ -670,53 +809,123 @@ func esmHandler() rex.Handle {
 
 		// or use `?path=$PATH` query to override the pathname
 		if v := ctx.Form.Value("path"); v != "" {
+			if isUnsafePath(v) {
+				return rex.Status(400, "invalid path")
+			}
 			reqPkg.Submodule = utils.CleanPath(v)[1:]
 		}
 
 		var reqType string
 		if reqPkg.Subpath != "" {
 			ext := path.Ext(reqPkg.Subpath)
-			switch ext {
-			case ".mjs", ".js", ".jsx", ".ts", ".mts", ".tsx":
-				if endsWith(pathname, ".d.ts", ".d.mts") {
-					if !hasBuildVerPrefix {
-						url := fmt.Sprintf("%s%s/v%d%s", cdnOrigin, cfg.BasePath, CTX_VERSION, pathname)
-						return rex.Redirect(url, http.StatusMovedPermanently)
+			// serve the file of the package as it is with the `?raw` query, only the scripts and the assets
+			if ctx.Form.Has("raw") && !(hasBuildVerPrefix && hasTargetSegment(reqPkg.Subpath)) && (endsWith(ext, ".mjs", ".js", ".cjs", ".jsx", ".ts", ".mts", ".cts", ".tsx") || (ext != "" && assetExts[ext[1:]])) {
+				reqType = "raw"
+			} else {
+				switch ext {
+				case ".mjs", ".js", ".jsx", ".ts", ".mts", ".cts", ".tsx":
+					if isDtsFile(pathname) {
+						if !hasBuildVerPrefix {
+							url := fmt.Sprintf("%s%s%s%s", cdnOrigin, cfg.BasePath, typesPathPrefix, pathname)
+							return rex.Redirect(url, http.StatusMovedPermanently)
+						}
+						reqType = "types"
+					} else if hasBuildVerPrefix && hasTargetSegment(reqPkg.Subpath) {
+						reqType = "builds"
+					}
+				case ".wasm":
+					if ctx.Form.Has("module") {
+						buf := &bytes.Buffer{}
+						wasmUrl := fmt.Sprintf("%s%s%s", cdnOrigin, cfg.BasePath, pathname)
+						fmt.Fprintf(buf, "/* esm.sh - CompiledWasm */\n")
+						fmt.Fprintf(buf, "const data = await fetch(%s).then(r => r.arrayBuffer());\nexport default new WebAssembly.Module(data);", strings.TrimSpace(string(utils.MustEncodeJSON(wasmUrl))))
+						ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
+						ctx.SetHeader("Content-Type", "application/javascript; charset=utf-8")
+						return buf
+					} else {
+						reqType = "raw"
+					}
+				case ".integrity":
+					if hasBuildVerPrefix && hasTargetSegment(reqPkg.Subpath) {
+						reqType = "integrity"
+					}
+				case ".css", ".map":
+					// serve the CSS as a JS module that injects the stylesheet into the document with the `?module` query
+					if ext == ".css" && ctx.Form.Has("module") {
+						cssUrl := fmt.Sprintf("%s%s%s", cdnOrigin, cfg.BasePath, pathname)
+						ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
+						ctx.SetHeader("Content-Type", "application/javascript; charset=utf-8")
+						return cssModuleJS(cssUrl)
+					}
+					if hasBuildVerPrefix && hasTargetSegment(reqPkg.Subpath) {
+						reqType = "builds"
+					} else {
+						reqType = "raw"
+					}
+				default:
+					// serve the JSON file as a JS module with the `?module` query
+					if ext == ".json" && ctx.Form.Has("module") {
+						break
+					}
+					if ext != "" && assetExts[ext[1:]] {
+						reqType = "raw"
 					}
-					reqType = "types"
-				} else if hasBuildVerPrefix && hasTargetSegment(reqPkg.Subpath) {
-					reqType = "builds"
-				}
-			case ".wasm":
-				if ctx.Form.Has("module") {
-					buf := &bytes.Buffer{}
-					wasmUrl := fmt.Sprintf("%s%s%s", cdnOrigin, cfg.BasePath, pathname)
-					fmt.Fprintf(buf, "/* esm.sh - CompiledWasm */\n")
-					fmt.Fprintf(buf, "const data = await fetch(%s).then(r => r.arrayBuffer());\nexport default new WebAssembly.Module(data);", strings.TrimSpace(string(utils.MustEncodeJSON(wasmUrl))))
-					ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
-					ctx.SetHeader("Content-Type", "application/javascript; charset=utf-8")
-					return buf
-				} else {
-					reqType = "raw"
 				}
-			case ".css", ".map":
-				if hasBuildVerPrefix && hasTargetSegment(reqPkg.Subpath) {
-					reqType = "builds"
-				} else {
-					reqType = "raw"
+			}
+		}
+
+		// only the types are served under the `/types/` prefix
+		if hasTypesPrefix && reqType != "types" {
+			return rex.Status(404, "not found")
+		}
+
+		// redirect the main entry path to the canonical url, e.g. `/react@18.2.0/index.js` -> `/react@18.2.0`,
+		// so the caches converge on a single entry per build
+		if reqType == "" && endsWith(reqPkg.Subpath, ".mjs", ".js", ".cjs") && !reqPkg.FromGithub && !reqPkg.FromEsmsh && extraQuery == "" && !ctx.Form.Has("path") && strings.HasSuffix(ctx.R.URL.Path, "/"+reqPkg.Subpath) {
+			info, _, err := getRegistryPackageInfo(registry, "", reqPkg.Name, reqPkg.Version)
+			if err == nil && isMainEntrySubpath(info, reqPkg.Subpath) {
+				url := cdnOrigin + strings.TrimSuffix(ctx.R.URL.Path, "/"+reqPkg.Subpath)
+				if ctx.R.URL.RawQuery != "" {
+					url += "?" + ctx.R.URL.RawQuery
 				}
-			default:
-				if ext != "" && assetExts[ext[1:]] {
-					reqType = "raw"
+				// only the redirect of an exact version is immutable
+				if regexpFullVersion.MatchString(reqPkg.Version) && !reqPkg.stale {
+					ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
+					return rex.Redirect(url, http.StatusMovedPermanently)
 				}
+				ctx.SetHeader("Cache-Control", redirectCacheControl)
+				return rex.Redirect(url, http.StatusFound)
 			}
 		}
 
+		// serve the subresource integrity of the build, e.g. "/v135/react@18.3.1/es2022/react.mjs.integrity"
+		if reqType == "integrity" {
+			integrity, err := getBuildIntegrity(getBuildIDOfPath(strings.TrimSuffix(pathname, ".integrity"), CTX_VERSION, hasStablePrefix, outdatedBuildVer))
+			if err != nil {
+				if err == storage.ErrNotFound {
+					return rex.Status(404, "Build not found")
+				}
+				return rex.Status(500, err.Error())
+			}
+			ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
+			ctx.SetHeader("Content-Type", "text/plain; charset=utf-8")
+			return integrity
+		}
+
 		// serve raw dist or npm dist files like CSS/map etc..
 		if reqType == "raw" {
 			installDir := fmt.Sprintf("npm/%s", reqPkg.VersionName())
-			savePath := path.Join(cfg.WorkDir, installDir, "node_modules", reqPkg.Name, reqPkg.Subpath)
-			fi, err := os.Lstat(savePath)
+			if registry != "" {
+				// the package of the alternate registry is installed in a separate work directory
+				installDir = fmt.Sprintf("registries/%s/npm/%s", registry, reqPkg.VersionName())
+			}
+			savePath, err := securejoin(path.Join(cfg.WorkDir, installDir, "node_modules", reqPkg.Name), reqPkg.Subpath)
+			if err != nil {
+				return rex.Status(400, "invalid path")
+			}
+			cacheStatus := "HIT"
+			var buildDuration time.Duration
+			fi, err := os.Stat(savePath)
 			if err != nil {
 				if os.IsExist(err) {
 					return rex.Status(500, err.Error())
@@ -730,8 +939,23 @@ func esmHandler() rex.Handle {
 						external:    newStringSet(),
 						treeShaking: newStringSet(),
 						conditions:  newStringSet(),
+						registry:    registry,
 					},
-					Target: "raw",
+					Target:    "raw",
+					requestID: getRequestID(ctx),
+					priority:  getRequestPriority(ctx),
+				}
+				if readOnly {
+					return readOnlyError(ctx)
+				}
+				if failure := getBuildFailure(task.ID()); failure != nil {
+					return rex.Status(failure.Status, "Fail to install package: "+failure.Error())
+				}
+				if isQueueSaturated(task) {
+					return queueSaturatedError(ctx)
+				}
+				if ok, retryAfter := allowGithubBuild(task); !ok {
+					return githubBuildLimitError(ctx, task.Pkg.Name, retryAfter)
 				}
 				c := buildQueue.Add(task, ctx.RemoteIP())
 				select {
@@ -739,13 +963,19 @@ func esmHandler() rex.Handle {
 					if output.err != nil {
 						return rex.Status(500, "Fail to install package: "+output.err.Error())
 					}
-					fi, err = os.Lstat(savePath)
+					cacheStatus = "MISS"
+					buildDuration = output.duration
+					fi, err = os.Stat(savePath)
 					if err != nil {
 						if os.IsExist(err) {
 							return rex.Status(500, err.Error())
 						}
 						return rex.Status(404, "File Not Found")
 					}
+				case <-ctx.R.Context().Done():
+					// the client is gone, cancel the build unless other requests are waiting for it
+					buildQueue.Cancel(task, c)
+					return rex.Status(499, "client closed request")
 				case <-time.After(time.Minute):
 					buildQueue.RemoveConsumer(task, c)
 					ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
@@ -753,15 +983,33 @@ func esmHandler() rex.Handle {
 				}
 			}
 
-			content, err := os.Open(savePath)
+			// the package files may be linked to the `.pnpm` store, don't serve the files out of the install dir
+			realSavePath, err := realpathIn(path.Join(cfg.WorkDir, installDir), savePath)
+			if err != nil {
+				return rex.Status(404, "File Not Found")
+			}
+			content, err := os.Open(realSavePath)
 			if err != nil {
 				if os.IsExist(err) {
 					return rex.Status(500, err.Error())
 				}
 				return rex.Status(404, "File Not Found")
 			}
+			// the raw scripts(with the `?raw` query) are served with the script types instead of the mime types
+			// of the extensions, e.g. `.ts` -> video/mp2t
+			switch path.Ext(savePath) {
+			case ".js", ".mjs", ".cjs", ".jsx":
+				ctx.SetHeader("Content-Type", "application/javascript; charset=utf-8")
+			case ".ts", ".mts", ".cts", ".tsx":
+				ctx.SetHeader("Content-Type", "application/typescript; charset=utf-8")
+			}
+			if ext := path.Ext(savePath); ext != "" && markupExts[strings.ToLower(ext[1:])] {
+				ctx.SetHeader("Content-Security-Policy", "sandbox")
+			}
+			ctx.SetHeader("X-Content-Type-Options", "nosniff")
 			ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
-			return rex.Content(savePath, fi.ModTime(), content) // auto closed
+			setCacheStatusHeaders(ctx, cacheStatus, buildDuration)
+			return serveStorageFile(ctx, savePath, fi.ModTime(), content)
 		}
 
 		// serve build files
@@ -777,7 +1025,21 @@ func esmHandler() rex.Handle {
 			if reqType == "types" {
 				savePath = path.Join("types", getTypesRoot(cdnOrigin), strings.TrimPrefix(savePath, "types/"))
 			}
-			fi, err := fs.Stat(savePath)
+			if reqType == "builds" && endsWith(pathname, ".mjs", ".js") {
+				if res := checkSizeBudget(ctx, savePath); res != nil {
+					return res
+				}
+			}
+			if reqType == "builds" && ctx.Form.Has("analyze") {
+				if !cfg.BuildAnalysis {
+					return rex.Status(404, "The build analysis is disabled")
+				}
+				if _, err := fs.Stat(savePath); err == nil {
+					ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
+					return serveBuildAnalysis(ctx, savePath, reqPkg.String(), cdnOrigin)
+				}
+			}
+			r, modtime, err := openStorageFile(savePath)
 			if err != nil {
 				if err == storage.ErrNotFound && strings.HasSuffix(pathname, ".map") {
 					return rex.Status(404, "Not found")
@@ -788,10 +1050,6 @@ func esmHandler() rex.Handle {
 			}
 
 			if err == nil {
-				r, err := fs.OpenFile(savePath)
-				if err != nil {
-					return rex.Status(500, err.Error())
-				}
 				if reqType == "types" {
 					ctx.SetHeader("Content-Type", "application/typescript; charset=utf-8")
 				} else if endsWith(pathname, ".js", ".mjs", ".jsx", ".ts", ".mts", ".tsx") {
@@ -799,7 +1057,24 @@ func esmHandler() rex.Handle {
 				} else if strings.HasSuffix(savePath, ".map") {
 					ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
 				}
-				ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
+				ctx.SetHeader("Cache-Control", getTypesCacheControl(hasTypesPrefix))
+				if reqType == "builds" {
+					target := ""
+					for _, part := range strings.Split(reqPkg.Subpath, "/") {
+						if targets[part] > 0 {
+							target = part
+							break
+						}
+					}
+					buildVersion, _ := utils.SplitByFirstByte(strings.TrimPrefix(savePath, "builds/"), '/')
+					setBuildHeaders(ctx, reqPkg, target, strings.Contains(path.Base(savePath), ".development."), buildVersion)
+					if endsWith(pathname, ".mjs", ".js") && !ctx.Form.Has("worker") {
+						if esm, ok := queryESMBuild(getBuildIDOfPath(pathname, CTX_VERSION, hasStablePrefix, outdatedBuildVer)); ok && esm.Integrity != "" {
+							ctx.SetHeader("X-Esm-Integrity", esm.Integrity)
+						}
+					}
+				}
+				setCacheStatusHeaders(ctx, "HIT", 0)
 				if ctx.Form.Has("worker") && reqType == "builds" {
 					defer r.Close()
 					buf, err := ioutil.ReadAll(r)
@@ -808,9 +1083,9 @@ func esmHandler() rex.Handle {
 					}
 					code := bytes.TrimSuffix(buf, []byte(fmt.Sprintf(`//# sourceMappingURL=%s.map`, path.Base(savePath))))
 					ctx.SetHeader("Content-Type", "application/javascript; charset=utf-8")
-					return fmt.Sprintf(`export default function workerFactory(inject) { const blob = new Blob([%s, typeof inject === "string" ? "\n// inject\n" + inject : ""], { type: "application/javascript" }); return new Worker(URL.createObjectURL(blob), { type: "module" })}`, utils.MustEncodeJSON(string(code)))
+					return workerFactoryJS(code)
 				}
-				return rex.Content(savePath, fi.ModTime(), r) // auto closed
+				return serveStorageFile(ctx, savePath, modtime, r)
 			}
 		}
 
@@ -833,12 +1108,12 @@ func esmHandler() rex.Handle {
 		for _, p := range strings.Split(ctx.Form.Value("deps"), ",") {
 			p = strings.TrimSpace(p)
 			if p != "" {
-				m, _, err := validatePkgPath(p)
+				m, _, err := validateRegistryPkgPath(registry, p)
 				if err != nil {
 					if strings.HasSuffix(err.Error(), "not found") {
 						continue
 					}
-					return rex.Status(400, fmt.Sprintf("Invalid deps query: %v not found", p))
+					return rex.Status(400, fmt.Sprintf("Invalid deps query %s: %v", p, err))
 				}
 				if reqPkg.Name == "react-dom" && m.Name == "react" {
 					// the `react` version always matches `react-dom` version
@@ -870,13 +1145,21 @@ func esmHandler() rex.Handle {
 			}
 		}
 
-		// determine build target by `?target` query or `User-Agent` header
+		// determine build target by `?target` query, the default target of the host or `User-Agent` header
 		target := strings.ToLower(ctx.Form.Value("target"))
 		targetFromUA := targets[target] == 0
-		if targetFromUA {
+		if targetFromUA && targets[hostConfig.DefaultTarget] > 0 {
+			target = hostConfig.DefaultTarget
+			targetFromUA = false
+		} else if targetFromUA {
 			target = getTargetByUA(ctx.R.UserAgent())
 		}
 
+		// count the module requests(e.g. `/react@18.2.0`) for the analytics
+		if reqType == "" {
+			recordRequest(reqPkg, target)
+		}
+
 		if strings.HasPrefix(target, "es") && includes(nativeNodePackages, reqPkg.Name) {
 			return throwErrorJS(ctx, fmt.Errorf(
 				`unsupported npm package "%s": native node module is not supported in browser`,
@@ -890,6 +1173,9 @@ func esmHandler() rex.Handle {
 		if outdatedBuildVer == "" {
 			pv = ctx.Form.Value("pin")
 		}
+		if pv == "" && !hasBuildVerPrefix && hostConfig.BuildVersion > 0 {
+			pv = fmt.Sprintf("v%d", hostConfig.BuildVersion)
+		}
 		if pv != "" && strings.HasPrefix(pv, "v") {
 			i, err := strconv.Atoi(pv[1:])
 			if err == nil && i > 0 && i < CTX_VERSION {
@@ -926,6 +1212,43 @@ func esmHandler() rex.Handle {
 		ignoreRequire := ctx.Form.Has("ignore-require") || ctx.Form.Has("no-require") || reqPkg.Name == "@unocss/preset-icons"
 		keepNames := ctx.Form.Has("keep-names")
 		ignoreAnnotations := ctx.Form.Has("ignore-annotations")
+		isMV3 := ctx.Form.Has("mv3")
+		isLazy := ctx.Form.Has("lazy")
+
+		// check `?global` query
+		global := strings.TrimSpace(ctx.Form.Value("global"))
+		if global != "" && !isValidGlobalName(global) {
+			return rex.Status(400, "Invalid `global` query: "+global)
+		}
+
+		// check `?bundle-scope` query
+		bundleScopes := newStringSet()
+		for _, scope := range strings.Split(ctx.Form.Value("bundle-scope"), ",") {
+			scope = strings.TrimSpace(scope)
+			if scope != "" {
+				if !isValidBundleScope(scope) {
+					return rex.Status(400, fmt.Sprintf("Invalid `bundle-scope` query: %s", scope))
+				}
+				bundleScopes.Add(scope)
+			}
+		}
+
+		// check `?bundle-deps-under` query
+		var bundleDepsUnder int64
+		if v := ctx.Form.Value("bundle-deps-under"); v != "" {
+			bundleDepsUnder, err = parseByteSize(v)
+			if err != nil {
+				return rex.Status(400, "Invalid `bundle-deps-under` query: "+err.Error())
+			}
+		}
+
+		// the browser extension(manifest v3) can't load remote modules and blob workers
+		if isMV3 {
+			if isWorker {
+				return rex.Status(400, "The `worker` query is not supported by the `mv3` profile")
+			}
+			isBundle = !stableBuild[reqPkg.Name]
+		}
 
 		// force react/jsx-dev-runtime and react-refresh into `dev` mode
 		if !isDev && ((reqPkg.Name == "react" && reqPkg.Submodule == "jsx-dev-runtime") || reqPkg.Name == "react-refresh") {
@@ -938,9 +1261,15 @@ func esmHandler() rex.Handle {
 			denoStdVersion:    dsv,
 			deps:              deps,
 			external:          external,
+			global:            global,
 			ignoreAnnotations: ignoreAnnotations,
 			ignoreRequire:     ignoreRequire,
 			keepNames:         keepNames,
+			mv3:               isMV3,
+			lazy:              isLazy,
+			bundleDepsUnder:   bundleDepsUnder,
+			bundleScopes:      bundleScopes,
+			registry:          registry,
 			treeShaking:       treeShaking,
 		}
 
@@ -951,6 +1280,9 @@ func esmHandler() rex.Handle {
 				reqPkg.Submodule = strings.Join(a[1:], "/")
 				args, err := decodeBuildArgsPrefix(a[0])
 				if err != nil {
+					if errors.Is(err, errInvalidBuildArgs) {
+						return rex.Status(400, err.Error())
+					}
 					return throwErrorJS(ctx, err)
 				}
 				reqPkg.Subpath = strings.Join(strings.Split(reqPkg.Subpath, "/")[1:], "/")
@@ -958,6 +1290,11 @@ func esmHandler() rex.Handle {
 					// ensure deno/std version used
 					args.denoStdVersion = denoStdVersion
 				}
+				if args.registry != "" && args.registry != registry {
+					if ret := checkRequestRegistry(ctx, args.registry); ret != nil {
+						return ret
+					}
+				}
 				buildArgs = args
 			}
 		}
@@ -968,6 +1305,8 @@ func esmHandler() rex.Handle {
 				external:    newStringSet(),
 				treeShaking: newStringSet(),
 				conditions:  buildArgs.conditions,
+				mv3:         buildArgs.mv3,
+				registry:    buildArgs.registry,
 			}
 		}
 
@@ -978,7 +1317,11 @@ func esmHandler() rex.Handle {
 			if len(a) > 0 {
 				maybeTarget := a[0]
 				if _, ok := targets[maybeTarget]; ok {
-					submodule := strings.Join(a[1:], "/")
+					// the build path is the build id, the `/index` suffix of the directory index is kept
+					submodule := strings.Join(strings.Split(reqPkg.Subpath, "/")[1:], "/")
+					if !strings.HasSuffix(submodule, ".css") {
+						submodule = strings.TrimSuffix(strings.TrimSuffix(submodule, ".mjs"), ".js")
+					}
 					pkgName := strings.TrimSuffix(path.Base(reqPkg.Name), ".js")
 					if strings.HasSuffix(submodule, ".css") {
 						if submodule == pkgName+".css" {
@@ -1029,6 +1372,17 @@ func esmHandler() rex.Handle {
 			}
 		}
 
+		// the build args of the bare path are kept as they are since the path is the build id
+		if !isBarePath {
+			isESM := false
+			if buildArgs.global != "" && !reqPkg.FromGithub && !reqPkg.FromEsmsh {
+				if info, _, err := getRegistryPackageInfo(buildArgs.registry, "", reqPkg.Name, reqPkg.Version); err == nil {
+					isESM = info.Type == "module" || info.Module != ""
+				}
+			}
+			normalizeBuildArgs(&buildArgs, isDev, isBundle, isESM)
+		}
+
 		// build and return dts
 		if hasBuildVerPrefix && reqType == "types" {
 			findDts := func() (savePath string, fi storage.FileStat, err error) {
@@ -1056,6 +1410,8 @@ func esmHandler() rex.Handle {
 				fi, err = fs.Stat(savePath)
 				return savePath, fi, err
 			}
+			cacheStatus := "HIT"
+			var buildDuration time.Duration
 			_, _, err := findDts()
 			if err == storage.ErrNotFound {
 				task := &BuildTask{
@@ -1064,6 +1420,20 @@ func esmHandler() rex.Handle {
 					BuildVersion: buildVersion,
 					Pkg:          reqPkg,
 					Target:       "types",
+					requestID:    getRequestID(ctx),
+					priority:     getRequestPriority(ctx),
+				}
+				if readOnly {
+					return readOnlyError(ctx)
+				}
+				if failure := getBuildFailure(task.ID()); failure != nil {
+					return rex.Status(failure.Status, "types: "+failure.Error())
+				}
+				if isQueueSaturated(task) {
+					return queueSaturatedError(ctx)
+				}
+				if ok, retryAfter := allowGithubBuild(task); !ok {
+					return githubBuildLimitError(ctx, task.Pkg.Name, retryAfter)
 				}
 				c := buildQueue.Add(task, ctx.RemoteIP())
 				select {
@@ -1071,6 +1441,12 @@ func esmHandler() rex.Handle {
 					if output.err != nil {
 						return rex.Status(500, "types: "+output.err.Error())
 					}
+					cacheStatus = "MISS"
+					buildDuration = output.duration
+				case <-ctx.R.Context().Done():
+					// the client is gone, cancel the build unless other requests are waiting for it
+					buildQueue.Cancel(task, c)
+					return rex.Status(499, "client closed request")
 				case <-time.After(time.Minute):
 					buildQueue.RemoveConsumer(task, c)
 					ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
@@ -1089,8 +1465,9 @@ func esmHandler() rex.Handle {
 				return rex.Status(500, err.Error())
 			}
 			ctx.SetHeader("Content-Type", "application/typescript; charset=utf-8")
-			ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
-			return rex.Content(savePath, fi.ModTime(), r) // auto closed
+			ctx.SetHeader("Cache-Control", getTypesCacheControl(hasTypesPrefix))
+			setCacheStatusHeaders(ctx, cacheStatus, buildDuration)
+			return serveStorageFile(ctx, savePath, fi.ModTime(), r)
 		}
 
 		task := &BuildTask{
@@ -1101,11 +1478,37 @@ func esmHandler() rex.Handle {
 			Target:       target,
 			Dev:          isDev,
 			Bundle:       isBundle || isWorker,
+			requestID:    getRequestID(ctx),
+			priority:     getRequestPriority(ctx),
+		}
+
+		// report how the url is interpreted, e.g. `/react-dom@18/client?dev&deps=react@18.2.0&parse`
+		if ctx.Form.Has("parse") {
+			if targetFromUA {
+				ctx.AddHeader("Vary", "User-Agent")
+			}
+			ctx.SetHeader("Cache-Control", fmt.Sprintf("public, max-age=%d", 10*60))
+			return task.parse(targetFromUA, isWorker)
+		}
+
+		// report how the dependencies would be resolved without building, e.g. `?dry-run&bundle`
+		if ctx.Form.Has("dry-run") {
+			out, err := task.dryRun()
+			if err != nil {
+				return rex.Status(500, err.Error())
+			}
+			if targetFromUA {
+				ctx.AddHeader("Vary", "User-Agent")
+			}
+			ctx.SetHeader("Cache-Control", fmt.Sprintf("public, max-age=%d", 10*60))
+			return out
 		}
 
 		taskID := task.ID()
 		esm, hasBuild := queryESMBuild(taskID)
 		fallback := false
+		cacheStatus := "HIT"
+		var buildDuration time.Duration
 
 		if !hasBuild {
 			if !isBarePath && !isPined {
@@ -1125,8 +1528,30 @@ func esmHandler() rex.Handle {
 			// if the previous build exists and is not pin/bare mode, then build current module in backgound,
 			// or wait the current build task for 60 seconds
 			if esm != nil {
-				buildQueue.Add(task, "")
+				if !readOnly && !isQueueSaturated(task) && getBuildFailure(task.ID()) == nil {
+					if ok, _ := allowGithubBuild(task); ok {
+						buildQueue.Add(task, "")
+					}
+				}
+			} else if readOnly {
+				return readOnlyError(ctx)
+			} else if failure := getBuildFailure(task.ID()); failure != nil {
+				// fail fast if the build failed recently instead of retrying the whole pipeline
+				return throwErrorJS(ctx, failure)
+			} else if isQueueSaturated(task) {
+				return queueSaturatedError(ctx)
+			} else if ok, retryAfter := allowGithubBuild(task); !ok {
+				return githubBuildLimitError(ctx, task.Pkg.Name, retryAfter)
 			} else {
+				// stream the artifact of the bare build path while the build writes it to the storage,
+				// the size budget(`?max-size`) can't be checked until the build is done, and the signed
+				// responses are not streamed since the signature is computed from the whole module
+				var stream *buildStream
+				if cfg.StreamBuilds && signingKey == nil && isBarePath && !isWorker && !strings.HasSuffix(reqPkg.Subpath, ".css") && !ctx.Form.Has("max-size") {
+					savePath := task.getSavepath()
+					stream = subscribeBuildStream(savePath)
+					defer unsubscribeBuildStream(savePath, stream)
+				}
 				c := buildQueue.Add(task, ctx.RemoteIP())
 				select {
 				case output := <-c.C:
@@ -1134,6 +1559,21 @@ func esmHandler() rex.Handle {
 						return throwErrorJS(ctx, output.err)
 					}
 					esm = output.meta
+					cacheStatus = "MISS"
+					buildDuration = output.duration
+				case <-stream.Ready():
+					// the build keeps running after the response, it's stored for the next requests
+					buildQueue.RemoveConsumer(task, c)
+					// the streamed content is truncated if the build fails to store it, don't cache it
+					ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
+					ctx.SetHeader("Content-Type", "application/javascript; charset=utf-8")
+					ctx.SetHeader("X-Esm-Cache", "STREAM")
+					setBuildHeaders(ctx, reqPkg, task.Target, task.Dev, fmt.Sprintf("v%d", task.BuildVersion))
+					return stream
+				case <-ctx.R.Context().Done():
+					// the client is gone, cancel the build unless other requests are waiting for it
+					buildQueue.Cancel(task, c)
+					return rex.Status(499, "client closed request")
 				case <-time.After(time.Minute):
 					buildQueue.RemoveConsumer(task, c)
 					ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
@@ -1142,6 +1582,44 @@ func esmHandler() rex.Handle {
 			}
 		}
 
+		// the previous build version or the last known version resolution is served
+		if fallback || reqPkg.stale {
+			cacheStatus = "STALE"
+		}
+		// the `Cache-Control` header is set by the responses below
+		defer setCacheStatusHeaders(ctx, cacheStatus, buildDuration)
+		if esm.FallbackTarget != "" {
+			ctx.SetHeader("X-Esm-Fallback-Target", esm.FallbackTarget)
+		}
+
+		// the artifact of the build, it's the previous build version in fallback mode
+		artifactPath := task.getSavepath()
+		if fallback {
+			artifactPath = path.Join("builds", taskID)
+		}
+
+		// analyze the build with the esbuild metafile
+		if ctx.Form.Has("analyze") {
+			if !cfg.BuildAnalysis {
+				return rex.Status(404, "The build analysis is disabled")
+			}
+			if fallback || reqPkg.stale {
+				ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
+			} else if isPined {
+				ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
+			} else {
+				ctx.SetHeader("Cache-Control", fmt.Sprintf("public, max-age=%d", 24*3600)) // cache for 24 hours
+			}
+			return serveBuildAnalysis(ctx, artifactPath, reqPkg.String(), cdnOrigin)
+		}
+
+		// check the size budget of the build with the `?max-size` query
+		if !esm.TypesOnly && !isPkgCss && !strings.HasSuffix(reqPkg.Subpath, ".css") {
+			if res := checkSizeBudget(ctx, artifactPath); res != nil {
+				return res
+			}
+		}
+
 		// should redirect to `*.d.ts` file
 		if esm.TypesOnly {
 			dtsUrl := fmt.Sprintf(
@@ -1154,6 +1632,8 @@ func esmHandler() rex.Handle {
 			ctx.SetHeader("Content-Type", "application/javascript; charset=utf-8")
 			if fallback {
 				ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
+			} else if reqPkg.stale {
+				ctx.SetHeader("Cache-Control", "no-cache")
 			} else {
 				if isPined {
 					ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
@@ -1173,6 +1653,9 @@ func esmHandler() rex.Handle {
 				return rex.Status(404, "Package CSS not found")
 			}
 			url := fmt.Sprintf("%s%s/%s.css", cdnOrigin, cfg.BasePath, strings.TrimSuffix(taskID, path.Ext(taskID)))
+			if ctx.Form.Has("module") {
+				url += "?module"
+			}
 			code := 302
 			if isPined {
 				code = 301
@@ -1186,17 +1669,13 @@ func esmHandler() rex.Handle {
 				base, _ := utils.SplitByLastByte(savePath, '.')
 				savePath = base + ".css"
 			}
-			fi, err := fs.Stat(savePath)
+			f, modtime, err := openStorageFile(savePath)
 			if err != nil {
 				if err == storage.ErrNotFound {
 					return rex.Status(404, "File not found")
 				}
 				return rex.Status(500, err.Error())
 			}
-			f, err := fs.OpenFile(savePath)
-			if err != nil {
-				return rex.Status(500, err.Error())
-			}
 			ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
 			if isWorker && endsWith(savePath, ".mjs", ".js") {
 				buf, err := ioutil.ReadAll(f)
@@ -1206,12 +1685,16 @@ func esmHandler() rex.Handle {
 				}
 				code := bytes.TrimSuffix(buf, []byte(fmt.Sprintf(`//# sourceMappingURL=%s.map`, path.Base(savePath))))
 				ctx.SetHeader("Content-Type", "application/javascript; charset=utf-8")
-				return fmt.Sprintf(`export default function workerFactory() { const blob = new Blob([%s], { type: "application/javascript" }); return new Worker(URL.createObjectURL(blob), { type: "module" })}`, utils.MustEncodeJSON(string(code)))
+				return workerFactoryJS(code)
 			}
 			if endsWith(savePath, ".mjs", ".js") {
 				ctx.SetHeader("Content-Type", "application/javascript; charset=utf-8")
+				if esm.Integrity != "" {
+					ctx.SetHeader("X-Esm-Integrity", esm.Integrity)
+				}
 			}
-			return rex.Content(savePath, fi.ModTime(), f) // auto closed
+			setBuildHeaders(ctx, reqPkg, task.Target, task.Dev, fmt.Sprintf("v%d", task.BuildVersion))
+			return serveStorageFile(ctx, savePath, modtime, f)
 		}
 
 		buf := bytes.NewBuffer(nil)
@@ -1245,6 +1728,8 @@ func esmHandler() rex.Handle {
 		}
 		if fallback {
 			ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
+		} else if reqPkg.stale {
+			ctx.SetHeader("Cache-Control", "no-cache")
 		} else {
 			if isPined {
 				ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
@@ -1266,13 +1751,170 @@ func esmHandler() rex.Handle {
 
 func auth(secret string) rex.Handle {
 	return func(ctx *rex.Context) interface{} {
-		if secret != "" && ctx.R.Header.Get("Authorization") != "Bearer "+secret {
+		secret := secret
+		if hostConfig := getHostConfig(ctx); hostConfig.AuthSecret != "" {
+			secret = hostConfig.AuthSecret
+		}
+		// the priority tokens don't pass the check, the secret unlocks more than the priority(e.g. `?registry`)
+		if secret != "" && !checkBearerToken(ctx.R, secret) {
 			return rex.Status(401, "Unauthorized")
 		}
 		return nil
 	}
 }
 
+// checkBearerToken checks the `Authorization: Bearer <token>` header of the request in constant time.
+func checkBearerToken(r *http.Request, token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) == 1
+}
+
+// isPriorityToken checks whether the request has a token of the `priorityTokens` config,
+// the priority of the token is returned.
+func isPriorityToken(ctx *rex.Context) (priority int, ok bool) {
+	authorization := ctx.R.Header.Get("Authorization")
+	if len(cfg.PriorityTokens) == 0 || !strings.HasPrefix(authorization, "Bearer ") {
+		return 0, false
+	}
+	token := []byte(strings.TrimPrefix(authorization, "Bearer "))
+	for t, p := range cfg.PriorityTokens {
+		if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+			priority, ok = p, true
+		}
+	}
+	return
+}
+
+// getRequestPriority returns the build priority of the request that is marked as high priority
+// by the `X-Esm-Priority: high` header with a priority token, 0 is returned otherwise.
+func getRequestPriority(ctx *rex.Context) int {
+	if ctx.R.Header.Get("X-Esm-Priority") != "high" {
+		return 0
+	}
+	priority, _ := isPriorityToken(ctx)
+	return priority
+}
+
+// checkRequestRegistry checks if the request can select the registry of `npmRegistries` config,
+// only the authorized requests are allowed to prevent abuse of the registry credentials.
+func checkRequestRegistry(ctx *rex.Context, registry string) interface{} {
+	if _, ok := cfg.NpmRegistries[registry]; !ok {
+		return rex.Status(400, fmt.Sprintf("Unknown registry '%s'", registry))
+	}
+	// the `auth` middleware has verified the request if the secret is set
+	if cfg.AuthSecret == "" && getHostConfig(ctx).AuthSecret == "" {
+		return rex.Status(403, "The `registry` query requires the `authSecret` config")
+	}
+	return nil
+}
+
+// checkRequestScope checks if the request can access the packages of a private scope of `npmScopes`
+// config, the packages that are installed with the registry credentials are only served to the
+// authorized requests.
+func checkRequestScope(ctx *rex.Context, packageFullName string) interface{} {
+	if !strings.HasPrefix(packageFullName, "@") {
+		return nil
+	}
+	scope, _ := utils.SplitByFirstByte(packageFullName, '/')
+	reg, ok := cfg.NpmScopes[scope]
+	if !ok || (reg.Token == "" && (reg.User == "" || reg.Password == "")) {
+		return nil
+	}
+	// the `auth` middleware has verified the request if the secret is set
+	if cfg.AuthSecret == "" && getHostConfig(ctx).AuthSecret == "" {
+		return rex.Status(403, fmt.Sprintf("The packages of the private scope '%s' require the `authSecret` config", scope))
+	}
+	return nil
+}
+
+// the max depth of the dependency chain to look up the allowed dependents
+const maxAllowListDepth = 8
+
+// isImportedByAllowedPackage checks if the package version is imported by the builds of the packages
+// that the host allows, directly or through the dependencies, by the reverse-dependency index.
+func isImportedByAllowedPackage(hc *config.HostConfig, pkg Pkg) bool {
+	visited := map[string]bool{getDependentsDBKeyPrefix(pkg): true}
+	queue := []Pkg{pkg}
+	for depth := 0; depth < maxAllowListDepth && len(queue) > 0; depth++ {
+		var next []Pkg
+		for _, p := range queue {
+			ids, err := getDependents(p)
+			if err != nil {
+				log.Errorf("db: %v", err)
+				return false
+			}
+			for _, id := range ids {
+				dependent, ok := parseBuildIDPkg(id)
+				if !ok || visited[getDependentsDBKeyPrefix(dependent)] {
+					continue
+				}
+				if hc.IsPackageAllowed(dependent.Name) {
+					return true
+				}
+				visited[getDependentsDBKeyPrefix(dependent)] = true
+				next = append(next, dependent)
+			}
+		}
+		queue = next
+	}
+	return false
+}
+
+// getHostConfig returns the config of the request host, an empty config is returned
+// if the host is not configured.
+func getHostConfig(ctx *rex.Context) *config.HostConfig {
+	if len(cfg.Hosts) > 0 {
+		host := strings.ToLower(ctx.R.Host)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if hc, ok := cfg.Hosts[host]; ok {
+			return &hc
+		}
+	}
+	return &config.HostConfig{}
+}
+
+// setBuildHeaders sets the `X-Esm-*` headers of the build file: the package, the build target,
+// the environment and the build version.
+func setBuildHeaders(ctx *rex.Context, pkg Pkg, target string, dev bool, buildVersion string) {
+	env := "production"
+	if dev {
+		env = "development"
+	}
+	ctx.SetHeader("X-Esm-Pkg", pkg.VersionName())
+	if target != "" {
+		ctx.SetHeader("X-Esm-Target", target)
+	}
+	ctx.SetHeader("X-Esm-Env", env)
+	ctx.SetHeader("X-Esm-Version", buildVersion)
+}
+
+// getBuildIDOfPath returns the build id of the build path that the build version prefix is trimmed.
+func getBuildIDOfPath(pathname string, buildVersion int, hasStablePrefix bool, outdatedBuildVer string) string {
+	if outdatedBuildVer != "" {
+		return outdatedBuildVer + pathname
+	}
+	if hasStablePrefix {
+		return "stable" + pathname
+	}
+	return fmt.Sprintf("v%d", buildVersion) + pathname
+}
+
+// setCacheStatusHeaders sets the `X-Esm-Cache` header: "HIT" if the artifact is served from the
+// storage, "MISS" if it's built for the request, or "STALE" if the previous build version or the
+// last known version resolution is served. The build duration(ms) is sent for the fresh builds.
+// The headers are not sent with the immutable response that CDNs cache forever, so it must be
+// called after the `Cache-Control` header is set.
+func setCacheStatusHeaders(ctx *rex.Context, status string, buildDuration time.Duration) {
+	if strings.Contains(ctx.W.Header().Get("Cache-Control"), "immutable") {
+		return
+	}
+	ctx.SetHeader("X-Esm-Cache", status)
+	if status == "MISS" {
+		ctx.SetHeader("X-Esm-Build-Duration", strconv.FormatInt(buildDuration.Milliseconds(), 10))
+	}
+}
+
 func hasTargetSegment(path string) bool {
 	parts := strings.Split(path, "/")
 	for _, part := range parts {
@@ -1283,9 +1925,43 @@ func hasTargetSegment(path string) bool {
 	return false
 }
 
+// readOnlyError returns the error response for the requests that need a new build in read-only mode.
+func readOnlyError(ctx *rex.Context) interface{} {
+	ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
+	if cfg.ReadOnly {
+		return rex.Status(404, "Not Found: the module is not cached on this server(read-only), please try again after it has been synced from the build server")
+	}
+	return rex.Status(503, "Service Unavailable: the server is running in read-only mode since the preflight checks failed, only cached builds are served")
+}
+
+// isQueueSaturated checks whether the build queue is saturated for a new task, the task
+// that is already in the queue is not counted and the high priority task is exempted.
+func isQueueSaturated(task *BuildTask) bool {
+	return cfg.MaxQueueDepth > 0 && task.priority == 0 && buildQueue.Len() >= int(cfg.MaxQueueDepth) && !buildQueue.Has(task.ID())
+}
+
+// queueSaturatedError returns a `503` response with the `Retry-After` header when the build queue is saturated.
+func queueSaturatedError(ctx *rex.Context) interface{} {
+	n := buildQueue.Len()
+	wait := int(math.Ceil(buildQueue.EstimateWait().Seconds()))
+	ctx.SetHeader("Retry-After", strconv.Itoa(wait))
+	ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
+	return rex.Status(http.StatusServiceUnavailable, map[string]interface{}{
+		"error": map[string]interface{}{
+			"status":  http.StatusServiceUnavailable,
+			"message": "the build queue is full, please try again later",
+		},
+		"queue": map[string]interface{}{
+			"length":        n,
+			"position":      n + 1,
+			"estimatedWait": wait,
+		},
+	})
+}
+
 func throwErrorJS(ctx *rex.Context, err error) interface{} {
 	buf := bytes.NewBuffer(nil)
-	fmt.Fprintf(buf, "/* esm.sh - error */\n")
+	fmt.Fprintf(buf, "/* esm.sh - error (request id: %s) */\n", getRequestID(ctx))
 	fmt.Fprintf(
 		buf,
 		`throw new Error("[esm.sh] " + %s);%s`,
@@ -1293,9 +1969,18 @@ func throwErrorJS(ctx *rex.Context, err error) interface{} {
 		"\n",
 	)
 	fmt.Fprintf(buf, "export default null;\n")
-	ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
+	if cfg.CacheTTL.Error > 0 {
+		ctx.SetHeader("Cache-Control", fmt.Sprintf("public, max-age=%d", cfg.CacheTTL.Error))
+	} else {
+		ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
+	}
 	ctx.SetHeader("Content-Type", "application/javascript; charset=utf-8")
-	return rex.Status(500, buf)
+	status := 500
+	var failure *BuildFailure
+	if errors.As(err, &failure) && failure.Status > 0 {
+		status = failure.Status
+	}
+	return rex.Status(status, buf)
 }
 
 func getTypesRoot(cdnOrigin string) string {
@@ -1305,3 +1990,82 @@ func getTypesRoot(cdnOrigin string) string {
 	}
 	return strings.ReplaceAll(url.Host, ":", "_")
 }
+
+// typesPathPrefix is the url prefix of the types of the current build version, the urls don't
+// change across the server upgrades, e.g. `/types/react@18.2.0/index.d.ts`. The types are stored
+// in the layout of the current build version.
+const typesPathPrefix = "/types"
+
+// getTypesPathPrefix returns the url prefix of the types of the build version.
+func getTypesPathPrefix(buildVersion int) string {
+	if buildVersion == VERSION {
+		return typesPathPrefix
+	}
+	return fmt.Sprintf("/v%d", buildVersion)
+}
+
+// getTypesCacheControl returns the `Cache-Control` header of the types, the types of the
+// `/types/` urls may change after a server upgrade.
+func getTypesCacheControl(hasTypesPrefix bool) string {
+	if hasTypesPrefix {
+		return "public, max-age=86400"
+	}
+	return "public, max-age=31536000, immutable"
+}
+
+// workerFactoryJS wraps the module code in a factory that creates a web worker from the blob url,
+// the custom code snippet passed to the factory is appended to the module code.
+func workerFactoryJS(code []byte) string {
+	return fmt.Sprintf(`export default function workerFactory(inject) { const blob = new Blob([%s, typeof inject === "string" ? "\n// inject\n" + inject : ""], { type: "application/javascript" }); return new Worker(URL.createObjectURL(blob), { type: "module" })}`, utils.MustEncodeJSON(string(code)))
+}
+
+// serveBuildAnalysis serves the esbuild metafile of the build, or renders it as a treemap page
+// with the `?analyze=html` query.
+func serveBuildAnalysis(ctx *rex.Context, savePath string, title string, cdnOrigin string) interface{} {
+	name := savePath + ".metafile.json"
+	f, modtime, err := openStorageFile(name)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			// the builds before the metafile was stored
+			return rex.Status(404, "Metafile not found")
+		}
+		return rex.Status(500, err.Error())
+	}
+	if ctx.Form.Value("analyze") == "html" {
+		var meta Metafile
+		err = json.NewDecoder(f).Decode(&meta)
+		f.Close()
+		if err != nil {
+			return rex.Status(500, err.Error())
+		}
+		ctx.SetHeader("Content-Type", "text/html; charset=utf-8")
+		setHTMLSecurityHeaders(ctx, cdnOrigin)
+		return renderTreemapHTML(title, &meta)
+	}
+	ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
+	return serveStorageFile(ctx, name, modtime, f)
+}
+
+// cssModuleJS returns a JS module that fetches the stylesheet and injects it into the document, as a
+// constructable stylesheet if it's supported, or a `<style>` tag. The relative urls of the stylesheet
+// are resolved to the stylesheet url, and the sheet is exported as default.
+func cssModuleJS(cssUrl string) string {
+	return fmt.Sprintf(`/* esm.sh - CSS Module */
+const url = %s;
+const css = (await fetch(url).then(r => r.text())).replace(/url\(\s*(['"]?)([^'")]+)\1\s*\)/g, (m, q, p) => /^([a-z][a-z0-9+.-]*:|#)/i.test(p) ? m : "url(" + q + new URL(p, url) + q + ")");
+let sheet = null;
+if (typeof document !== "undefined") {
+  if (typeof CSSStyleSheet === "function" && "adoptedStyleSheets" in document && !css.includes("@import")) {
+    sheet = new CSSStyleSheet();
+    sheet.replaceSync(css);
+    document.adoptedStyleSheets = [...document.adoptedStyleSheets, sheet];
+  } else {
+    const style = document.createElement("style");
+    style.textContent = css;
+    document.head.appendChild(style);
+    sheet = style.sheet;
+  }
+}
+export default sheet;
+`, strings.TrimSpace(string(utils.MustEncodeJSON(cssUrl))))
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/esm-dev/esm.sh/server/storage"
+)
+
+// the instance key to sign the stored artifacts, nil if signing is disabled
+var signingKey ed25519.PrivateKey
+
+// loadSigningKey loads the Ed25519 private key(PKCS#8 PEM) from the file, a new key
+// is generated and saved to the file if it doesn't exist.
+func loadSigningKey(filename string) (key ed25519.PrivateKey, err error) {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return
+		}
+		var der []byte
+		der, err = x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return
+		}
+		err = ensureDir(filepath.Dir(filename))
+		if err != nil {
+			return
+		}
+		err = os.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+		return
+	}
+	if err != nil {
+		return
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("invalid signing key: missing PEM block")
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return
+	}
+	key, ok := k.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("invalid signing key: not an Ed25519 key")
+	}
+	return
+}
+
+// getSigningPublicKey returns the public key of the instance key in PKIX PEM format.
+func getSigningPublicKey() ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(signingKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// signingMessage returns the signed message of an artifact, it binds the storage path
+// to the SHA-256 hash of the content.
+func signingMessage(name string, digest []byte) []byte {
+	return []byte(fmt.Sprintf("esm.sh-artifact:v1\n%s\n%x", name, digest))
+}
+
+// signedFS signs the files written to the storage, the signature is saved
+// in the `NAME.sig` file.
+type signedFS struct {
+	storage.FileSystem
+	key ed25519.PrivateKey
+}
+
+func (fs *signedFS) WriteFile(name string, r io.Reader) (written int64, err error) {
+	if strings.HasSuffix(name, ".sig") {
+		return fs.FileSystem.WriteFile(name, r)
+	}
+	h := sha256.New()
+	written, err = fs.FileSystem.WriteFile(name, io.TeeReader(r, h))
+	if err != nil {
+		return
+	}
+	sig := ed25519.Sign(fs.key, signingMessage(name, h.Sum(nil)))
+	_, err = fs.FileSystem.WriteFile(name+".sig", strings.NewReader(base64.StdEncoding.EncodeToString(sig)))
+	return
+}
+
+// Remove removes the file and the signature of it.
+func (fs *signedFS) Remove(name string) error {
+	err := fs.FileSystem.Remove(name)
+	if err != nil || strings.HasSuffix(name, ".sig") {
+		return err
+	}
+	return fs.FileSystem.Remove(name + ".sig")
+}
+
+// getArtifactSignature returns the base64 encoded signature of the stored file,
+// an empty string is returned if the file is not signed.
+func getArtifactSignature(name string) string {
+	if signingKey == nil {
+		return ""
+	}
+	r, err := fs.OpenFile(name + ".sig")
+	if err != nil {
+		return ""
+	}
+	defer r.Close()
+	data, err := io.ReadAll(io.LimitReader(r, 1024))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/esm-dev/esm.sh/server/storage"
+)
+
+func TestSignedFS(t *testing.T) {
+	dir := t.TempDir()
+	key, err := loadSigningKey(path.Join(dir, "signing.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// load the saved key
+	key2, err := loadSigningKey(path.Join(dir, "signing.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.Equal(key2) {
+		t.Fatal("signing key changed")
+	}
+
+	localFS, err := storage.OpenFS("local:" + path.Join(dir, "storage"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sfs := &signedFS{localFS, key}
+	content := "export default 42;\n"
+	_, err = sfs.WriteFile("builds/foo.mjs", strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := sfs.OpenFile("builds/foo.mjs.sig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte(content))
+	if !ed25519.Verify(key.Public().(ed25519.PublicKey), signingMessage("builds/foo.mjs", digest[:]), sig) {
+		t.Fatal("invalid signature")
+	}
+	if ed25519.Verify(key.Public().(ed25519.PublicKey), signingMessage("builds/bar.mjs", digest[:]), sig) {
+		t.Fatal("signature should be bound to the path")
+	}
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type redisCache struct {
+	client *redisClient
+	prefix string
+}
+
+func (rc *redisCache) Has(key string) (bool, error) {
+	reply, err := rc.client.Do("EXISTS", rc.prefix+key)
+	if err != nil {
+		return false, err
+	}
+	n, _ := reply.(int64)
+	return n > 0, nil
+}
+
+func (rc *redisCache) Get(key string) ([]byte, error) {
+	reply, err := rc.client.Do("GET", rc.prefix+key)
+	if err != nil {
+		if err == errRedisNil {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	value, _ := reply.([]byte)
+	return value, nil
+}
+
+func (rc *redisCache) Set(key string, value []byte, ttl time.Duration) error {
+	if ttl > 0 {
+		_, err := rc.client.Do("SET", rc.prefix+key, string(value), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+		return err
+	}
+	_, err := rc.client.Do("SET", rc.prefix+key, string(value))
+	return err
+}
+
+func (rc *redisCache) Delete(key string) error {
+	_, err := rc.client.Do("DEL", rc.prefix+key)
+	return err
+}
+
+// Flush deletes all the keys with the prefix, or the whole db if no prefix is set.
+func (rc *redisCache) Flush() error {
+	if rc.prefix == "" {
+		_, err := rc.client.Do("FLUSHDB")
+		return err
+	}
+	return rc.client.Scan(escapeRedisPattern(rc.prefix)+"*", func(keys []string) error {
+		_, err := rc.client.Do(append([]string{"DEL"}, keys...)...)
+		return err
+	})
+}
+
+type redisCacheDriver struct{}
+
+// Open opens a redis cache, the url format is `redis:[host:port]?password=xxx&db=0&prefix=esm:&poolSize=10&timeout=5s`.
+func (driver *redisCacheDriver) Open(addr string, options url.Values) (Cache, error) {
+	client, err := newRedisClient(addr, options)
+	if err != nil {
+		return nil, err
+	}
+	return &redisCache{client, options.Get("prefix")}, nil
+}
+
+func init() {
+	RegisterCache("redis", &redisCacheDriver{})
+}
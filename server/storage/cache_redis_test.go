@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serveFakeRedis starts a fake redis server that supports the `PING`, `GET`, `SET`, `EXISTS`, `DEL` and `SCAN`
+// commands, the `SCAN` command returns all the keys at once and only supports the `prefix*` pattern.
+func serveFakeRedis(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var lock sync.Mutex
+	store := map[string]string{}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					reply, err := readRedisReply(r)
+					if err != nil {
+						return
+					}
+					a := reply.([]interface{})
+					args := make([]string, len(a))
+					for i, v := range a {
+						args[i] = string(v.([]byte))
+					}
+					lock.Lock()
+					switch args[0] {
+					case "PING":
+						fmt.Fprint(conn, "+PONG\r\n")
+					case "SET":
+						store[args[1]] = args[2]
+						fmt.Fprint(conn, "+OK\r\n")
+					case "GET":
+						if v, ok := store[args[1]]; ok {
+							fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+						} else {
+							fmt.Fprint(conn, "$-1\r\n")
+						}
+					case "EXISTS":
+						_, ok := store[args[1]]
+						if ok {
+							fmt.Fprint(conn, ":1\r\n")
+						} else {
+							fmt.Fprint(conn, ":0\r\n")
+						}
+					case "DEL":
+						for _, key := range args[1:] {
+							delete(store, key)
+						}
+						fmt.Fprintf(conn, ":%d\r\n", len(args)-1)
+					case "SCAN":
+						prefix := strings.TrimSuffix(args[3], "*")
+						prefix = strings.NewReplacer(`\\`, `\`, `\*`, "*", `\?`, "?", `\[`, "[", `\]`, "]").Replace(prefix)
+						keys := []string{}
+						for key := range store {
+							if strings.HasPrefix(key, prefix) {
+								keys = append(keys, key)
+							}
+						}
+						fmt.Fprintf(conn, "*2\r\n$1\r\n0\r\n*%d\r\n", len(keys))
+						for _, key := range keys {
+							fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(key), key)
+						}
+					default:
+						fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+					}
+					lock.Unlock()
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestRedisCache(t *testing.T) {
+	addr := serveFakeRedis(t)
+	cache, err := OpenCache("redis:" + addr + "?prefix=test:&poolSize=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rc, ok := cache.(*redisCache)
+	if !ok {
+		t.Fatal("not a redis cache")
+	}
+	if rc.prefix != "test:" {
+		t.Fatalf("invalid prefix '%s', should be 'test:'", rc.prefix)
+	}
+
+	err = cache.Set("key", []byte("hello\r\nworld"), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := cache.Get("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "hello\r\nworld" {
+		t.Fatalf("invalid value(%q), shoud be 'hello\\r\\nworld'", value)
+	}
+
+	ok, err = cache.Has("key")
+	if err != nil || !ok {
+		t.Fatal("key should be existent")
+	}
+
+	err = cache.Delete("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cache.Get("key")
+	if err != ErrNotFound {
+		t.Fatalf("key should be not existent")
+	}
+
+	err = cache.Set("key", []byte("hello"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cache.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err = cache.Has("key")
+	if err != nil || ok {
+		t.Fatal("key should be flushed")
+	}
+
+	_, err = rc.client.Do("UNKNOWN")
+	if err == nil {
+		t.Fatal("should return an error for the unknown command")
+	}
+	if _, ok := err.(redisError); !ok {
+		t.Fatalf("invalid error type %T, should be redisError", err)
+	}
+}
@@ -9,8 +9,9 @@ import (
 )
 
 var (
-	ErrNotFound = errors.New("not found")
-	ErrExpired  = errors.New("record is expired")
+	ErrNotFound    = errors.New("not found")
+	ErrExpired     = errors.New("record is expired")
+	ErrInvalidPath = errors.New("invalid path")
 )
 
 func parseConfigUrl(configUrl string) (root string, options url.Values, err error) {
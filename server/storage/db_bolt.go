@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"bytes"
 	"net/url"
 
 	bolt "go.etcd.io/bbolt"
@@ -49,6 +50,18 @@ func (i *boltDB) Delete(key string) error {
 	})
 }
 
+func (i *boltDB) Keys(prefix string) (keys []string, err error) {
+	err = i.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(defaultBucket).Cursor()
+		p := []byte(prefix)
+		for k, _ := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = c.Next() {
+			keys = append(keys, string(k))
+		}
+		return nil
+	})
+	return
+}
+
 func (i *boltDB) Close() error {
 	return i.db.Close()
 }
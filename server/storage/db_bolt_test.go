@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltDB(t *testing.T) {
+	dir, err := os.MkdirTemp("", "esm-db-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := OpenDB("bolt:" + filepath.Join(dir, "esm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, key := range []string{"react@18.2.0/es2022/react.mjs", "react-dom@18.2.0/es2022/react-dom.mjs", "vue@3.3.4/es2022/vue.mjs"} {
+		err = db.Put(key, []byte("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	value, err := db.Get("vue@3.3.4/es2022/vue.mjs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "{}" {
+		t.Fatalf("invalid value '%s', should be '{}'", value)
+	}
+
+	keys, err := db.Keys("react")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("invalid keys %v, should have 2 keys", keys)
+	}
+
+	err = db.Delete("vue@3.3.4/es2022/vue.mjs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err = db.Keys("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("invalid keys %v, should have 2 keys", keys)
+	}
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"net/url"
+	"strings"
+)
+
+// redisDB stores the data in redis, that allows multiple servers to share the build metadata.
+type redisDB struct {
+	client *redisClient
+	prefix string
+}
+
+// Get returns nil without error if the key doesn't exist, same as the bolt db.
+func (rdb *redisDB) Get(key string) ([]byte, error) {
+	reply, err := rdb.client.Do("GET", rdb.prefix+key)
+	if err != nil {
+		if err == errRedisNil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	value, _ := reply.([]byte)
+	return value, nil
+}
+
+func (rdb *redisDB) Put(key string, value []byte) error {
+	_, err := rdb.client.Do("SET", rdb.prefix+key, string(value))
+	return err
+}
+
+func (rdb *redisDB) Delete(key string) error {
+	_, err := rdb.client.Do("DEL", rdb.prefix+key)
+	return err
+}
+
+func (rdb *redisDB) Keys(prefix string) (keys []string, err error) {
+	err = rdb.client.Scan(escapeRedisPattern(rdb.prefix+prefix)+"*", func(batch []string) error {
+		for _, key := range batch {
+			keys = append(keys, strings.TrimPrefix(key, rdb.prefix))
+		}
+		return nil
+	})
+	return
+}
+
+func (rdb *redisDB) Close() error {
+	return rdb.client.Close()
+}
+
+type redisDBDriver struct{}
+
+// Open opens a redis db, the url format is `redis:[host:port]?password=xxx&db=0&prefix=esm-db:&poolSize=10&timeout=5s`.
+// The keys are stored without expiration, use a separate redis db or a prefix to isolate them from the cache.
+func (driver *redisDBDriver) Open(addr string, options url.Values) (DataBase, error) {
+	client, err := newRedisClient(addr, options)
+	if err != nil {
+		return nil, err
+	}
+	return &redisDB{client, options.Get("prefix")}, nil
+}
+
+func init() {
+	RegisterDB("redis", &redisDBDriver{})
+}
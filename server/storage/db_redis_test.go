@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestRedisDB(t *testing.T) {
+	addr := serveFakeRedis(t)
+	db, err := OpenDB("redis:" + addr + "?prefix=esm-db:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, key := range []string{"react@18.2.0/es2022/react.mjs", "react-dom@18.2.0/es2022/react-dom.mjs", "vue@3.3.4/es2022/vue.mjs", "[abc]*"} {
+		err = db.Put(key, []byte("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	value, err := db.Get("vue@3.3.4/es2022/vue.mjs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "{}" {
+		t.Fatalf("invalid value '%s', should be '{}'", value)
+	}
+
+	value, err = db.Get("not-found")
+	if err != nil || value != nil {
+		t.Fatalf("should return nil for the missing key, got %q, %v", value, err)
+	}
+
+	keys, err := db.Keys("react")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("invalid keys %v, should have 2 keys", keys)
+	}
+	for _, key := range keys {
+		if key != "react@18.2.0/es2022/react.mjs" && key != "react-dom@18.2.0/es2022/react-dom.mjs" {
+			t.Fatalf("invalid key '%s'", key)
+		}
+	}
+
+	keys, err = db.Keys("[abc]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "[abc]*" {
+		t.Fatalf("invalid keys %v, should be ['[abc]*']", keys)
+	}
+
+	err = db.Delete("vue@3.3.4/es2022/vue.mjs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err = db.Keys("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("invalid keys %v, should have 3 keys", keys)
+	}
+}
@@ -14,6 +14,8 @@ type FileSystem interface {
 	Stat(path string) (stat FileStat, err error)
 	OpenFile(path string) (content io.ReadSeekCloser, err error)
 	WriteFile(path string, r io.Reader) (written int64, err error)
+	List(dir string) (files []string, err error)
+	Remove(path string) (err error)
 }
 
 type FileStat interface {
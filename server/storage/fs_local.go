@@ -1,30 +1,69 @@
 package storage
 
 import (
+	"errors"
 	"io"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
+	"strings"
 )
 
 type localFSDriver struct{}
 
 func (driver *localFSDriver) Open(root string, options url.Values) (FileSystem, error) {
-	root = filepath.Clean(root)
-	err := ensureDir(root)
+	// use the absolute path to support long paths on windows
+	root, err := filepath.Abs(root)
 	if err != nil {
 		return nil, err
 	}
-	return &localFSLayer{root}, nil
+	err = ensureDir(root)
+	if err != nil {
+		return nil, err
+	}
+	// escape the paths by default on windows, as the file system is case-insensitive
+	escape := options.Get("escape") == "true" || (options.Get("escape") == "" && runtime.GOOS == "windows")
+	if escape && !fileExists(filepath.Join(root, pathEscapeMarker)) {
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) > 0 {
+			return nil, errors.New("the paths of the local file system are not escaped, please run `esmd --migrate-fs-paths` first")
+		}
+		err = os.WriteFile(filepath.Join(root, pathEscapeMarker), []byte("1"), 0644)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &localFSLayer{root, escape}, nil
 }
 
 type localFSLayer struct {
-	root string
+	root   string
+	escape bool
 }
 
-func (fs *localFSLayer) Stat(name string) (FileStat, error) {
+// fullPath returns the full path of the file, the name is escaped if the `escape` option is enabled.
+// The `ErrInvalidPath` error is returned if the path escapes the root.
+func (fs *localFSLayer) fullPath(name string) (string, error) {
+	if fs.escape {
+		name = EscapePath(name)
+	}
 	fullPath := path.Join(fs.root, name)
+	if fullPath != fs.root && !strings.HasPrefix(fullPath, strings.TrimSuffix(fs.root, "/")+"/") {
+		return "", ErrInvalidPath
+	}
+	return fullPath, nil
+}
+
+func (fs *localFSLayer) Stat(name string) (FileStat, error) {
+	fullPath, err := fs.fullPath(name)
+	if err != nil {
+		return nil, err
+	}
 	fi, err := os.Lstat(fullPath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -36,7 +75,10 @@ func (fs *localFSLayer) Stat(name string) (FileStat, error) {
 }
 
 func (fs *localFSLayer) OpenFile(name string) (file io.ReadSeekCloser, err error) {
-	fullPath := path.Join(fs.root, name)
+	fullPath, err := fs.fullPath(name)
+	if err != nil {
+		return
+	}
 	file, err = os.Open(fullPath)
 	if err != nil && os.IsNotExist(err) {
 		err = ErrNotFound
@@ -45,19 +87,79 @@ func (fs *localFSLayer) OpenFile(name string) (file io.ReadSeekCloser, err error
 }
 
 func (fs *localFSLayer) WriteFile(name string, content io.Reader) (written int64, err error) {
-	fullPath := path.Join(fs.root, name)
+	fullPath, err := fs.fullPath(name)
+	if err != nil {
+		return
+	}
 	err = ensureDir(path.Dir(fullPath))
 	if err != nil {
 		return
 	}
 
-	file, err := os.Create(fullPath)
+	// write to a temporary file then rename it, so the readers never see a partial file
+	file, err := os.CreateTemp(path.Dir(fullPath), path.Base(fullPath)+".*.tmp")
 	if err != nil {
 		return
 	}
-	defer file.Close()
-
 	written, err = io.Copy(file, content)
+	if e := file.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), fullPath)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return
+}
+
+// Remove removes the file, no error is returned if the file doesn't exist.
+func (fs *localFSLayer) Remove(name string) error {
+	fullPath, err := fs.fullPath(name)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(fullPath)
+	if err != nil && os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// List returns all the files in the given directory recursively,
+// the returned paths are relative to the root of the file system.
+func (fs *localFSLayer) List(dir string) (files []string, err error) {
+	dirPath, err := fs.fullPath(dir)
+	if err != nil {
+		return
+	}
+	err = filepath.Walk(dirPath, func(fp string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			rel, err := filepath.Rel(fs.root, fp)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if rel == pathEscapeMarker {
+				return nil
+			}
+			if fs.escape {
+				rel, err = UnescapePath(rel)
+				if err != nil {
+					return err
+				}
+			}
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil && os.IsNotExist(err) {
+		err = ErrNotFound
+	}
 	return
 }
 
@@ -5,6 +5,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 )
 
@@ -56,6 +58,24 @@ func TestLocalFS(t *testing.T) {
 		t.Fatalf("invalid file content('%s'), shoud be 'bar'", string(data))
 	}
 
+	_, err = fs.WriteFile("foo/bar.txt", bytes.NewBufferString("bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := fs.List("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0] != "foo/bar.txt" {
+		t.Fatalf("invalid file list(%v), should be [foo/bar.txt]", files)
+	}
+
+	_, err = fs.List("fo0")
+	if err != ErrNotFound {
+		t.Fatalf("Directory should be not existent")
+	}
+
 	_, err = fs.Stat("fo0.txt")
 	if err != ErrNotFound {
 		t.Fatalf("File should be not existent")
@@ -65,4 +85,160 @@ func TestLocalFS(t *testing.T) {
 	if err != ErrNotFound {
 		t.Fatalf("File should be not existent")
 	}
+
+	err = fs.Remove("foo/bar.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fs.Stat("foo/bar.txt")
+	if err != ErrNotFound {
+		t.Fatalf("File should be removed")
+	}
+	err = fs.Remove("fo0.txt")
+	if err != nil {
+		t.Fatalf("Removing the non-existent file should not fail: %v", err)
+	}
+}
+
+func TestEscapePath(t *testing.T) {
+	for _, name := range []string{
+		"builds/v118/react@18.2.0/es2022/react.mjs",
+		"builds/v118/JSONStream@1.3.5/X-ZC9yZWFjdEAxOC4yLjA/es2022/JSONStream.mjs",
+		"builds/v118/@scope/pkg!name@1.0.0/es2022/pkg*name.mjs",
+		"types/v118/foo%bar@0.0.1/index.d.ts",
+	} {
+		escaped := EscapePath(name)
+		if strings.ToLower(escaped) != escaped {
+			t.Fatalf("escaped path '%s' should be lower case", escaped)
+		}
+		unescaped, err := UnescapePath(escaped)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if unescaped != name {
+			t.Fatalf("invalid unescaped path '%s', should be '%s'", unescaped, name)
+		}
+	}
+	if EscapePath("JSONStream") == EscapePath("jsonstream") {
+		t.Fatal("escaped paths should not collide")
+	}
+}
+
+func TestLocalFSEscape(t *testing.T) {
+	root, err := os.MkdirTemp("", "esm-fs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	fs, err := OpenFS("local:" + root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fs.WriteFile("builds/JSONStream.mjs", bytes.NewBufferString("bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenFS("local:" + root + "?escape=true")
+	if err == nil {
+		t.Fatal("should require migration")
+	}
+
+	n, err := MigrateLocalFSPaths(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("invalid renamed(%d), should be 1", n)
+	}
+
+	fs, err = OpenFS("local:" + root + "?escape=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fs.Stat("builds/JSONStream.mjs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(filepath.Join(root, "builds", "!j!s!o!n!stream.mjs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := fs.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0] != "builds/JSONStream.mjs" {
+		t.Fatalf("invalid file list(%v), should be [builds/JSONStream.mjs]", files)
+	}
+}
+
+func TestMigrateLocalFSPathsResume(t *testing.T) {
+	root, err := os.MkdirTemp("", "esm-fs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	os.MkdirAll(filepath.Join(root, "builds"), 0755)
+	os.WriteFile(filepath.Join(root, "builds", "React.mjs"), []byte("react"), 0644)
+	// an interrupted migration that has renamed the `JSONStream.mjs`
+	os.WriteFile(filepath.Join(root, "builds", "!j!s!o!n!stream.mjs"), []byte("bar"), 0644)
+	os.WriteFile(filepath.Join(root, pathEscapeProgress), []byte("builds/!j!s!o!n!stream.mjs\n"), 0644)
+
+	n, err := MigrateLocalFSPaths(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("invalid renamed(%d), should be 1", n)
+	}
+	if fileExists(filepath.Join(root, pathEscapeProgress)) {
+		t.Fatal("the progress file should be removed")
+	}
+
+	fs, err := OpenFS("local:" + root + "?escape=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := fs.List("builds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	if strings.Join(files, ",") != "builds/JSONStream.mjs,builds/React.mjs" {
+		t.Fatalf("invalid file list(%v)", files)
+	}
+}
+
+func TestLocalFSPathTraversal(t *testing.T) {
+	root, err := os.MkdirTemp("", "esm-fs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	fs, err := OpenFS("local:" + root + "/storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(root+"/secret", []byte("secret"), 0644)
+
+	_, err = fs.OpenFile("builds/../../secret")
+	if err != ErrInvalidPath {
+		t.Fatalf("expected ErrInvalidPath, got %v", err)
+	}
+	_, err = fs.Stat("../secret")
+	if err != ErrInvalidPath {
+		t.Fatalf("expected ErrInvalidPath, got %v", err)
+	}
+	_, err = fs.WriteFile("../foo", bytes.NewBufferString("bar"))
+	if err != ErrInvalidPath {
+		t.Fatalf("expected ErrInvalidPath, got %v", err)
+	}
+	_, err = fs.WriteFile("builds/../foo", bytes.NewBufferString("bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
 }
@@ -0,0 +1,347 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the S3 compatible file system, the url is like
+// "s3:https://s3.us-east-1.amazonaws.com/bucket/prefix?region=us-east-1&accessKeyId=xxx&secretAccessKey=xxx",
+// the objects are addressed in path style, that is supported by AWS S3, Cloudflare R2, MinIO, etc.
+// The credentials are read from the `AWS_ACCESS_KEY_ID`, `AWS_SECRET_ACCESS_KEY` and `AWS_SESSION_TOKEN`
+// env if they are not set in the url.
+type s3FSDriver struct{}
+
+func (driver *s3FSDriver) Open(root string, options url.Values) (FileSystem, error) {
+	u, err := url.Parse(root)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("invalid s3 url, should be like \"s3:https://s3.us-east-1.amazonaws.com/bucket/prefix\"")
+	}
+	bucket, prefix, _ := strings.Cut(strings.Trim(u.Path, "/"), "/")
+	if bucket == "" {
+		return nil, errors.New("invalid s3 url: missing bucket")
+	}
+	fs := &s3FSLayer{
+		endpoint:        u.Scheme + "://" + u.Host,
+		bucket:          bucket,
+		prefix:          prefix,
+		region:          options.Get("region"),
+		accessKeyID:     options.Get("accessKeyId"),
+		secretAccessKey: options.Get("secretAccessKey"),
+		sessionToken:    options.Get("sessionToken"),
+		client:          &http.Client{Timeout: 5 * time.Minute},
+	}
+	if fs.region == "" {
+		fs.region = "us-east-1"
+	}
+	if fs.accessKeyID == "" {
+		fs.accessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		fs.secretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		fs.sessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if fs.accessKeyID == "" || fs.secretAccessKey == "" {
+		return nil, errors.New("missing s3 credentials")
+	}
+	return fs, nil
+}
+
+type s3FSLayer struct {
+	endpoint        string
+	bucket          string
+	prefix          string
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	client          *http.Client
+}
+
+type s3FileStat struct {
+	size    int64
+	modtime time.Time
+}
+
+func (fi *s3FileStat) Size() int64 {
+	return fi.size
+}
+
+func (fi *s3FileStat) ModTime() time.Time {
+	return fi.modtime
+}
+
+type s3File struct {
+	*bytes.Reader
+}
+
+func (f *s3File) Close() error {
+	return nil
+}
+
+func (fs *s3FSLayer) key(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if fs.prefix != "" {
+		return fs.prefix + "/" + name
+	}
+	return name
+}
+
+func (fs *s3FSLayer) Stat(name string) (FileStat, error) {
+	if isUnsafeName(name) {
+		return nil, ErrInvalidPath
+	}
+	res, err := fs.do("HEAD", fs.key(name), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	modtime, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+	return &s3FileStat{res.ContentLength, modtime}, nil
+}
+
+// OpenFile reads the whole object into memory since the storage files need to be seekable.
+func (fs *s3FSLayer) OpenFile(name string) (io.ReadSeekCloser, error) {
+	if isUnsafeName(name) {
+		return nil, ErrInvalidPath
+	}
+	res, err := fs.do("GET", fs.key(name), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &s3File{bytes.NewReader(data)}, nil
+}
+
+// WriteFile buffers the content since the S3 API requires the content length of the object.
+func (fs *s3FSLayer) WriteFile(name string, content io.Reader) (written int64, err error) {
+	if isUnsafeName(name) {
+		return 0, ErrInvalidPath
+	}
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return
+	}
+	res, err := fs.do("PUT", fs.key(name), nil, data)
+	if err != nil {
+		return
+	}
+	res.Body.Close()
+	return int64(len(data)), nil
+}
+
+// List returns all the files in the given directory recursively,
+// the returned paths are relative to the root of the file system.
+func (fs *s3FSLayer) List(dir string) (files []string, err error) {
+	if isUnsafeName(dir) {
+		return nil, ErrInvalidPath
+	}
+	prefix := fs.key(strings.TrimSuffix(dir, "/"))
+	if prefix != "" {
+		prefix += "/"
+	}
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		var res *http.Response
+		res, err = fs.do("GET", "", query, nil)
+		if err != nil {
+			return
+		}
+		var ret struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(res.Body).Decode(&ret)
+		res.Body.Close()
+		if err != nil {
+			return
+		}
+		for _, c := range ret.Contents {
+			name := c.Key
+			if fs.prefix != "" {
+				name = strings.TrimPrefix(name, fs.prefix+"/")
+			}
+			files = append(files, name)
+		}
+		if !ret.IsTruncated || ret.NextContinuationToken == "" {
+			break
+		}
+		query.Set("continuation-token", ret.NextContinuationToken)
+	}
+	if len(files) == 0 {
+		err = ErrNotFound
+	}
+	return
+}
+
+// Remove removes the file, no error is returned if the file doesn't exist.
+func (fs *s3FSLayer) Remove(name string) error {
+	if isUnsafeName(name) {
+		return ErrInvalidPath
+	}
+	res, err := fs.do("DELETE", fs.key(name), nil, nil)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// do sends the signed request to the bucket, the `ErrNotFound` error is returned if the object
+// doesn't exist.
+func (fs *s3FSLayer) do(method string, key string, query url.Values, body []byte) (*http.Response, error) {
+	u := fs.endpoint + "/" + s3EscapePath(fs.bucket+"/"+key)
+	if len(query) > 0 {
+		u += "?" + s3CanonicalQuery(query)
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		req.Body = http.NoBody
+		req.ContentLength = 0
+	}
+	if fs.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", fs.sessionToken)
+	}
+	signS3Request(req, body, fs.accessKeyID, fs.secretAccessKey, fs.region, time.Now())
+	res, err := fs.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == 404 {
+		res.Body.Close()
+		return nil, ErrNotFound
+	}
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		res.Body.Close()
+		return nil, fmt.Errorf("s3: %s %s: %s %s", method, key, res.Status, strings.TrimSpace(string(msg)))
+	}
+	return res, nil
+}
+
+// signS3Request signs the request with the AWS Signature Version 4, the headers that are set
+// before signing are signed as well.
+// see https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func signS3Request(req *http.Request, body []byte, accessKeyID string, secretAccessKey string, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := bytes.NewBuffer(nil)
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalRequestHash[:])
+
+	key := []byte("AWS4" + secretAccessKey)
+	for _, s := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3EscapePath escapes the path with the URI encoding of AWS, the `/` is not escaped.
+func s3EscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = s3Escape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape escapes all the characters except the unreserved characters of RFC 3986.
+func s3Escape(s string) string {
+	buf := bytes.NewBuffer(nil)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			buf.WriteByte(c)
+		} else {
+			buf.WriteString("%" + strings.ToUpper(strconv.FormatInt(int64(c)|0x100, 16)[1:]))
+		}
+	}
+	return buf.String()
+}
+
+// isUnsafeName checks if the name escapes the root of the file system.
+func isUnsafeName(name string) bool {
+	for _, p := range strings.Split(name, "/") {
+		if p == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	RegisterFileSystem("s3", &s3FSDriver{})
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// the marker file in the root of the local file system that has escaped paths
+const pathEscapeMarker = ".path-escape"
+
+// the progress file of the migration, each line is an escaped path that has been renamed
+const pathEscapeProgress = ".path-escape-progress"
+
+// EscapePath encodes the path into a form that is safe on any file system, the encoding is reversible:
+//   - upper case letters are encoded as `!` followed by the lower case letter, to avoid collisions on case-insensitive file systems
+//   - characters other than `[a-z0-9/._~@+,=-]` are encoded as `%xx`
+func EscapePath(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'A' && c <= 'Z':
+			b.WriteByte('!')
+			b.WriteByte(c + ('a' - 'A'))
+		case (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || strings.IndexByte("/._~@+,=-", c) >= 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02x", c)
+		}
+	}
+	return b.String()
+}
+
+// UnescapePath decodes the path encoded by `EscapePath`.
+func UnescapePath(name string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch c {
+		case '!':
+			if i+1 >= len(name) || name[i+1] < 'a' || name[i+1] > 'z' {
+				return "", errors.New("invalid escaped path: " + name)
+			}
+			b.WriteByte(name[i+1] - ('a' - 'A'))
+			i++
+		case '%':
+			if i+2 >= len(name) {
+				return "", errors.New("invalid escaped path: " + name)
+			}
+			v, err := strconv.ParseUint(name[i+1:i+3], 16, 8)
+			if err != nil {
+				return "", errors.New("invalid escaped path: " + name)
+			}
+			b.WriteByte(byte(v))
+			i += 2
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+// MigrateLocalFSPaths renames the files in the local file system root with the escaped paths,
+// it's required before enabling the `escape` option of an existing local file system.
+// The progress is recorded before each rename, so an interrupted migration can be re-run
+// without escaping the renamed files twice.
+func MigrateLocalFSPaths(root string) (renamed int, err error) {
+	root = filepath.Clean(root)
+	if fileExists(filepath.Join(root, pathEscapeMarker)) {
+		return 0, nil
+	}
+
+	progressFile := filepath.Join(root, pathEscapeProgress)
+	migrated := map[string]bool{}
+	if data, err := ioutil.ReadFile(progressFile); err == nil {
+		for _, name := range strings.Split(string(data), "\n") {
+			if name != "" {
+				migrated[name] = true
+			}
+		}
+	}
+
+	var files []string
+	var dirs []string
+	err = filepath.Walk(root, func(fp string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() && fp != root {
+			dirs = append(dirs, fp)
+		} else if fi.Mode().IsRegular() {
+			rel, err := filepath.Rel(root, fp)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if rel != pathEscapeProgress && !migrated[rel] {
+				files = append(files, rel)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	progress, err := os.OpenFile(progressFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	defer progress.Close()
+
+	for _, name := range files {
+		escaped := EscapePath(name)
+		if escaped == name {
+			continue
+		}
+		dest := filepath.Join(root, filepath.FromSlash(escaped))
+		err = ensureDir(filepath.Dir(dest))
+		if err != nil {
+			return
+		}
+		_, err = progress.WriteString(escaped + "\n")
+		if err == nil {
+			err = progress.Sync()
+		}
+		if err != nil {
+			return
+		}
+		err = os.Rename(filepath.Join(root, filepath.FromSlash(name)), dest)
+		if err != nil {
+			return
+		}
+		renamed++
+	}
+
+	// remove the empty directories left by renaming
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+
+	err = ioutil.WriteFile(filepath.Join(root, pathEscapeMarker), []byte("1"), 0644)
+	if err != nil {
+		return
+	}
+	progress.Close()
+	os.Remove(progressFile)
+	return
+}
+
+func fileExists(filepath string) bool {
+	fi, err := os.Lstat(filepath)
+	return err == nil && !fi.IsDir()
+}
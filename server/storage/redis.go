@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// redisClient is a minimal redis client that speaks the RESP protocol.
+type redisClient struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+	pool     chan *redisConn
+}
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// errRedisNil is returned when the reply is a nil bulk string
+var errRedisNil = errors.New("redis: nil")
+
+func newRedisClient(addr string, options url.Values) (*redisClient, error) {
+	if addr == "" {
+		addr = "127.0.0.1:6379"
+	}
+	poolSize := 10
+	if v := options.Get("poolSize"); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil || i <= 0 {
+			return nil, errors.New("invalid poolSize value")
+		}
+		poolSize = i
+	}
+	db := 0
+	if v := options.Get("db"); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 0 {
+			return nil, errors.New("invalid db value")
+		}
+		db = i
+	}
+	timeout, err := parseDurationValue(options.Get("timeout"), 5*time.Second)
+	if err != nil {
+		return nil, errors.New("invalid timeout value")
+	}
+	client := &redisClient{
+		addr:     addr,
+		password: options.Get("password"),
+		db:       db,
+		timeout:  timeout,
+		pool:     make(chan *redisConn, poolSize),
+	}
+	// check the connection
+	_, err = client.Do("PING")
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func (client *redisClient) dial() (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", client.addr, client.timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn, bufio.NewReader(conn)}
+	if client.password != "" {
+		_, err = c.do(client.timeout, "AUTH", client.password)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if client.db > 0 {
+		_, err = c.do(client.timeout, "SELECT", strconv.Itoa(client.db))
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Do sends a command to the redis server and returns the reply.
+func (client *redisClient) Do(args ...string) (reply interface{}, err error) {
+	var c *redisConn
+	select {
+	case c = <-client.pool:
+	default:
+		c, err = client.dial()
+		if err != nil {
+			return
+		}
+	}
+	reply, err = c.do(client.timeout, args...)
+	if err != nil {
+		var e redisError
+		if !errors.As(err, &e) && err != errRedisNil {
+			// drop the broken connection
+			c.conn.Close()
+			return
+		}
+	}
+	select {
+	case client.pool <- c:
+	default:
+		c.conn.Close()
+	}
+	return
+}
+
+// Scan iterates the keys that match the pattern with the `SCAN` command, the callback is
+// called with every batch of the keys.
+func (client *redisClient) Scan(pattern string, callback func(keys []string) error) error {
+	cursor := "0"
+	for {
+		reply, err := client.Do("SCAN", cursor, "MATCH", pattern, "COUNT", "1000")
+		if err != nil {
+			return err
+		}
+		a, ok := reply.([]interface{})
+		if !ok || len(a) != 2 {
+			return redisError("invalid SCAN reply")
+		}
+		next, _ := a[0].([]byte)
+		items, _ := a[1].([]interface{})
+		if len(items) > 0 {
+			keys := make([]string, 0, len(items))
+			for _, item := range items {
+				if k, ok := item.([]byte); ok {
+					keys = append(keys, string(k))
+				}
+			}
+			err = callback(keys)
+			if err != nil {
+				return err
+			}
+		}
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return nil
+		}
+	}
+}
+
+// escapeRedisPattern escapes the glob characters of the `MATCH` pattern.
+func escapeRedisPattern(s string) string {
+	buf := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '*', '?', '[', ']', '\\':
+			buf = append(buf, '\\', c)
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return string(buf)
+}
+
+func (client *redisClient) Close() error {
+	for {
+		select {
+		case c := <-client.pool:
+			c.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (c *redisConn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	_, err := c.conn.Write(encodeRedisCommand(args))
+	if err != nil {
+		return nil, err
+	}
+	return readRedisReply(c.r)
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+func encodeRedisCommand(args []string) []byte {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, fmt.Sprintf("*%d\r\n", len(args))...)
+	for _, arg := range args {
+		buf = append(buf, fmt.Sprintf("$%d\r\n", len(arg))...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	return buf
+}
+
+// readRedisReply reads a RESP reply, the reply is one of `string`(simple string),
+// `int64`, `[]byte`(bulk string) and `[]interface{}`(array).
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: invalid reply")
+	}
+	kind, value := line[0], string(line[1:len(line)-2])
+	switch kind {
+	case '+':
+		return value, nil
+	case '-':
+		return nil, redisError(value)
+	case ':':
+		return strconv.ParseInt(value, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		data := make([]byte, n+2)
+		_, err = io.ReadFull(r, data)
+		if err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		a := make([]interface{}, n)
+		for i := range a {
+			a[i], err = readRedisReply(r)
+			if err != nil && err != errRedisNil {
+				return nil, err
+			}
+		}
+		return a, nil
+	}
+	return nil, errors.New("redis: invalid reply")
+}
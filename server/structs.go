@@ -2,7 +2,9 @@ package server
 
 import (
 	"encoding/json"
+	iofs "io/fs"
 	"io/ioutil"
+	"os"
 	"path"
 	"sync"
 )
@@ -15,17 +17,40 @@ func (fs devFS) ReadFile(name string) ([]byte, error) {
 	return ioutil.ReadFile(path.Join(fs.cwd, name))
 }
 
+// overlayFS reads the files from the dir first, then falls back to the embedded files,
+// the files in the dir use the same paths as the embedded files, e.g. `server/embed/index.html`.
+type overlayFS struct {
+	dir string
+	efs EmbedFS
+}
+
+func (fs *overlayFS) ReadFile(name string) ([]byte, error) {
+	if iofs.ValidPath(name) {
+		data, err := ioutil.ReadFile(path.Join(fs.dir, name))
+		if err == nil {
+			return data, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return fs.efs.ReadFile(name)
+}
+
+// stringSet is a set of strings that keeps the order of the first insertion of the keys,
+// e.g. the order of the `?conditions` query is the priority of the conditions.
 type stringSet struct {
 	lock sync.RWMutex
 	set  map[string]struct{}
+	keys []string
 }
 
 func newStringSet(keys ...string) *stringSet {
-	set := make(map[string]struct{}, len(keys))
+	s := &stringSet{set: make(map[string]struct{}, len(keys))}
 	for _, key := range keys {
-		set[key] = struct{}{}
+		s.add(key)
 	}
-	return &stringSet{set: set}
+	return s
 }
 
 func (s *stringSet) Len() int {
@@ -47,14 +72,29 @@ func (s *stringSet) Add(key string) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	s.set[key] = struct{}{}
+	s.add(key)
+}
+
+func (s *stringSet) add(key string) {
+	if _, ok := s.set[key]; !ok {
+		s.set[key] = struct{}{}
+		s.keys = append(s.keys, key)
+	}
 }
 
 func (s *stringSet) Remove(key string) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	delete(s.set, key)
+	if _, ok := s.set[key]; ok {
+		delete(s.set, key)
+		for i, k := range s.keys {
+			if k == key {
+				s.keys = append(s.keys[:i:i], s.keys[i+1:]...)
+				break
+			}
+		}
+	}
 }
 
 func (s *stringSet) Reset() {
@@ -62,38 +102,16 @@ func (s *stringSet) Reset() {
 	defer s.lock.Unlock()
 
 	s.set = map[string]struct{}{}
+	s.keys = nil
 }
 
+// Values returns the keys of the set in the order of the first insertion.
 func (s *stringSet) Values() []string {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
 
-	a := make([]string, len(s.set))
-	i := 0
-	for key := range s.set {
-		a[i] = key
-		i++
-	}
-	return a
-}
-
-type orderedStringSet struct {
-	set []string
-}
-
-func (s *orderedStringSet) Len() int {
-	return len(s.set)
-}
-
-func (s *orderedStringSet) Add(key string) {
-	if !includes(s.set, key) {
-		s.set = append(s.set, key)
-	}
-}
-
-func (s *orderedStringSet) Values() []string {
-	a := make([]string, len(s.set))
-	copy(a, s.set)
+	a := make([]string, len(s.keys))
+	copy(a, s.keys)
 	return a
 }
 
@@ -0,0 +1,19 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStringSetValues(t *testing.T) {
+	set := newStringSet("react", "preact", "@preact/signals")
+	set.Add("buffer")
+	set.Add("react")
+	set.Remove("preact")
+	set.Add("preact")
+	for i := 0; i < 10; i++ {
+		if v := strings.Join(set.Values(), ","); v != "react,@preact/signals,buffer,preact" {
+			t.Fatalf("invalid values: %s", v)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/esm-dev/esm.sh/server/storage"
+	"github.com/ije/rex"
+)
+
+// the top-level directories of the storage that can be synced
+var syncDirs = []string{"builds", "types", "publish"}
+
+type SyncManifest struct {
+	Time  int64          `json:"time"`
+	Files []SyncFileInfo `json:"files"`
+}
+
+type SyncFileInfo struct {
+	Path    string          `json:"path"`
+	Size    int64           `json:"size"`
+	ModTime int64           `json:"mtime"`
+	DBKey   string          `json:"dbKey,omitempty"`
+	Meta    json.RawMessage `json:"meta,omitempty"`
+}
+
+// syncHandler serves the sync endpoints for the read-only replicas:
+//   - `GET /_sync/manifest?prefix=builds/v126/&since=1690000000000` lists the files changed since the given time(ms)
+//   - `GET /_sync/file?path=builds/v126/react@18.2.0/es2022/react.mjs` returns the file content
+func syncHandler() rex.Handle {
+	return func(ctx *rex.Context) interface{} {
+		pathname := ctx.Path.String()
+		if !strings.HasPrefix(pathname, "/_sync/") {
+			return nil
+		}
+		if !checkBearerToken(ctx.R, cfg.Sync.Token) {
+			return rex.Status(401, "Unauthorized")
+		}
+		ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
+
+		switch pathname {
+		case "/_sync/manifest":
+			var since int64
+			if v := ctx.Form.Value("since"); v != "" {
+				i, err := strconv.ParseInt(v, 10, 64)
+				if err != nil {
+					return rex.Status(400, "invalid since")
+				}
+				since = i
+			}
+			manifest, err := getSyncManifest(ctx.R.URL.Query()["prefix"], since)
+			if err != nil {
+				return rex.Status(500, err.Error())
+			}
+			return manifest
+
+		case "/_sync/file":
+			name := ctx.Form.Value("path")
+			if !isSyncablePath(name) {
+				return rex.Status(400, "invalid path")
+			}
+			fi, err := fs.Stat(name)
+			if err != nil {
+				if err == storage.ErrNotFound {
+					return rex.Status(404, "not found")
+				}
+				return rex.Status(500, err.Error())
+			}
+			r, err := fs.OpenFile(name)
+			if err != nil {
+				return rex.Status(500, err.Error())
+			}
+			ctx.SetHeader("Content-Type", "application/octet-stream")
+			return rex.Content(name, fi.ModTime(), r) // auto closed
+
+		default:
+			return rex.Status(404, "not found")
+		}
+	}
+}
+
+// isSyncablePath checks whether the given storage path can be synced.
+func isSyncablePath(name string) bool {
+	if name == "" || strings.Contains(name, "..") || strings.HasPrefix(name, "/") {
+		return false
+	}
+	for _, dir := range syncDirs {
+		if strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// the sync index of the storage, it's set when the server serves the sync endpoints
+var syncIndex *syncIndexFS
+
+// syncIndexFS records the changes of the syncable files, so the manifest is served from memory
+// instead of walking the whole storage on every poll. The storage is walked once on the first
+// manifest request.
+type syncIndexFS struct {
+	storage.FileSystem
+	lock    sync.Mutex
+	loaded  bool
+	files   map[string]SyncFileInfo
+	changes []SyncFileInfo // in the order of the changes, the outdated entries are compacted
+}
+
+func newSyncIndexFS(fs storage.FileSystem) *syncIndexFS {
+	return &syncIndexFS{FileSystem: fs, files: map[string]SyncFileInfo{}}
+}
+
+func (fs *syncIndexFS) WriteFile(name string, r io.Reader) (written int64, err error) {
+	written, err = fs.FileSystem.WriteFile(name, r)
+	if err == nil && isSyncablePath(name) {
+		if fi, e := fs.FileSystem.Stat(name); e == nil {
+			fs.lock.Lock()
+			fs.add(SyncFileInfo{Path: name, Size: fi.Size(), ModTime: fi.ModTime().UnixMilli()})
+			fs.lock.Unlock()
+		}
+	}
+	return
+}
+
+func (fs *syncIndexFS) Remove(name string) (err error) {
+	err = fs.FileSystem.Remove(name)
+	if err == nil {
+		fs.lock.Lock()
+		delete(fs.files, name)
+		fs.lock.Unlock()
+	}
+	return
+}
+
+func (fs *syncIndexFS) add(info SyncFileInfo) {
+	fs.files[info.Path] = info
+	fs.changes = append(fs.changes, info)
+	if len(fs.changes) > 2*len(fs.files)+1024 {
+		fs.compact()
+	}
+}
+
+// compact rebuilds the changes from the current files in the order of the modified time.
+func (fs *syncIndexFS) compact() {
+	changes := make([]SyncFileInfo, 0, len(fs.files))
+	for _, info := range fs.files {
+		changes = append(changes, info)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].ModTime < changes[j].ModTime })
+	fs.changes = changes
+}
+
+// load walks the storage to index the existing files, the files that are written meanwhile are kept.
+func (fs *syncIndexFS) load() error {
+	fs.lock.Lock()
+	loaded := fs.loaded
+	fs.lock.Unlock()
+	if loaded {
+		return nil
+	}
+	walked := []SyncFileInfo{}
+	for _, dir := range syncDirs {
+		files, err := fs.FileSystem.List(dir)
+		if err != nil {
+			if err == storage.ErrNotFound {
+				continue
+			}
+			return err
+		}
+		for _, name := range files {
+			if fi, e := fs.FileSystem.Stat(name); e == nil {
+				walked = append(walked, SyncFileInfo{Path: name, Size: fi.Size(), ModTime: fi.ModTime().UnixMilli()})
+			}
+		}
+	}
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	if !fs.loaded {
+		for _, info := range walked {
+			if _, ok := fs.files[info.Path]; !ok {
+				fs.files[info.Path] = info
+			}
+		}
+		fs.compact()
+		fs.loaded = true
+	}
+	return nil
+}
+
+// changedSince returns the files that are modified after the `since` time(ms).
+func (fs *syncIndexFS) changedSince(since int64) []SyncFileInfo {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	files := []SyncFileInfo{}
+	seen := map[string]bool{}
+	// the changes are appended in order, the concurrent writes may be a bit out of order
+	for i := len(fs.changes) - 1; i >= 0 && fs.changes[i].ModTime > since-60*1000; i-- {
+		info := fs.changes[i]
+		if current, ok := fs.files[info.Path]; ok && info.ModTime > since && !seen[info.Path] && current.ModTime == info.ModTime {
+			seen[info.Path] = true
+			files = append(files, info)
+		}
+	}
+	return files
+}
+
+// getSyncManifest lists the files that match the given prefixes and are modified after the `since` time(ms).
+func getSyncManifest(prefixes []string, since int64) (manifest *SyncManifest, err error) {
+	manifest = &SyncManifest{Time: time.Now().UnixMilli(), Files: []SyncFileInfo{}}
+	var changed []SyncFileInfo
+	if syncIndex != nil {
+		err = syncIndex.load()
+		if err != nil {
+			return
+		}
+		changed = syncIndex.changedSince(since)
+	} else {
+		for _, dir := range syncDirs {
+			var files []string
+			files, err = fs.List(dir)
+			if err != nil {
+				if err == storage.ErrNotFound {
+					err = nil
+					continue
+				}
+				return
+			}
+			for _, name := range files {
+				if fi, e := fs.Stat(name); e == nil && fi.ModTime().UnixMilli() > since {
+					changed = append(changed, SyncFileInfo{Path: name, Size: fi.Size(), ModTime: fi.ModTime().UnixMilli()})
+				}
+			}
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool { return changed[i].Path < changed[j].Path })
+	for _, info := range changed {
+		name := info.Path
+		if len(prefixes) > 0 && !startsWith(name, prefixes...) {
+			continue
+		}
+		if strings.HasPrefix(name, "builds/") {
+			id := strings.TrimPrefix(name, "builds/")
+			keys := []string{id}
+			if stablePrefix := fmt.Sprintf("v%d/", STABLE_VERSION); strings.HasPrefix(id, stablePrefix) {
+				keys = append(keys, "stable/"+strings.TrimPrefix(id, stablePrefix))
+			}
+			for _, key := range keys {
+				meta, e := db.Get(key)
+				if e == nil && meta != nil {
+					info.DBKey = key
+					info.Meta = meta
+					break
+				}
+			}
+		}
+		manifest.Files = append(manifest.Files, info)
+	}
+	return
+}
+
+// startSync pulls the artifacts from the build server periodically.
+func startSync() {
+	var since int64
+	for {
+		t, err := syncFrom(cfg.Sync.From, cfg.Sync.Prefixes, since)
+		if err != nil {
+			log.Errorf("sync from %s: %v", cfg.Sync.From, err)
+		} else {
+			since = t
+		}
+		time.Sleep(time.Duration(cfg.Sync.Interval) * time.Second)
+	}
+}
+
+// syncFrom pulls the files changed since the given time(ms) from the build server,
+// it returns the time of the manifest that is used for the next sync.
+func syncFrom(origin string, prefixes []string, since int64) (int64, error) {
+	query := url.Values{}
+	for _, prefix := range prefixes {
+		query.Add("prefix", prefix)
+	}
+	query.Set("since", strconv.FormatInt(since, 10))
+	res, err := syncRequest(fmt.Sprintf("%s/_sync/manifest?%s", origin, query.Encode()))
+	if err != nil {
+		return since, err
+	}
+	defer res.Body.Close()
+
+	var manifest SyncManifest
+	err = json.NewDecoder(res.Body).Decode(&manifest)
+	if err != nil {
+		return since, fmt.Errorf("invalid manifest: %v", err)
+	}
+
+	n := 0
+	for _, file := range manifest.Files {
+		if !isSyncablePath(file.Path) {
+			continue
+		}
+		// skip the unchanged files
+		fi, err := fs.Stat(file.Path)
+		if err == nil && fi.Size() == file.Size && fi.ModTime().UnixMilli() >= file.ModTime {
+			continue
+		}
+		err = syncFile(origin, file)
+		if err != nil {
+			// retry in next sync
+			return since, fmt.Errorf("%s: %v", file.Path, err)
+		}
+		n++
+	}
+	if n > 0 {
+		log.Infof("synced %d files from %s", n, origin)
+	}
+	return manifest.Time, nil
+}
+
+func syncFile(origin string, file SyncFileInfo) error {
+	res, err := syncRequest(fmt.Sprintf("%s/_sync/file?path=%s", origin, url.QueryEscape(file.Path)))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// read the whole content before writing to avoid leaving a broken file in the storage
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	_, err = fs.WriteFile(file.Path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	smallFileCache.Delete(file.Path)
+	// the db record must be stored after the file is written, since the `queryESMBuild`
+	// function deletes the record if the build file is not found.
+	if file.DBKey != "" && len(file.Meta) > 0 {
+		cache.Delete("esm-build:" + file.DBKey)
+		return db.Put(file.DBKey, file.Meta)
+	}
+	return nil
+}
+
+func syncRequest(url string) (res *http.Response, err error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return
+	}
+	if cfg.Sync.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.Sync.Token)
+	}
+	res, err = httpClient.Do(req)
+	if err != nil {
+		return
+	}
+	if res.StatusCode != 200 {
+		res.Body.Close()
+		err = fmt.Errorf("unexpected http status %d", res.StatusCode)
+	}
+	return
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/esm-dev/esm.sh/server/storage"
+)
+
+func TestSyncIndex(t *testing.T) {
+	local, err := storage.OpenFS("local:" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	local.WriteFile("builds/v126/a@1.0.0/es2022/a.mjs", strings.NewReader("a"))
+
+	index := newSyncIndexFS(local)
+	err = index.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := index.changedSince(0); len(files) != 1 || files[0].Path != "builds/v126/a@1.0.0/es2022/a.mjs" {
+		t.Fatalf("the existing files should be indexed, got %v", files)
+	}
+
+	since := time.Now().UnixMilli()
+	time.Sleep(10 * time.Millisecond)
+	index.WriteFile("builds/v126/b@1.0.0/es2022/b.mjs", strings.NewReader("b"))
+	index.WriteFile("builds/v126/b@1.0.0/es2022/b.mjs", strings.NewReader("bb"))
+	index.WriteFile("tmp/c.txt", strings.NewReader("c"))
+	files := index.changedSince(since)
+	if len(files) != 1 || path.Base(files[0].Path) != "b.mjs" || files[0].Size != 2 {
+		t.Fatalf("the changed files should be listed once, got %v", files)
+	}
+
+	index.Remove("builds/v126/b@1.0.0/es2022/b.mjs")
+	if files := index.changedSince(since); len(files) != 0 {
+		t.Fatalf("the removed files should not be listed, got %v", files)
+	}
+}
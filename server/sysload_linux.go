@@ -0,0 +1,82 @@
+//go:build linux
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// getSystemLoad reads the load average, the available memory and the io pressure from `/proc`,
+// the io pressure is unavailable(-1) if the kernel doesn't support PSI.
+func getSystemLoad() (load systemLoad, err error) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return
+	}
+	load.Load1, err = parseLoadAvg(string(data))
+	if err != nil {
+		return
+	}
+	data, err = os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return
+	}
+	load.FreeMemory, err = parseMemAvailable(string(data))
+	if err != nil {
+		return
+	}
+	load.CPUs = runtime.NumCPU()
+	load.IOPressure = -1
+	if data, e := os.ReadFile("/proc/pressure/io"); e == nil {
+		if v, e := parseIOPressure(string(data)); e == nil {
+			load.IOPressure = v
+		}
+	}
+	return
+}
+
+// parseLoadAvg parses the 1-minute load average of `/proc/loadavg`, e.g. "0.52 0.58 0.59 1/467 12345".
+func parseLoadAvg(s string) (float64, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("invalid loadavg %q", s)
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// parseMemAvailable parses the `MemAvailable` of `/proc/meminfo` in bytes.
+func parseMemAvailable(s string) (uint64, error) {
+	for _, line := range strings.Split(s, "\n") {
+		if strings.HasPrefix(line, "MemAvailable:") {
+			fields := strings.Fields(strings.TrimPrefix(line, "MemAvailable:"))
+			if len(fields) == 0 {
+				break
+			}
+			kb, err := strconv.ParseUint(fields[0], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return kb * 1024, nil
+		}
+	}
+	return 0, fmt.Errorf("MemAvailable not found")
+}
+
+// parseIOPressure parses the `some avg10` of `/proc/pressure/io`, e.g.
+// "some avg10=1.23 avg60=0.50 avg300=0.10 total=123456".
+func parseIOPressure(s string) (float64, error) {
+	for _, line := range strings.Split(s, "\n") {
+		if strings.HasPrefix(line, "some ") {
+			for _, field := range strings.Fields(line) {
+				if strings.HasPrefix(field, "avg10=") {
+					return strconv.ParseFloat(strings.TrimPrefix(field, "avg10="), 64)
+				}
+			}
+		}
+	}
+	return 0, fmt.Errorf("invalid io pressure %q", s)
+}
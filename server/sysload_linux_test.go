@@ -0,0 +1,28 @@
+//go:build linux
+
+package server
+
+import (
+	"testing"
+)
+
+func TestParseSystemLoad(t *testing.T) {
+	load, err := parseLoadAvg("3.52 0.58 0.59 1/467 12345\n")
+	if err != nil || load != 3.52 {
+		t.Fatalf("invalid load average: %v, %v", load, err)
+	}
+	free, err := parseMemAvailable("MemTotal:       16318480 kB\nMemFree:         1024000 kB\nMemAvailable:    8159240 kB\n")
+	if err != nil || free != 8159240*1024 {
+		t.Fatalf("invalid available memory: %v, %v", free, err)
+	}
+	if _, err = parseMemAvailable("MemTotal:       16318480 kB\n"); err == nil {
+		t.Fatal("should fail without MemAvailable")
+	}
+	pressure, err := parseIOPressure("some avg10=12.50 avg60=0.50 avg300=0.10 total=123456\nfull avg10=1.00 avg60=0.00 avg300=0.00 total=2345\n")
+	if err != nil || pressure != 12.5 {
+		t.Fatalf("invalid io pressure: %v, %v", pressure, err)
+	}
+	if _, err := getSystemLoad(); err != nil {
+		t.Fatal(err)
+	}
+}
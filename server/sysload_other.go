@@ -0,0 +1,10 @@
+//go:build !linux
+
+package server
+
+import "errors"
+
+// getSystemLoad is only supported on Linux.
+func getSystemLoad() (load systemLoad, err error) {
+	return load, errors.New("system load is unavailable on this platform")
+}
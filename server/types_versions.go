@@ -0,0 +1,108 @@
+package server
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+var regexpRangeVersion = regexp.MustCompile(`\d+(\.\d+){0,2}`)
+
+// selectTypesVersions returns the path mappings of the `typesVersions` field of package.json
+// for the `typescriptVersion`.
+// TypeScript uses the first matched range in the object order which is lost in the go map,
+// so the most specific range(the highest version in the range) is selected instead.
+// ref https://www.typescriptlang.org/docs/handbook/declaration-files/publishing.html#version-selection-with-typesversions
+func selectTypesVersions(typesVersions map[string]interface{}) (mappings map[string]interface{}) {
+	tsv := semver.MustParse(typescriptVersion)
+	var bestVersion *semver.Version
+	for r, v := range typesVersions {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		c, err := semver.NewConstraint(r)
+		if err != nil || !c.Check(tsv) {
+			continue
+		}
+		ver := semver.MustParse("0.0.0")
+		if s := regexpRangeVersion.FindString(r); s != "" {
+			if v, err := semver.NewVersion(s); err == nil {
+				ver = v
+			}
+		}
+		if mappings == nil || ver.GreaterThan(bestVersion) {
+			mappings = m
+			bestVersion = ver
+		}
+	}
+	return
+}
+
+// resolveTypesVersions maps the subpath(e.g. "index.d.ts", "lib/core") by the path mappings
+// of the `typesVersions` field of package.json, the patterns(e.g. "*", "lib/*") can have one
+// `*` wildcard and the first target of the matched pattern is used.
+func resolveTypesVersions(typesVersions map[string]interface{}, subpath string) (string, bool) {
+	mappings := selectTypesVersions(typesVersions)
+	if len(mappings) == 0 {
+		return "", false
+	}
+	targets, match, ok := matchPathMappings(mappings, strings.TrimPrefix(subpath, "./"))
+	if !ok {
+		return "", false
+	}
+	s, ok := targets[0].(string)
+	if !ok || s == "" {
+		return "", false
+	}
+	resolved := path.Clean(strings.ReplaceAll(s, "*", match))
+	if isUnsafePath(resolved) || strings.HasPrefix(resolved, "/") {
+		return "", false
+	}
+	return resolved, true
+}
+
+// matchPathMappings matches the specifier by the path mappings(e.g. `{"*": ["ts4.2/*"]}`), the
+// exact pattern is matched firstly, then the wildcard pattern with the longest prefix.
+func matchPathMappings(mappings map[string]interface{}, specifier string) (targets []interface{}, match string, ok bool) {
+	target, ok := mappings[specifier]
+	if !ok {
+		bestPrefix := -1
+		for pattern, t := range mappings {
+			prefix, suffix, ok := strings.Cut(pattern, "*")
+			if !ok || strings.ContainsRune(suffix, '*') || len(prefix) <= bestPrefix {
+				continue
+			}
+			if len(specifier) >= len(prefix)+len(suffix) && strings.HasPrefix(specifier, prefix) && strings.HasSuffix(specifier, suffix) {
+				bestPrefix = len(prefix)
+				target = t
+				match = specifier[len(prefix) : len(specifier)-len(suffix)]
+			}
+		}
+		if bestPrefix < 0 {
+			return nil, "", false
+		}
+	}
+	targets, ok = target.([]interface{})
+	return targets, match, ok && len(targets) > 0
+}
+
+// resolveTypesVersionsFile resolves the dts file of the submodule by the `typesVersions` field,
+// the resolved file must exist in the package directory.
+func resolveTypesVersionsFile(pkgDir string, typesVersions map[string]interface{}, submodule string) (string, bool) {
+	if len(typesVersions) == 0 {
+		return "", false
+	}
+	resolved, ok := resolveTypesVersions(typesVersions, submodule)
+	if !ok {
+		return "", false
+	}
+	for _, f := range []string{resolved, resolved + ".d.ts", path.Join(resolved, "index.d.ts")} {
+		if isDtsFile(f) && fileExists(path.Join(pkgDir, f)) {
+			return f, true
+		}
+	}
+	return "", false
+}
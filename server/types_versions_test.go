@@ -0,0 +1,68 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestResolveTypesVersions(t *testing.T) {
+	var typesVersions map[string]interface{}
+	err := json.Unmarshal([]byte(`{
+		"<4.0": { "*": ["ts3.x/*"] },
+		">=4.2": { "*": ["ts4.2/*"], "lib/core": ["ts4.2/core/index.d.ts"], "utils/*": ["ts4.2/utils/*.d.ts"] },
+		"*": { "*": ["ts4.0/*"] }
+	}`), &typesVersions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for subpath, want := range map[string]string{
+		"index.d.ts":  "ts4.2/index.d.ts",
+		"./submodule": "ts4.2/submodule",
+		"lib/core":    "ts4.2/core/index.d.ts",
+		"utils/fs":    "ts4.2/utils/fs.d.ts",
+	} {
+		resolved, ok := resolveTypesVersions(typesVersions, subpath)
+		if !ok || resolved != want {
+			t.Fatalf("resolveTypesVersions(%q) = %q, want %q", subpath, resolved, want)
+		}
+	}
+
+	if _, ok := resolveTypesVersions(map[string]interface{}{"<4.0": map[string]interface{}{"*": []interface{}{"ts3.x/*"}}}, "index.d.ts"); ok {
+		t.Fatal("the range that doesn't match the typescript version should be ignored")
+	}
+	if _, ok := resolveTypesVersions(map[string]interface{}{"*": map[string]interface{}{"*": []interface{}{"../*"}}}, "index.d.ts"); ok {
+		t.Fatal("the mapping out of the package should be ignored")
+	}
+
+	dir, err := os.MkdirTemp("", "esm-types-versions-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"ts4.2/submodule.d.ts", "ts4.2/nested/index.d.ts"} {
+		ensureDir(path.Dir(path.Join(dir, name)))
+		err = os.WriteFile(path.Join(dir, name), []byte("export {}"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for submodule, want := range map[string]string{
+		"submodule": "ts4.2/submodule.d.ts",
+		"nested":    "ts4.2/nested/index.d.ts",
+		"missing":   "",
+	} {
+		f, _ := resolveTypesVersionsFile(dir, typesVersions, submodule)
+		if f != want {
+			t.Fatalf("resolveTypesVersionsFile(%q) = %q, want %q", submodule, f, want)
+		}
+	}
+
+	task := &BuildTask{}
+	p := task.fixNpmPackage(NpmPackage{Name: "foo", Version: "1.0.0", Types: "./index.d.ts", TypesVersions: typesVersions})
+	if p.Types != "ts4.2/index.d.ts" {
+		t.Fatalf("invalid types: %s", p.Types)
+	}
+}
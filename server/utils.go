@@ -5,8 +5,10 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -16,6 +18,7 @@ import (
 	"time"
 
 	"github.com/Masterminds/semver/v3"
+	"github.com/ije/esbuild-internal/config"
 	"github.com/ije/esbuild-internal/js_ast"
 	"github.com/ije/esbuild-internal/js_parser"
 	"github.com/ije/esbuild-internal/logger"
@@ -32,7 +35,9 @@ var (
 
 var httpClient = &http.Client{
 	Transport: &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			return getProxyURL(req.URL)
+		},
 		DialContext: transportDialContext(&net.Dialer{
 			Timeout:   30 * time.Second,
 			KeepAlive: 30 * time.Second,
@@ -46,10 +51,6 @@ var httpClient = &http.Client{
 	},
 }
 
-func transportDialContext(dialer *net.Dialer) func(context.Context, string, string) (net.Conn, error) {
-	return dialer.DialContext
-}
-
 func fetch(url string) (res *http.Response, err error) {
 	return httpClient.Get(url)
 }
@@ -81,6 +82,20 @@ func includes(a []string, s string) bool {
 	return false
 }
 
+func startsWith(s string, prefixes ...string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isDtsFile checks if the file is a declaration file: `.d.ts`, `.d.mts` or `.d.cts`.
+func isDtsFile(name string) bool {
+	return endsWith(name, ".d.ts", ".d.mts", ".d.cts")
+}
+
 func endsWith(s string, suffixs ...string) bool {
 	for _, suffix := range suffixs {
 		if strings.HasSuffix(s, suffix) {
@@ -90,16 +105,36 @@ func endsWith(s string, suffixs ...string) bool {
 	return false
 }
 
+// dirExists checks if the directory exists, the symlinks are followed
+// since the packages are linked to the `.pnpm` store by pnpm.
 func dirExists(filepath string) bool {
-	fi, err := os.Lstat(filepath)
+	fi, err := os.Stat(filepath)
 	return err == nil && fi.IsDir()
 }
 
+// fileExists checks if the file exists, the symlinks are followed.
 func fileExists(filepath string) bool {
-	fi, err := os.Lstat(filepath)
+	fi, err := os.Stat(filepath)
 	return err == nil && !fi.IsDir()
 }
 
+// realpathIn resolves the symlinks of the filename and returns the real path, an error is
+// returned if the real path escapes the root directory.
+func realpathIn(root string, filename string) (string, error) {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	realpath, err := filepath.EvalSymlinks(filename)
+	if err != nil {
+		return "", err
+	}
+	if realpath != realRoot && !strings.HasPrefix(realpath, realRoot+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: the real path escapes the directory %s", filename, root)
+	}
+	return realpath, nil
+}
+
 func ensureDir(dir string) (err error) {
 	_, err = os.Lstat(dir)
 	if err != nil && os.IsNotExist(err) {
@@ -209,6 +244,20 @@ func validateJS(filename string) (isESM bool, namedExports []string, err error)
 	if err != nil {
 		return
 	}
+	// parse the typescript/jsx sources with the loader of the extension
+	var options config.Options
+	switch path.Ext(filename) {
+	case ".ts":
+		options.TS.Parse = true
+	case ".mts", ".cts":
+		options.TS.Parse = true
+		options.TS.NoAmbiguousLessThan = true
+	case ".tsx":
+		options.TS.Parse = true
+		options.JSX.Parse = true
+	case ".jsx":
+		options.JSX.Parse = true
+	}
 	log := logger.NewDeferLog(logger.DeferLogNoVerboseOrDebug, nil)
 	ast, pass := js_parser.Parse(log, logger.Source{
 		Index:          0,
@@ -216,7 +265,7 @@ func validateJS(filename string) (isESM bool, namedExports []string, err error)
 		PrettyPath:     "<stdin>",
 		Contents:       string(data),
 		IdentifierName: "stdin",
-	}, js_parser.Options{})
+	}, js_parser.OptionsFromConfig(&options))
 	if !pass {
 		err = errors.New("invalid syntax, require javascript/typescript")
 		return
@@ -291,9 +340,42 @@ func removeHttpPrefix(s string) (string, error) {
 	return "", fmt.Errorf("colon not found in string: %s", s)
 }
 
+// parseByteSize parses the size like "512", "5kb" or "1mb" to bytes.
+func parseByteSize(raw string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(raw))
+	unit := int64(1)
+	if strings.HasSuffix(s, "kb") {
+		unit = 1024
+		s = strings.TrimSuffix(s, "kb")
+	} else if strings.HasSuffix(s, "mb") {
+		unit = 1024 * 1024
+		s = strings.TrimSuffix(s, "mb")
+	} else {
+		s = strings.TrimSuffix(s, "b")
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", raw)
+	}
+	return n * unit, nil
+}
+
 func concatBytes(a, b []byte) []byte {
 	c := make([]byte, len(a)+len(b))
 	copy(c, a)
 	copy(c[len(a):], b)
 	return c
 }
+
+// contextReader is the reader that stops reading when the context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
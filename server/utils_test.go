@@ -0,0 +1,96 @@
+package server
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/esm-dev/esm.sh/server/config"
+)
+
+// newTestBuildTask returns a build task of the package with the empty build args.
+func newTestBuildTask(pkg Pkg, target string) *BuildTask {
+	return &BuildTask{
+		BuildArgs: BuildArgs{
+			alias:        map[string]string{},
+			deps:         PkgSlice{},
+			external:     newStringSet(),
+			treeShaking:  newStringSet(),
+			conditions:   newStringSet(),
+			bundleScopes: newStringSet(),
+		},
+		Pkg:          pkg,
+		Target:       target,
+		BuildVersion: VERSION,
+	}
+}
+
+// withConfig sets the global config for the test, it's reset when the test finishes.
+func withConfig(t *testing.T, c *config.Config) {
+	cfg = c
+	t.Cleanup(func() {
+		cfg = nil
+	})
+}
+
+func TestRealpathIn(t *testing.T) {
+	dir := t.TempDir()
+	wd := filepath.Join(dir, "npm", "foo@1.0.0")
+	storeDir := filepath.Join(wd, "node_modules", ".pnpm", "foo@1.0.0", "node_modules", "foo")
+	ensureDir(storeDir)
+	os.WriteFile(filepath.Join(storeDir, "index.js"), []byte("export default 1;"), 0644)
+	os.WriteFile(filepath.Join(dir, "secret"), []byte("secret"), 0644)
+	os.Symlink(storeDir, filepath.Join(wd, "node_modules", "foo"))
+	os.Symlink(filepath.Join(dir, "secret"), filepath.Join(storeDir, "secret.js"))
+
+	if !dirExists(filepath.Join(wd, "node_modules", "foo")) {
+		t.Fatal("the linked package dir should exist")
+	}
+	realpath, err := realpathIn(wd, filepath.Join(wd, "node_modules", "foo", "index.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := filepath.EvalSymlinks(filepath.Join(storeDir, "index.js"))
+	if realpath != expected {
+		t.Fatalf("expected %s, got %s", expected, realpath)
+	}
+	_, err = realpathIn(wd, filepath.Join(wd, "node_modules", "foo", "secret.js"))
+	if err == nil {
+		t.Fatal("the link escapes the build dir should be rejected")
+	}
+	_, err = realpathIn(wd, filepath.Join(wd, "node_modules", "foo", "missing.js"))
+	if err == nil {
+		t.Fatal("the missing file should be rejected")
+	}
+}
+
+func TestValidateJS(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.ts":  "export interface Props { a: string }\nexport const a = <T>(v: T): T => v;\n",
+		"b.tsx": "export type B = { b: number };\nexport const B = (props: { b: number }) => <div>{props.b}</div>;\n",
+		"c.jsx": "export default function C() { return <div />; }\n",
+		"d.mts": "export const d = <T,>(v: T) => v;\n",
+		"e.js":  "module.exports = { e: 1 };\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(path.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, exports := range map[string]string{"a.ts": "a", "b.tsx": "B", "c.jsx": "default", "d.mts": "d"} {
+		isESM, namedExports, err := validateJS(path.Join(dir, name))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !isESM || strings.Join(namedExports, ",") != exports {
+			t.Fatalf("%s: invalid exports %v", name, namedExports)
+		}
+	}
+	isESM, _, err := validateJS(path.Join(dir, "e.js"))
+	if err != nil || isESM {
+		t.Fatalf("e.js should be a commonjs module: %v", err)
+	}
+}
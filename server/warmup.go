@@ -0,0 +1,233 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/esm-dev/esm.sh/server/config"
+	"github.com/ije/gox/utils"
+)
+
+// WarmupManifest lists the builds to pre-build on startup, e.g.
+//
+//	{
+//	  "origin": "https://esm.sh",
+//	  "builds": [
+//	    { "pkg": "react@18", "targets": ["es2022", "deno"] },
+//	    { "pkg": "react-dom@18/client", "dev": true }
+//	  ]
+//	}
+type WarmupManifest struct {
+	// the origin of the builds, default is the `origin` config
+	Origin string        `json:"origin,omitempty"`
+	Builds []WarmupBuild `json:"builds"`
+}
+
+type WarmupBuild struct {
+	Pkg     string   `json:"pkg"`
+	Targets []string `json:"targets,omitempty"`
+	Dev     bool     `json:"dev,omitempty"`
+	Bundle  bool     `json:"bundle,omitempty"`
+}
+
+func loadWarmupManifest(filename string) (manifest *WarmupManifest, err error) {
+	err = utils.ParseJSONFile(filename, &manifest)
+	if err == nil && manifest == nil {
+		manifest = &WarmupManifest{}
+	}
+	return
+}
+
+// toBuildTasks resolves the packages of the manifest and returns the build tasks,
+// the invalid builds are reported by the errors.
+func (manifest *WarmupManifest) toBuildTasks() (tasks []*BuildTask, errs []error) {
+	origin := manifest.Origin
+	if origin == "" {
+		origin = cfg.Origin
+	}
+	if origin == "" {
+		return nil, []error{fmt.Errorf("the origin is required")}
+	}
+	origin = strings.TrimSuffix(origin, "/")
+	for _, b := range manifest.Builds {
+		pkg, _, err := validatePkgPath("/" + strings.TrimPrefix(b.Pkg, "/"))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", b.Pkg, err))
+			continue
+		}
+		buildTargets := b.Targets
+		if len(buildTargets) == 0 {
+			buildTargets = []string{"es2022"}
+		}
+		for _, target := range buildTargets {
+			if _, ok := targets[target]; !ok && target != "types" {
+				errs = append(errs, fmt.Errorf("%s: invalid target '%s'", b.Pkg, target))
+				continue
+			}
+			tasks = append(tasks, &BuildTask{
+				BuildArgs:    newBuildArgs(),
+				Pkg:          pkg,
+				CdnOrigin:    origin,
+				Target:       target,
+				BuildVersion: VERSION,
+				Dev:          b.Dev,
+				Bundle:       b.Bundle,
+			})
+		}
+	}
+	return
+}
+
+// WarmupOutput is the response of the `POST /_admin/warmup` endpoint.
+type WarmupOutput struct {
+	Queued  int      `json:"queued"`
+	Skipped int      `json:"skipped"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// queueWarmupBuilds queues the builds of the manifest that are missing in the storage, the builds
+// respect the `buildConcurrency` config of the build queue.
+func queueWarmupBuilds(manifest *WarmupManifest) (out WarmupOutput) {
+	tasks, errs := manifest.toBuildTasks()
+	for _, err := range errs {
+		out.Errors = append(out.Errors, err.Error())
+	}
+	for _, task := range tasks {
+		if _, ok := queryESMBuild(task.ID()); ok {
+			out.Skipped++
+			continue
+		}
+		buildQueue.Add(task, "")
+		out.Queued++
+	}
+	return
+}
+
+// warmup queues the builds of the manifest file on startup.
+func warmup(filename string) {
+	manifest, err := loadWarmupManifest(filename)
+	if err != nil {
+		log.Errorf("warmup: %v", err)
+		return
+	}
+	out := queueWarmupBuilds(manifest)
+	for _, err := range out.Errors {
+		log.Warnf("warmup: %s", err)
+	}
+	log.Infof("warmup: %d builds queued", out.Queued)
+}
+
+// prebuildCommand runs the `esmd prebuild <pkg...>` command, it posts the builds to the
+// `/_admin/warmup` endpoint of the running server.
+func prebuildCommand(args []string) {
+	var (
+		cfile   string
+		server  string
+		token   string
+		origin  string
+		targets string
+		isDev   bool
+		bundle  bool
+	)
+	fset := flag.NewFlagSet("prebuild", flag.ExitOnError)
+	fset.StringVar(&cfile, "config", "config.json", "the config file path to read the port and the admin token")
+	fset.StringVar(&server, "server", "", "the server url, default is http://localhost:<port>")
+	fset.StringVar(&token, "token", "", "the admin token, default is the `adminToken` config")
+	fset.StringVar(&origin, "origin", "", "the origin of the builds, default is the `origin` config of the server")
+	fset.StringVar(&targets, "targets", "", "the build targets separated by commas, default is es2022")
+	fset.BoolVar(&isDev, "dev", false, "to prebuild the development builds")
+	fset.BoolVar(&bundle, "bundle", false, "to prebuild the bundle builds")
+
+	// the flags can be placed after the packages, e.g. `esmd prebuild react@18 --targets=es2022,deno`
+	var pkgs []string
+	for {
+		fset.Parse(args)
+		if fset.NArg() == 0 {
+			break
+		}
+		pkgs = append(pkgs, fset.Arg(0))
+		args = fset.Args()[1:]
+	}
+	if len(pkgs) == 0 {
+		fmt.Println("Usage: esmd prebuild <pkg...> [--targets=es2022,deno] [--dev] [--bundle] [--server=http://localhost:8080] [--token=TOKEN]")
+		os.Exit(1)
+	}
+
+	c := config.Default()
+	if fileExists(cfile) {
+		var err error
+		c, err = config.Load(cfile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if server == "" {
+		server = fmt.Sprintf("http://localhost:%d", c.Port)
+	}
+	if token == "" {
+		token = c.AdminToken
+	}
+
+	manifest := &WarmupManifest{Origin: origin}
+	var targetList []string
+	if targets != "" {
+		targetList = strings.Split(targets, ",")
+	}
+	for _, pkg := range pkgs {
+		manifest.Builds = append(manifest.Builds, WarmupBuild{Pkg: pkg, Targets: targetList, Dev: isDev, Bundle: bundle})
+	}
+	out, err := postWarmupManifest(server, token, manifest)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	for _, e := range out.Errors {
+		fmt.Println("error:", e)
+	}
+	fmt.Printf("%d builds queued, %d builds skipped(already built)\n", out.Queued, out.Skipped)
+	if len(out.Errors) > 0 {
+		os.Exit(1)
+	}
+}
+
+// postWarmupManifest posts the manifest to the `/_admin/warmup` endpoint of the server.
+func postWarmupManifest(server string, token string, manifest *WarmupManifest) (out WarmupOutput, err error) {
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest("POST", strings.TrimSuffix(server, "/")+"/_admin/warmup", bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		var ret struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(res.Body).Decode(&ret)
+		if ret.Error.Message != "" {
+			err = errors.New(ret.Error.Message)
+		} else {
+			err = fmt.Errorf("unexpected http status %d", res.StatusCode)
+		}
+		return
+	}
+	err = json.NewDecoder(res.Body).Decode(&out)
+	return
+}
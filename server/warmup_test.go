@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/esm-dev/esm.sh/server/config"
+)
+
+func TestWarmupManifest(t *testing.T) {
+	dir, err := os.MkdirTemp("", "esm-warmup-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	withConfig(t, &config.Config{Origin: "https://esm.sh"})
+
+	filename := path.Join(dir, "warmup.json")
+	err = os.WriteFile(filename, []byte(`{"builds": [
+		{"pkg": "react@18.2.0", "targets": ["es2022", "deno"]},
+		{"pkg": "react-dom@18.2.0/client", "dev": true},
+		{"pkg": "preact@10.19.0", "targets": ["es3"]}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := loadWarmupManifest(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasks, errs := manifest.toBuildTasks()
+	if len(errs) != 1 || errs[0].Error() != "preact@10.19.0: invalid target 'es3'" {
+		t.Fatalf("invalid errors: %v", errs)
+	}
+	ids := []string{}
+	for _, task := range tasks {
+		if task.CdnOrigin != "https://esm.sh" {
+			t.Fatalf("invalid origin: %s", task.CdnOrigin)
+		}
+		ids = append(ids, task.ID())
+	}
+	if len(ids) != 3 || ids[0] != "stable/react@18.2.0/es2022/react.mjs" || ids[1] != "stable/react@18.2.0/deno/react.mjs" || ids[2] != fmt.Sprintf("v%d/react-dom@18.2.0/es2022/client.development.js", VERSION) {
+		t.Fatalf("invalid tasks: %v", ids)
+	}
+}
+
+func TestPostWarmupManifest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/_admin/warmup" {
+			w.WriteHeader(404)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(401)
+			w.Write([]byte(`{"error": {"status": 401, "message": "unauthorized"}}`))
+			return
+		}
+		var manifest WarmupManifest
+		json.NewDecoder(r.Body).Decode(&manifest)
+		json.NewEncoder(w).Encode(WarmupOutput{Queued: len(manifest.Builds), Skipped: 1})
+	}))
+	defer ts.Close()
+
+	manifest := &WarmupManifest{Builds: []WarmupBuild{
+		{Pkg: "react@18.2.0", Targets: []string{"es2020", "deno"}},
+		{Pkg: "react-dom@18.2.0", Targets: []string{"es2020", "deno"}},
+	}}
+	out, err := postWarmupManifest(ts.URL, "secret", manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Queued != 2 || out.Skipped != 1 {
+		t.Fatalf("invalid output: %v", out)
+	}
+	_, err = postWarmupManifest(ts.URL+"/", "invalid", manifest)
+	if err == nil || err.Error() != "unauthorized" {
+		t.Fatalf("should be unauthorized, got %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/esm-dev/esm.sh/server/config"
+	"github.com/ije/gox/utils"
+)
+
+// the db key to store the webhooks registered by the admin API
+const webhooksDBKey = "_webhooks"
+
+var (
+	webhooksLock sync.RWMutex
+	webhooks     []config.Webhook
+)
+
+type WebhookPayload struct {
+	Event     string `json:"event"`
+	ID        string `json:"id"`
+	Pkg       string `json:"pkg"`
+	Target    string `json:"target"`
+	Duration  int64  `json:"duration"`
+	Size      int64  `json:"size,omitempty"`
+	Error     string `json:"error,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// restoreWebhooks loads the webhooks registered by the admin API from the db.
+func restoreWebhooks() {
+	data, err := db.Get(webhooksDBKey)
+	if err != nil || data == nil {
+		return
+	}
+	webhooksLock.Lock()
+	defer webhooksLock.Unlock()
+	err = json.Unmarshal(data, &webhooks)
+	if err != nil {
+		log.Errorf("restore webhooks: %v", err)
+	}
+}
+
+// getWebhooks returns all the webhooks of the config and registered by the admin API.
+func getWebhooks() []config.Webhook {
+	webhooksLock.RLock()
+	defer webhooksLock.RUnlock()
+
+	a := make([]config.Webhook, 0, len(cfg.Webhooks)+len(webhooks))
+	a = append(a, cfg.Webhooks...)
+	a = append(a, webhooks...)
+	return a
+}
+
+func addWebhook(hook config.Webhook) error {
+	webhooksLock.Lock()
+	defer webhooksLock.Unlock()
+
+	for i, h := range webhooks {
+		if h.URL == hook.URL {
+			webhooks[i] = hook
+			return db.Put(webhooksDBKey, utils.MustEncodeJSON(webhooks))
+		}
+	}
+	webhooks = append(webhooks, hook)
+	return db.Put(webhooksDBKey, utils.MustEncodeJSON(webhooks))
+}
+
+func removeWebhook(url string) error {
+	webhooksLock.Lock()
+	defer webhooksLock.Unlock()
+
+	a := make([]config.Webhook, 0, len(webhooks))
+	for _, h := range webhooks {
+		if h.URL != url {
+			a = append(a, h)
+		}
+	}
+	webhooks = a
+	return db.Put(webhooksDBKey, utils.MustEncodeJSON(webhooks))
+}
+
+// notifyWebhooks posts the build result to the webhooks.
+func notifyWebhooks(task *BuildTask, output BuildOutput, duration time.Duration) {
+	hooks := getWebhooks()
+	if len(hooks) == 0 {
+		return
+	}
+
+	payload := WebhookPayload{
+		ID:        task.ID(),
+		Pkg:       task.Pkg.String(),
+		Target:    task.Target,
+		Duration:  duration.Milliseconds(),
+		RequestID: task.requestID,
+	}
+	event := "success"
+	if output.err != nil {
+		event = "failure"
+		payload.Error = output.err.Error()
+	} else if output.meta != nil && !output.meta.TypesOnly {
+		if fi, err := fs.Stat(task.getSavepath()); err == nil {
+			payload.Size = fi.Size()
+		}
+	}
+	payload.Event = "build." + event
+	body := utils.MustEncodeJSON(payload)
+
+	for _, hook := range hooks {
+		if len(hook.Events) > 0 && !includes(hook.Events, event) {
+			continue
+		}
+		go func(hook config.Webhook) {
+			err := postWebhook(hook, body)
+			if err != nil {
+				log.Warnf("webhook(%s): %v", hook.URL, err)
+			}
+		}(hook)
+	}
+}
+
+func postWebhook(hook config.Webhook, body []byte) error {
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "esm.sh/webhook")
+	if hook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(hook.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Esm-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("unexpected http status %s", res.Status)
+	}
+	return nil
+}